@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+symbols_alias.csv (optional, under DATA_DIR)
+old,new
+FB,META
+
+Aliases only affect pricing and aggregation; stored transactions keep the
+symbol they were recorded with.
+*/
+
+const symbolAliasFile = "symbols_alias.csv"
+
+// LoadSymbolAliases reads old->new symbol renames from path. A missing file
+// yields an empty map. Chains (A->B, B->C) are resolved to their final symbol.
+func LoadSymbolAliases(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]string{}
+	for i, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		from := strings.ToUpper(strings.TrimSpace(row[0]))
+		to := strings.ToUpper(strings.TrimSpace(row[1]))
+		if i == 0 && from == "OLD" && to == "NEW" {
+			continue // header
+		}
+		if from == "" || to == "" || from == to {
+			continue
+		}
+		raw[from] = to
+	}
+	out := make(map[string]string, len(raw))
+	for from := range raw {
+		to := from
+		for hops := 0; ; hops++ {
+			next, ok := raw[to]
+			if !ok {
+				break
+			}
+			if hops > len(raw) {
+				return nil, fmt.Errorf("%s: alias cycle involving %s", path, from)
+			}
+			to = next
+		}
+		out[from] = to
+	}
+	return out, nil
+}
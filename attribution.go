@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+/* ===================== Benchmark-relative attribution ===================== */
+
+// AttributionItem is one symbol's share of the portfolio's return over the
+// period. Amounts are in the reference currency; *PP fields are percentage
+// points of the portfolio return.
+type AttributionItem struct {
+	Symbol     string  `json:"symbol"`
+	StartValue float64 `json:"start_value"`
+	EndValue   float64 `json:"end_value"`
+	// NetFlows is buys and transfers in minus sells during the period
+	NetFlows float64 `json:"net_flows"`
+	Income   float64 `json:"income"` // dividends during the period
+	PL       float64 `json:"pl"`     // end − start − net_flows + income
+	// WeightPercent is the symbol's share of time-weighted capital
+	WeightPercent        float64 `json:"weight_percent"`
+	ReturnPercent        float64 `json:"return_percent"`
+	ContributionPP       float64 `json:"contribution_pp"`
+	ExcessReturnPercent  float64 `json:"excess_return_percent"`  // return − benchmark return
+	ExcessContributionPP float64 `json:"excess_contribution_pp"` // contribution − weight × benchmark return
+
+	refCCY string // for rounding only; set by the enclosing response
+}
+
+type AttributionResponse struct {
+	Benchmark              string            `json:"benchmark"`
+	From                   time.Time         `json:"from"`
+	To                     time.Time         `json:"to"`
+	RefCurrency            string            `json:"ref_currency"`
+	TotalReturnPercent     float64           `json:"total_return_percent"`
+	BenchmarkReturnPercent float64           `json:"benchmark_return_percent"`
+	ExcessReturnPercent    float64           `json:"excess_return_percent"`
+	Items                  []AttributionItem `json:"items"`
+	// Skipped lists symbols left out because a period-end price was missing
+	Skipped []SkippedSymbol `json:"skipped,omitempty"`
+}
+
+// ComputeAttribution splits the portfolio's return on its positions (cash
+// excluded) between [from, to] into per-symbol contributions. Returns are
+// Modified Dietz: flows are weighted by the share of the period they were
+// invested, and every symbol shares the portfolio's denominator, so the
+// contributions sum to the total return. A zero from starts at the first
+// transaction; a zero to means today.
+func (s *TransactionService) ComputeAttribution(portfolioID, benchmark string, from, to time.Time) (AttributionResponse, error) {
+	hp, ok := s.prices.(HistoryProvider)
+	if !ok {
+		return AttributionResponse{}, errors.New("attribution needs a price provider with daily history")
+	}
+	benchmark = strings.ToUpper(strings.TrimSpace(benchmark))
+	if benchmark == "" {
+		return AttributionResponse{}, errors.New("benchmark is required")
+	}
+	if _, err := s.repoPf.GetByID(portfolioID); err != nil {
+		return AttributionResponse{}, ErrPortfolioNotFound
+	}
+	txs, err := s.repoTx.List(portfolioID, ListFilter{Limit: 0})
+	if err != nil {
+		return AttributionResponse{}, err
+	}
+	stableSort(txs, lessForPositions)
+
+	if to.IsZero() {
+		to = s.today()
+	}
+	if from.IsZero() && len(txs) > 0 {
+		from = txs[0].Date
+	}
+	if !from.Before(to) {
+		return AttributionResponse{}, errors.New("from must be before to")
+	}
+	period := to.Sub(from).Hours()
+
+	type state struct {
+		shares, startShares float64
+		ccy                 string
+		flows, weighted     float64
+		income              float64
+		active              bool // held at from or traded during the period
+	}
+	st := map[string]*state{}
+	for _, tx := range txs {
+		if tx.Date.After(to) {
+			break
+		}
+		if tx.TradeType == TradeTypeCash {
+			continue
+		}
+		sym := s.canonicalSymbol(tx.Symbol)
+		a := st[sym]
+		if a == nil {
+			a = &state{}
+			st[sym] = a
+		}
+		if tx.Currency != "" {
+			a.ccy = strings.ToUpper(tx.Currency)
+		}
+		amt := tx.Total
+		if amt < 0 {
+			amt = -amt
+		}
+		r, _ := s.rate(tx.Currency) // not txRate: start/end values are at today's rate too
+		amt *= r
+		inPeriod := tx.Date.After(from)
+		var flow float64
+		switch tx.TradeType {
+		case TradeTypeBuy:
+			a.shares += tx.Shares
+			flow = amt
+		case TradeTypeSell:
+			a.shares -= tx.Shares
+			flow = -amt
+		case TradeTypeTransfer:
+			// Valued at the carried cost basis
+			a.shares += tx.Shares
+			if tx.Shares >= 0 {
+				flow = amt
+			} else {
+				flow = -amt
+			}
+		case TradeTypeDividend:
+			if inPeriod {
+				a.income += amt
+				a.active = true
+			}
+		}
+		if !inPeriod {
+			a.startShares = a.shares
+			continue
+		}
+		if flow != 0 {
+			a.flows += flow
+			a.weighted += flow * to.Sub(tx.Date).Hours() / period
+			a.active = true
+		}
+	}
+
+	out := AttributionResponse{Benchmark: benchmark, From: from, To: to, RefCurrency: s.refCCY}
+	valueOn := func(sym, ccy string, shares float64, day time.Time) (float64, error) {
+		if shares == 0 {
+			return 0, nil
+		}
+		p, _, err := hp.GetPriceOn(sym, day)
+		if err != nil {
+			return 0, err
+		}
+		rate, _ := s.valuationRate(sym, &positionAgg{currency: ccy, byCCY: map[string]float64{ccy: shares}})
+		return shares * p * multiplierForSymbol(sym) * rate, nil
+	}
+
+	var totalPL, denom float64
+	items := make([]AttributionItem, 0, len(st))
+	bases := make([]float64, 0, len(st))
+	for sym, a := range st {
+		if a.startShares > 0 {
+			a.active = true
+		}
+		if !a.active {
+			continue
+		}
+		start, err := valueOn(sym, a.ccy, a.startShares, from)
+		if err == nil {
+			var end float64
+			end, err = valueOn(sym, a.ccy, a.shares, to)
+			if err == nil {
+				it := AttributionItem{
+					Symbol:     sym,
+					StartValue: start,
+					EndValue:   end,
+					NetFlows:   a.flows,
+					Income:     a.income,
+					PL:         end - start - a.flows + a.income,
+				}
+				base := start + a.weighted
+				items = append(items, it)
+				bases = append(bases, base)
+				totalPL += it.PL
+				denom += base
+				continue
+			}
+		}
+		out.Skipped = append(out.Skipped, SkippedSymbol{Symbol: sym, Reason: err.Error()})
+	}
+
+	pb0, _, err := hp.GetPriceOn(benchmark, from)
+	if err != nil {
+		return AttributionResponse{}, errors.New("benchmark price at from: " + err.Error())
+	}
+	pb1, _, err := hp.GetPriceOn(benchmark, to)
+	if err != nil {
+		return AttributionResponse{}, errors.New("benchmark price at to: " + err.Error())
+	}
+	var rb float64
+	if pb0 > 0 {
+		rb = pb1/pb0 - 1
+	}
+	out.BenchmarkReturnPercent = rb * 100.0
+
+	for i := range items {
+		it := &items[i]
+		if bases[i] > 0 {
+			it.ReturnPercent = (it.PL / bases[i]) * 100.0
+			it.ExcessReturnPercent = it.ReturnPercent - out.BenchmarkReturnPercent
+		}
+		if denom > 0 {
+			w := bases[i] / denom
+			it.WeightPercent = w * 100.0
+			it.ContributionPP = (it.PL / denom) * 100.0
+			it.ExcessContributionPP = it.ContributionPP - w*out.BenchmarkReturnPercent
+		}
+	}
+	if denom > 0 {
+		out.TotalReturnPercent = (totalPL / denom) * 100.0
+		out.ExcessReturnPercent = out.TotalReturnPercent - out.BenchmarkReturnPercent
+	}
+	stableSort(items, func(a, b AttributionItem) bool { return a.Symbol < b.Symbol })
+	stableSort(items, func(a, b AttributionItem) bool { return a.ContributionPP > b.ContributionPP })
+	out.Items = items
+	sortSkipped(out.Skipped)
+	return out, nil
+}
+
+// parseQueryDate parses an optional YYYY-MM-DD (or YYYY/MM/DD) query date as
+// a calendar date (midnight UTC). Empty yields the zero time.
+func parseQueryDate(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return parseCalendarDate(payloadDateLayout, strings.ReplaceAll(v, "-", "/"))
+}
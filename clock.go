@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// Clock tells the services what time it is: the instant stamped on writes and
+// the day "today" is for future-date checks, daily P/L, backtests and default
+// end dates. The default is the system clock; tests can install a fixed one.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// FixedClock always reports the same instant.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time { return time.Time(c) }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFixedClockStampsRepoWrites(t *testing.T) {
+	at := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	pf, svc := newTestServices(t, nil, nil, "USD")
+	pf.SetClock(FixedClock(at))
+	svc.SetClock(FixedClock(at))
+	id := mustPortfolio(t, pf, svc, "USD")
+	tx, err := svc.CreateOne(id, transactionDTO{TradeType: TradeTypeCash, Currency: "USD", Date: "2025/01/02", Total: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Delete(id, tx.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := svc.repoTx.List(id, ListFilter{IncludeDeleted: true})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("list = %v, %v; want the deleted row", rows, err)
+	}
+	got := rows[0]
+	if got.DeletedAt == nil || !got.DeletedAt.Equal(at) || !got.UpdatedAt.Equal(at) {
+		t.Fatalf("deleted_at %v, updated_at %v; want both %v", got.DeletedAt, got.UpdatedAt, at)
+	}
+
+	// A day's retention on the same clock keeps the row; a day later it goes.
+	if n, err := svc.PurgeDeleted(24 * time.Hour); err != nil || n != 0 {
+		t.Fatalf("purge on the deletion day = %d, %v; want 0", n, err)
+	}
+	svc.SetClock(FixedClock(at.Add(48 * time.Hour)))
+	if n, err := svc.PurgeDeleted(24 * time.Hour); err != nil || n != 1 {
+		t.Fatalf("purge two days later = %d, %v; want 1", n, err)
+	}
+}
+
+func TestParseAsOfUsesGivenToday(t *testing.T) {
+	today := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := parseAsOf("2020/06/01", today); err != nil {
+		t.Errorf("as_of today: %v", err)
+	}
+	if _, err := parseAsOf("2020/06/02", today); err == nil {
+		t.Error("as_of after the clock's today was accepted")
+	}
+}
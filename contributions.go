@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+/* ===================== Contributions by period ===================== */
+
+// ContributionBucket is one month or year of contributions, in the reference
+// currency. Net = Deposits + InferredDeposits − Withdrawals.
+type ContributionBucket struct {
+	Period           string    `json:"period"` // "2025-01" or "2025"
+	Start            time.Time `json:"start"`
+	Deposits         float64   `json:"deposits"`
+	InferredDeposits float64   `json:"inferred_deposits"`
+	Withdrawals      float64   `json:"withdrawals"`
+	Net              float64   `json:"net"`
+	CumulativeNet    float64   `json:"cumulative_net"`
+
+	refCCY string // for rounding only; set by the enclosing response
+}
+
+type ContributionsResponse struct {
+	RefCurrency string               `json:"ref_currency"`
+	Interval    string               `json:"interval"`
+	TotalNet    float64              `json:"total_net"` // the summary's effective_cash_in
+	Buckets     []ContributionBucket `json:"buckets"`
+}
+
+// ComputeContributions buckets every portfolio's (WithTag) deposit, inferred
+// deposit and withdrawal events by month or year. Cash stats run per
+// portfolio, as in the global summary, so the buckets add up to its
+// effective_cash_in. Buckets run from the first event's period through
+// today's, empty periods included.
+func (s *TransactionService) ComputeContributions(interval string) (ContributionsResponse, error) {
+	var period func(time.Time) (string, time.Time)
+	var next func(time.Time) time.Time
+	switch interval {
+	case "month":
+		period = func(t time.Time) (string, time.Time) {
+			return t.Format("2006-01"), time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	case "year":
+		period = func(t time.Time) (string, time.Time) {
+			return t.Format("2006"), time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		next = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	default:
+		return ContributionsResponse{}, fmt.Errorf("invalid interval %q (use month|year)", interval)
+	}
+
+	pfs, err := s.listPortfolios()
+	if err != nil {
+		return ContributionsResponse{}, err
+	}
+	byStart := map[time.Time]*ContributionBucket{}
+	var first time.Time
+	bucket := func(when time.Time) *ContributionBucket {
+		key, start := period(when)
+		b, ok := byStart[start]
+		if !ok {
+			b = &ContributionBucket{Period: key, Start: start}
+			byStart[start] = b
+		}
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		return b
+	}
+	out := ContributionsResponse{RefCurrency: s.refCCY, Interval: interval, Buckets: []ContributionBucket{}}
+	for _, pf := range pfs {
+		txs, err := s.repoTx.List(pf.ID, ListFilter{Limit: 0})
+		if err != nil {
+			return ContributionsResponse{}, err
+		}
+		cs := s.computeCashStats(txs)
+		for _, e := range cs.depositEvents {
+			bucket(e.when).Deposits += e.amount
+		}
+		for _, e := range cs.inferredEvents {
+			bucket(e.when).InferredDeposits += e.amount
+		}
+		for _, e := range cs.withdrawalEvents {
+			bucket(e.when).Withdrawals += e.amount
+		}
+	}
+	if first.IsZero() {
+		return out, nil
+	}
+
+	_, last := period(s.today())
+	var cum float64
+	for start := first; !start.After(last); start = next(start) {
+		b, ok := byStart[start]
+		if !ok {
+			key, _ := period(start)
+			b = &ContributionBucket{Period: key, Start: start}
+		}
+		b.Net = b.Deposits + b.InferredDeposits - b.Withdrawals
+		cum += b.Net
+		b.CumulativeNet = cum
+		out.Buckets = append(out.Buckets, *b)
+	}
+	out.TotalNet = cum
+	return out, nil
+}
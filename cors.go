@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// CORS configuration:
+//
+// CORS_ORIGINS  comma-separated allowlist; a matching Origin is echoed back
+//               with credentials allowed. Unset means "*" (dev default).
+// CORS_METHODS  Access-Control-Allow-Methods (default GET,POST,PUT,DELETE,OPTIONS)
+// CORS_HEADERS  Access-Control-Allow-Headers (default corsDefaultHeaders)
+
+// corsDefaultHeaders are the request headers browsers may send: the API's own
+// X-Request-Id, and the conditional headers of the ETag round trip.
+const corsDefaultHeaders = "Content-Type, Authorization, Accept, X-Request-Id, If-Match, If-None-Match, If-Modified-Since"
+
+// corsExposeHeaders are the response headers scripts may read.
+const corsExposeHeaders = "ETag, Last-Modified, Location, Retry-After, X-Limit-Clamped, X-Request-Id"
+
+type corsConfig struct {
+	origins map[string]bool // nil allows any origin via "*"
+	methods string
+	headers string
+}
+
+func corsFromEnv() corsConfig {
+	c := corsConfig{
+		methods: envString("CORS_METHODS", "GET,POST,PUT,DELETE,OPTIONS"),
+		headers: envString("CORS_HEADERS", corsDefaultHeaders),
+	}
+	if v := strings.TrimSpace(os.Getenv("CORS_ORIGINS")); v != "" && v != "*" {
+		c.origins = map[string]bool{}
+		for _, o := range strings.Split(v, ",") {
+			if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+				c.origins[o] = true
+			}
+		}
+	}
+	return c
+}
+
+func envString(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// apply sets the CORS response headers for r. With an allowlist, origins not
+// on it get no Access-Control-Allow-Origin, so browsers block the response.
+func (c corsConfig) apply(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	if c.origins == nil {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if !c.origins[origin] {
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", c.methods)
+	h.Set("Access-Control-Allow-Headers", c.headers)
+	h.Set("Access-Control-Expose-Headers", corsExposeHeaders)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCORSDefaults(t *testing.T) {
+	t.Setenv("CORS_HEADERS", "")
+	rec := httptest.NewRecorder()
+	corsFromEnv().apply(rec, httptest.NewRequest("OPTIONS", "/portfolios", nil))
+	h := rec.Header()
+	for _, tc := range []struct {
+		header string
+		want   []string
+	}{
+		{"Access-Control-Allow-Headers", []string{"X-Request-Id", "If-Match", "If-None-Match"}},
+		{"Access-Control-Expose-Headers", []string{"ETag", "X-Request-Id"}},
+	} {
+		got := strings.Split(h.Get(tc.header), ", ")
+		for _, w := range tc.want {
+			found := false
+			for _, g := range got {
+				found = found || strings.EqualFold(g, w)
+			}
+			if !found {
+				t.Errorf("%s = %q, missing %s", tc.header, h.Get(tc.header), w)
+			}
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// wantsCSV reports whether r asks for CSV: ?format=csv, or an Accept header
+// listing text/csv when format is not given. ?format=json forces JSON.
+func wantsCSV(r *http.Request) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format"))) {
+	case "csv":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+		return strings.Contains(strings.ToLower(r.Header.Get("Accept")), "text/csv"), nil
+	}
+	return false, errors.New("invalid format (use json|csv)")
+}
+
+// writeRows answers with JSON, or with rows as CSV when the request asks for
+// it (see wantsCSV). A bad format is a 400.
+func writeRows[T any](w http.ResponseWriter, r *http.Request, v any, rows []T) {
+	w.Header().Add("Vary", "Accept")
+	asCSV, err := wantsCSV(r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !asCSV {
+		writeJSON(w, http.StatusOK, v)
+		return
+	}
+	body, err := csvRows(rows)
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// csvRows renders rows with one column per JSON field of T, in declaration
+// order and named as in JSON. Values go through each row's MarshalJSON, so
+// they are rounded as in the JSON response. Nested objects are left out and
+// lists are joined with ';'. Numbers and flags omitted from the JSON as empty
+// are written as 0 and false; omitted optional values stay blank.
+func csvRows[T any](rows []T) ([]byte, error) {
+	type column struct {
+		name string
+		kind reflect.Kind
+	}
+	var cols []column
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" || name == "" {
+			continue
+		}
+		k := f.Type.Kind()
+		if k == reflect.Struct && f.Type.String() != "time.Time" || k == reflect.Ptr && f.Type.Elem().Kind() == reflect.Struct {
+			continue
+		}
+		cols = append(cols, column{name, k})
+	}
+
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	header := make([]string, len(cols))
+	for i, c := range cols {
+		header[i] = c.name
+	}
+	_ = cw.Write(header)
+	for _, row := range rows {
+		b, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]any
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		rec := make([]string, len(cols))
+		for i, c := range cols {
+			rec[i] = csvCell(m[c.name], c.kind)
+		}
+		_ = cw.Write(rec)
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+func csvCell(v any, kind reflect.Kind) string {
+	switch x := v.(type) {
+	case nil:
+		switch kind {
+		case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int64:
+			return "0"
+		case reflect.Bool:
+			return "false"
+		}
+		return ""
+	case []any:
+		parts := make([]string, len(x))
+		for i, p := range x {
+			parts[i] = fmt.Sprint(p)
+		}
+		return strings.Join(parts, ";")
+	}
+	return fmt.Sprint(v)
+}
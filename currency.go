@@ -0,0 +1,44 @@
+package main
+
+import "strings"
+
+// iso4217 is the set of active ISO 4217 currency codes.
+var iso4217 = func() map[string]struct{} {
+	codes := `AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND
+BOB BOV BRL BSD BTN BWP BYN BZD CAD CDF CHE CHF CHW CLF CLP CNY COP COU CRC CUC CUP
+CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD GNF GTQ GYD HKD
+HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT
+LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MXV MYR MZN
+NAD NGN NIO NOK NPR NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD
+SCR SDG SEK SGD SHP SLE SLL SOS SRD SSP STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD
+TWD TZS UAH UGX USD USN UYI UYU UYW UZS VED VES VND VUV WST XAF XAG XAU XBA XBB XBC
+XBD XCD XDR XOF XPD XPF XPT XSU XTS XUA XXX YER ZAR ZMW ZWL`
+	m := make(map[string]struct{}, 200)
+	for _, c := range strings.Fields(codes) {
+		m[c] = struct{}{}
+	}
+	return m
+}()
+
+// iso4217MinorUnits lists the codes whose minor unit isn't 2 decimals: whole
+// yen and won, thousandths of a dinar. Codes missing here use 2.
+var iso4217MinorUnits = map[string]int{
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0, "PYG": 0,
+	"RWF": 0, "UGX": 0, "UYI": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"CLF": 4, "UYW": 4,
+}
+
+// minorUnits is the number of decimals amounts in code are written with.
+func minorUnits(code string) int {
+	if n, ok := iso4217MinorUnits[strings.ToUpper(strings.TrimSpace(code))]; ok {
+		return n
+	}
+	return 2
+}
+
+// isISOCurrency reports whether code (case-insensitive) is a known ISO 4217 code.
+func isISOCurrency(code string) bool {
+	_, ok := iso4217[strings.ToUpper(strings.TrimSpace(code))]
+	return ok
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"time"
+)
+
+/* ===================== Dashboard ===================== */
+
+// DashboardPortfolio is a portfolio with the totals of its own summary.
+// Error is set instead of the totals when that summary failed.
+type DashboardPortfolio struct {
+	Portfolio
+	TransactionCount    int        `json:"transaction_count"`
+	LastTransactionDate *time.Time `json:"last_transaction_date,omitempty"`
+	TotalInvested       float64    `json:"total_invested"`
+	TotalMarketValue    float64    `json:"total_market_value"`
+	TotalUnrealizedPL   float64    `json:"total_unrealized_pl"`
+	Balance             float64    `json:"balance"`
+	Error               string     `json:"error,omitempty"`
+
+	refCCY string // for rounding only; set by the enclosing response
+}
+
+// DashboardResponse bundles what the frontend loads on start.
+type DashboardResponse struct {
+	Summary            SummaryResponse      `json:"summary"`
+	Allocations        AllocationResponse   `json:"allocations"` // market_value basis
+	RecentTransactions []Transaction        `json:"recent_transactions"`
+	Portfolios         []DashboardPortfolio `json:"portfolios"`
+}
+
+// WithPriceMemo returns a shallow copy whose price lookups are memoized for
+// the copy's lifetime (see priceMemo). Use one per request.
+func (s *TransactionService) WithPriceMemo() *TransactionService {
+	cp := *s
+	cp.prices = newPriceMemo(s.prices)
+	return &cp
+}
+
+// ComputeDashboard computes the global summary, market-value allocations,
+// the latest recent transactions and every portfolio's totals (all honoring
+// WithTag) on one memoized price provider, so each symbol is priced once
+// however many of the parts hold it.
+func (s *TransactionService) ComputeDashboard(recent int) (DashboardResponse, error) {
+	svc := s.WithPriceMemo()
+	var out DashboardResponse
+	var err error
+	if out.Summary, err = svc.ComputeSummaryAll(); err != nil {
+		return DashboardResponse{}, err
+	}
+	if out.Allocations, err = svc.ComputeAllocationsAll("market_value"); err != nil {
+		return DashboardResponse{}, err
+	}
+	if out.RecentTransactions, err = svc.ListAll(ListFilter{Sort: "date_desc", Limit: recent}); err != nil {
+		return DashboardResponse{}, err
+	}
+	if out.RecentTransactions == nil {
+		out.RecentTransactions = []Transaction{}
+	}
+
+	pfs, err := svc.listPortfolios()
+	if err != nil {
+		return DashboardResponse{}, err
+	}
+	// Same order as GET /portfolios: created_asc, ties by ID
+	stableSort(pfs, func(a, b Portfolio) bool { return a.ID < b.ID })
+	stableSort(pfs, portfolioSorts["created_asc"])
+	out.Portfolios = make([]DashboardPortfolio, 0, len(pfs))
+	for _, pf := range pfs {
+		dp := DashboardPortfolio{Portfolio: pf}
+		txs, err := svc.repoTx.List(pf.ID, ListFilter{Limit: 0})
+		if err != nil {
+			return DashboardResponse{}, err
+		}
+		dp.TransactionCount = len(txs)
+		for _, tx := range txs {
+			if dp.LastTransactionDate == nil || tx.Date.After(*dp.LastTransactionDate) {
+				d := tx.Date
+				dp.LastTransactionDate = &d
+			}
+		}
+		sum, err := svc.ComputeSummary(pf.ID)
+		if err != nil {
+			dp.Error = err.Error()
+		} else {
+			dp.TotalInvested = sum.TotalInvested
+			dp.TotalMarketValue = sum.TotalMarketValue
+			dp.TotalUnrealizedPL = sum.TotalUnrealizedPL
+			dp.Balance = sum.Balance
+		}
+		out.Portfolios = append(out.Portfolios, dp)
+	}
+	return out, nil
+}
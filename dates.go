@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// Transaction dates, as_of and from/to are calendar dates with no zone of
+// their own. They are parsed and stored as midnight UTC whatever zone the
+// server runs in, so they line up with the UTC days of the price history
+// (sameYMD, the backtest day loop) and survive a CSV round trip unchanged.
+//
+// TZ  zone that decides which calendar day "today" is, for the future-date
+//     check, executing pending trades and default end dates (default UTC,
+//     even when the host has a local zone)
+
+var calendarZone = calendarZoneFromEnv()
+
+func calendarZoneFromEnv() *time.Location {
+	if strings.TrimSpace(os.Getenv("TZ")) == "" {
+		return time.UTC
+	}
+	return time.Local // the Go runtime has already loaded TZ into it
+}
+
+// calendarDay is the calendar date an instant falls on in calendarZone, as
+// midnight UTC. Pass it instants such as time.Now(), not stored dates.
+func calendarDay(t time.Time) time.Time {
+	y, m, d := t.In(calendarZone).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// parseCalendarDate parses v as a calendar date at midnight UTC.
+func parseCalendarDate(layout, v string) (time.Time, error) {
+	return time.ParseInLocation(layout, v, time.UTC)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalendarDatesAcrossTheYearBoundary(t *testing.T) {
+	east := time.FixedZone("UTC+8", 8*3600)  // e.g. Asia/Taipei
+	west := time.FixedZone("UTC-8", -8*3600) // e.g. America/Los_Angeles
+	old := calendarZone
+	t.Cleanup(func() { calendarZone = old })
+
+	dec31 := time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)
+	jan1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	lateDec31 := time.Date(2024, 12, 31, 20, 0, 0, 0, time.UTC) // Jan 1 04:00 east, Dec 31 12:00 west
+	earlyJan1 := time.Date(2025, 1, 1, 3, 0, 0, 0, time.UTC)    // Jan 1 11:00 east, Dec 31 19:00 west
+
+	cases := []struct {
+		name  string
+		zone  *time.Location
+		now   time.Time
+		today time.Time
+	}{
+		{"east, late Dec 31 UTC", east, lateDec31, jan1},
+		{"east, early Jan 1 UTC", east, earlyJan1, jan1},
+		{"west, late Dec 31 UTC", west, lateDec31, dec31},
+		{"west, early Jan 1 UTC", west, earlyJan1, dec31},
+		{"utc, late Dec 31", time.UTC, lateDec31, dec31},
+	}
+	buy := transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Shares: 1, Price: 100, Total: -100}
+	for _, c := range cases {
+		calendarZone = c.zone
+		if got := calendarDay(c.now); !got.Equal(c.today) {
+			t.Errorf("%s: today is %s, want %s", c.name, got.Format(time.DateOnly), c.today.Format(time.DateOnly))
+		}
+		for _, date := range []string{"2024/12/31", "2025/01/01"} {
+			d := buy
+			d.Date = date
+			tx, err := d.toDomain(c.now, "p1", "USD")
+			want, _ := parseCalendarDate("2006/01/02", date)
+			if want.After(c.today) {
+				// A day after today in this zone is still in the future
+				if err == nil {
+					t.Errorf("%s: %s accepted before it has started", c.name, date)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: %s: %v", c.name, date, err)
+				continue
+			}
+			// Stored as midnight UTC, so it lines up with the UTC history bar
+			// of the same day whatever the zone
+			if !tx.Date.Equal(want) || tx.Date.Location() != time.UTC || !sameYMD(tx.Date, want.Add(14*time.Hour)) {
+				t.Errorf("%s: %s stored as %v, want %v", c.name, date, tx.Date, want)
+			}
+		}
+	}
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,17 +15,52 @@ import (
 // ===== DTOs =====
 
 type portfolioDTO struct {
-	Name    string `json:"name"`
-	BaseCCY string `json:"base_ccy,omitempty"`
+	Name    string   `json:"name"`
+	BaseCCY string   `json:"base_ccy,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
 }
 
 func (d portfolioDTO) validate() error {
 	if strings.TrimSpace(d.Name) == "" {
 		return errors.New("name is required")
 	}
+	if b := strings.TrimSpace(d.BaseCCY); b != "" && !isISOCurrency(b) {
+		return fmt.Errorf("unsupported base_ccy %q (use an ISO 4217 code, e.g. TWD, USD)", d.BaseCCY)
+	}
+	for _, t := range d.Tags {
+		if strings.Contains(t, ";") {
+			return fmt.Errorf("invalid tag %q (must not contain ';')", t)
+		}
+	}
 	return nil
 }
 
+// normalizeTags trims tags, drops empties and case-insensitive duplicates.
+func normalizeTags(tags []string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range tags {
+		t = strings.TrimSpace(t)
+		k := strings.ToLower(t)
+		if t == "" || seen[k] {
+			continue
+		}
+		seen[k] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// hasTag reports whether tags contains tag (case-insensitive).
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
 func (d portfolioDTO) toDomain(now time.Time, idOpt ...string) (Portfolio, error) {
 	if err := d.validate(); err != nil {
 		return Portfolio{}, err
@@ -39,12 +77,14 @@ func (d portfolioDTO) toDomain(now time.Time, idOpt ...string) (Portfolio, error
 		ID:        id,
 		Name:      strings.TrimSpace(d.Name),
 		BaseCCY:   base,
+		Tags:      normalizeTags(d.Tags),
 		CreatedAt: now,
 		UpdatedAt: now,
 	}, nil
 }
 
 type transactionDTO struct {
+	ID        string    `json:"id,omitempty"` // optional client-chosen id (UUID); ignored on update
 	Symbol    string    `json:"symbol"`
 	TradeType TradeType `json:"trade_type"`
 	Currency  string    `json:"currency"`
@@ -53,10 +93,149 @@ type transactionDTO struct {
 	Fee       float64   `json:"fee"`
 	Date      string    `json:"date"` // "2025/08/06"
 	Total     float64   `json:"total"`
+	Pending   bool      `json:"pending"` // planned trade, excluded from totals until executed
+	Note      string    `json:"note"`
+	CashKind  string    `json:"cash_kind"` // cash only: deposit|withdrawal|interest|fee
+	FXRate    float64   `json:"trade_fx_rate,omitempty"` // currency→REF_CCY on the trade date; looked up when omitted
 }
 
 const payloadDateLayout = "2006/01/02"
 
+// maxNoteLen bounds a transaction note, in characters.
+const maxNoteLen = 500
+
+// allowFutureDates lifts the check that transaction dates are not after
+// today (ALLOW_FUTURE_DATES=1). Future dates break the backtest day loop and
+// daily P/L, so they're rejected by default; pending transactions are exempt.
+var allowFutureDates = truthy(os.Getenv("ALLOW_FUTURE_DATES"))
+
+// deterministicIDs derives new transaction ids from their content instead of
+// drawing random ones (DETERMINISTIC_IDS=1), so re-importing an unchanged row
+// yields the same id and is caught as a duplicate rather than stored twice.
+var deterministicIDs = truthy(os.Getenv("DETERMINISTIC_IDS"))
+
+// strictTotals rejects buys and sells whose total doesn't match shares ×
+// price ± fee within totalsTolerance (STRICT_TOTALS=1). Otherwise they're
+// accepted and summaries report the gap per symbol as total_discrepancy.
+var strictTotals = truthy(os.Getenv("STRICT_TOTALS"))
+
+// totalsTolerance is the accepted gap, in the trade currency (TOTALS_TOLERANCE, default 0.01).
+var totalsTolerance = envNonNegativeFloat("TOTALS_TOLERANCE", 0.01)
+
+// totalDiscrepancy is how far |Total| is from shares × price, plus the fee on
+// a buy or minus it on a sell, in the trade currency. The price may be per
+// share or per contract, so the closer reading counts. Zero for other trade
+// types and for rows recorded without a price.
+func totalDiscrepancy(tx Transaction) float64 {
+	sign := 1.0
+	switch tx.TradeType {
+	case TradeTypeBuy:
+	case TradeTypeSell:
+		sign = -1
+	default:
+		return 0
+	}
+	if tx.Price == 0 {
+		return 0
+	}
+	fee := math.Abs(tx.Fee)
+	return math.Abs(math.Abs(tx.Total) - (tradeGross(tx) + sign*fee))
+}
+
+// tradeGross is shares × price of a buy or sell, in the trade currency. With
+// a contract multiplier the price may be per share (× multiplier) or per
+// contract; the reading closer to |Total| is used.
+func tradeGross(tx Transaction) float64 {
+	gross := math.Abs(tx.Shares * tx.Price)
+	if m := multiplierForSymbol(tx.Symbol); m != 1 {
+		if total := math.Abs(tx.Total); math.Abs(total-gross*m) < math.Abs(total-gross) {
+			gross *= m
+		}
+	}
+	return gross
+}
+
+// How buys and sells are booked into cost and proceeds (INVESTED_INCLUDES_FEES).
+const (
+	bookTotals   = iota // |total| as recorded (unset; the original behavior)
+	bookWithFees        // true: shares × price, plus the fee on buys and minus it on sells
+	bookNoFees          // false: shares × price; fees stay out of cost and proceeds
+)
+
+var tradeBooking = tradeBookingFromEnv(os.Getenv("INVESTED_INCLUDES_FEES"))
+
+func tradeBookingFromEnv(v string) int {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "1", "true", "yes":
+		return bookWithFees
+	case "0", "false", "no":
+		return bookNoFees
+	}
+	return bookTotals
+}
+
+// bookedAmount is what a buy adds to cost or a sell brings in as proceeds, in
+// the trade currency, per tradeBooking. Rows without a price, and other trade
+// types, book |Total|.
+func bookedAmount(tx Transaction) float64 {
+	total := math.Abs(tx.Total)
+	if tradeBooking == bookTotals || tx.Price == 0 {
+		return total
+	}
+	fee := 0.0
+	if tradeBooking == bookWithFees {
+		fee = math.Abs(tx.Fee)
+	}
+	switch tx.TradeType {
+	case TradeTypeBuy:
+		return tradeGross(tx) + fee
+	case TradeTypeSell:
+		return math.Max(0, tradeGross(tx)-fee)
+	}
+	return total
+}
+
+// txIDNamespace is the UUIDv5 namespace for deterministic transaction ids.
+var txIDNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/linchengweiii/stock-portfolios/transactions"))
+
+// deterministicTxID hashes the fields that identify a row in an import. Rows
+// equal on all of them (e.g. identical same-day fills) are told apart by n,
+// their occurrence in the request: the first keeps the plain id.
+func deterministicTxID(tx Transaction, n int) string {
+	key := strings.Join([]string{
+		tx.PortfolioID,
+		tx.Symbol,
+		string(tx.TradeType),
+		tx.Date.Format("2006-01-02"),
+		strconv.FormatFloat(tx.Shares, 'f', -1, 64),
+		strconv.FormatFloat(tx.Price, 'f', -1, 64),
+		strconv.FormatFloat(tx.Total, 'f', -1, 64),
+	}, "|")
+	if n > 1 {
+		key += "|#" + strconv.Itoa(n)
+	}
+	return uuid.NewSHA1(txIDNamespace, []byte(key)).String()
+}
+
+// derivedIDs counts the deterministic ids handed out within one request, so
+// repeated identical rows get distinct ids that are still the same on every
+// re-import of the request.
+type derivedIDs map[string]int
+
+// distinct returns tx's id, re-derived with its occurrence number when an
+// earlier row of the request derived the same one. Ids the client sent are
+// returned as they are.
+func (seen derivedIDs) distinct(d transactionDTO, tx Transaction) string {
+	if !deterministicIDs || strings.TrimSpace(d.ID) != "" {
+		return tx.ID
+	}
+	seen[tx.ID]++
+	if n := seen[tx.ID]; n > 1 {
+		return deterministicTxID(tx, n)
+	}
+	return tx.ID
+}
+
 func normalizeTradeType(tt TradeType) (TradeType, error) {
     switch strings.ToLower(string(tt)) {
     case "buy":
@@ -67,20 +246,35 @@ func normalizeTradeType(tt TradeType) (TradeType, error) {
         return TradeTypeDividend, nil
     case "cash":
         return TradeTypeCash, nil
+    case "transfer":
+        return TradeTypeTransfer, nil
     default:
-        return "", fmt.Errorf("unsupported trade_type: %q (use buy|sell|dividend|cash)", tt)
+        return "", fmt.Errorf("unsupported trade_type: %q (use buy|sell|dividend|cash|transfer)", tt)
     }
 }
 
-func (d transactionDTO) toDomain(now time.Time, portfolioID string, idOpt ...string) (Transaction, error) {
-    t, err := time.ParseInLocation(payloadDateLayout, d.Date, time.Local)
+// toDomain validates d and builds the transaction. baseCCY is the owning
+// portfolio's base currency, used when d leaves currency blank.
+func (d transactionDTO) toDomain(now time.Time, portfolioID, baseCCY string, idOpt ...string) (Transaction, error) {
+    t, err := parseCalendarDate(payloadDateLayout, d.Date)
 	if err != nil {
 		return Transaction{}, fmt.Errorf("invalid date %q (use YYYY/MM/DD): %w", d.Date, err)
 	}
+	if !allowFutureDates && !d.Pending {
+		if t.After(calendarDay(now)) {
+			return Transaction{}, fmt.Errorf("date %q is in the future (record it as pending, or set ALLOW_FUTURE_DATES=1)", d.Date)
+		}
+	}
 
-	id := uuid.New().String()
+	id := ""
 	if len(idOpt) > 0 && idOpt[0] != "" {
 		id = idOpt[0]
+	} else if v := strings.TrimSpace(d.ID); v != "" {
+		u, err := uuid.Parse(v)
+		if err != nil {
+			return Transaction{}, fmt.Errorf("invalid id %q (must be a UUID)", d.ID)
+		}
+		id = u.String()
 	}
     tt, err := normalizeTradeType(d.TradeType)
     if err != nil {
@@ -91,19 +285,131 @@ func (d transactionDTO) toDomain(now time.Time, portfolioID string, idOpt ...str
     if symbol == "" && tt != TradeTypeCash {
         return Transaction{}, errors.New("symbol is required")
     }
+    if tt == TradeTypeTransfer && d.Shares == 0 {
+        return Transaction{}, errors.New("transfer shares must be non-zero (positive in, negative out)")
+    }
+    kind, err := normalizeCashKind(tt, d.CashKind, d.Total)
+    if err != nil {
+        return Transaction{}, err
+    }
+    ccy := strings.ToUpper(strings.TrimSpace(d.Currency))
+    if ccy == "" {
+        ccy = strings.ToUpper(strings.TrimSpace(baseCCY))
+    }
+    note := strings.TrimSpace(d.Note)
+    if len([]rune(note)) > maxNoteLen {
+        return Transaction{}, fmt.Errorf("note is too long (max %d characters)", maxNoteLen)
+    }
+    if d.FXRate < 0 {
+        return Transaction{}, errors.New("trade_fx_rate must not be negative")
+    }
+    shares := d.Shares
+    if tt == TradeTypeBuy || tt == TradeTypeSell || tt == TradeTypeTransfer {
+        if shares, err = applySharesPrecision(symbol, shares); err != nil {
+            return Transaction{}, err
+        }
+    }
 
-	return Transaction{
+	tx := Transaction{
 		ID:          id,
 		PortfolioID: portfolioID,
 		Symbol:      symbol,
         TradeType:   tt,
-		Currency:    d.Currency,
-		Shares:      d.Shares,
+		Currency:    ccy,
+		Shares:      shares,
 		Price:       d.Price,
 		Fee:         d.Fee,
 		Date:        t,
 		Total:       d.Total,
 		CreatedAt:   now,
 		UpdatedAt:   now,
-	}, nil
+		Pending:     d.Pending,
+		Note:        note,
+		CashKind:    kind,
+		FXRate:      d.FXRate,
+	}
+	if strictTotals {
+		if gap := totalDiscrepancy(tx); gap > totalsTolerance {
+			return Transaction{}, fmt.Errorf("total %g is off from shares × price ± fee by %g (tolerance %g)", d.Total, gap, totalsTolerance)
+		}
+	}
+	if tx.ID == "" {
+		if deterministicIDs {
+			tx.ID = deterministicTxID(tx, 1)
+		} else {
+			tx.ID = uuid.New().String()
+		}
+	}
+	return tx, nil
+}
+
+// normalizeCashKind validates cash_kind: only cash transactions take one, and
+// Total must carry the kind's sign (money in positive, money out negative).
+func normalizeCashKind(tt TradeType, kind string, total float64) (string, error) {
+	k := strings.ToLower(strings.TrimSpace(kind))
+	if k == "" {
+		return "", nil
+	}
+	if tt != TradeTypeCash {
+		return "", errors.New("cash_kind is only valid for trade_type cash")
+	}
+	switch k {
+	case CashKindDeposit, CashKindInterest:
+		if total < 0 {
+			return "", fmt.Errorf("cash_kind %s needs a positive total", k)
+		}
+	case CashKindWithdrawal, CashKindFee:
+		if total > 0 {
+			return "", fmt.Errorf("cash_kind %s needs a negative total", k)
+		}
+	default:
+		return "", fmt.Errorf("unsupported cash_kind %q (use deposit|withdrawal|interest|fee)", kind)
+	}
+	return k, nil
+}
+
+// transferDTO moves shares from the URL's portfolio to ToPortfolioID, at
+// the source's average cost. CostBasis is kept only to reject clients that
+// still send one.
+type transferDTO struct {
+	ToPortfolioID string  `json:"to_portfolio_id"`
+	Symbol        string  `json:"symbol"`
+	Currency      string  `json:"currency"`
+	Shares        float64 `json:"shares"`
+	CostBasis     float64 `json:"cost_basis"`
+	Date          string  `json:"date"` // "2025/08/06"
+	Note          string  `json:"note"` // copied onto both legs
+}
+
+// toDomain builds the matched out/in pair; the out leg's shares are negative.
+// Their cost (Total and Price) is filled in from the source's average cost
+// when stored (see TransactionService.transferCost). A blank currency is
+// taken from baseCCY, the source portfolio's, for both legs.
+func (d transferDTO) toDomain(now time.Time, fromPortfolioID, baseCCY string) (out, in Transaction, err error) {
+	if strings.TrimSpace(d.ToPortfolioID) == "" {
+		return out, in, errors.New("to_portfolio_id is required")
+	}
+	if d.ToPortfolioID == fromPortfolioID {
+		return out, in, errors.New("to_portfolio_id must differ from the source portfolio")
+	}
+	if d.Shares <= 0 {
+		return out, in, errors.New("shares must be positive")
+	}
+	if d.CostBasis != 0 {
+		return out, in, errors.New("cost_basis is derived from the source portfolio's average cost; omit it")
+	}
+	leg := transactionDTO{
+		Symbol:    d.Symbol,
+		TradeType: TradeTypeTransfer,
+		Currency:  d.Currency,
+		Shares:    -d.Shares,
+		Date:      d.Date,
+		Note:      d.Note,
+	}
+	if out, err = leg.toDomain(now, fromPortfolioID, baseCCY); err != nil {
+		return out, in, err
+	}
+	leg.Shares = d.Shares
+	in, err = leg.toDomain(now, d.ToPortfolioID, baseCCY)
+	return out, in, err
 }
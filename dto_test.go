@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransactionCurrencyIsUpperCased(t *testing.T) {
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	want, err := buyTx("AAPL", "USD", 1, 100).toDomain(now, "p1", "TWD", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ccy := range []string{"usd", "Usd", " usd ", "USD\t"} {
+		got, err := buyTx("AAPL", ccy, 1, 100).toDomain(now, "p1", "TWD", "a")
+		if err != nil {
+			t.Errorf("%q: %v", ccy, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q converts to %+v, want %+v", ccy, got, want)
+		}
+	}
+}
+
+func TestFutureDatesRejected(t *testing.T) {
+	now := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name    string
+		date    string
+		pending bool
+		allow   bool
+		ok      bool
+	}{
+		{"past", "2025/01/02", false, false, true},
+		{"today", "2025/06/02", false, false, true},
+		{"tomorrow", "2025/06/03", false, false, false},
+		{"next year", "2026/01/01", false, false, false},
+		{"pending tomorrow", "2025/06/03", true, false, true},
+		{"allowed tomorrow", "2025/06/03", false, true, true},
+	}
+	old := allowFutureDates
+	t.Cleanup(func() { allowFutureDates = old })
+	for _, c := range cases {
+		allowFutureDates = c.allow
+		d := buyTx("AAPL", "USD", 1, 100)
+		d.Date, d.Pending = c.date, c.pending
+		_, err := d.toDomain(now, "p1", "USD")
+		if (err == nil) != c.ok {
+			t.Errorf("%s: err %v, want ok=%v", c.name, err, c.ok)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed is returned when an If-Match header no longer matches
+// the stored transaction (someone else changed it first).
+var ErrPreconditionFailed = errors.New("precondition failed: transaction was modified")
+
+// recordETag derives a strong ETag from v's JSON encoding, so any change to
+// the record (every write also bumps UpdatedAt) yields a new tag.
+func recordETag(v any) string {
+	b, _ := json.Marshal(v)
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+func transactionETag(tx Transaction) string { return recordETag(tx) }
+func portfolioETag(p Portfolio) string      { return recordETag(p) }
+
+// etagListHas reports whether a comma-separated If-Match/If-None-Match value
+// names want or is "*". Weak tags compare by their opaque part.
+func etagListHas(header, want string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == want || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatches reports whether an If-Match header value accepts tx. An empty
+// header means the client did not ask for a check.
+func etagMatches(ifMatch string, tx Transaction) bool {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" {
+		return true
+	}
+	return etagListHas(ifMatch, transactionETag(tx))
+}
+
+// notModified sets ETag and Last-Modified for a GET of a single record and,
+// when the client's copy is current, writes 304 and returns true.
+// If-None-Match takes precedence over If-Modified-Since (RFC 9110).
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	fresh := false
+	if inm := strings.TrimSpace(r.Header.Get("If-None-Match")); inm != "" {
+		fresh = etagListHas(inm, etag)
+	} else if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		fresh = !modified.Truncate(time.Second).After(t) // header has second precision
+	}
+	if fresh {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return fresh
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// staleReads serves GetByID from a snapshot taken before another write, as a
+// read racing that write would see it.
+type staleReads struct {
+	TransactionRepository
+	snapshot Transaction
+}
+
+func (r staleReads) GetByID(portfolioID, txID string) (Transaction, error) { return r.snapshot, nil }
+
+func TestIfMatchIsCheckedAtTheWrite(t *testing.T) {
+	pf, svc := newTestServices(t, nil, nil, "USD")
+	id := mustPortfolio(t, pf, svc, "USD")
+	dto := transactionDTO{TradeType: TradeTypeCash, Currency: "USD", Date: "2025/01/02", Total: 100}
+	orig, err := svc.CreateOne(id, dto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag := transactionETag(orig)
+	dto.Note = "first writer"
+	if _, err := svc.Update(id, orig.ID, dto, tag); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second writer read the row before the first writer's update landed.
+	racing := *svc
+	racing.repoTx = staleReads{svc.repoTx, orig}
+	dto.Note = "second writer"
+	if _, err := racing.Update(id, orig.ID, dto, tag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("update with a stale If-Match: err %v, want ErrPreconditionFailed", err)
+	}
+	if err := racing.Delete(id, orig.ID, tag); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("delete with a stale If-Match: err %v, want ErrPreconditionFailed", err)
+	}
+	cur, err := svc.Get(id, orig.ID)
+	if err != nil || cur.Note != "first writer" {
+		t.Errorf("stored row = %+v, %v; want the first writer's", cur, err)
+	}
+}
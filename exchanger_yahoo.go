@@ -5,40 +5,103 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 )
 
 type YahooExchanger struct {
-	http *http.Client
+	yahoo *yahooSession
+	ttl   time.Duration
+	mu    sync.RWMutex
+	cache map[string]cachedQuote // by pair, e.g. USDTWD
+	fails map[string]fxFailure   // recent failed lookups, by pair
+	hist  *YahooProvider         // daily closes for RateOn
+}
+
+// fxFailTTL is how long a failed rate lookup is answered from memory, so a
+// summary over many positions in that currency doesn't retry it each time.
+const fxFailTTL = 30 * time.Second
+
+type fxFailure struct {
+	err error
+	at  time.Time
 }
 
 func NewYahooExchanger() *YahooExchanger {
-	return &YahooExchanger{http: &http.Client{Timeout: 8 * time.Second}}
+	return &YahooExchanger{
+		yahoo: sharedYahooSession(),
+		ttl:   60 * time.Second,
+		cache: make(map[string]cachedQuote),
+		fails: make(map[string]fxFailure),
+		hist:  NewYahooProvider(),
+	}
+}
+
+// RateOn returns how many 'to' per 1 'from' at the daily close on or before date.
+func (y *YahooExchanger) RateOn(from, to string, date time.Time) (float64, time.Time, error) {
+	from = strings.ToUpper(strings.TrimSpace(from))
+	to = strings.ToUpper(strings.TrimSpace(to))
+	if from == "" || to == "" {
+		return 0, time.Time{}, fmt.Errorf("invalid currency")
+	}
+	if from == to {
+		return 1, date, nil
+	}
+	return y.hist.GetPriceOn(from+to+"=X", date)
 }
 
 // Rate returns how many 'to' per 1 'from' using Yahoo chart v8 (e.g., USDTWD=X).
 func (y *YahooExchanger) Rate(from, to string) (float64, time.Time, error) {
+	rate, asOf, _, err := y.RateDetail(from, to)
+	return rate, asOf, err
+}
+
+// RateDetail is Rate plus whether the rate was served from the cache.
+func (y *YahooExchanger) RateDetail(from, to string) (float64, time.Time, bool, error) {
 	from = strings.ToUpper(strings.TrimSpace(from))
 	to = strings.ToUpper(strings.TrimSpace(to))
 	if from == "" || to == "" {
-		return 0, time.Time{}, fmt.Errorf("invalid currency")
+		return 0, time.Time{}, false, fmt.Errorf("invalid currency")
 	}
 	if from == to {
-		return 1, time.Now(), nil
+		return 1, time.Now(), false, nil
+	}
+
+	y.mu.RLock()
+	if c, ok := y.cache[from+to]; ok && time.Since(c.fetched) < y.ttl {
+		y.mu.RUnlock()
+		return c.price, c.asOf, true, nil
 	}
+	if f, ok := y.fails[from+to]; ok && time.Since(f.at) < fxFailTTL {
+		y.mu.RUnlock()
+		return 0, time.Time{}, true, f.err
+	}
+	y.mu.RUnlock()
+
+	rate, asOf, err := y.fetch(from, to)
+	y.mu.Lock()
+	if err != nil {
+		y.fails[from+to] = fxFailure{err: err, at: time.Now()}
+		y.mu.Unlock()
+		return 0, time.Time{}, false, err
+	}
+	delete(y.fails, from+to)
+	y.cache[from+to] = cachedQuote{price: rate, asOf: asOf, fetched: time.Now()}
+	y.mu.Unlock()
+	return rate, asOf, false, nil
+}
 
+func (y *YahooExchanger) fetch(from, to string) (float64, time.Time, error) {
 	pair := from + to + "=X"
-	url := fmt.Sprintf("https://query2.finance.yahoo.com/v8/finance/chart/%s?interval=1h&range=1d", pair)
+	url := fmt.Sprintf("%s/v8/finance/chart/%s?interval=1h&range=1d", yahooBaseURL(), pair)
 
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
-	req.Header.Set("User-Agent", "stock-portfolios/1.0")
-	resp, err := y.http.Do(req)
+	resp, err := y.yahoo.get(url)
 	if err != nil {
 		return 0, time.Time{}, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return 0, time.Time{}, fmt.Errorf("yahoo fx http %d", resp.StatusCode)
+		return 0, time.Time{}, &httpStatusError{"yahoo fx", resp.StatusCode}
 	}
 
 	var raw struct {
@@ -48,6 +111,12 @@ func (y *YahooExchanger) Rate(from, to string) (float64, time.Time, error) {
 					RegularMarketPrice float64 `json:"regularMarketPrice"`
 					RegularMarketTime  int64   `json:"regularMarketTime"`
 				} `json:"meta"`
+				Timestamp  []int64 `json:"timestamp"`
+				Indicators struct {
+					Quote []struct {
+						Close []float64 `json:"close"`
+					} `json:"quote"`
+				} `json:"indicators"`
 			} `json:"result"`
 		} `json:"chart"`
 	}
@@ -55,11 +124,17 @@ func (y *YahooExchanger) Rate(from, to string) (float64, time.Time, error) {
 		return 0, time.Time{}, err
 	}
 	if len(raw.Chart.Result) == 0 {
-		return 0, time.Time{}, fmt.Errorf("fx rate not found")
+		return 0, time.Time{}, fmt.Errorf("fx rate not found: %w", ErrPriceNotFound)
+	}
+	r := raw.Chart.Result[0]
+	rate := r.Meta.RegularMarketPrice
+	asOf := time.Unix(r.Meta.RegularMarketTime, 0)
+	// Fallback: last valid hourly close if meta missing
+	if (rate <= 0 || r.Meta.RegularMarketTime == 0) && len(r.Indicators.Quote) > 0 {
+		if c, at, ok := lastYahooClose(r.Timestamp, r.Indicators.Quote[0].Close); ok {
+			rate, asOf = c, at
+		}
 	}
-	meta := raw.Chart.Result[0].Meta
-	rate := meta.RegularMarketPrice
-	asOf := time.Unix(meta.RegularMarketTime, 0)
 	if asOf.IsZero() {
 		asOf = time.Now()
 	}
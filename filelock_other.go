@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Without flock the store is only safe within a single process.
+func flockFile(f *os.File, exclusive bool) error { return nil }
+
+func funlockFile(f *os.File) error { return nil }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+/* ===================== Partial-fill aggregation ===================== */
+
+// FilledTransaction is a stored transaction with the fills merged into it
+// (the response of a create with ?aggregate_fills=1).
+type FilledTransaction struct {
+	Transaction
+	Fills []transactionDTO `json:"fills,omitempty"` // only when several rows were merged
+}
+
+type fillKey struct {
+	symbol, tradeType, currency, date string
+	pending                           bool
+}
+
+// aggregateFills merges buy and sell rows that share symbol, trade type,
+// currency, date and pending into one row per group, in the position of the
+// group's first row: shares, fee and total are summed and price is the
+// share-weighted average of the fills that have one (a fill without a price
+// doesn't drag it toward zero). Notes are joined, and the trade-date FX rate is
+// kept only when every fill has the same one. Rows with a client id and other
+// trade types pass through alone. groups[i] lists the input rows merged into
+// out[i].
+func aggregateFills(in []transactionDTO) (out []transactionDTO, groups [][]int) {
+	at := map[fillKey]int{}
+	priced := map[int]float64{} // by out index: shares of the fills with a price
+	for i, d := range in {
+		tt := strings.ToLower(strings.TrimSpace(string(d.TradeType)))
+		if strings.TrimSpace(d.ID) != "" || (tt != string(TradeTypeBuy) && tt != string(TradeTypeSell)) {
+			out = append(out, d)
+			groups = append(groups, []int{i})
+			continue
+		}
+		k := fillKey{
+			symbol:    strings.ToUpper(strings.TrimSpace(d.Symbol)),
+			tradeType: tt,
+			currency:  strings.ToUpper(strings.TrimSpace(d.Currency)),
+			date:      strings.TrimSpace(d.Date),
+			pending:   d.Pending,
+		}
+		j, ok := at[k]
+		if !ok {
+			at[k] = len(out)
+			if d.Price > 0 {
+				priced[len(out)] = d.Shares
+			}
+			out = append(out, d)
+			groups = append(groups, []int{i})
+			continue
+		}
+		m := &out[j]
+		if d.Price > 0 {
+			if shares := priced[j] + d.Shares; shares != 0 {
+				m.Price = (priced[j]*m.Price + d.Shares*d.Price) / shares
+			}
+			priced[j] += d.Shares
+		}
+		m.Shares += d.Shares
+		m.Fee += d.Fee
+		m.Total += d.Total
+		if note := strings.TrimSpace(d.Note); note != "" && !strings.Contains(m.Note, note) {
+			m.Note = strings.TrimPrefix(strings.TrimSpace(m.Note)+"; "+note, "; ")
+		}
+		if math.Abs(m.FXRate-d.FXRate) > 1e-12 {
+			m.FXRate = 0 // looked up for the date instead
+		}
+		groups[j] = append(groups[j], i)
+	}
+	return out, groups
+}
+
+// perInputRow maps a validation of aggregated rows back onto the input rows:
+// every fill of a merged row gets that row's outcome, under its own index.
+func perInputRow(v ValidateResponse, groups [][]int) ValidateResponse {
+	var rows []ValidateRow
+	v.Valid, v.Invalid = 0, 0
+	for i, r := range v.Rows {
+		for _, j := range groups[i] {
+			r.Index = j
+			rows = append(rows, r)
+			if r.OK {
+				v.Valid++
+			} else {
+				v.Invalid++
+			}
+		}
+	}
+	stableSort(rows, func(a, b ValidateRow) bool { return a.Index < b.Index })
+	v.Rows = rows
+	return v
+}
+
+// withFills pairs stored transactions with the input rows merged into each.
+func withFills(txs []Transaction, in []transactionDTO, groups [][]int) []FilledTransaction {
+	out := make([]FilledTransaction, len(txs))
+	for i, tx := range txs {
+		out[i].Transaction = tx
+		if i < len(groups) && len(groups[i]) > 1 {
+			for _, j := range groups[i] {
+				out[i].Fills = append(out[i].Fills, in[j])
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+func TestAggregateFillsPrice(t *testing.T) {
+	fill := func(shares, price float64) transactionDTO {
+		return transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Date: "2025/03/04", Shares: shares, Price: price}
+	}
+	cases := []struct {
+		name  string
+		in    []transactionDTO
+		price float64
+	}{
+		{"weighted", []transactionDTO{fill(10, 100), fill(30, 200)}, 175},
+		{"unpriced fill last", []transactionDTO{fill(10, 100), fill(10, 0)}, 100},
+		{"unpriced fill first", []transactionDTO{fill(10, 0), fill(10, 120), fill(30, 80)}, 90},
+		{"no price at all", []transactionDTO{fill(10, 0), fill(5, 0)}, 0},
+	}
+	for _, c := range cases {
+		out, groups := aggregateFills(c.in)
+		if len(out) != 1 || len(groups[0]) != len(c.in) {
+			t.Fatalf("%s: got %d rows %v, want one merged row", c.name, len(out), groups)
+		}
+		var shares float64
+		for _, d := range c.in {
+			shares += d.Shares
+		}
+		if out[0].Shares != shares {
+			t.Errorf("%s: shares %v, want %v", c.name, out[0].Shares, shares)
+		}
+		if !approx(out[0].Price, c.price) {
+			t.Errorf("%s: price %v, want %v", c.name, out[0].Price, c.price)
+		}
+	}
+}
+
+func TestValidateAggregatedFillsPerInputRow(t *testing.T) {
+	v := ValidateResponse{
+		Valid:   1,
+		Invalid: 1,
+		Rows: []ValidateRow{
+			{Index: 0, OK: true, ID: "a"},
+			{Index: 1, Error: "bad"},
+		},
+	}
+	// Input rows 0 and 2 merged into the first transaction, 1 and 3 into the second.
+	got := perInputRow(v, [][]int{{0, 2}, {1, 3}})
+	if got.Valid != 2 || got.Invalid != 2 {
+		t.Errorf("valid/invalid = %d/%d, want 2/2", got.Valid, got.Invalid)
+	}
+	want := []ValidateRow{
+		{Index: 0, OK: true, ID: "a"},
+		{Index: 1, Error: "bad"},
+		{Index: 2, OK: true, ID: "a"},
+		{Index: 3, Error: "bad"},
+	}
+	if len(got.Rows) != len(want) {
+		t.Fatalf("rows %+v, want %+v", got.Rows, want)
+	}
+	for i := range want {
+		if got.Rows[i] != want[i] {
+			t.Errorf("row %d = %+v, want %+v", i, got.Rows[i], want[i])
+		}
+	}
+}
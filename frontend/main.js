@@ -325,8 +325,8 @@ function wire(){
 
 async function inferPfRefCcy(pfId){
   try{
-    // Fetch all tx for the portfolio (no ref_ccy needed)
-    const url = state.baseUrl.replace(/\/$/,'') + `/portfolios/${pfId}/transactions?limit=0`;
+    // Fetch a page of tx for the portfolio (no ref_ccy needed)
+    const url = state.baseUrl.replace(/\/$/,'') + `/portfolios/${pfId}/transactions?limit=1000`; // capped by LIST_MAX_LIMIT; a sample is enough
     const res = await fetch(url);
     if(!res.ok) return null;
     const txs = await res.json();
@@ -305,7 +305,7 @@ function wire(){
 
 async function inferPfRefCcy(pfId){
   try{
-    const url = state.baseUrl.replace(/\/$/,'') + `/portfolios/${pfId}/transactions?limit=0`;
+    const url = state.baseUrl.replace(/\/$/,'') + `/portfolios/${pfId}/transactions?limit=1000`; // capped by LIST_MAX_LIMIT; a sample is enough
     const res = await fetch(url);
     if(!res.ok) return null;
     const txs = await res.json();
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FX audit: shows the rates the exchanger resolves, to tell an FX problem
+// from a pricing problem when a converted total looks wrong.
+
+type FXAuditItem struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Rate    float64   `json:"rate,omitempty"`
+	Inverse float64   `json:"inverse,omitempty"`
+	AsOf    time.Time `json:"as_of,omitempty"`
+	Cached  bool      `json:"cached"`
+	// UsedRate is what computations apply: 1.0 when the lookup fails
+	UsedRate float64 `json:"used_rate"`
+	Error    string  `json:"error,omitempty"`
+}
+
+type FXAuditResponse struct {
+	RefCurrency string        `json:"ref_currency"`
+	Rates       []FXAuditItem `json:"rates"`
+}
+
+// FXAudit resolves one from->to rate through the configured exchanger.
+func (s *TransactionService) FXAudit(from, to string) FXAuditItem {
+	from = strings.ToUpper(strings.TrimSpace(from))
+	to = strings.ToUpper(strings.TrimSpace(to))
+	it := FXAuditItem{From: from, To: to, UsedRate: 1.0}
+	if s.exchanger == nil {
+		it.Error = "no currency exchanger configured"
+		return it
+	}
+	type detailer interface {
+		RateDetail(from, to string) (float64, time.Time, bool, error)
+	}
+	var err error
+	if d, ok := s.exchanger.(detailer); ok {
+		it.Rate, it.AsOf, it.Cached, err = d.RateDetail(from, to)
+	} else {
+		it.Rate, it.AsOf, err = s.exchanger.Rate(from, to)
+	}
+	if err != nil || it.Rate <= 0 {
+		if err != nil {
+			it.Error = err.Error()
+		} else {
+			it.Error = "invalid fx rate"
+		}
+		it.Rate = 0
+		return it
+	}
+	it.Inverse = 1 / it.Rate
+	it.UsedRate = it.Rate
+	return it
+}
+
+// FXAuditAll resolves every transaction currency (across the portfolios in
+// scope) into the reference currency.
+func (s *TransactionService) FXAuditAll() (FXAuditResponse, error) {
+	pfs, err := s.listPortfolios()
+	if err != nil {
+		return FXAuditResponse{}, err
+	}
+	seen := map[string]bool{}
+	var ccys []string
+	for _, pf := range pfs {
+		txs, err := s.repoTx.List(pf.ID, ListFilter{Limit: 0})
+		if err != nil {
+			return FXAuditResponse{}, err
+		}
+		for _, tx := range txs {
+			c := strings.ToUpper(strings.TrimSpace(tx.Currency))
+			if c != "" && !seen[c] {
+				seen[c] = true
+				ccys = append(ccys, c)
+			}
+		}
+	}
+	stableSort(ccys, func(a, b string) bool { return a < b })
+	out := FXAuditResponse{RefCurrency: s.refCCY, Rates: make([]FXAuditItem, 0, len(ccys))}
+	for _, c := range ccys {
+		out.Rates = append(out.Rates, s.FXAudit(c, s.refCCY))
+	}
+	return out, nil
+}
+
+// GET /admin/fx?from=USD&to=TWD   single pair (to defaults to ref_ccy)
+// GET /admin/fx?ref_ccy=TWD&tag=x every transaction currency -> ref_ccy
+func (s *Server) handleAdminFX(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	ref, ok := refParam(w, r)
+	if !ok {
+		return
+	}
+	svc := s.tx.WithRef(ref).WithTag(q.Get("tag"))
+	if from := strings.TrimSpace(q.Get("from")); from != "" {
+		to := strings.TrimSpace(q.Get("to"))
+		if to == "" {
+			to = svc.refCCY
+		}
+		writeJSON(w, http.StatusOK, svc.FXAudit(from, to))
+		return
+	}
+	out, err := svc.FXAuditAll()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+holdings.csv (optional, under DATA_DIR)
+fund,symbol,weight_percent
+VT,AAPL,4.1
+VT,MSFT,3.9
+
+Used by ?look_through=1 on allocations to split fund positions into their
+constituents. Weights are percentages of the fund; whatever they don't cover
+stays under the fund symbol.
+*/
+
+const fundHoldingsFile = "holdings.csv"
+
+// FundWeight is one constituent of a fund and its share of the fund, in percent.
+type FundWeight struct {
+	Symbol        string
+	WeightPercent float64
+}
+
+// LoadFundHoldings reads fund -> constituent weights from path. A missing file
+// yields an empty map.
+func LoadFundHoldings(path string) (map[string][]FundWeight, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string][]FundWeight{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string][]FundWeight{}
+	total := map[string]float64{}
+	for i, row := range rows {
+		if len(row) < 3 {
+			continue
+		}
+		fund := strings.ToUpper(strings.TrimSpace(row[0]))
+		sym := strings.ToUpper(strings.TrimSpace(row[1]))
+		if i == 0 && fund == "FUND" {
+			continue // header
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(row[2]), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid weight %q", path, i+1, row[2])
+		}
+		if fund == "" || sym == "" || sym == fund || w == 0 {
+			continue
+		}
+		out[fund] = append(out[fund], FundWeight{Symbol: sym, WeightPercent: w})
+		total[fund] += w
+	}
+	for fund, t := range total {
+		if t > 100.0001 {
+			return nil, fmt.Errorf("%s: weights for %s add up to %.4g%% (more than 100%%)", path, fund, t)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+/* ===================== Projected dividend income ===================== */
+
+// IncomeItem is one open position's projected dividends for the next year.
+// Amounts are in the reference currency except AnnualDividendPerShare, which
+// is in the trade currency.
+type IncomeItem struct {
+	Symbol   string  `json:"symbol"`
+	Shares   float64 `json:"shares"`
+	Currency string  `json:"currency"`
+	// AnnualDividendPerShare sums each dividend of the trailing 12 months
+	// divided by the shares held when it was paid
+	AnnualDividendPerShare float64 `json:"annual_dividend_per_share"`
+	TrailingIncome         float64 `json:"trailing_income"`  // dividends actually received in the window
+	ProjectedIncome        float64 `json:"projected_income"` // shares × annual_dividend_per_share
+	MarketValue            float64 `json:"market_value"`
+	Invested               float64 `json:"invested"`
+	YieldPercent           float64 `json:"yield_percent"`         // on market value
+	YieldOnCostPercent     float64 `json:"yield_on_cost_percent"` // on invested
+
+	refCCY string // for rounding only; set by the enclosing response
+}
+
+type IncomeProjection struct {
+	From               time.Time    `json:"from"`
+	To                 time.Time    `json:"to"`
+	RefCurrency        string       `json:"ref_currency"`
+	TrailingIncome     float64      `json:"trailing_income"`
+	ProjectedIncome    float64      `json:"projected_income"`
+	TotalMarketValue   float64      `json:"total_market_value"`
+	TotalInvested      float64      `json:"total_invested"`
+	YieldPercent       float64      `json:"yield_percent"`
+	YieldOnCostPercent float64      `json:"yield_on_cost_percent"`
+	Items              []IncomeItem `json:"items"`
+	// Skipped lists symbols without a current price; their income is still
+	// projected, but they are left out of yield_percent
+	Skipped []SkippedSymbol `json:"skipped,omitempty"`
+}
+
+// ComputeIncomeProjection projects the next 12 months of dividends from the
+// trailing 12: each symbol's dividends per share held on the payment date,
+// summed, times the shares held now, at today's FX rate. Symbols that paid
+// nothing in the window project zero.
+func (s *TransactionService) ComputeIncomeProjection(portfolioID string) (IncomeProjection, error) {
+	if s.prices == nil {
+		return IncomeProjection{}, errors.New("no PriceProvider configured for the income projection")
+	}
+	if _, err := s.repoPf.GetByID(portfolioID); err != nil {
+		return IncomeProjection{}, ErrPortfolioNotFound
+	}
+	txs, err := s.repoTx.List(portfolioID, ListFilter{Limit: 0})
+	if err != nil {
+		return IncomeProjection{}, err
+	}
+	stableSort(txs, lessForPositions)
+
+	to := s.today()
+	from := to.AddDate(-1, 0, 0)
+	out := IncomeProjection{From: from, To: to, RefCurrency: s.refCCY}
+
+	type income struct {
+		shares   float64 // running share count
+		perShare float64 // trade currency
+		trailing float64 // reference currency
+	}
+	st := map[string]*income{}
+	for _, tx := range txs {
+		if tx.Date.After(to) {
+			break
+		}
+		if tx.TradeType == TradeTypeCash {
+			continue
+		}
+		sym := s.canonicalSymbol(tx.Symbol)
+		a := st[sym]
+		if a == nil {
+			a = &income{}
+			st[sym] = a
+		}
+		switch tx.TradeType {
+		case TradeTypeBuy, TradeTypeTransfer:
+			a.shares += tx.Shares
+		case TradeTypeSell:
+			a.shares -= tx.Shares
+		case TradeTypeDividend:
+			if !tx.Date.After(from) {
+				continue
+			}
+			amt := tx.Total
+			if amt < 0 {
+				amt = -amt
+			}
+			r, _ := s.rate(tx.Currency)
+			a.trailing += amt * r
+			if a.shares > 0 {
+				a.perShare += amt / a.shares
+			}
+		}
+	}
+
+	var pricedIncome float64
+	items := []IncomeItem{}
+	for sym, a := range s.aggregatePositions(txs) {
+		if a.shares <= 0 {
+			continue
+		}
+		it := IncomeItem{Symbol: sym, Shares: a.shares, Currency: a.currency, Invested: a.invested}
+		if inc := st[sym]; inc != nil {
+			it.AnnualDividendPerShare = inc.perShare
+			it.TrailingIncome = inc.trailing
+			r, _ := s.rate(a.currency)
+			it.ProjectedIncome = a.shares * inc.perShare * r
+		}
+		if price, _, err := s.priceFor(sym); err != nil {
+			out.Skipped = append(out.Skipped, SkippedSymbol{Symbol: sym, Reason: err.Error()})
+		} else {
+			fx, _ := s.valuationRate(sym, a)
+			it.MarketValue = a.shares * price * multiplierForSymbol(sym) * fx
+			pricedIncome += it.ProjectedIncome
+		}
+		if it.MarketValue > 0 {
+			it.YieldPercent = (it.ProjectedIncome / it.MarketValue) * 100.0
+		}
+		if it.Invested > 0 {
+			it.YieldOnCostPercent = (it.ProjectedIncome / it.Invested) * 100.0
+		}
+		out.ProjectedIncome += it.ProjectedIncome
+		out.TotalMarketValue += it.MarketValue
+		out.TotalInvested += it.Invested
+		items = append(items, it)
+	}
+	// Dividends from positions since closed still count as received
+	for _, a := range st {
+		out.TrailingIncome += a.trailing
+	}
+
+	if out.TotalMarketValue > 0 {
+		out.YieldPercent = (pricedIncome / out.TotalMarketValue) * 100.0
+	}
+	if out.TotalInvested > 0 {
+		out.YieldOnCostPercent = (out.ProjectedIncome / out.TotalInvested) * 100.0
+	}
+	stableSort(items, func(a, b IncomeItem) bool { return a.Symbol < b.Symbol })
+	stableSort(items, func(a, b IncomeItem) bool { return a.ProjectedIncome > b.ProjectedIncome })
+	out.Items = items
+	sortSkipped(out.Skipped)
+	return out, nil
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -11,38 +12,79 @@ func main() {
 	var pfRepo PortfolioRepository
 	var txRepo TransactionRepository
 
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+
 	repoKind := strings.ToLower(strings.TrimSpace(os.Getenv("REPO_KIND")))
 	switch repoKind {
 	case "memory":
+		activeConfig.repoKind = "memory"
 		mem := newMemoryStore()
 		pfRepo = NewMemoryPortfolioRepo(mem)
 		txRepo = NewMemoryTransactionRepo(mem)
 	default:
-		dataDir := os.Getenv("DATA_DIR")
-		if dataDir == "" {
-			dataDir = "./data"
-		}
+		activeConfig.repoKind = "csv"
 		store, err := NewCSVStore(dataDir)
 		if err != nil {
 			log.Fatalf("init csv store: %v", err)
 		}
+		// Bad rows don't stop startup, but they shouldn't go unnoticed
+		if errs := store.LoadErrors(); len(errs) > 0 {
+			log.Printf("csv store: %d row(s) could not be loaded cleanly (see GET /admin/load-errors)", len(errs))
+			for _, e := range errs {
+				log.Printf("  %s", e)
+			}
+		}
 		pfRepo = NewCSVPortfolioRepo(store)
 		txRepo = NewCSVTransactionRepo(store)
 	}
 
-	// Price provider selection
-	var priceProv PriceProvider
-	switch strings.ToLower(strings.TrimSpace(os.Getenv("PRICE_PROVIDER"))) {
-	case "alphavantage", "alpha", "av":
-		ap, err := NewAlphaVantageProviderFromEnv()
+	// Price providers by name; each gets its own circuit breaker so one
+	// failing feed doesn't block the others when routing.
+	providers := map[string]PriceProvider{
+		"yahoo": NewCircuitBreakerProviderFromEnv(NewYahooProvider()),
+	}
+	ap, avErr := NewAlphaVantageProviderFromEnv()
+	if avErr == nil {
+		providers["alphavantage"] = NewCircuitBreakerProviderFromEnv(ap)
+	}
+	defaultProv := canonicalProviderName(os.Getenv("PRICE_PROVIDER"))
+	if defaultProv == "alphavantage" && avErr != nil {
+		log.Printf("Alpha Vantage not configured (%v); falling back to Yahoo.", avErr)
+		defaultProv = "yahoo"
+	}
+	if _, ok := providers[defaultProv]; !ok {
+		defaultProv = "yahoo" // default to Yahoo
+	}
+	priceProv := providers[defaultProv]
+	activeConfig.priceProvider = defaultProv
+
+	// Optional per-symbol routing (e.g. TW funds -> alphavantage)
+	if v := strings.TrimSpace(os.Getenv("PRICE_ROUTES")); v != "" {
+		routes, err := LoadPriceRoutes(v)
+		if err != nil {
+			log.Fatalf("PRICE_ROUTES: %v", err)
+		}
+		rp, err := NewRoutingProvider(routes, providers, defaultProv)
 		if err != nil {
-			log.Printf("Alpha Vantage not configured (%v); falling back to Yahoo.", err)
-			priceProv = NewYahooProvider()
-		} else {
-			priceProv = ap
+			log.Fatalf("PRICE_ROUTES: %v", err)
 		}
-	default: // default to Yahoo
-		priceProv = NewYahooProvider()
+		priceProv = rp
+		activeConfig.priceProvider = defaultProv + " (routed)"
+		log.Printf("price routing enabled: %d route(s), default %s", len(routes), defaultProv)
+	}
+
+	// Optional manual marks for symbols no provider covers
+	marks, err := LoadMarks(filepath.Join(dataDir, marksFile))
+	if err != nil {
+		log.Fatalf("load marks: %v", err)
+	}
+	if len(marks) > 0 {
+		priceProv = NewOverlayProvider(priceProv, marks)
+		activeConfig.priceProvider += " + marks"
+		log.Printf("manual marks: %d symbol(s)", len(marks))
 	}
 
 	// Currency exchanger (Yahoo) and reference currency (default TWD; override via REF_CCY)
@@ -51,11 +93,70 @@ func main() {
 	if ref == "" {
 		ref = "TWD"
 	}
+	if !isISOCurrency(ref) {
+		log.Fatalf("REF_CCY: %q is not an ISO 4217 code", ref)
+	}
+	activeConfig.refCCY = ref
 
 	pfSvc := NewPortfolioService(pfRepo)
 	txSvc := NewTransactionService(txRepo, pfRepo, priceProv, ex, ref)
+	pfSvc.OnChange(txSvc.InvalidateSummaries)
+
+	// Optional symbol renames (e.g. FB -> META) applied to pricing/aggregation
+	aliases, err := LoadSymbolAliases(filepath.Join(dataDir, symbolAliasFile))
+	if err != nil {
+		log.Fatalf("load symbol aliases: %v", err)
+	}
+	txSvc.SetSymbolAliases(aliases)
+
+	// Optional fund constituents for look-through allocations
+	holdings, err := LoadFundHoldings(filepath.Join(dataDir, fundHoldingsFile))
+	if err != nil {
+		log.Fatalf("load fund holdings: %v", err)
+	}
+	txSvc.SetFundHoldings(holdings)
+
+	// Optional contract multiplier overrides (futures, index options, ...)
+	contractMultipliers, err = LoadMultipliers(filepath.Join(dataDir, multiplierFile))
+	if err != nil {
+		log.Fatalf("load multipliers: %v", err)
+	}
+	// Optional per-symbol share precision and lot sizes
+	sharesPrecision, err = LoadSharesPrecision(filepath.Join(dataDir, sharesPrecisionFile))
+	if err != nil {
+		log.Fatalf("load shares precision: %v", err)
+	}
+	// Optional per-symbol quote cache TTLs
+	priceTTLs, err = LoadPriceTTLs(filepath.Join(dataDir, priceTTLFile))
+	if err != nil {
+		log.Fatalf("load price ttls: %v", err)
+	}
+	if err := txSvc.SetCashOrdering(os.Getenv("CASH_ORDERING")); err != nil {
+		log.Fatalf("CASH_ORDERING: %v", err)
+	}
+
+	// Optional demo data for a first run (SEED=1); skipped once any portfolio exists
+	if truthy(os.Getenv("SEED")) {
+		seeded, err := SeedSampleData(pfSvc, txSvc)
+		if err != nil {
+			log.Fatalf("seed sample data: %v", err)
+		}
+		if seeded {
+			log.Println("seeded sample portfolios (SEED=1)")
+		}
+	}
+
+	// Purge soft-deleted transactions past their retention window
+	startDeleteSweep(txSvc, deleteRetentionFromEnv())
+
+	// Optional outbound webhook on transaction changes
+	if wh, err := NewWebhookNotifierFromEnv(); err == nil {
+		txSvc.Subscribe(wh)
+		log.Println("webhook enabled for transaction changes")
+	}
 
 	srv := NewServer(pfSvc, txSvc)
+	srv.SetSymbolSearcher(NewYahooSymbolSearch())
 
 	log.Println("listening on :8080")
 	log.Fatal(http.ListenAndServe(":8080", srv))
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+multipliers.csv (optional, under DATA_DIR)
+symbol,multiplier
+ES=F,50
+NIY*,500
+
+Patterns work as in the other per-symbol tables (see symbol_patterns.go).
+Symbols without a match fall back to 100 for OCC-style options and 1 for
+everything else.
+*/
+
+const multiplierFile = "multipliers.csv"
+
+// contractMultipliers holds the overrides consulted by multiplierForSymbol.
+// It is set once during wiring and only read afterwards.
+var contractMultipliers = symbolTable[float64]{}
+
+// LoadMultipliers reads symbol-or-pattern -> contract multiplier overrides
+// from path. A missing file yields an empty table.
+func LoadMultipliers(path string) (symbolTable[float64], error) {
+	return loadSymbolTable(path, func(cols []string) (float64, error) {
+		m, err := strconv.ParseFloat(strings.TrimSpace(cols[0]), 64)
+		if err != nil || m <= 0 {
+			return 0, fmt.Errorf("invalid multiplier %q", cols[0])
+		}
+		return m, nil
+	})
+}
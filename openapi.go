@@ -0,0 +1,23 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openapiSpec is the hand-maintained OpenAPI 3 document for the HTTP API.
+// Keep openapi.json in step with server.go routes and the response structs.
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+// GET /openapi.json serves the embedded spec
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openapiSpec)
+}
@@ -0,0 +1,98 @@
+package main
+
+/* ===================== Raw holdings (no pricing) ===================== */
+
+// HoldingItem is an open position as recorded: share count and average cost
+// in the trade currency. Nothing is priced or converted.
+type HoldingItem struct {
+	Symbol   string  `json:"symbol"`
+	Shares   float64 `json:"shares"`
+	Currency string  `json:"currency"`
+	AvgCost  float64 `json:"avg_cost"` // per share (per contract for options), 0 when closed
+}
+
+// Per-portfolio
+func (s *TransactionService) ComputeHoldings(portfolioID string, includeClosed bool) ([]HoldingItem, error) {
+	pf, err := s.repoPf.GetByID(portfolioID)
+	if err != nil {
+		return nil, ErrPortfolioNotFound
+	}
+	txs, err := s.repoTx.List(portfolioID, ListFilter{Limit: 0})
+	if err != nil {
+		return nil, err
+	}
+	return s.holdingsFromTxs(txs, map[string]string{pf.ID: pf.BaseCCY}, includeClosed), nil
+}
+
+// Global (all portfolios, honoring WithTag)
+func (s *TransactionService) ComputeHoldingsAll(includeClosed bool) ([]HoldingItem, error) {
+	pfs, err := s.listPortfolios()
+	if err != nil {
+		return nil, err
+	}
+	var all []Transaction
+	baseCCY := make(map[string]string, len(pfs))
+	for _, pf := range pfs {
+		txs, err := s.repoTx.List(pf.ID, ListFilter{Limit: 0})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, txs...)
+		baseCCY[pf.ID] = pf.BaseCCY
+	}
+	return s.holdingsFromTxs(all, baseCCY, includeClosed), nil
+}
+
+// holdingsFromTxs runs the usual average-cost aggregation with FX switched
+// off, so costs stay in the currency they were recorded in and no exchanger
+// call is made. There is one row per symbol. A row recorded without a
+// currency is taken to be in the symbol's currency from its other rows, or
+// else in its portfolio's base currency (baseCCY, by portfolio ID). A symbol
+// still recorded in several currencies has no single-currency cost, so its
+// avg_cost is 0.
+func (s *TransactionService) holdingsFromTxs(txs []Transaction, baseCCY map[string]string, includeClosed bool) []HoldingItem {
+	native := *s
+	native.exchanger = nil
+
+	symCCY := map[string]string{}
+	for _, tx := range txs {
+		if c := ccyKey(tx.Currency); c != "" {
+			symCCY[s.canonicalSymbol(tx.Symbol)] = c
+		}
+	}
+	rows := make([]Transaction, len(txs))
+	ccys := map[string]map[string]bool{}
+	for i, tx := range txs {
+		sym := s.canonicalSymbol(tx.Symbol)
+		tx.Currency = ccyKey(tx.Currency)
+		if tx.Currency == "" {
+			if c, ok := symCCY[sym]; ok {
+				tx.Currency = c
+			} else {
+				tx.Currency = ccyKey(baseCCY[tx.PortfolioID])
+			}
+		}
+		if ccys[sym] == nil {
+			ccys[sym] = map[string]bool{}
+		}
+		ccys[sym][tx.Currency] = true
+		rows[i] = tx
+	}
+
+	out := []HoldingItem{}
+	for sym, a := range native.aggregatePositions(rows) {
+		if a.shares < 0 && !allowShorts {
+			continue // oversold; dropped as in summaries
+		}
+		if a.shares == 0 && !includeClosed {
+			continue
+		}
+		it := HoldingItem{Symbol: sym, Shares: a.shares, Currency: a.currency}
+		if a.shares != 0 && len(ccys[sym]) == 1 {
+			it.AvgCost = a.invested / a.shares
+		}
+		out = append(out, it)
+	}
+	stableSort(out, func(a, b HoldingItem) bool { return a.Symbol < b.Symbol })
+	return out
+}
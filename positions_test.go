@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoldingsOneRowPerSymbol(t *testing.T) {
+	_, svc := newTestServices(t, fixedPrices{}, nil, "USD")
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	trade := func(pf, sym string, tt TradeType, ccy string, shares, total float64, d int) Transaction {
+		return Transaction{ID: sym + ccy + string(tt), PortfolioID: pf, Symbol: sym, TradeType: tt, Currency: ccy, Shares: shares, Total: total, Date: day(d)}
+	}
+	cases := []struct {
+		name string
+		txs  []Transaction
+		want HoldingItem
+	}{
+		{
+			"blank currency takes the symbol's",
+			[]Transaction{trade("p1", "AAPL", TradeTypeBuy, "USD", 10, -1000, 2), trade("p1", "AAPL", TradeTypeSell, "", 4, 480, 3)},
+			HoldingItem{Symbol: "AAPL", Shares: 6, Currency: "USD", AvgCost: 100},
+		},
+		{
+			"blank currency everywhere takes the base currency",
+			[]Transaction{trade("p2", "2330.TW", TradeTypeBuy, "", 1000, -500000, 2)},
+			HoldingItem{Symbol: "2330.TW", Shares: 1000, Currency: "TWD", AvgCost: 500},
+		},
+		{
+			"two currencies stay one row",
+			[]Transaction{trade("p1", "TSM", TradeTypeBuy, "USD", 10, -1000, 2), trade("p1", "TSM", TradeTypeBuy, "TWD", 5, -15000, 3)},
+			HoldingItem{Symbol: "TSM", Shares: 15, Currency: "TWD"},
+		},
+	}
+	base := map[string]string{"p1": "USD", "p2": "TWD"}
+	for _, c := range cases {
+		got := svc.holdingsFromTxs(c.txs, base, false)
+		if len(got) != 1 {
+			t.Errorf("%s: %d rows %+v, want one", c.name, len(got), got)
+			continue
+		}
+		if g := got[0]; g.Symbol != c.want.Symbol || g.Currency != c.want.Currency || !approx(g.Shares, c.want.Shares) || !approx(g.AvgCost, c.want.AvgCost) {
+			t.Errorf("%s: %+v, want %+v", c.name, g, c.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+shares_precision.csv (optional, under DATA_DIR)
+symbol,decimals,lot
+*.TW,0
+*.TWO,0
+2330.TW,0,1000
+BRK*,0
+
+decimals is how many decimal places a quantity may have; lot, when given, is
+the size every quantity must be a whole multiple of. Patterns work as in the
+other per-symbol tables (see symbol_patterns.go). Symbols without a match take
+any quantity.
+*/
+
+const sharesPrecisionFile = "shares_precision.csv"
+
+// sharesPrecision holds the rules consulted by applySharesPrecision.
+// It is set once during wiring and only read afterwards.
+var sharesPrecision = symbolTable[precisionRule]{}
+
+// rejectImpreciseShares rejects quantities with more decimals than their rule
+// allows (SHARES_PRECISION_MODE=reject). By default they're rounded instead.
+var rejectImpreciseShares = strings.EqualFold(strings.TrimSpace(os.Getenv("SHARES_PRECISION_MODE")), "reject")
+
+type precisionRule struct {
+	decimals int
+	lot      float64 // 0: no lot size
+}
+
+// applySharesPrecision rounds shares to the rule for symbol, or rejects them
+// when rejectImpreciseShares is set, and checks the lot size. Quantities
+// within 1e-9 of the rounded value (float noise) are always accepted.
+func applySharesPrecision(symbol string, shares float64) (float64, error) {
+	r, ok := sharesPrecision.lookup(symbol)
+	if !ok || shares == 0 {
+		return shares, nil
+	}
+	scale := math.Pow(10, float64(r.decimals))
+	rounded := math.Round(shares*scale) / scale
+	if math.Abs(rounded-shares) > 1e-9 {
+		if rejectImpreciseShares {
+			return 0, fmt.Errorf("shares %g of %s allow at most %d decimal places", shares, symbol, r.decimals)
+		}
+		if rounded == 0 {
+			return 0, fmt.Errorf("shares %g of %s round to zero at %d decimal places", shares, symbol, r.decimals)
+		}
+	}
+	if r.lot > 0 {
+		if n := rounded / r.lot; math.Abs(n-math.Round(n)) > 1e-9 {
+			return 0, fmt.Errorf("shares %g of %s are not a multiple of the lot size %g", rounded, symbol, r.lot)
+		}
+	}
+	return rounded, nil
+}
+
+// LoadSharesPrecision reads symbol-or-pattern -> precision rules from path.
+// A missing file yields an empty table.
+func LoadSharesPrecision(path string) (symbolTable[precisionRule], error) {
+	return loadSymbolTable(path, func(cols []string) (precisionRule, error) {
+		var rule precisionRule
+		var err error
+		rule.decimals, err = strconv.Atoi(strings.TrimSpace(cols[0]))
+		if err != nil || rule.decimals < 0 || rule.decimals > 8 {
+			return rule, fmt.Errorf("invalid decimals %q (use 0-8)", cols[0])
+		}
+		if len(cols) > 1 && strings.TrimSpace(cols[1]) != "" {
+			rule.lot, err = strconv.ParseFloat(strings.TrimSpace(cols[1]), 64)
+			if err != nil || rule.lot <= 0 {
+				return rule, fmt.Errorf("invalid lot %q", cols[1])
+			}
+		}
+		return rule, nil
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+price_ttl.csv (optional, under DATA_DIR)
+symbol,ttl
+0050.TW,1h
+TSLA,5s
+*.TWO,30m
+
+How long a live quote stays cached, per symbol, as a Go duration. Patterns
+work as in the other per-symbol tables (see symbol_patterns.go) and are
+matched against the symbol both as recorded and in its Yahoo form, so BRK.B*
+and BRK-B* both cover BRK.B. Symbols without a row keep the provider's
+default (60s).
+*/
+
+const priceTTLFile = "price_ttl.csv"
+
+// priceTTLs holds the overrides consulted by the providers' quote caches.
+// It is set once during wiring and only read afterwards.
+var priceTTLs = symbolTable[time.Duration]{}
+
+// priceTTLFor returns the quote cache TTL for sym, or def when no row
+// matches.
+func priceTTLFor(sym string, def time.Duration) time.Duration {
+	if d, ok := priceTTLs.lookupAny(sym, yahooSymbol(sym)); ok {
+		return d
+	}
+	return def
+}
+
+// LoadPriceTTLs reads symbol-or-pattern -> quote TTL overrides from path. A
+// missing file yields an empty table.
+func LoadPriceTTLs(path string) (symbolTable[time.Duration], error) {
+	return loadSymbolTable(path, func(cols []string) (time.Duration, error) {
+		d, err := time.ParseDuration(strings.TrimSpace(cols[0]))
+		if err != nil || d <= 0 {
+			return 0, fmt.Errorf("invalid ttl %q (use a duration such as 30s or 1h)", cols[0])
+		}
+		return d, nil
+	})
+}
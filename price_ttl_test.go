@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPriceTTLMatchesBothSymbolForms(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, priceTTLFile, "symbol,ttl\nBRK.*,10s\nRDS-A,20s\n*.TWO,30m\nBRK.B,5s\n")
+	tbl, err := LoadPriceTTLs(filepath.Join(dir, priceTTLFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := priceTTLs
+	priceTTLs = tbl
+	t.Cleanup(func() { priceTTLs = old })
+
+	def := time.Minute
+	cases := []struct {
+		sym  string
+		want time.Duration
+	}{
+		{"BRK.B", 5 * time.Second},  // exact row
+		{"BRK.A", 10 * time.Second}, // prefix on the recorded form
+		{"RDS.A", 20 * time.Second}, // exact row in Yahoo form
+		{"6488.TWO", 30 * time.Minute},
+		{"AAPL", def},
+	}
+	for _, c := range cases {
+		if got := priceTTLFor(c.sym, def); got != c.want {
+			t.Errorf("priceTTLFor(%q) = %v, want %v", c.sym, got, c.want)
+		}
+	}
+}
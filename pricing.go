@@ -7,14 +7,60 @@ type PriceProvider interface {
     GetPrice(symbol string) (price float64, asOf time.Time, err error)
 }
 
+// Quote is a live quote with the session context some providers include.
+type Quote struct {
+    Price         float64
+    AsOf          time.Time
+    PreviousClose float64 // prior session close; 0 when unknown
+    ChangePercent float64 // change vs PreviousClose, in percent
+    Currency      string  // quote currency as listed (e.g. "TWD", "GBp"); empty when unknown
+}
+
+// QuoteProvider optionally returns the extended Quote. It lets daily P/L work
+// from spot quotes alone when no daily history is available.
+type QuoteProvider interface {
+    GetQuote(symbol string) (Quote, error)
+}
+
 // CurrencyExchanger converts money from one currency into another.
 type CurrencyExchanger interface {
     // Rate returns how many 'to' units per 1 'from' unit. (amount_in_to = amount_in_from * rate)
     Rate(from, to string) (rate float64, asOf time.Time, err error)
 }
 
+// HistoryExchanger optionally returns the rate as of a past date (the last
+// daily close at or before it). Used to record the rate in force on a trade date.
+type HistoryExchanger interface {
+    RateOn(from, to string, date time.Time) (rate float64, asOf time.Time, err error)
+}
+
 // HistoryProvider optionally provides daily historical prices.
 // Implementations should return the last available CLOSE price at or before the given date.
 type HistoryProvider interface {
     GetPriceOn(symbol string, date time.Time) (price float64, asOf time.Time, err error)
 }
+
+// BasisHistoryProvider optionally provides daily prices on an explicit basis ("open" or "close").
+type BasisHistoryProvider interface {
+    GetPriceOnBasis(symbol string, date time.Time, basis string) (price float64, asOf time.Time, err error)
+}
+
+// DailySeriesProvider optionally returns a symbol's whole daily history at once:
+// ascending bar days (midnight UTC) and their prices on basis ("open" or
+// "close"). Loops over many days index it instead of calling GetPriceOn per day.
+type DailySeriesProvider interface {
+    DailySeries(symbol, basis string) (days []time.Time, prices []float64, err error)
+}
+
+// SymbolMatch is one result of a symbol lookup.
+type SymbolMatch struct {
+    Symbol   string `json:"symbol"`
+    Name     string `json:"name"`
+    Exchange string `json:"exchange"`
+    Type     string `json:"type"` // e.g. EQUITY, ETF, MUTUALFUND
+}
+
+// SymbolSearcher looks up tradable symbols by free-text query.
+type SymbolSearcher interface {
+    SearchSymbols(query string) ([]SymbolMatch, error)
+}
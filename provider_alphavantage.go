@@ -30,9 +30,12 @@ type AlphaVantageProvider struct {
 }
 
 type cachedQuote struct {
-	price   float64
-	asOf    time.Time
-	fetched time.Time
+	price     float64
+	asOf      time.Time
+	fetched   time.Time
+	prevClose float64 // Yahoo only
+	changePct float64 // Yahoo only
+	currency  string  // Yahoo only
 }
 
 func NewAlphaVantageProviderFromEnv() (*AlphaVantageProvider, error) {
@@ -56,7 +59,7 @@ func (p *AlphaVantageProvider) GetPrice(symbol string) (float64, time.Time, erro
 
 	// cache hit?
 	p.mu.RLock()
-	if c, ok := p.cache[symbol]; ok && time.Since(c.fetched) < p.ttl {
+	if c, ok := p.cache[symbol]; ok && time.Since(c.fetched) < priceTTLFor(symbol, p.ttl) {
 		p.mu.RUnlock()
 		return c.price, c.asOf, nil
 	}
@@ -73,7 +76,7 @@ func (p *AlphaVantageProvider) GetPrice(symbol string) (float64, time.Time, erro
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, time.Time{}, fmt.Errorf("alphavantage http %d", resp.StatusCode)
+		return 0, time.Time{}, &httpStatusError{"alphavantage", resp.StatusCode}
 	}
 
 	var raw map[string]any
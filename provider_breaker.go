@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker around a PriceProvider. Only upstream trouble counts as a
+// failure (see breakerFailure); a symbol the provider has no data for is an
+// answer, not an outage. After maxFailures consecutive failures within
+// window the circuit opens and calls fail fast with ErrPriceNotFound for
+// cooldown; then a single half-open probe is let through and its outcome
+// closes or re-opens the circuit.
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+type BreakerStatus struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"`
+}
+
+type CircuitBreakerProvider struct {
+	inner       PriceProvider
+	maxFailures int
+	window      time.Duration
+	cooldown    time.Duration
+
+	mu           sync.Mutex
+	state        string
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+	lastErr      string
+}
+
+// historyBreakerProvider keeps the HistoryProvider capability of the wrapped
+// provider visible to type assertions.
+type historyBreakerProvider struct {
+	*CircuitBreakerProvider
+	hist HistoryProvider
+}
+
+// NewCircuitBreakerProviderFromEnv wraps p using PRICE_BREAKER_FAILURES
+// (default 5; 0 disables), PRICE_BREAKER_WINDOW (default 30s) and
+// PRICE_BREAKER_COOLDOWN (default 30s).
+func NewCircuitBreakerProviderFromEnv(p PriceProvider) PriceProvider {
+	n := 5
+	if v := strings.TrimSpace(os.Getenv("PRICE_BREAKER_FAILURES")); v != "" {
+		if x, err := strconv.Atoi(v); err == nil && x >= 0 {
+			n = x
+		}
+	}
+	if n == 0 {
+		return p
+	}
+	return NewCircuitBreakerProvider(p, n,
+		envDuration("PRICE_BREAKER_WINDOW", 30*time.Second),
+		envDuration("PRICE_BREAKER_COOLDOWN", 30*time.Second))
+}
+
+func NewCircuitBreakerProvider(p PriceProvider, maxFailures int, window, cooldown time.Duration) PriceProvider {
+	b := &CircuitBreakerProvider{
+		inner:       p,
+		maxFailures: maxFailures,
+		window:      window,
+		cooldown:    cooldown,
+		state:       BreakerClosed,
+	}
+	if hp, ok := p.(HistoryProvider); ok {
+		return &historyBreakerProvider{CircuitBreakerProvider: b, hist: hp}
+	}
+	return b
+}
+
+func (b *CircuitBreakerProvider) GetPrice(symbol string) (float64, time.Time, error) {
+	if !b.allow() {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	price, asOf, err := b.inner.GetPrice(symbol)
+	b.record(err)
+	return price, asOf, err
+}
+
+// GetQuote passes through to the inner provider's extended quote, if any.
+func (b *CircuitBreakerProvider) GetQuote(symbol string) (Quote, error) {
+	qp, ok := b.inner.(QuoteProvider)
+	if !ok {
+		return Quote{}, ErrPriceNotFound
+	}
+	if !b.allow() {
+		return Quote{}, ErrPriceNotFound
+	}
+	q, err := qp.GetQuote(symbol)
+	b.record(err)
+	return q, err
+}
+
+func (h *historyBreakerProvider) GetPriceOn(symbol string, date time.Time) (float64, time.Time, error) {
+	if !h.allow() {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	price, asOf, err := h.hist.GetPriceOn(symbol, date)
+	h.record(err)
+	return price, asOf, err
+}
+
+func (h *historyBreakerProvider) GetPriceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
+	bp, ok := h.inner.(BasisHistoryProvider)
+	if !ok {
+		return h.GetPriceOn(symbol, date)
+	}
+	if !h.allow() {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	price, asOf, err := bp.GetPriceOnBasis(symbol, date, basis)
+	h.record(err)
+	return price, asOf, err
+}
+
+// DailySeries passes through to the inner provider's series, if any.
+func (h *historyBreakerProvider) DailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+	sp, ok := h.inner.(DailySeriesProvider)
+	if !ok {
+		return nil, nil, ErrPriceNotFound
+	}
+	if !h.allow() {
+		return nil, nil, ErrPriceNotFound
+	}
+	days, prices, err := sp.DailySeries(symbol, basis)
+	h.record(err)
+	return days, prices, err
+}
+
+func (b *CircuitBreakerProvider) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false // one probe at a time
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// httpStatusError is a non-200 reply from an upstream API. Its message keeps
+// the "<source> http <code>" form.
+type httpStatusError struct {
+	source string
+	code   int
+}
+
+func (e *httpStatusError) Error() string { return fmt.Sprintf("%s http %d", e.source, e.code) }
+
+// breakerFailure reports whether err means the upstream itself is in
+// trouble: transport errors, timeouts, 5xx and 429. Per-symbol misses (no
+// such symbol, no data, other 4xx) are not failures.
+func breakerFailure(err error) bool {
+	if err == nil || errors.Is(err, ErrPriceNotFound) || errors.Is(err, ErrYahooNoResult) {
+		return false
+	}
+	var se *httpStatusError
+	if errors.As(err, &se) {
+		return se.code >= 500 || se.code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+func (b *CircuitBreakerProvider) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if !breakerFailure(err) {
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+		return
+	}
+	b.lastErr = err.Error()
+	if b.state == BreakerHalfOpen {
+		b.state = BreakerOpen
+		b.openedAt = now
+		b.probing = false
+		return
+	}
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= b.maxFailures {
+		b.state = BreakerOpen
+		b.openedAt = now
+	}
+}
+
+// Status reports the current breaker state.
+func (b *CircuitBreakerProvider) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := BreakerStatus{State: b.state, ConsecutiveFailures: b.failures, LastError: b.lastErr}
+	if b.state != BreakerClosed {
+		opened := b.openedAt
+		retry := b.openedAt.Add(b.cooldown)
+		st.OpenedAt = &opened
+		st.RetryAt = &retry
+	}
+	return st
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
+}
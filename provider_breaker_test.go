@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBreakerFailure(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"not found", ErrPriceNotFound, false},
+		{"wrapped not found", fmt.Errorf("fx rate not found: %w", ErrPriceNotFound), false},
+		{"yahoo no result", ErrYahooNoResult, false},
+		{"404", &httpStatusError{"yahoo", 404}, false},
+		{"429", &httpStatusError{"yahoo", 429}, true},
+		{"503", &httpStatusError{"yahoo", 503}, true},
+		{"timeout", &net.DNSError{Err: "timeout", IsTimeout: true}, true},
+		{"other", errors.New("connection reset"), true},
+	}
+	for _, c := range cases {
+		if got := breakerFailure(c.err); got != c.want {
+			t.Errorf("%s: breakerFailure(%v) = %v, want %v", c.name, c.err, got, c.want)
+		}
+	}
+}
+
+type errProvider struct{ err error }
+
+func (p errProvider) GetPrice(string) (float64, time.Time, error) { return 0, time.Time{}, p.err }
+
+func TestBreakerIgnoresSymbolMisses(t *testing.T) {
+	b := NewCircuitBreakerProvider(errProvider{ErrPriceNotFound}, 2, time.Minute, time.Minute).(*CircuitBreakerProvider)
+	for i := 0; i < 5; i++ {
+		b.GetPrice("DELISTED")
+	}
+	if st := b.Status(); st.State != BreakerClosed {
+		t.Fatalf("state after misses = %s, want closed", st.State)
+	}
+
+	b = NewCircuitBreakerProvider(errProvider{&httpStatusError{"yahoo", 502}}, 2, time.Minute, time.Minute).(*CircuitBreakerProvider)
+	b.GetPrice("AAPL")
+	b.GetPrice("AAPL")
+	if st := b.Status(); st.State != BreakerOpen {
+		t.Fatalf("state after 5xx = %s, want open", st.State)
+	}
+}
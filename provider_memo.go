@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// priceMemo remembers every answer (errors included) of the wrapped provider
+// for its lifetime, so computations that share one, such as the parts of a
+// dashboard, fetch each price once. It is meant for a single request: nothing
+// expires.
+type priceMemo struct {
+	inner PriceProvider
+
+	mu     sync.Mutex
+	prices map[string]memoPrice
+	quotes map[string]memoQuote
+	closes map[memoDay]memoPrice
+	bases  map[memoBasisDay]memoPrice
+	series map[memoSeriesKey]memoSeries
+}
+
+type memoPrice struct {
+	price float64
+	asOf  time.Time
+	err   error
+}
+
+type memoQuote struct {
+	q   Quote
+	err error
+}
+
+type memoDay struct {
+	symbol string
+	day    time.Time
+}
+
+type memoBasisDay struct {
+	memoDay
+	basis string
+}
+
+type memoSeriesKey struct{ symbol, basis string }
+
+type memoSeries struct {
+	days   []time.Time
+	prices []float64
+	err    error
+}
+
+// The variants keep the QuoteProvider and HistoryProvider capabilities of
+// the wrapped provider visible to type assertions, as historyBreakerProvider
+// does. Like it, the history variants also answer GetPriceOnBasis and
+// DailySeries, passing them through when the wrapped provider has them.
+type quoteMemo struct{ *priceMemo }
+type historyMemo struct{ *priceMemo }
+type quoteHistoryMemo struct{ *priceMemo }
+
+func (m quoteMemo) GetQuote(symbol string) (Quote, error) { return m.quote(symbol) }
+func (m historyMemo) GetPriceOn(symbol string, date time.Time) (float64, time.Time, error) {
+	return m.priceOn(symbol, date)
+}
+func (m historyMemo) GetPriceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
+	return m.priceOnBasis(symbol, date, basis)
+}
+func (m historyMemo) DailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+	return m.dailySeries(symbol, basis)
+}
+func (m quoteHistoryMemo) GetQuote(symbol string) (Quote, error) { return m.quote(symbol) }
+func (m quoteHistoryMemo) GetPriceOn(symbol string, date time.Time) (float64, time.Time, error) {
+	return m.priceOn(symbol, date)
+}
+func (m quoteHistoryMemo) GetPriceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
+	return m.priceOnBasis(symbol, date, basis)
+}
+func (m quoteHistoryMemo) DailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+	return m.dailySeries(symbol, basis)
+}
+
+// Unwrap returns the wrapped provider, for status lookups (see unwrapProvider).
+func (m *priceMemo) Unwrap() PriceProvider { return m.inner }
+
+// newPriceMemo wraps p; a nil p stays nil.
+func newPriceMemo(p PriceProvider) PriceProvider {
+	if p == nil {
+		return nil
+	}
+	m := &priceMemo{
+		inner:  p,
+		prices: map[string]memoPrice{},
+		quotes: map[string]memoQuote{},
+		closes: map[memoDay]memoPrice{},
+		bases:  map[memoBasisDay]memoPrice{},
+		series: map[memoSeriesKey]memoSeries{},
+	}
+	_, quotes := p.(QuoteProvider)
+	_, history := p.(HistoryProvider)
+	switch {
+	case quotes && history:
+		return quoteHistoryMemo{m}
+	case quotes:
+		return quoteMemo{m}
+	case history:
+		return historyMemo{m}
+	}
+	return m
+}
+
+func (m *priceMemo) GetPrice(symbol string) (float64, time.Time, error) {
+	m.mu.Lock()
+	r, ok := m.prices[symbol]
+	m.mu.Unlock()
+	if ok {
+		return r.price, r.asOf, r.err
+	}
+	r.price, r.asOf, r.err = m.inner.GetPrice(symbol)
+	m.mu.Lock()
+	m.prices[symbol] = r
+	m.mu.Unlock()
+	return r.price, r.asOf, r.err
+}
+
+func (m *priceMemo) quote(symbol string) (Quote, error) {
+	m.mu.Lock()
+	r, ok := m.quotes[symbol]
+	m.mu.Unlock()
+	if ok {
+		return r.q, r.err
+	}
+	r.q, r.err = m.inner.(QuoteProvider).GetQuote(symbol)
+	m.mu.Lock()
+	m.quotes[symbol] = r
+	m.mu.Unlock()
+	return r.q, r.err
+}
+
+func (m *priceMemo) priceOn(symbol string, date time.Time) (float64, time.Time, error) {
+	k := memoDay{symbol, time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)}
+	m.mu.Lock()
+	r, ok := m.closes[k]
+	m.mu.Unlock()
+	if ok {
+		return r.price, r.asOf, r.err
+	}
+	r.price, r.asOf, r.err = m.inner.(HistoryProvider).GetPriceOn(symbol, date)
+	m.mu.Lock()
+	m.closes[k] = r
+	m.mu.Unlock()
+	return r.price, r.asOf, r.err
+}
+
+func (m *priceMemo) priceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
+	bp, ok := m.inner.(BasisHistoryProvider)
+	if !ok {
+		return m.priceOn(symbol, date)
+	}
+	k := memoBasisDay{memoDay{symbol, time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)}, basis}
+	m.mu.Lock()
+	r, ok := m.bases[k]
+	m.mu.Unlock()
+	if ok {
+		return r.price, r.asOf, r.err
+	}
+	r.price, r.asOf, r.err = bp.GetPriceOnBasis(symbol, date, basis)
+	m.mu.Lock()
+	m.bases[k] = r
+	m.mu.Unlock()
+	return r.price, r.asOf, r.err
+}
+
+func (m *priceMemo) dailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+	sp, ok := m.inner.(DailySeriesProvider)
+	if !ok {
+		return nil, nil, ErrPriceNotFound
+	}
+	k := memoSeriesKey{symbol, basis}
+	m.mu.Lock()
+	r, ok := m.series[k]
+	m.mu.Unlock()
+	if ok {
+		return r.days, r.prices, r.err
+	}
+	r.days, r.prices, r.err = sp.DailySeries(symbol, basis)
+	m.mu.Lock()
+	m.series[k] = r
+	m.mu.Unlock()
+	return r.days, r.prices, r.err
+}
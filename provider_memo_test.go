@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionPrices is a provider with quotes, per-basis closes and daily series,
+// counting calls so tests can check memoization. Each symbol closes at
+// close[symbol] every day and opens at open[symbol].
+type sessionPrices struct {
+	mu     sync.Mutex
+	open   map[string]float64
+	close  map[string]float64
+	prev   map[string]float64
+	calls  map[string]int
+	status *BreakerStatus
+}
+
+func newSessionPrices() *sessionPrices {
+	return &sessionPrices{open: map[string]float64{}, close: map[string]float64{}, prev: map[string]float64{}, calls: map[string]int{}}
+}
+
+func (p *sessionPrices) count(name string) {
+	p.mu.Lock()
+	p.calls[name]++
+	p.mu.Unlock()
+}
+
+func (p *sessionPrices) GetPrice(symbol string) (float64, time.Time, error) {
+	p.count("price")
+	c, ok := p.close[symbol]
+	if !ok {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	return c, time.Now(), nil
+}
+
+func (p *sessionPrices) GetQuote(symbol string) (Quote, error) {
+	p.count("quote")
+	c, ok := p.close[symbol]
+	if !ok {
+		return Quote{}, ErrPriceNotFound
+	}
+	return Quote{Price: c, PreviousClose: p.prev[symbol], AsOf: time.Now()}, nil
+}
+
+func (p *sessionPrices) GetPriceOn(symbol string, date time.Time) (float64, time.Time, error) {
+	return p.GetPriceOnBasis(symbol, date, "")
+}
+
+func (p *sessionPrices) GetPriceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
+	p.count("basis:" + basis)
+	px := p.close
+	if basis == "open" {
+		px = p.open
+	}
+	v, ok := px[symbol]
+	if !ok {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	return v, date, nil
+}
+
+func (p *sessionPrices) DailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+	p.count("series:" + basis)
+	return nil, nil, ErrPriceNotFound
+}
+
+func (p *sessionPrices) Status() BreakerStatus {
+	if p.status == nil {
+		return BreakerStatus{}
+	}
+	return *p.status
+}
+
+func TestPriceMemoForwardsOptionalInterfaces(t *testing.T) {
+	inner := newSessionPrices()
+	inner.open["AAPL"], inner.close["AAPL"] = 100, 105
+	m := newPriceMemo(inner)
+
+	bp, ok := m.(BasisHistoryProvider)
+	if !ok {
+		t.Fatal("memo hides BasisHistoryProvider")
+	}
+	if _, ok := m.(DailySeriesProvider); !ok {
+		t.Fatal("memo hides DailySeriesProvider")
+	}
+	day := time.Date(2025, 6, 2, 15, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		v, _, err := bp.GetPriceOnBasis("AAPL", day, "open")
+		if err != nil || v != 100 {
+			t.Fatalf("session open = %v, %v; want 100", v, err)
+		}
+		m.(DailySeriesProvider).DailySeries("AAPL", "open")
+	}
+	if n := inner.calls["basis:open"]; n != 1 {
+		t.Errorf("inner GetPriceOnBasis called %d times, want 1", n)
+	}
+	if n := inner.calls["series:open"]; n != 1 {
+		t.Errorf("inner DailySeries called %d times, want 1", n)
+	}
+}
+
+func TestProviderStatusThroughMemo(t *testing.T) {
+	inner := newSessionPrices()
+	inner.status = &BreakerStatus{State: "open"}
+	_, svc := newTestServices(t, inner, nil, "TWD")
+	st, ok := svc.WithPriceMemo().ProviderStatus()
+	if !ok || st.State != "open" {
+		t.Fatalf("status through memo = %+v, %v; want the inner breaker's", st, ok)
+	}
+}
+
+func TestSummaryAllRefsKeepsSessionOpenDailyPL(t *testing.T) {
+	prices := newSessionPrices()
+	prices.open["AAPL"], prices.close["AAPL"] = 100, 105
+	pf, base := newTestServices(t, prices, fixedRates{"USDTWD": 30}, "USD")
+	mustPortfolio(t, pf, base, "USD",
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Shares: 10, Price: 90, Date: "2025/01/02", Total: -900},
+	)
+	svc, err := base.WithDailyBasis(DailyBasisSessionOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	single, err := svc.ComputeSummaryAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(single.DailyPL, 50) {
+		t.Fatalf("single-ref daily P/L %v, want 50 (10 × (105 − 100))", single.DailyPL)
+	}
+	multi, err := svc.ComputeSummaryAllRefs([]string{"USD", "TWD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := multi["USD"].DailyPL; !approx(got, single.DailyPL) {
+		t.Errorf("refs= daily P/L %v, want %v as in the single-ref summary", got, single.DailyPL)
+	}
+	if got := multi["TWD"].DailyPL; !approx(got, single.DailyPL*30) {
+		t.Errorf("refs= TWD daily P/L %v, want %v", got, single.DailyPL*30)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+marks.csv (optional, under DATA_DIR)
+symbol,price,currency,as_of
+ACME-PRIVATE,12.5,USD,2025/06/30
+TW-FUND-X,10.82,TWD,2025/07/01
+
+A mark pins a symbol's price from as_of on: quotes return it, and daily
+history returns it for as_of and later days and nothing before. Marked
+symbols never reach the network provider. currency may be blank, in which
+case the transactions' own currency is used.
+*/
+
+const marksFile = "marks.csv"
+
+// Mark is a manually entered price.
+type Mark struct {
+	Price    float64
+	Currency string
+	AsOf     time.Time // calendar date, midnight UTC
+}
+
+// OverlayProvider answers marked symbols from their Mark and passes every
+// other symbol to the wrapped provider.
+type OverlayProvider struct {
+	inner PriceProvider
+	marks map[string]Mark // by upper-cased symbol
+}
+
+func NewOverlayProvider(inner PriceProvider, marks map[string]Mark) *OverlayProvider {
+	return &OverlayProvider{inner: inner, marks: marks}
+}
+
+func (o *OverlayProvider) mark(symbol string) (Mark, bool) {
+	m, ok := o.marks[strings.ToUpper(strings.TrimSpace(symbol))]
+	return m, ok
+}
+
+func (o *OverlayProvider) GetPrice(symbol string) (float64, time.Time, error) {
+	if m, ok := o.mark(symbol); ok {
+		return m.Price, m.AsOf, nil
+	}
+	return o.inner.GetPrice(symbol)
+}
+
+func (o *OverlayProvider) GetQuote(symbol string) (Quote, error) {
+	if m, ok := o.mark(symbol); ok {
+		return Quote{Price: m.Price, AsOf: m.AsOf, Currency: m.Currency}, nil
+	}
+	qp, ok := o.inner.(QuoteProvider)
+	if !ok {
+		return Quote{}, ErrPriceNotFound
+	}
+	return qp.GetQuote(symbol)
+}
+
+// GetPriceOn returns a marked symbol's mark for dates from its as_of on;
+// other symbols get ErrPriceNotFound when the wrapped provider has no history.
+func (o *OverlayProvider) GetPriceOn(symbol string, date time.Time) (float64, time.Time, error) {
+	if m, ok := o.mark(symbol); ok {
+		day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+		if day.Before(m.AsOf) {
+			return 0, time.Time{}, fmt.Errorf("%w: %s is marked from %s", ErrPriceNotFound, symbol, m.AsOf.Format(txDateLayout))
+		}
+		return m.Price, m.AsOf, nil
+	}
+	hp, ok := o.inner.(HistoryProvider)
+	if !ok {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	return hp.GetPriceOn(symbol, date)
+}
+
+// GetPriceOnBasis treats a mark as both the open and the close.
+func (o *OverlayProvider) GetPriceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
+	if _, ok := o.mark(symbol); ok {
+		return o.GetPriceOn(symbol, date)
+	}
+	if bp, ok := o.inner.(BasisHistoryProvider); ok {
+		return bp.GetPriceOnBasis(symbol, date, basis)
+	}
+	return o.GetPriceOn(symbol, date)
+}
+
+// DailySeries is the single as_of bar for a marked symbol, which callers
+// forward-fill.
+func (o *OverlayProvider) DailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+	if m, ok := o.mark(symbol); ok {
+		return []time.Time{m.AsOf}, []float64{m.Price}, nil
+	}
+	sp, ok := o.inner.(DailySeriesProvider)
+	if !ok {
+		return nil, nil, ErrPriceNotFound
+	}
+	return sp.DailySeries(symbol, basis)
+}
+
+// Unwrap returns the wrapped provider, so the circuit breaker (or router)
+// underneath still reports on /admin/provider.
+func (o *OverlayProvider) Unwrap() PriceProvider { return o.inner }
+
+// LoadMarks reads symbol -> Mark rows from path. A missing file yields no
+// marks.
+func LoadMarks(path string) (map[string]Mark, error) {
+	marks := map[string]Mark{}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return marks, nil
+	}
+	if err != nil {
+		return marks, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return marks, err
+	}
+	for i, row := range rows {
+		if len(row) < 4 {
+			continue
+		}
+		sym := strings.ToUpper(strings.TrimSpace(row[0]))
+		if i == 0 && sym == "SYMBOL" {
+			continue // header
+		}
+		if sym == "" {
+			continue
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(row[1]), 64)
+		if err != nil || price <= 0 {
+			return marks, fmt.Errorf("%s:%d: invalid price %q", path, i+1, row[1])
+		}
+		asOf, err := parseCalendarDate(payloadDateLayout, strings.TrimSpace(row[3]))
+		if err != nil {
+			return marks, fmt.Errorf("%s:%d: invalid as_of %q (use YYYY/MM/DD)", path, i+1, row[3])
+		}
+		marks[sym] = Mark{Price: price, Currency: strings.TrimSpace(row[2]), AsOf: asOf}
+	}
+	return marks, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestOverlayForwardsBreakerStatus(t *testing.T) {
+	inner := newSessionPrices()
+	inner.status = &BreakerStatus{State: "half-open"}
+	marks := map[string]Mark{"PRIVATE": {Price: 10}}
+
+	_, svc := newTestServices(t, NewOverlayProvider(inner, marks), nil, "TWD")
+	if st, ok := svc.ProviderStatus(); !ok || st.State != "half-open" {
+		t.Errorf("overlay status = %+v, %v; want the wrapped breaker's", st, ok)
+	}
+
+	rp, err := NewRoutingProvider(nil, map[string]PriceProvider{"yahoo": inner}, "yahoo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, svc = newTestServices(t, NewOverlayProvider(rp, marks), nil, "TWD")
+	if st, ok := svc.ProviderStatus(); !ok || st.State != "half-open" {
+		t.Errorf("overlay over router status = %+v, %v; want the fallback's breaker", st, ok)
+	}
+	if routes, ok := svc.RouteStatuses(); !ok || routes["yahoo"].State != "half-open" {
+		t.Errorf("overlay over router routes = %+v, %v", routes, ok)
+	}
+}
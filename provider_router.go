@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Routing price provider: dispatches each symbol to one of several named
+// providers by regex rules (first match wins), falling back to a default.
+// Configured with PRICE_ROUTES, either inline JSON or a path to a JSON file:
+//
+//	[{"pattern": "^\\d{4,6}\\.TWO?$", "provider": "alphavantage"}]
+
+type PriceRoute struct {
+	Pattern  string `json:"pattern"`
+	Provider string `json:"provider"`
+}
+
+type routeRule struct {
+	re   *regexp.Regexp
+	name string
+}
+
+type RoutingProvider struct {
+	rules     []routeRule
+	providers map[string]PriceProvider
+	fallback  string
+}
+
+// LoadPriceRoutes parses PRICE_ROUTES: a JSON array, or a path to a file
+// holding one.
+func LoadPriceRoutes(v string) ([]PriceRoute, error) {
+	v = strings.TrimSpace(v)
+	data := []byte(v)
+	if !strings.HasPrefix(v, "[") {
+		b, err := os.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	}
+	var routes []PriceRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("parse price routes: %w", err)
+	}
+	return routes, nil
+}
+
+// canonicalProviderName maps PRICE_PROVIDER-style aliases to provider names.
+func canonicalProviderName(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "alphavantage", "alpha", "av":
+		return "alphavantage"
+	case "", "yahoo":
+		return "yahoo"
+	default:
+		return strings.ToLower(strings.TrimSpace(name))
+	}
+}
+
+func NewRoutingProvider(routes []PriceRoute, providers map[string]PriceProvider, fallback string) (*RoutingProvider, error) {
+	known := func() string {
+		names := make([]string, 0, len(providers))
+		for n := range providers {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ", ")
+	}
+	if _, ok := providers[fallback]; !ok {
+		return nil, fmt.Errorf("default provider %q not configured (available: %s)", fallback, known())
+	}
+	r := &RoutingProvider{providers: providers, fallback: fallback}
+	for i, rt := range routes {
+		re, err := regexp.Compile(rt.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: invalid pattern %q: %w", i, rt.Pattern, err)
+		}
+		name := canonicalProviderName(rt.Provider)
+		if _, ok := providers[name]; !ok {
+			return nil, fmt.Errorf("route %d: provider %q not configured (available: %s)", i, rt.Provider, known())
+		}
+		r.rules = append(r.rules, routeRule{re: re, name: name})
+	}
+	return r, nil
+}
+
+func (r *RoutingProvider) route(symbol string) (string, PriceProvider) {
+	sym := strings.ToUpper(strings.TrimSpace(symbol))
+	for _, rule := range r.rules {
+		if rule.re.MatchString(sym) {
+			return rule.name, r.providers[rule.name]
+		}
+	}
+	return r.fallback, r.providers[r.fallback]
+}
+
+func (r *RoutingProvider) GetPrice(symbol string) (float64, time.Time, error) {
+	_, p := r.route(symbol)
+	return p.GetPrice(symbol)
+}
+
+func (r *RoutingProvider) GetQuote(symbol string) (Quote, error) {
+	_, p := r.route(symbol)
+	qp, ok := p.(QuoteProvider)
+	if !ok {
+		return Quote{}, ErrPriceNotFound
+	}
+	return qp.GetQuote(symbol)
+}
+
+// GetPriceOn delegates to the routed provider; symbols routed to a provider
+// without daily history get ErrPriceNotFound.
+func (r *RoutingProvider) GetPriceOn(symbol string, date time.Time) (float64, time.Time, error) {
+	name, p := r.route(symbol)
+	hp, ok := p.(HistoryProvider)
+	if !ok {
+		return 0, time.Time{}, fmt.Errorf("%w: %s has no daily history", ErrPriceNotFound, name)
+	}
+	return hp.GetPriceOn(symbol, date)
+}
+
+func (r *RoutingProvider) GetPriceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
+	_, p := r.route(symbol)
+	if bp, ok := p.(BasisHistoryProvider); ok {
+		return bp.GetPriceOnBasis(symbol, date, basis)
+	}
+	return r.GetPriceOn(symbol, date)
+}
+
+// DailySeries delegates to the routed provider; symbols routed to a provider
+// without it get ErrPriceNotFound, and callers fall back to GetPriceOn.
+func (r *RoutingProvider) DailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+	name, p := r.route(symbol)
+	sp, ok := p.(DailySeriesProvider)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s has no daily series", ErrPriceNotFound, name)
+	}
+	return sp.DailySeries(symbol, basis)
+}
+
+// Breakers reports the circuit breaker state of each routed provider that
+// has one, keyed by provider name.
+func (r *RoutingProvider) Breakers() map[string]BreakerStatus {
+	type statuser interface{ Status() BreakerStatus }
+	out := map[string]BreakerStatus{}
+	for name, p := range r.providers {
+		unwrapProvider(p, func(p PriceProvider) bool {
+			b, ok := p.(statuser)
+			if ok {
+				out[name] = b.Status()
+			}
+			return ok
+		})
+	}
+	return out
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -14,57 +15,105 @@ import (
 
 var ErrYahooNoResult = errors.New("yahoo: no result")
 
+// yahooSymbolOverrides maps broker spellings that no rule covers to Yahoo's.
+// Keys are in the form yahooSymbol has produced before the lookup.
+var yahooSymbolOverrides = map[string]string{
+	"SPX":  "^GSPC",
+	"NDX":  "^NDX",
+	"DJI":  "^DJI",
+	"VIX":  "^VIX",
+	"RUT":  "^RUT",
+	"TWII": "^TWII",
+}
+
+// yahooExchangeSuffixes are one-letter Yahoo market suffixes (London, Tokyo,
+// TSX Venture, Frankfurt), which must not be read as share classes.
+var yahooExchangeSuffixes = map[string]bool{"L": true, "T": true, "V": true, "F": true}
+
+// yahooSymbol maps common broker conventions to Yahoo's: trims, uppercases,
+// drops a leading "$", and writes share classes with a dash (BRK.B, BRK/B and
+// "BRK B" all become BRK-B). Exchange suffixes such as 2330.TW are kept.
+func yahooSymbol(sym string) string {
+	s := strings.ToUpper(strings.TrimSpace(sym))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "$"))
+	s = strings.Join(strings.Fields(s), "-")
+	s = strings.ReplaceAll(s, "/", "-")
+	if i := strings.LastIndexByte(s, '.'); i > 0 && i == len(s)-2 {
+		if class := s[i+1:]; !yahooExchangeSuffixes[class] && class[0] >= 'A' && class[0] <= 'Z' {
+			s = s[:i] + "-" + class
+		}
+	}
+	if o, ok := yahooSymbolOverrides[s]; ok {
+		return o
+	}
+	return s
+}
+
 type YahooProvider struct {
-    cli   *http.Client
-    ttl   time.Duration
-    mu    sync.RWMutex
-    cache map[string]cachedQuote
-    hist  map[string]histSeries
+    yahoo   *yahooSession // cookie + crumb on chart requests
+    ttl     time.Duration // live quotes and today's in-progress daily bar
+    histTTL time.Duration // settled daily history (HIST_CACHE_TTL, default 12h)
+    mu      sync.RWMutex
+    cache   map[string]cachedQuote
+    hist    map[string]histSeries
 }
 
 func NewYahooProvider() *YahooProvider {
     return &YahooProvider{
-        cli:   &http.Client{Timeout: 8 * time.Second},
-        ttl:   60 * time.Second,
-        cache: make(map[string]cachedQuote),
-        hist:  make(map[string]histSeries),
+        yahoo:   sharedYahooSession(),
+        ttl:     60 * time.Second,
+        histTTL: envDuration("HIST_CACHE_TTL", 12*time.Hour),
+        cache:   make(map[string]cachedQuote),
+        hist:    make(map[string]histSeries),
     }
 }
 
 func (p *YahooProvider) GetPrice(symbol string) (float64, time.Time, error) {
-	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	q, err := p.GetQuote(symbol)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return q.Price, q.AsOf, nil
+}
+
+// GetQuote returns the live price plus the previous close and change percent
+// from the chart meta.
+func (p *YahooProvider) GetQuote(symbol string) (Quote, error) {
+	ttl := p.quoteTTL(symbol)
+	symbol = yahooSymbol(symbol)
 	if symbol == "" {
-		return 0, time.Time{}, ErrPriceNotFound
+		return Quote{}, ErrPriceNotFound
 	}
 
 	// Cache
 	p.mu.RLock()
-	if c, ok := p.cache[symbol]; ok && time.Since(c.fetched) < p.ttl {
+	if c, ok := p.cache[symbol]; ok && time.Since(c.fetched) < ttl {
 		p.mu.RUnlock()
-		return c.price, c.asOf, nil
+		return Quote{Price: c.price, AsOf: c.asOf, PreviousClose: c.prevClose, ChangePercent: c.changePct, Currency: c.currency}, nil
 	}
 	p.mu.RUnlock()
 
-	url := fmt.Sprintf("https://query2.finance.yahoo.com/v8/finance/chart/%s?interval=1m&range=1d", symbol)
-	req, _ := http.NewRequest(http.MethodGet, url, nil)
-	req.Header.Set("User-Agent", "stock-portfolios/1.0")
-
-	resp, err := p.cli.Do(req)
+	url := fmt.Sprintf("%s/v8/finance/chart/%s?interval=1m&range=1d", yahooBaseURL(), symbol)
+	resp, err := p.yahoo.get(url)
 	if err != nil {
-		return 0, time.Time{}, err
+		return Quote{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, time.Time{}, fmt.Errorf("yahoo http %d", resp.StatusCode)
+		return Quote{}, &httpStatusError{"yahoo", resp.StatusCode}
 	}
 
 	var raw struct {
 		Chart struct {
 			Result []struct {
 				Meta struct {
-					RegularMarketPrice float64 `json:"regularMarketPrice"`
-					RegularMarketTime  int64   `json:"regularMarketTime"`
+					Currency                   string  `json:"currency"`
+					RegularMarketPrice         float64 `json:"regularMarketPrice"`
+					RegularMarketTime          int64   `json:"regularMarketTime"`
+					PreviousClose              float64 `json:"previousClose"`
+					ChartPreviousClose         float64 `json:"chartPreviousClose"`
+					RegularMarketChangePercent float64 `json:"regularMarketChangePercent"`
 				} `json:"meta"`
 				Timestamp  []int64 `json:"timestamp"`
 				Indicators struct {
@@ -78,40 +127,75 @@ func (p *YahooProvider) GetPrice(symbol string) (float64, time.Time, error) {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
-		return 0, time.Time{}, err
+		return Quote{}, err
 	}
 	if len(raw.Chart.Result) == 0 {
-		return 0, time.Time{}, ErrYahooNoResult
+		return Quote{}, ErrYahooNoResult
 	}
 
 	r := raw.Chart.Result[0]
 	price := r.Meta.RegularMarketPrice
 	asOf := time.Unix(r.Meta.RegularMarketTime, 0)
 
-	// Fallback: last non-zero close if meta missing
-	if (price <= 0 || r.Meta.RegularMarketTime == 0) && len(r.Timestamp) > 0 && len(r.Indicators.Quote) > 0 && len(r.Indicators.Quote[0].Close) == len(r.Timestamp) {
-		for i := len(r.Timestamp) - 1; i >= 0; i-- {
-			c := r.Indicators.Quote[0].Close[i]
-			if c > 0 {
-				price = c
-				asOf = time.Unix(r.Timestamp[i], 0)
-				break
-			}
+	// Fallback: last valid close if meta missing
+	if (price <= 0 || r.Meta.RegularMarketTime == 0) && len(r.Indicators.Quote) > 0 {
+		if c, at, ok := lastYahooClose(r.Timestamp, r.Indicators.Quote[0].Close); ok {
+			price, asOf = c, at
 		}
 	}
 
 	if price <= 0 {
-		return 0, time.Time{}, ErrPriceNotFound
+		return Quote{}, ErrPriceNotFound
 	}
 	if asOf.IsZero() {
 		asOf = time.Now()
 	}
 
+	// 1d-range charts report the prior close as chartPreviousClose
+	prevClose := r.Meta.PreviousClose
+	if prevClose <= 0 {
+		prevClose = r.Meta.ChartPreviousClose
+	}
+	changePct := r.Meta.RegularMarketChangePercent
+	if changePct == 0 && prevClose > 0 {
+		changePct = (price/prevClose - 1) * 100.0
+	}
+
 	p.mu.Lock()
-	p.cache[symbol] = cachedQuote{price: price, asOf: asOf, fetched: time.Now()}
+	p.cache[symbol] = cachedQuote{price: price, asOf: asOf, fetched: time.Now(), prevClose: prevClose, changePct: changePct, currency: r.Meta.Currency}
 	p.mu.Unlock()
 
-	return price, asOf, nil
+	return Quote{Price: price, AsOf: asOf, PreviousClose: prevClose, ChangePercent: changePct, Currency: r.Meta.Currency}, nil
+}
+
+// Yahoo sends null for bars without trades, often in the middle of a series,
+// and its indicator arrays can be shorter than the timestamps. Nulls decode
+// as 0, so a value <= 0 means "no data here"; callers skip those bars
+// one index at a time instead of giving up on the whole series.
+
+// yahooValueAt is vals[i], or 0 when the array doesn't reach i.
+func yahooValueAt(vals []float64, i int) float64 {
+	if i < len(vals) {
+		return vals[i]
+	}
+	return 0
+}
+
+// lastYahooClose returns the latest bar with a valid close.
+func lastYahooClose(ts []int64, closes []float64) (float64, time.Time, bool) {
+	for i := len(ts) - 1; i >= 0; i-- {
+		if c := yahooValueAt(closes, i); c > 0 {
+			return c, time.Unix(ts[i], 0), true
+		}
+	}
+	return 0, time.Time{}, false
+}
+
+// quoteTTL is how long symbol's live quote stays cached: its price_ttl.csv
+// override, or the provider's ttl. symbol is the one the caller asked for,
+// before yahooSymbol, so rows can match either form.
+func (p *YahooProvider) quoteTTL(symbol string) time.Duration {
+	return priceTTLFor(symbol, p.ttl)
 }
 
 // ---- Historical daily prices ----
@@ -124,7 +208,8 @@ type histSeries struct {
 }
 
 func (p *YahooProvider) GetPriceOn(symbol string, date time.Time) (float64, time.Time, error) {
-    symbol = strings.ToUpper(strings.TrimSpace(symbol))
+    ttl := p.quoteTTL(symbol)
+    symbol = yahooSymbol(symbol)
     if symbol == "" {
         return 0, time.Time{}, ErrPriceNotFound
     }
@@ -133,25 +218,22 @@ func (p *YahooProvider) GetPriceOn(symbol string, date time.Time) (float64, time
     // cache hit
     p.mu.RLock()
     hs, ok := p.hist[symbol]
-    if ok && time.Since(hs.fetched) < p.ttl && len(hs.days) > 0 {
+    if ok && p.histFresh(hs, date, ttl) {
         p.mu.RUnlock()
-        return lookupHistClose(hs, date)
+        return p.lookupClose(symbol, hs, date, ttl)
     }
     p.mu.RUnlock()
 
     // fetch range daily for up to 10y
-    url := fmt.Sprintf("https://query2.finance.yahoo.com/v8/finance/chart/%s?interval=1d&range=10y", symbol)
-    req, _ := http.NewRequest(http.MethodGet, url, nil)
-    req.Header.Set("User-Agent", "stock-portfolios/1.0")
-
-    resp, err := p.cli.Do(req)
+    url := fmt.Sprintf("%s/v8/finance/chart/%s?interval=1d&range=10y", yahooBaseURL(), symbol)
+    resp, err := p.yahoo.get(url)
     if err != nil {
         return 0, time.Time{}, err
     }
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusOK {
-        return 0, time.Time{}, fmt.Errorf("yahoo http %d", resp.StatusCode)
+        return 0, time.Time{}, &httpStatusError{"yahoo", resp.StatusCode}
     }
 
     var raw struct {
@@ -175,19 +257,17 @@ func (p *YahooProvider) GetPriceOn(symbol string, date time.Time) (float64, time
         return 0, time.Time{}, ErrYahooNoResult
     }
     r := raw.Chart.Result[0]
-    if len(r.Timestamp) == 0 || len(r.Indicators.Quote) == 0 || len(r.Indicators.Quote[0].Close) != len(r.Timestamp) {
+    if len(r.Timestamp) == 0 || len(r.Indicators.Quote) == 0 {
         return 0, time.Time{}, ErrPriceNotFound
     }
+    q := r.Indicators.Quote[0]
     days := make([]time.Time, 0, len(r.Timestamp))
     closes := make([]float64, 0, len(r.Timestamp))
     opens := make([]float64, 0, len(r.Timestamp))
     for i := 0; i < len(r.Timestamp); i++ {
         ts := time.Unix(r.Timestamp[i], 0).UTC()
-        c := r.Indicators.Quote[0].Close[i]
-        o := 0.0
-        if len(r.Indicators.Quote[0].Open) == len(r.Timestamp) {
-            o = r.Indicators.Quote[0].Open[i]
-        }
+        c := yahooValueAt(q.Close, i)
+        o := yahooValueAt(q.Open, i)
         if c > 0 {
             days = append(days, time.Date(ts.Year(), ts.Month(), ts.Day(), 0, 0, 0, 0, time.UTC))
             closes = append(closes, c)
@@ -201,28 +281,29 @@ func (p *YahooProvider) GetPriceOn(symbol string, date time.Time) (float64, time
     p.mu.Lock()
     p.hist[symbol] = hs
     p.mu.Unlock()
-    return lookupHistClose(hs, date)
+    return p.lookupClose(symbol, hs, date, ttl)
 }
 
 // GetPriceOnBasis returns a daily price with an explicit basis: "open" or "close".
 func (p *YahooProvider) GetPriceOnBasis(symbol string, date time.Time, basis string) (float64, time.Time, error) {
-    symbol = strings.ToUpper(strings.TrimSpace(symbol))
+    raw, ttl := symbol, p.quoteTTL(symbol)
+    symbol = yahooSymbol(symbol)
     if symbol == "" {
         return 0, time.Time{}, ErrPriceNotFound
     }
     date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
     p.mu.RLock()
     hs, ok := p.hist[symbol]
-    if ok && time.Since(hs.fetched) < p.ttl && len(hs.days) > 0 {
+    if ok && p.histFresh(hs, date, ttl) {
         p.mu.RUnlock()
         if strings.EqualFold(basis, "open") {
             return lookupHistOpen(hs, date)
         }
-        return lookupHistClose(hs, date)
+        return p.lookupClose(symbol, hs, date, ttl)
     }
     p.mu.RUnlock()
     // Ensure cache is populated (reuse GetPriceOn path)
-    _, _, err := p.GetPriceOn(symbol, date)
+    _, _, err := p.GetPriceOn(raw, date)
     if err != nil {
         return 0, time.Time{}, err
     }
@@ -232,17 +313,83 @@ func (p *YahooProvider) GetPriceOnBasis(symbol string, date time.Time, basis str
     if strings.EqualFold(basis, "open") {
         return lookupHistOpen(hs, date)
     }
-    return lookupHistClose(hs, date)
+    return p.lookupClose(symbol, hs, date, ttl)
 }
 
-func lookupHistClose(hs histSeries, date time.Time) (float64, time.Time, error) {
-    idx := -1
-    for i := len(hs.days) - 1; i >= 0; i-- {
-        if !hs.days[i].After(date) {
-            idx = i
-            break
+// DailySeries returns the cached daily series (fetching it if needed) with
+// opens falling back to the close like lookupHistOpen. The slices are copies.
+func (p *YahooProvider) DailySeries(symbol, basis string) ([]time.Time, []float64, error) {
+    // Yesterday is a settled bar, so a cached series stays usable for histTTL
+    if _, _, err := p.GetPriceOn(symbol, time.Now().UTC().AddDate(0, 0, -1)); err != nil && !errors.Is(err, ErrPriceNotFound) {
+        return nil, nil, err
+    }
+    p.mu.RLock()
+    hs, ok := p.hist[yahooSymbol(symbol)]
+    p.mu.RUnlock()
+    if !ok || len(hs.days) == 0 {
+        return nil, nil, ErrPriceNotFound
+    }
+    days := append([]time.Time(nil), hs.days...)
+    prices := append([]float64(nil), hs.closes...)
+    if strings.EqualFold(basis, "open") && len(hs.opens) == len(hs.days) {
+        for i, o := range hs.opens {
+            if o > 0 {
+                prices[i] = o
+            }
+        }
+    }
+    return days, prices, nil
+}
+
+// histFresh reports whether a cached series can answer a lookup for date.
+// Past bars are settled and kept for histTTL; lookups for today (or later)
+// may land on the in-progress bar, so they follow the symbol's quote ttl.
+func (p *YahooProvider) histFresh(hs histSeries, date time.Time, ttl time.Duration) bool {
+    if len(hs.days) == 0 {
+        return false
+    }
+    age := time.Since(hs.fetched)
+    if age >= p.histTTL {
+        return false
+    }
+    now := time.Now().UTC()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    if !date.Before(today) {
+        return age < ttl
+    }
+    return true
+}
+
+// lookupClose is lookupHistClose with today's live quote spliced in: when the
+// requested date is today and a fresh cached quote is at least as recent as
+// the last daily bar, the quote stands in as today's close. This keeps
+// history-derived valuations in step with the live summary price.
+func (p *YahooProvider) lookupClose(symbol string, hs histSeries, date time.Time, ttl time.Duration) (float64, time.Time, error) {
+    now := time.Now().UTC()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    if !date.Before(today) {
+        p.mu.RLock()
+        c, ok := p.cache[symbol]
+        p.mu.RUnlock()
+        if ok && time.Since(c.fetched) < ttl && c.price > 0 {
+            q := c.asOf.UTC()
+            qDay := time.Date(q.Year(), q.Month(), q.Day(), 0, 0, 0, 0, time.UTC)
+            if !qDay.After(date) && (len(hs.days) == 0 || !qDay.Before(hs.days[len(hs.days)-1])) {
+                return c.price, qDay, nil
+            }
         }
     }
+    return lookupHistClose(hs, date)
+}
+
+// histIndex is the index of the last bar at or before date (-1 if none), by
+// binary search over the ascending days.
+func histIndex(hs histSeries, date time.Time) int {
+    return sort.Search(len(hs.days), func(i int) bool { return hs.days[i].After(date) }) - 1
+}
+
+func lookupHistClose(hs histSeries, date time.Time) (float64, time.Time, error) {
+    idx := histIndex(hs, date)
     if idx < 0 {
         return 0, time.Time{}, ErrPriceNotFound
     }
@@ -250,13 +397,7 @@ func lookupHistClose(hs histSeries, date time.Time) (float64, time.Time, error)
 }
 
 func lookupHistOpen(hs histSeries, date time.Time) (float64, time.Time, error) {
-    idx := -1
-    for i := len(hs.days) - 1; i >= 0; i-- {
-        if !hs.days[i].After(date) {
-            idx = i
-            break
-        }
-    }
+    idx := histIndex(hs, date)
     if idx < 0 {
         return 0, time.Time{}, ErrPriceNotFound
     }
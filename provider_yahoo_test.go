@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// yahooFixture serves testdata/name for every chart request and returns a
+// provider pointed at it.
+func yahooFixture(t *testing.T, name string) *YahooProvider {
+	t.Helper()
+	body, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("YAHOO_BASE_URL", srv.URL)
+	t.Setenv("YAHOO_CRUMB", "test") // no handshake
+	p := NewYahooProvider()
+	p.yahoo = newYahooSession(srv.Client())
+	return p
+}
+
+func TestYahooChartWithNulls(t *testing.T) {
+	// Closes 101, null, 104, null for five timestamps (the last has no value)
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+
+	q, err := yahooFixture(t, "yahoo_chart_nulls.json").GetQuote("AAPL")
+	if err != nil {
+		t.Fatalf("live quote: %v", err)
+	}
+	if q.Price != 104 || !sameYMD(q.AsOf, day(6)) {
+		t.Errorf("live quote %v at %v, want the last valid close 104 on Jan 6", q.Price, q.AsOf)
+	}
+
+	cases := []struct {
+		date  time.Time
+		close float64
+		on    time.Time
+	}{
+		{day(2), 101, day(2)},
+		{day(3), 101, day(2)}, // null bar: the close before it
+		{day(5), 101, day(2)}, // weekend
+		{day(6), 104, day(6)},
+		{day(8), 104, day(6)}, // past the end of the close array
+	}
+	p := yahooFixture(t, "yahoo_chart_nulls.json")
+	for _, c := range cases {
+		v, on, err := p.GetPriceOn("AAPL", c.date)
+		if err != nil || v != c.close || !on.Equal(c.on) {
+			t.Errorf("close on %s = %v (%s), %v; want %v (%s)", c.date.Format(time.DateOnly), v, on.Format(time.DateOnly), err, c.close, c.on.Format(time.DateOnly))
+		}
+	}
+	if v, _, err := p.GetPriceOnBasis("AAPL", day(7), "open"); err != nil || v != 103 {
+		t.Errorf("open on Jan 7 = %v, %v; want 103 from Jan 6", v, err)
+	}
+}
+
+// tenYearSeries is about ten years of weekday bars ending on end.
+func tenYearSeries(end time.Time) histSeries {
+	var hs histSeries
+	for d := end.AddDate(-10, 0, 0); !d.After(end); d = d.AddDate(0, 0, 1) {
+		if wd := d.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			continue
+		}
+		hs.days = append(hs.days, d)
+		hs.closes = append(hs.closes, float64(len(hs.days)))
+	}
+	return hs
+}
+
+// histIndexLinear is the backward scan histIndex replaced, kept as the
+// reference for its semantics and as the benchmark baseline.
+func histIndexLinear(hs histSeries, date time.Time) int {
+	for i := len(hs.days) - 1; i >= 0; i-- {
+		if !hs.days[i].After(date) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestHistIndexMatchesLinearScan(t *testing.T) {
+	end := time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+	hs := tenYearSeries(end)
+	for d := end.AddDate(-10, 0, -3); !d.After(end.AddDate(0, 0, 3)); d = d.AddDate(0, 0, 1) {
+		if got, want := histIndex(hs, d), histIndexLinear(hs, d); got != want {
+			t.Fatalf("histIndex(%s) = %d, want %d", d.Format(time.DateOnly), got, want)
+		}
+	}
+}
+
+// BenchmarkHistIndex queries a 10y series 3000 times, one query per
+// backtest day, walking back from the end.
+func BenchmarkHistIndex(b *testing.B) {
+	end := time.Date(2025, 6, 30, 0, 0, 0, 0, time.UTC)
+	hs := tenYearSeries(end)
+	for _, bm := range []struct {
+		name string
+		find func(histSeries, time.Time) int
+	}{
+		{"binary", histIndex},
+		{"linear", histIndexLinear},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for b.Loop() {
+				for i := range 3000 {
+					bm.find(hs, end.AddDate(0, 0, -i))
+				}
+			}
+		})
+	}
+}
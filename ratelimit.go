@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limiting, per client IP (token bucket):
+//
+// RATE_LIMIT  sustained requests per second (default 0, off; fractions
+//             allowed)
+// RATE_BURST  requests allowed at once before the rate applies (default 40)
+//
+// Over the limit a request gets 429 with Retry-After. The static UI,
+// /openapi.json and /version (the liveness check) are exempt, as are CORS
+// preflights.
+
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiterFromEnv returns nil when limiting is disabled.
+func rateLimiterFromEnv() *rateLimiter {
+	rate := envNonNegativeFloat("RATE_LIMIT", 0)
+	if rate == 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(envPositiveInt("RATE_BURST", 40)),
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+func envNonNegativeFloat(key string, def float64) float64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return def
+	}
+	return f
+}
+
+// rateExempt reports paths that never count against the limit.
+func rateExempt(path string) bool {
+	switch {
+	case path == "/version", path == "/openapi.json", path == "/app", path == "/mobile":
+		return true
+	case strings.HasPrefix(path, "/app/"), strings.HasPrefix(path, "/mobile/"):
+		return true
+	}
+	return false
+}
+
+// allow takes a token from key's bucket. When none is left it returns how
+// long until one is.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely (idle clients), at most
+// once a minute, so the map doesn't grow with every address seen.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// clientIP is the request's remote address without the port. Forwarding
+// headers are not trusted, so behind a proxy all clients share its bucket.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limit writes a 429 and returns false when r is over its client's limit.
+func (l *rateLimiter) limit(w http.ResponseWriter, r *http.Request) bool {
+	if l == nil || rateExempt(r.URL.Path) {
+		return true
+	}
+	ok, wait := l.allow(clientIP(r), time.Now())
+	if ok {
+		return true
+	}
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	httpError(w, http.StatusTooManyRequests, "rate limit exceeded; retry after "+strconv.Itoa(secs)+"s")
+	return false
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestRateLimitOffByDefault(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "")
+	if l := rateLimiterFromEnv(); l != nil {
+		t.Fatalf("limiter %+v without RATE_LIMIT, want none", l)
+	}
+	t.Setenv("RATE_LIMIT", "10")
+	if l := rateLimiterFromEnv(); l == nil || l.rate != 10 || l.burst != 40 {
+		t.Fatalf("RATE_LIMIT=10 gave %+v, want rate 10, burst 40", l)
+	}
+}
+
+func TestRateExempt(t *testing.T) {
+	for path, want := range map[string]bool{
+		"/version":       true,
+		"/openapi.json":  true,
+		"/app":           true,
+		"/app/index.js":  true,
+		"/mobile/":       true,
+		"/summary":       false,
+		"/portfolios":    false,
+		"/openapi.jsonx": false,
+	} {
+		if got := rateExempt(path); got != want {
+			t.Errorf("rateExempt(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+/* ===================== Realized P/L per sell ===================== */
+
+// ErrNotSell is returned when realized P/L is asked of anything but a sell.
+var ErrNotSell = errors.New("realized P/L is only available for sell transactions")
+
+// washSaleDays is the wash-sale window: a loss-making sell with a buy of the
+// same symbol this many days before or after it has (part of) its loss
+// disallowed (WASH_SALE_DAYS, default 30; 0 turns the check off).
+var washSaleDays = int(envNonNegativeFloat("WASH_SALE_DAYS", 30))
+
+// RealizedLot is the part of one buy (or transfer in) a sell consumed.
+// Cost is in the lot's Currency, CostRef in the reference currency.
+type RealizedLot struct {
+	TransactionID string    `json:"transaction_id"`
+	Date          time.Time `json:"date"`
+	Currency      string    `json:"currency"`
+	Shares        float64   `json:"shares"`
+	Cost          float64   `json:"cost"`
+	CostRef       float64   `json:"cost_ref"`
+
+	refCCY string // for rounding only; set by the enclosing response
+}
+
+// RealizedResponse is a sell's realized P/L. The trade-currency Cost and
+// RealizedPL are omitted when the lots were bought in another currency.
+type RealizedResponse struct {
+	TransactionID   string        `json:"transaction_id"`
+	Symbol          string        `json:"symbol"`
+	Date            time.Time     `json:"date"`
+	Shares          float64       `json:"shares"`
+	Currency        string        `json:"currency"`
+	RefCurrency     string        `json:"ref_currency"`
+	CostBasisMethod string        `json:"cost_basis_method"`
+	Proceeds        float64       `json:"proceeds"`
+	ProceedsRef     float64       `json:"proceeds_ref"`
+	Cost            *float64      `json:"cost,omitempty"`
+	CostRef         float64       `json:"cost_ref"`
+	RealizedPL      *float64      `json:"realized_pl,omitempty"`
+	RealizedPLRef   float64       `json:"realized_pl_ref"`
+	UnmatchedShares float64       `json:"unmatched_shares,omitempty"` // sold beyond the shares held
+	Lots            []RealizedLot `json:"lots"`
+
+	// Wash sale: set when realized_pl_ref is a loss and replacement shares
+	// were bought within washSaleDays of the sell, in any portfolio
+	WashSale          bool     `json:"wash_sale"`
+	WashSaleShares    float64  `json:"wash_sale_shares,omitempty"`
+	DisallowedLoss    *float64 `json:"disallowed_loss,omitempty"`
+	DisallowedLossRef float64  `json:"disallowed_loss_ref,omitempty"`
+	ReplacementBuys   []string `json:"replacement_buys,omitempty"` // transaction ids
+}
+
+// ComputeRealized matches sell txID against the portfolio's open lots of its
+// symbol at average cost, as the summary books it: a sell takes the same
+// fraction of every open lot, so the lots' cost adds up to average cost ×
+// shares sold. Proceeds and cost follow INVESTED_INCLUDES_FEES and are
+// converted at each transaction's stored (or today's) rate.
+func (s *TransactionService) ComputeRealized(portfolioID, txID string) (RealizedResponse, error) {
+	target, err := s.repoTx.GetByID(portfolioID, txID)
+	if err != nil {
+		return RealizedResponse{}, err
+	}
+	if target.TradeType != TradeTypeSell {
+		return RealizedResponse{}, ErrNotSell
+	}
+	if target.DeletedAt != nil || target.Pending {
+		return RealizedResponse{}, errors.New("deleted and pending sells realize nothing")
+	}
+	txs, err := s.repoTx.List(portfolioID, ListFilter{Limit: 0})
+	if err != nil {
+		return RealizedResponse{}, err
+	}
+	sym := s.canonicalSymbol(target.Symbol)
+	stableSort(txs, lessForPositions)
+
+	var lots []RealizedLot // open lots, shrunk in proportion by earlier sells
+	var held float64
+	take := func(n float64) []RealizedLot {
+		if n > held {
+			n = held
+		}
+		if n <= 0 {
+			return nil
+		}
+		f := n / held
+		taken := make([]RealizedLot, 0, len(lots))
+		for i := range lots {
+			l := &lots[i]
+			if l.Shares <= 0 {
+				continue
+			}
+			taken = append(taken, RealizedLot{TransactionID: l.TransactionID, Date: l.Date, Currency: l.Currency,
+				Shares: l.Shares * f, Cost: l.Cost * f, CostRef: l.CostRef * f})
+			l.Shares -= l.Shares * f
+			l.Cost -= l.Cost * f
+			l.CostRef -= l.CostRef * f
+		}
+		held -= n
+		if held <= cashEpsilon {
+			lots, held = nil, 0 // closed out; later buys start afresh
+		}
+		return taken
+	}
+	for _, tx := range txs {
+		if s.canonicalSymbol(tx.Symbol) != sym {
+			continue
+		}
+		if tx.ID == target.ID {
+			break
+		}
+		switch {
+		case tx.TradeType == TradeTypeBuy || (tx.TradeType == TradeTypeTransfer && tx.Shares > 0):
+			cost := bookedAmount(tx)
+			if tx.TradeType == TradeTypeTransfer {
+				cost = math.Abs(tx.Total)
+			}
+			lots = append(lots, RealizedLot{TransactionID: tx.ID, Date: tx.Date, Currency: ccyKey(tx.Currency),
+				Shares: tx.Shares, Cost: cost, CostRef: cost * s.txRate(tx)})
+			held += tx.Shares
+		case tx.TradeType == TradeTypeSell:
+			take(tx.Shares)
+		case tx.TradeType == TradeTypeTransfer:
+			take(-tx.Shares)
+		}
+	}
+
+	out := RealizedResponse{
+		TransactionID:   target.ID,
+		Symbol:          target.Symbol,
+		Date:            target.Date,
+		Shares:          target.Shares,
+		Currency:        ccyKey(target.Currency),
+		RefCurrency:     s.refCCY,
+		CostBasisMethod: "average",
+		Proceeds:        bookedAmount(target),
+	}
+	out.ProceedsRef = out.Proceeds * s.txRate(target)
+	if target.Shares > held {
+		out.UnmatchedShares = target.Shares - held
+	}
+	out.Lots = take(target.Shares)
+	if out.Lots == nil {
+		out.Lots = []RealizedLot{}
+	}
+	var cost float64
+	sameCCY := true
+	for _, l := range out.Lots {
+		cost += l.Cost
+		out.CostRef += l.CostRef
+		sameCCY = sameCCY && l.Currency == out.Currency
+	}
+	out.RealizedPLRef = out.ProceedsRef - out.CostRef
+	if sameCCY {
+		pl := out.Proceeds - cost
+		out.Cost, out.RealizedPL = &cost, &pl
+	}
+	if err := s.flagWashSale(&out, target); err != nil {
+		return RealizedResponse{}, err
+	}
+	return out, nil
+}
+
+// flagWashSale checks a loss-making sell for buys of the same symbol, in any
+// portfolio, within washSaleDays before or after it. Shares of those buys
+// that the sell itself used up don't count as replacements. The disallowed
+// part of the loss is the replacement shares' share of the shares sold. Each
+// sell is judged on its own, so one buy may flag several sells.
+func (s *TransactionService) flagWashSale(out *RealizedResponse, target Transaction) error {
+	if washSaleDays == 0 || out.RealizedPLRef >= 0 || target.Shares <= 0 {
+		return nil
+	}
+	pfs, err := s.repoPf.List()
+	if err != nil {
+		return err
+	}
+	used := map[string]float64{}
+	for _, l := range out.Lots {
+		used[l.TransactionID] += l.Shares
+	}
+	from, to := target.Date.AddDate(0, 0, -washSaleDays), target.Date.AddDate(0, 0, washSaleDays)
+	sym := s.canonicalSymbol(target.Symbol)
+	var replacement float64
+	for _, pf := range pfs {
+		txs, err := s.repoTx.List(pf.ID, ListFilter{TradeType: TradeTypeBuy, From: from, To: to, Limit: 0})
+		if err != nil {
+			return err
+		}
+		for _, tx := range txs {
+			if s.canonicalSymbol(tx.Symbol) != sym {
+				continue
+			}
+			if n := tx.Shares - used[tx.ID]; n > cashEpsilon {
+				replacement += n
+				out.ReplacementBuys = append(out.ReplacementBuys, tx.ID)
+			}
+		}
+	}
+	if replacement == 0 {
+		return nil
+	}
+	out.WashSale = true
+	out.WashSaleShares = math.Min(replacement, target.Shares)
+	f := out.WashSaleShares / target.Shares
+	out.DisallowedLossRef = -out.RealizedPLRef * f
+	if out.RealizedPL != nil && *out.RealizedPL < 0 {
+		v := -*out.RealizedPL * f
+		out.DisallowedLoss = &v
+	}
+	return nil
+}
@@ -1,29 +1,63 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// csvReadRecheck is how long reads trust the loaded index before checking
+// the data files for another process's saves again. Writes always check.
+var csvReadRecheck = time.Second
+
 /*
 CSV layout
 
 portfolios.csv
-id,name,base_ccy,created_at,updated_at
+id,name,base_ccy,created_at,updated_at,tags
 
 transactions.csv
-id,portfolio_id,symbol,trade_type,currency,shares,price,fee,date,total,created_at,updated_at
+id,portfolio_id,symbol,trade_type,currency,shares,price,fee,date,total,created_at,updated_at,deleted_at,pending,note,cash_kind,trade_fx_rate,trade_fx_ref
 
 Notes:
 - date = "2006-01-02" (day precision)
 - created_at/updated_at = RFC3339Nano
-- We keep an in-memory index and write the entire file atomically after each mutation.
+- tags = semicolon-joined (optional; older files without the column load with no tags)
+- deleted_at = RFC3339Nano for soft-deleted rows, empty otherwise (optional; older files lack the column)
+- pending = "true" for planned, not yet executed trades, empty otherwise (optional; older files lack the column)
+- note = free-form memo (optional; older files lack the column)
+- cash_kind = deposit|withdrawal|interest|fee for cash rows, empty otherwise (optional; older files lack the column)
+- trade_fx_rate/trade_fx_ref = currency→ref rate on the trade date and the ref it targets, empty when unknown (optional; older files lack the columns)
+- resync.csv (resync_before) holds the time rows last left for good, for delta
+  syncs (see resyncTracker); it is written before the rows are removed
+- We keep an in-memory index and write the entire file atomically after each mutation
+  (temp file fsynced, renamed into place, then the directory fsynced).
+
+Several processes may share one DATA_DIR:
+- every load and save runs under an advisory flock on DATA_DIR/.lock, exclusive
+  for mutations and shared for reads
+- before using the index, each write stats the files and reloads them all
+  when any was rewritten, added or removed since this process last read or wrote it;
+  reads do the same at most once per csvReadRecheck
+
+Yearly files (CSV_SHARD_BY_YEAR=1, or whenever transactions-YYYY.csv files exist):
+- transactions-YYYY.csv hold the rows dated in year YYYY, same layout as above
+- all year files, plus a legacy transactions.csv, are loaded and merged at startup
+- a save routes each row to its year's file (moving it when its date changes year)
+  and rewrites only the files whose rows changed; legacy rows migrate on the first
+  save, leaving transactions.csv with just the header
 */
 
 const (
@@ -34,11 +68,108 @@ const (
 type csvStore struct {
 	dir    string
 	pfPath string
-	txPath string
+	txPath string // single file, or legacy rows in the yearly layout
+
+	resyncPath   string
+	resyncBefore time.Time // see resyncTracker
+
+	byYear  bool                // rows sharded into transactions-YYYY.csv
+	txFile  map[string]string   // by txID: file the row was loaded from or last saved to
+	txFiles map[string][32]byte // yearly layout: known files and a digest of their last-saved rows
 
 	mu           sync.RWMutex
+	lockFile     *os.File               // flocked around loads and saves, shared with other processes
+	stamps       map[string]os.FileInfo // by path: the data files as last read or written here
 	portfolios   map[string]Portfolio
 	transactions map[string]Transaction // by txID
+	loadErrors   []LoadError            // rows skipped or repaired at the last load
+
+	// Rows skipped at load are written back unchanged, so a typo never
+	// costs data: kept holds them by file. A record too malformed to
+	// re-encode blocks writing its file instead (reason by path).
+	kept    map[string][][]string
+	blocked map[string]string
+
+	checked atomic.Int64 // unix nanos of the last stamp check
+	reloads uint64       // reloads of files another process changed
+	clock   Clock        // stamps updated_at/deleted_at
+}
+
+// LoadError describes a CSV row that could not be loaded cleanly. Loading
+// never aborts on a bad row; the row is skipped (or loaded with the bad field
+// left zero, where noted) and reported here instead. Skipped rows stay in
+// their file: saves write them back as they were.
+type LoadError struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+func (e LoadError) String() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Reason)
+}
+
+// LoadErrors returns the problems found while loading the CSV files.
+func (s *csvStore) LoadErrors() []LoadError {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]LoadError(nil), s.loadErrors...)
+}
+
+type csvRow struct {
+	line   int
+	fields []string // nil when the record could not be parsed
+	errMsg string
+}
+
+// readCSVRows reads every data row (header skipped) with its line number.
+// Malformed records come back with errMsg set instead of failing the read.
+func readCSVRows(path string) ([]csvRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1 // row lengths are checked by the loaders
+	var rows []csvRow
+	for first := true; ; first = false {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line, _ := r.FieldPos(0)
+		if err != nil {
+			var pe *csv.ParseError
+			if errors.As(err, &pe) {
+				rows = append(rows, csvRow{line: pe.Line, errMsg: pe.Err.Error()})
+				continue
+			}
+			return nil, err
+		}
+		if first {
+			continue // header
+		}
+		rows = append(rows, csvRow{line: line, fields: rec})
+	}
+	return rows, nil
+}
+
+func (s *csvStore) reportLoadError(path string, line int, format string, args ...any) {
+	s.loadErrors = append(s.loadErrors, LoadError{File: filepath.Base(path), Line: line, Reason: fmt.Sprintf(format, args...)})
+}
+
+// skipRow reports a row that isn't loaded and keeps it for the next save of
+// path. A row whose record couldn't be parsed blocks that save instead.
+func (s *csvStore) skipRow(path string, cr csvRow, format string, args ...any) {
+	s.reportLoadError(path, cr.line, format, args...)
+	if cr.fields == nil {
+		if _, ok := s.blocked[path]; !ok {
+			s.blocked[path] = fmt.Sprintf("line %d: %s", cr.line, cr.errMsg)
+		}
+		return
+	}
+	s.kept[path] = append(s.kept[path], cr.fields)
 }
 
 func NewCSVStore(dir string) (*csvStore, error) {
@@ -48,62 +179,292 @@ func NewCSVStore(dir string) (*csvStore, error) {
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return nil, err
 	}
+	lf, err := os.OpenFile(filepath.Join(dir, ".lock"), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
 	s := &csvStore{
-		dir:          dir,
-		pfPath:       filepath.Join(dir, "portfolios.csv"),
-		txPath:       filepath.Join(dir, "transactions.csv"),
-		portfolios:   map[string]Portfolio{},
-		transactions: map[string]Transaction{},
+		dir:        dir,
+		pfPath:     filepath.Join(dir, "portfolios.csv"),
+		txPath:     filepath.Join(dir, "transactions.csv"),
+		resyncPath: filepath.Join(dir, "resync.csv"),
+		byYear:     truthy(os.Getenv("CSV_SHARD_BY_YEAR")),
+		lockFile:   lf,
+		stamps:     map[string]os.FileInfo{},
+		clock:      systemClock{},
+	}
+	if err := flockFile(lf, true); err != nil {
+		lf.Close()
+		return nil, fmt.Errorf("lock %s: %w", lf.Name(), err)
+	}
+	defer funlockFile(lf)
+	yearFiles, err := s.yearFiles()
+	if err != nil {
+		lf.Close()
+		return nil, err
+	}
+	if len(yearFiles) > 0 {
+		s.byYear = true
 	}
 	if err := s.ensureFiles(); err != nil {
+		lf.Close()
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		lf.Close()
 		return nil, err
 	}
+	return s, nil
+}
+
+// load replaces the in-memory index with the contents of the data files and
+// records their stamps. The caller holds the file lock.
+func (s *csvStore) load() error {
+	s.portfolios = map[string]Portfolio{}
+	s.transactions = map[string]Transaction{}
+	s.txFile = map[string]string{}
+	s.txFiles = map[string][32]byte{}
+	s.loadErrors = nil
+	s.kept = map[string][][]string{}
+	s.blocked = map[string]string{}
+	yearFiles, err := s.yearFiles()
+	if err != nil {
+		return err
+	}
+	if len(yearFiles) > 0 {
+		s.byYear = true
+	}
 	if err := s.loadPortfolios(); err != nil {
+		return err
+	}
+	if err := s.loadTransactions(yearFiles); err != nil {
+		return err
+	}
+	if err := s.loadResync(); err != nil {
+		return err
+	}
+	stamps, err := s.statFiles()
+	if err != nil {
+		return err
+	}
+	s.stamps = stamps
+	return nil
+}
+
+// statFiles stats every data file currently in the directory.
+func (s *csvStore) statFiles() (map[string]os.FileInfo, error) {
+	paths, err := s.yearFiles()
+	if err != nil {
 		return nil, err
 	}
-	if err := s.loadTransactions(); err != nil {
+	paths = append(paths, s.pfPath, s.txPath, s.resyncPath)
+	out := make(map[string]os.FileInfo, len(paths))
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[path] = fi
+	}
+	return out, nil
+}
+
+// refreshLocked reloads the index when another process has changed the data
+// files since they were last read or written here. Every save replaces its
+// file by rename, so a rewrite shows up as a different file even when the
+// size and mtime happen to match.
+func (s *csvStore) refreshLocked() error {
+	now, err := s.statFiles()
+	if err != nil {
+		return err
+	}
+	s.checked.Store(time.Now().UnixNano())
+	changed := len(now) != len(s.stamps)
+	for path, fi := range now {
+		old, ok := s.stamps[path]
+		if !ok || !os.SameFile(old, fi) || !old.ModTime().Equal(fi.ModTime()) || old.Size() != fi.Size() {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.reloads++
+	return nil
+}
+
+// lock takes the store for a mutation: the process mutex, then the exclusive
+// file lock, then a reload if another process has saved in the meantime.
+func (s *csvStore) lock() error {
+	s.mu.Lock()
+	if err := flockFile(s.lockFile, true); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("lock %s: %w", s.lockFile.Name(), err)
+	}
+	if err := s.refreshLocked(); err != nil {
+		s.unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *csvStore) unlock() {
+	funlockFile(s.lockFile)
+	s.mu.Unlock()
+}
+
+// rlock takes the store for reading, after picking up any changes another
+// process has saved. The shared file lock is held only while reloading, and
+// the files are checked at most once per csvReadRecheck.
+func (s *csvStore) rlock() error {
+	s.mu.RLock()
+	if time.Since(time.Unix(0, s.checked.Load())) < csvReadRecheck {
+		return nil
+	}
+	s.mu.RUnlock()
+	s.mu.Lock()
+	if err := flockFile(s.lockFile, false); err != nil {
+		s.mu.Unlock()
+		return fmt.Errorf("lock %s: %w", s.lockFile.Name(), err)
+	}
+	err := s.refreshLocked()
+	funlockFile(s.lockFile)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	return nil
+}
+
+func (s *csvStore) runlock() { s.mu.RUnlock() }
+
+// writeCSV atomically replaces path and records its new stamp, so this
+// process doesn't mistake its own save for another process's.
+func (s *csvStore) writeCSV(path string, rows [][]string) error {
+	if reason, ok := s.blocked[path]; ok {
+		return fmt.Errorf("%s has a malformed row that would be lost (%s); fix the file first", filepath.Base(path), reason)
+	}
+	if err := atomicWriteCSV(path, rows); err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	s.stamps[path] = fi
+	return nil
+}
+
+var reYearFile = regexp.MustCompile(`^transactions-(\d{4})\.csv$`)
+
+// yearFiles lists the transactions-YYYY.csv files in the data directory.
+func (s *csvStore) yearFiles() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
 		return nil, err
 	}
-	return s, nil
+	var out []string
+	for _, e := range entries {
+		if !e.IsDir() && reYearFile.MatchString(e.Name()) {
+			out = append(out, filepath.Join(s.dir, e.Name()))
+		}
+	}
+	return out, nil
+}
+
+// yearPath is the file a row belongs in under the yearly layout.
+func (s *csvStore) yearPath(tx Transaction) string {
+	return filepath.Join(s.dir, fmt.Sprintf("transactions-%04d.csv", tx.Date.Year()))
 }
 
 func (s *csvStore) ensureFiles() error {
 	// portfolios.csv
 	if _, err := os.Stat(s.pfPath); errors.Is(err, os.ErrNotExist) {
-		if err := atomicWriteCSV(s.pfPath, [][]string{
-			{"id", "name", "base_ccy", "created_at", "updated_at"},
+		if err := s.writeCSV(s.pfPath, [][]string{
+			{"id", "name", "base_ccy", "created_at", "updated_at", "tags"},
 		}); err != nil {
 			return err
 		}
 	}
-	// transactions.csv
-	if _, err := os.Stat(s.txPath); errors.Is(err, os.ErrNotExist) {
-		if err := atomicWriteCSV(s.txPath, [][]string{
-			{"id", "portfolio_id", "symbol", "trade_type", "currency", "shares", "price", "fee", "date", "total", "created_at", "updated_at"},
-		}); err != nil {
+	// transactions.csv (year files are created on first save)
+	if _, err := os.Stat(s.txPath); errors.Is(err, os.ErrNotExist) && !s.byYear {
+		if err := s.writeCSV(s.txPath, [][]string{txHeader}); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (s *csvStore) loadPortfolios() error {
-	f, err := os.Open(s.pfPath)
-	if err != nil {
-		return err
+// loadResync reads the resync time; a missing file means nothing has been
+// removed for good. An unreadable time is reported and taken as the load
+// time, so clients resync rather than miss deletes.
+func (s *csvStore) loadResync() error {
+	s.resyncBefore = time.Time{}
+	rows, err := readCSVRows(s.resyncPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
 	}
-	defer f.Close()
-	r := csv.NewReader(f)
-	rows, err := r.ReadAll()
 	if err != nil {
 		return err
 	}
-	if len(rows) <= 1 {
+	for _, cr := range rows {
+		var t time.Time
+		if cr.errMsg == "" && len(cr.fields) > 0 {
+			t, err = time.Parse(tsLayout, cr.fields[0])
+		}
+		if cr.errMsg != "" || len(cr.fields) == 0 || err != nil {
+			s.reportLoadError(s.resyncPath, cr.line, "unreadable resync_before; using the load time")
+			t = s.clock.Now()
+		}
+		if t.After(s.resyncBefore) {
+			s.resyncBefore = t
+		}
+	}
+	return nil
+}
+
+// removedForGoodLocked moves the resync time up to at and saves it. Callers
+// run it before removing rows, so a failure leaves the rows in place.
+func (s *csvStore) removedForGoodLocked(at time.Time) error {
+	if !at.After(s.resyncBefore) {
 		return nil
 	}
-	for i := 1; i < len(rows); i++ {
-		row := rows[i]
+	if err := s.writeCSV(s.resyncPath, [][]string{{"resync_before"}, {at.UTC().Format(tsLayout)}}); err != nil {
+		return err
+	}
+	s.resyncBefore = at
+	return nil
+}
+
+func (s *csvStore) ResyncBefore() (time.Time, error) {
+	if err := s.rlock(); err != nil {
+		return time.Time{}, err
+	}
+	defer s.runlock()
+	return s.resyncBefore, nil
+}
+
+func (s *csvStore) loadPortfolios() error {
+	rows, err := readCSVRows(s.pfPath)
+	if err != nil {
+		return err
+	}
+	for _, cr := range rows {
+		if cr.errMsg != "" {
+			s.skipRow(s.pfPath, cr, "%s; row skipped", cr.errMsg)
+			continue
+		}
+		row := cr.fields
 		if len(row) < 5 {
+			s.skipRow(s.pfPath, cr, "expected at least 5 fields, got %d; row skipped", len(row))
 			continue
 		}
 		createdAt, _ := time.Parse(tsLayout, row[3])
@@ -115,34 +476,62 @@ func (s *csvStore) loadPortfolios() error {
 			CreatedAt: createdAt,
 			UpdatedAt: updatedAt,
 		}
+		if len(row) > 5 && row[5] != "" {
+			p.Tags = strings.Split(row[5], ";")
+		}
 		s.portfolios[p.ID] = p
 	}
 	return nil
 }
 
-func (s *csvStore) loadTransactions() error {
-	f, err := os.Open(s.txPath)
-	if err != nil {
-		return err
+// loadTransactions loads transactions.csv (when present) and the year files.
+// An id already loaded from an earlier file is reported and skipped.
+func (s *csvStore) loadTransactions(yearFiles []string) error {
+	paths := yearFiles
+	if _, err := os.Stat(s.txPath); err == nil {
+		paths = append([]string{s.txPath}, yearFiles...)
 	}
-	defer f.Close()
-	r := csv.NewReader(f)
-	rows, err := r.ReadAll()
+	for _, path := range paths {
+		if err := s.loadTransactionFile(path); err != nil {
+			return err
+		}
+	}
+	if s.byYear {
+		for _, path := range paths {
+			s.txFiles[path] = s.digestRows(s.rowsFor(path))
+		}
+	}
+	return nil
+}
+
+func (s *csvStore) loadTransactionFile(path string) error {
+	rows, err := readCSVRows(path)
 	if err != nil {
 		return err
 	}
-	if len(rows) <= 1 {
-		return nil
-	}
-	for i := 1; i < len(rows); i++ {
-		row := rows[i]
+rowLoop:
+	for _, cr := range rows {
+		if cr.errMsg != "" {
+			s.skipRow(path, cr, "%s; row skipped", cr.errMsg)
+			continue
+		}
+		row := cr.fields
 		if len(row) < 12 {
+			s.skipRow(path, cr, "expected at least 12 fields, got %d; row skipped", len(row))
 			continue
 		}
-		shares, _ := strconv.ParseFloat(row[5], 64)
-		price, _ := strconv.ParseFloat(row[6], 64)
-		fee, _ := strconv.ParseFloat(row[7], 64)
-		total, _ := strconv.ParseFloat(row[9], 64)
+		// Amounts are required: a row with an unreadable number is skipped
+		// rather than loaded as zero.
+		var nums [4]float64
+		for k, col := range []int{5, 6, 7, 9} {
+			v, err := strconv.ParseFloat(row[col], 64)
+			if err != nil {
+				s.skipRow(path, cr, "transaction %s: invalid number %q in column %d; row skipped", row[0], row[col], col+1)
+				continue rowLoop
+			}
+			nums[k] = v
+		}
+		shares, price, fee, total := nums[0], nums[1], nums[2], nums[3]
 
 		// date: prefer 2006-01-02; fallback to RFC3339; then "2006/01/02" if needed
 		var dt time.Time
@@ -153,6 +542,12 @@ func (s *csvStore) loadTransactions() error {
 				break
 			}
 		}
+		if e != nil {
+			s.skipRow(path, cr, "transaction %s: invalid date %q; row skipped", row[0], row[8])
+			continue
+		}
+		// An RFC3339 value keeps its offset; keep only its calendar date
+		dt = time.Date(dt.Year(), dt.Month(), dt.Day(), 0, 0, 0, 0, time.UTC)
 
 		createdAt, _ := time.Parse(tsLayout, row[10])
 		updatedAt, _ := time.Parse(tsLayout, row[11])
@@ -162,7 +557,7 @@ func (s *csvStore) loadTransactions() error {
 			PortfolioID: row[1],
 			Symbol:      row[2],
 			TradeType:   TradeType(row[3]),
-			Currency:    row[4],
+			Currency:    strings.ToUpper(strings.TrimSpace(row[4])), // older files may hold mixed case
 			Shares:      shares,
 			Price:       price,
 			Fee:         fee,
@@ -171,44 +566,198 @@ func (s *csvStore) loadTransactions() error {
 			CreatedAt:   createdAt,
 			UpdatedAt:   updatedAt,
 		}
+		if len(row) > 12 && row[12] != "" {
+			t, err := time.Parse(tsLayout, row[12])
+			if err != nil {
+				s.reportLoadError(path, cr.line, "transaction %s: invalid deleted_at %q; loaded as not deleted", row[0], row[12])
+			} else {
+				tx.DeletedAt = &t
+			}
+		}
+		if len(row) > 13 && row[13] != "" {
+			p, err := strconv.ParseBool(row[13])
+			if err != nil {
+				s.reportLoadError(path, cr.line, "transaction %s: invalid pending %q; loaded as executed", row[0], row[13])
+			}
+			tx.Pending = p
+		}
+		if len(row) > 14 {
+			tx.Note = row[14]
+		}
+		if len(row) > 15 {
+			tx.CashKind = strings.ToLower(strings.TrimSpace(row[15]))
+		}
+		if len(row) > 17 && row[16] != "" {
+			r, err := strconv.ParseFloat(row[16], 64)
+			if err != nil || r <= 0 {
+				s.reportLoadError(path, cr.line, "transaction %s: invalid trade_fx_rate %q; spot rate will be used", row[0], row[16])
+			} else {
+				tx.FXRate = r
+				tx.FXRef = strings.ToUpper(strings.TrimSpace(row[17]))
+			}
+		}
+		if _, dup := s.transactions[tx.ID]; dup {
+			s.skipRow(path, cr, "transaction %s: id already loaded from %s; row skipped", tx.ID, filepath.Base(s.txFile[tx.ID]))
+			continue
+		}
 		s.transactions[tx.ID] = tx
+		s.txFile[tx.ID] = path
 	}
 	return nil
 }
 
 func (s *csvStore) savePortfoliosLocked() error {
 	rows := make([][]string, 0, len(s.portfolios)+1)
-	rows = append(rows, []string{"id", "name", "base_ccy", "created_at", "updated_at"})
+	rows = append(rows, []string{"id", "name", "base_ccy", "created_at", "updated_at", "tags"})
 	for _, p := range s.portfolios {
 		rows = append(rows, []string{
 			p.ID, p.Name, p.BaseCCY,
 			p.CreatedAt.Format(tsLayout),
 			p.UpdatedAt.Format(tsLayout),
+			strings.Join(p.Tags, ";"),
 		})
 	}
-	return atomicWriteCSV(s.pfPath, rows)
+	rows = append(rows, s.kept[s.pfPath]...)
+	return s.writeCSV(s.pfPath, rows)
 }
 
+var txHeader = []string{"id", "portfolio_id", "symbol", "trade_type", "currency", "shares", "price", "fee", "date", "total", "created_at", "updated_at", "deleted_at", "pending", "note", "cash_kind", "trade_fx_rate", "trade_fx_ref"}
+
 func (s *csvStore) saveTransactionsLocked() error {
+	if s.byYear {
+		return s.saveYearFilesLocked()
+	}
 	rows := make([][]string, 0, len(s.transactions)+1)
-	rows = append(rows, []string{"id", "portfolio_id", "symbol", "trade_type", "currency", "shares", "price", "fee", "date", "total", "created_at", "updated_at"})
+	rows = append(rows, txHeader)
 	for _, tx := range s.transactions {
-		rows = append(rows, []string{
-			tx.ID,
-			tx.PortfolioID,
-			tx.Symbol,
-			string(tx.TradeType),
-			tx.Currency,
-			fmt.Sprintf("%.10f", tx.Shares),
-			fmt.Sprintf("%.10f", tx.Price),
-			fmt.Sprintf("%.10f", tx.Fee),
-			tx.Date.Format(txDateLayout),
-			fmt.Sprintf("%.10f", tx.Total),
-			tx.CreatedAt.Format(tsLayout),
-			tx.UpdatedAt.Format(tsLayout),
-		})
+		rows = append(rows, txRecord(tx))
+	}
+	rows = append(rows, s.kept[s.txPath]...)
+	return s.writeCSV(s.txPath, rows)
+}
+
+// saveYearFilesLocked routes every row to its year file and rewrites the
+// files whose rows differ from the last save, including files a row moved
+// out of. A file left with no rows keeps just the header.
+func (s *csvStore) saveYearFilesLocked() error {
+	prev := make(map[string]string, len(s.txFile))
+	for id, path := range s.txFile {
+		prev[id] = path
+	}
+	for id := range s.txFile {
+		if _, ok := s.transactions[id]; !ok {
+			delete(s.txFile, id) // purged
+		}
+	}
+	for _, tx := range s.transactions {
+		path := s.yearPath(tx)
+		s.txFile[tx.ID] = path
+		if _, ok := s.txFiles[path]; !ok {
+			s.txFiles[path] = [32]byte{} // new year file
+		}
+	}
+	paths := make([]string, 0, len(s.txFiles))
+	for path := range s.txFiles {
+		paths = append(paths, path)
+	}
+	// A row that moved (a new date's year, or a legacy row leaving
+	// transactions.csv) changes two files. The files gaining rows are written
+	// first and those only losing rows last, so a crash in between leaves
+	// the row in both files (reported as a duplicate id at load) rather than
+	// in neither.
+	gains := map[string]bool{}
+	for id, path := range s.txFile {
+		if prev[id] != path {
+			gains[path] = true
+		}
+	}
+	sort.Strings(paths)
+	stableSort(paths, func(a, b string) bool { return gains[a] && !gains[b] })
+	for _, path := range paths {
+		recs := s.rowsFor(path)
+		sum := s.digestRows(recs)
+		if sum == s.txFiles[path] {
+			continue
+		}
+		if err := s.writeCSV(path, append([][]string{txHeader}, recs...)); err != nil {
+			return err
+		}
+		s.txFiles[path] = sum
+	}
+	return nil
+}
+
+// rowsFor returns the records stored in path, ordered by date then id,
+// followed by the rows kept from its last load.
+func (s *csvStore) rowsFor(path string) [][]string {
+	var txs []Transaction
+	for id, p := range s.txFile {
+		if p == path {
+			txs = append(txs, s.transactions[id])
+		}
+	}
+	stableSort(txs, func(a, b Transaction) bool {
+		if !a.Date.Equal(b.Date) {
+			return a.Date.Before(b.Date)
+		}
+		return a.ID < b.ID
+	})
+	recs := make([][]string, len(txs))
+	for i, tx := range txs {
+		recs[i] = txRecord(tx)
+	}
+	return append(recs, s.kept[path]...)
+}
+
+// digestRows fingerprints a file's records, to skip rewriting unchanged files.
+func (s *csvStore) digestRows(recs [][]string) [32]byte {
+	h := sha256.New()
+	for _, rec := range recs {
+		for _, f := range rec {
+			h.Write([]byte(f))
+			h.Write([]byte{0x1f})
+		}
+		h.Write([]byte{0x1e})
+	}
+	var sum [32]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// txRecord is tx as a transactions CSV row (see txHeader).
+func txRecord(tx Transaction) []string {
+	deletedAt := ""
+	if tx.DeletedAt != nil {
+		deletedAt = tx.DeletedAt.Format(tsLayout)
+	}
+	pending := ""
+	if tx.Pending {
+		pending = "true"
+	}
+	fxRate := ""
+	if tx.FXRate > 0 {
+		fxRate = fmt.Sprintf("%.10f", tx.FXRate)
+	}
+	return []string{
+		tx.ID,
+		tx.PortfolioID,
+		tx.Symbol,
+		string(tx.TradeType),
+		tx.Currency,
+		fmt.Sprintf("%.10f", tx.Shares),
+		fmt.Sprintf("%.10f", tx.Price),
+		fmt.Sprintf("%.10f", tx.Fee),
+		tx.Date.Format(txDateLayout),
+		fmt.Sprintf("%.10f", tx.Total),
+		tx.CreatedAt.Format(tsLayout),
+		tx.UpdatedAt.Format(tsLayout),
+		deletedAt,
+		pending,
+		tx.Note,
+		tx.CashKind,
+		fxRate,
+		tx.FXRef,
 	}
-	return atomicWriteCSV(s.txPath, rows)
 }
 
 func atomicWriteCSV(path string, rows [][]string) error {
@@ -230,11 +779,36 @@ func atomicWriteCSV(path string, rows [][]string) error {
 		os.Remove(tmpPath)
 		return err
 	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
 	if err := tmp.Close(); err != nil {
 		os.Remove(tmpPath)
 		return err
 	}
-	return os.Rename(tmpPath, path)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	// The rename has landed: the new rows are what readers (and the next
+	// load) see, so a failed directory sync must not make callers roll
+	// their in-memory state back to rows that are no longer on disk.
+	if err := syncDir(dir); err != nil {
+		log.Printf("csv store: sync %s after writing %s: %v", dir, filepath.Base(path), err)
+	}
+	return nil
+}
+
+// syncDir fsyncs a directory so a rename into it survives a power loss.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 /* ======================== Portfolio repo ======================== */
@@ -243,16 +817,28 @@ type csvPortfolioRepo struct{ s *csvStore }
 
 func NewCSVPortfolioRepo(s *csvStore) *csvPortfolioRepo { return &csvPortfolioRepo{s: s} }
 
+func (r *csvPortfolioRepo) SetClock(c Clock) { r.s.clock = c }
+
+func (r *csvPortfolioRepo) ResyncBefore() (time.Time, error) { return r.s.ResyncBefore() }
+
 func (r *csvPortfolioRepo) Create(p Portfolio) (Portfolio, error) {
-	r.s.mu.Lock()
-	defer r.s.mu.Unlock()
+	if err := r.s.lock(); err != nil {
+		return Portfolio{}, err
+	}
+	defer r.s.unlock()
 	r.s.portfolios[p.ID] = p
-	return p, r.s.savePortfoliosLocked()
+	if err := r.s.savePortfoliosLocked(); err != nil {
+		delete(r.s.portfolios, p.ID)
+		return Portfolio{}, err
+	}
+	return p, nil
 }
 
 func (r *csvPortfolioRepo) GetByID(id string) (Portfolio, error) {
-	r.s.mu.RLock()
-	defer r.s.mu.RUnlock()
+	if err := r.s.rlock(); err != nil {
+		return Portfolio{}, err
+	}
+	defer r.s.runlock()
 	p, ok := r.s.portfolios[id]
 	if !ok {
 		return Portfolio{}, ErrNotFound
@@ -261,8 +847,10 @@ func (r *csvPortfolioRepo) GetByID(id string) (Portfolio, error) {
 }
 
 func (r *csvPortfolioRepo) List() ([]Portfolio, error) {
-	r.s.mu.RLock()
-	defer r.s.mu.RUnlock()
+	if err := r.s.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.s.runlock()
 	out := make([]Portfolio, 0, len(r.s.portfolios))
 	for _, p := range r.s.portfolios {
 		out = append(out, p)
@@ -271,34 +859,62 @@ func (r *csvPortfolioRepo) List() ([]Portfolio, error) {
 }
 
 func (r *csvPortfolioRepo) Update(p Portfolio) (Portfolio, error) {
-	r.s.mu.Lock()
-	defer r.s.mu.Unlock()
-	if _, ok := r.s.portfolios[p.ID]; !ok {
+	if err := r.s.lock(); err != nil {
+		return Portfolio{}, err
+	}
+	defer r.s.unlock()
+	old, ok := r.s.portfolios[p.ID]
+	if !ok {
 		return Portfolio{}, ErrNotFound
 	}
 	// ensure UpdatedAt is respected by caller (service sets it); still bump to now for safety
-	p.UpdatedAt = time.Now()
+	p.UpdatedAt = r.s.clock.Now()
 	r.s.portfolios[p.ID] = p
-	return p, r.s.savePortfoliosLocked()
+	if err := r.s.savePortfoliosLocked(); err != nil {
+		r.s.portfolios[p.ID] = old
+		return Portfolio{}, err
+	}
+	return p, nil
 }
 
 func (r *csvPortfolioRepo) Delete(id string) error {
-	r.s.mu.Lock()
-	defer r.s.mu.Unlock()
-	if _, ok := r.s.portfolios[id]; !ok {
+	if err := r.s.lock(); err != nil {
+		return err
+	}
+	defer r.s.unlock()
+	p, ok := r.s.portfolios[id]
+	if !ok {
 		return ErrNotFound
 	}
+	if err := r.s.removedForGoodLocked(r.s.clock.Now()); err != nil {
+		return err
+	}
 	delete(r.s.portfolios, id)
 	// cascade delete transactions
+	removed := map[string]Transaction{}
 	for txID, tx := range r.s.transactions {
 		if tx.PortfolioID == id {
+			removed[txID] = tx
 			delete(r.s.transactions, txID)
 		}
 	}
-	if err := r.s.savePortfoliosLocked(); err != nil {
+	err := r.s.savePortfoliosLocked()
+	if err == nil {
+		err = r.s.saveTransactionsLocked()
+	}
+	if err != nil {
+		// Put everything back, and rewrite the portfolios file in case it
+		// was saved before the transactions failed
+		r.s.portfolios[id] = p
+		for txID, tx := range removed {
+			r.s.transactions[txID] = tx
+		}
+		if rerr := r.s.savePortfoliosLocked(); rerr != nil {
+			log.Printf("csv: restoring portfolio %s after a failed delete: %v", id, rerr)
+		}
 		return err
 	}
-	return r.s.saveTransactionsLocked()
+	return nil
 }
 
 /* ======================== Transaction repo ======================== */
@@ -307,44 +923,131 @@ type csvTransactionRepo struct{ s *csvStore }
 
 func NewCSVTransactionRepo(s *csvStore) *csvTransactionRepo { return &csvTransactionRepo{s: s} }
 
+func (r *csvTransactionRepo) SetClock(c Clock) { r.s.clock = c }
+
+func (r *csvTransactionRepo) ResyncBefore() (time.Time, error) { return r.s.ResyncBefore() }
+
+// LoadErrors exposes the store's startup load problems (both CSV files).
+func (r *csvTransactionRepo) LoadErrors() []LoadError { return r.s.LoadErrors() }
+
+func (r *csvTransactionRepo) Reloads() (uint64, error) {
+	if err := r.s.rlock(); err != nil {
+		return 0, err
+	}
+	defer r.s.runlock()
+	return r.s.reloads, nil
+}
+
 func (r *csvTransactionRepo) Create(portfolioID string, tx Transaction) (Transaction, error) {
-	r.s.mu.Lock()
-	defer r.s.mu.Unlock()
+	if err := r.s.lock(); err != nil {
+		return Transaction{}, err
+	}
+	defer r.s.unlock()
 	if _, ok := r.s.portfolios[portfolioID]; !ok {
 		return Transaction{}, ErrPortfolioNotFound
 	}
+	if _, ok := r.s.transactions[tx.ID]; ok {
+		return Transaction{}, fmt.Errorf("%w %q", ErrDuplicateID, tx.ID)
+	}
 	r.s.transactions[tx.ID] = tx
-	return tx, r.s.saveTransactionsLocked()
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		delete(r.s.transactions, tx.ID)
+		return Transaction{}, err
+	}
+	return tx, nil
 }
 
 func (r *csvTransactionRepo) CreateBatch(portfolioID string, txs []Transaction) ([]Transaction, error) {
-	r.s.mu.Lock()
-	defer r.s.mu.Unlock()
+	if err := r.s.lock(); err != nil {
+		return nil, err
+	}
+	defer r.s.unlock()
 	if _, ok := r.s.portfolios[portfolioID]; !ok {
 		return nil, ErrPortfolioNotFound
 	}
-	for _, tx := range txs {
+	batch, err := prepareBatch(portfolioID, txs, func(id string) bool {
+		_, ok := r.s.transactions[id]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range batch {
 		r.s.transactions[tx.ID] = tx
 	}
-	return txs, r.s.saveTransactionsLocked()
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		// roll back the in-memory index so it keeps matching the file on disk
+		for _, tx := range batch {
+			delete(r.s.transactions, tx.ID)
+		}
+		return nil, err
+	}
+	return batch, nil
+}
+
+func (r *csvTransactionRepo) Upsert(portfolioID string, txs []Transaction) ([]Transaction, []bool, error) {
+	if err := r.s.lock(); err != nil {
+		return nil, nil, err
+	}
+	defer r.s.unlock()
+	if _, ok := r.s.portfolios[portfolioID]; !ok {
+		return nil, nil, ErrPortfolioNotFound
+	}
+	batch, err := prepareBatch(portfolioID, txs, func(id string) bool {
+		old, ok := r.s.transactions[id]
+		return ok && old.PortfolioID != portfolioID
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	prev := make(map[string]Transaction)
+	created := make([]bool, len(batch))
+	for i, tx := range batch {
+		if old, ok := r.s.transactions[tx.ID]; ok {
+			prev[tx.ID] = old
+			tx.CreatedAt = old.CreatedAt
+			tx.UpdatedAt = r.s.clock.Now()
+			tx.DeletedAt = nil
+			batch[i] = tx
+		} else {
+			created[i] = true
+		}
+		r.s.transactions[tx.ID] = batch[i]
+	}
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		// restore replaced rows and drop inserted ones
+		for i, tx := range batch {
+			if created[i] {
+				delete(r.s.transactions, tx.ID)
+			} else {
+				r.s.transactions[tx.ID] = prev[tx.ID]
+			}
+		}
+		return nil, nil, err
+	}
+	return batch, created, nil
 }
 
 func (r *csvTransactionRepo) GetByID(portfolioID, txID string) (Transaction, error) {
-	r.s.mu.RLock()
-	defer r.s.mu.RUnlock()
+	if err := r.s.rlock(); err != nil {
+		return Transaction{}, err
+	}
+	defer r.s.runlock()
 	if _, ok := r.s.portfolios[portfolioID]; !ok {
 		return Transaction{}, ErrPortfolioNotFound
 	}
 	tx, ok := r.s.transactions[txID]
-	if !ok || tx.PortfolioID != portfolioID {
+	if !ok || tx.PortfolioID != portfolioID || tx.DeletedAt != nil {
 		return Transaction{}, ErrNotFound
 	}
 	return tx, nil
 }
 
 func (r *csvTransactionRepo) List(portfolioID string, filter ListFilter) ([]Transaction, error) {
-	r.s.mu.RLock()
-	defer r.s.mu.RUnlock()
+	if err := r.s.rlock(); err != nil {
+		return nil, err
+	}
+	defer r.s.runlock()
 	if _, ok := r.s.portfolios[portfolioID]; !ok {
 		return nil, ErrPortfolioNotFound
 	}
@@ -353,53 +1056,211 @@ func (r *csvTransactionRepo) List(portfolioID string, filter ListFilter) ([]Tran
 		if tx.PortfolioID != portfolioID {
 			continue
 		}
-		if filter.Symbol != "" && !equalFold(filter.Symbol, tx.Symbol) {
-			continue
+		if filter.matches(tx) {
+			out = append(out, tx)
 		}
-		out = append(out, tx)
-	}
-	switch filter.Sort {
-	case "date_asc":
-		insertionSort(out, func(a, b Transaction) bool { return a.Date.Before(b.Date) })
-	case "date_desc":
-		insertionSort(out, func(a, b Transaction) bool { return a.Date.After(b.Date) })
 	}
-	start := filter.Offset
-	if start > len(out) {
-		return []Transaction{}, nil
-	}
-	end := len(out)
-	if filter.Limit > 0 && start+filter.Limit < end {
-		end = start + filter.Limit
-	}
-	return out[start:end], nil
+	return pageTransactions(out, filter), nil
 }
 
-func (r *csvTransactionRepo) Update(portfolioID string, tx Transaction) (Transaction, error) {
-	r.s.mu.Lock()
-	defer r.s.mu.Unlock()
+func (r *csvTransactionRepo) Update(portfolioID string, tx Transaction, check precondition) (Transaction, error) {
+	if err := r.s.lock(); err != nil {
+		return Transaction{}, err
+	}
+	defer r.s.unlock()
 	if _, ok := r.s.portfolios[portfolioID]; !ok {
 		return Transaction{}, ErrPortfolioNotFound
 	}
 	old, ok := r.s.transactions[tx.ID]
-	if !ok || old.PortfolioID != portfolioID {
+	if !ok || old.PortfolioID != portfolioID || old.DeletedAt != nil {
 		return Transaction{}, ErrNotFound
 	}
-	tx.UpdatedAt = time.Now()
+	if check != nil {
+		if err := check(old); err != nil {
+			return Transaction{}, err
+		}
+	}
+	tx.UpdatedAt = r.s.clock.Now()
 	r.s.transactions[tx.ID] = tx
-	return tx, r.s.saveTransactionsLocked()
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		r.s.transactions[tx.ID] = old
+		return Transaction{}, err
+	}
+	return tx, nil
 }
 
-func (r *csvTransactionRepo) Delete(portfolioID, txID string) error {
-	r.s.mu.Lock()
-	defer r.s.mu.Unlock()
+func (r *csvTransactionRepo) StampFX(tx Transaction, rate float64, ref string) (Transaction, bool, error) {
+	if err := r.s.lock(); err != nil {
+		return Transaction{}, false, err
+	}
+	defer r.s.unlock()
+	old, ok := r.s.transactions[tx.ID]
+	if !ok || !fxStampable(old, tx) {
+		return Transaction{}, false, nil
+	}
+	cur := old
+	cur.FXRate, cur.FXRef = rate, ref
+	cur.UpdatedAt = r.s.clock.Now()
+	r.s.transactions[tx.ID] = cur
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		r.s.transactions[tx.ID] = old
+		return Transaction{}, false, err
+	}
+	return cur, true, nil
+}
+
+func (r *csvTransactionRepo) Delete(portfolioID, txID string, check precondition) error {
+	if err := r.s.lock(); err != nil {
+		return err
+	}
+	defer r.s.unlock()
 	if _, ok := r.s.portfolios[portfolioID]; !ok {
 		return ErrPortfolioNotFound
 	}
 	tx, ok := r.s.transactions[txID]
-	if !ok || tx.PortfolioID != portfolioID {
+	if !ok || tx.PortfolioID != portfolioID || tx.DeletedAt != nil {
 		return ErrNotFound
 	}
-	delete(r.s.transactions, txID)
-	return r.s.saveTransactionsLocked()
+	if check != nil {
+		if err := check(tx); err != nil {
+			return err
+		}
+	}
+	old := tx
+	now := r.s.clock.Now()
+	tx.DeletedAt = &now
+	tx.UpdatedAt = now
+	r.s.transactions[txID] = tx
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		r.s.transactions[txID] = old
+		return err
+	}
+	return nil
+}
+
+func (r *csvTransactionRepo) Restore(portfolioID, txID string) (Transaction, error) {
+	if err := r.s.lock(); err != nil {
+		return Transaction{}, err
+	}
+	defer r.s.unlock()
+	if _, ok := r.s.portfolios[portfolioID]; !ok {
+		return Transaction{}, ErrPortfolioNotFound
+	}
+	tx, ok := r.s.transactions[txID]
+	if !ok || tx.PortfolioID != portfolioID || tx.DeletedAt == nil {
+		return Transaction{}, ErrNotFound
+	}
+	old := tx
+	tx.DeletedAt = nil
+	tx.UpdatedAt = r.s.clock.Now()
+	r.s.transactions[txID] = tx
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		r.s.transactions[txID] = old
+		return Transaction{}, err
+	}
+	return tx, nil
+}
+
+func (r *csvTransactionRepo) CreateTransfer(out, in Transaction, prepare transferPrep) (Transaction, Transaction, error) {
+	if err := r.s.lock(); err != nil {
+		return Transaction{}, Transaction{}, err
+	}
+	defer r.s.unlock()
+	_, ok1 := r.s.portfolios[out.PortfolioID]
+	_, ok2 := r.s.portfolios[in.PortfolioID]
+	if !ok1 || !ok2 {
+		return Transaction{}, Transaction{}, ErrPortfolioNotFound
+	}
+	if prepare != nil {
+		var source []Transaction
+		for _, tx := range r.s.transactions {
+			if tx.PortfolioID == out.PortfolioID && (ListFilter{}).matches(tx) {
+				source = append(source, tx)
+			}
+		}
+		var err error
+		if out, in, err = prepare(source, out, in); err != nil {
+			return Transaction{}, Transaction{}, err
+		}
+	}
+	for _, id := range []string{out.ID, in.ID} {
+		if _, ok := r.s.transactions[id]; ok {
+			return Transaction{}, Transaction{}, fmt.Errorf("%w %q", ErrDuplicateID, id)
+		}
+	}
+	r.s.transactions[out.ID] = out
+	r.s.transactions[in.ID] = in
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		delete(r.s.transactions, out.ID)
+		delete(r.s.transactions, in.ID)
+		return Transaction{}, Transaction{}, err
+	}
+	return out, in, nil
+}
+
+func (r *csvTransactionRepo) DeleteAll(portfolioID string) ([]Transaction, error) {
+	if err := r.s.lock(); err != nil {
+		return nil, err
+	}
+	defer r.s.unlock()
+	if _, ok := r.s.portfolios[portfolioID]; !ok {
+		return nil, ErrPortfolioNotFound
+	}
+	var removed []Transaction
+	for _, tx := range r.s.transactions {
+		if tx.PortfolioID == portfolioID {
+			removed = append(removed, tx)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if err := r.s.removedForGoodLocked(r.s.clock.Now()); err != nil {
+		return nil, err
+	}
+	for _, tx := range removed {
+		delete(r.s.transactions, tx.ID)
+	}
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		// keep memory consistent with the file on failure
+		for _, tx := range removed {
+			r.s.transactions[tx.ID] = tx
+		}
+		return nil, err
+	}
+	return removed, nil
+}
+
+func (r *csvTransactionRepo) PurgeDeleted(cutoff time.Time) (int, error) {
+	if err := r.s.lock(); err != nil {
+		return 0, err
+	}
+	defer r.s.unlock()
+	removed := map[string]Transaction{}
+	var last time.Time
+	for id, tx := range r.s.transactions {
+		if tx.DeletedAt != nil && tx.DeletedAt.Before(cutoff) {
+			removed[id] = tx
+			if tx.UpdatedAt.After(last) {
+				last = tx.UpdatedAt
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return 0, nil
+	}
+	if err := r.s.removedForGoodLocked(last); err != nil {
+		return 0, err
+	}
+	for id := range removed {
+		delete(r.s.transactions, id)
+	}
+	if err := r.s.saveTransactionsLocked(); err != nil {
+		// keep memory consistent with the file on failure
+		for id, tx := range removed {
+			r.s.transactions[id] = tx
+		}
+		return 0, err
+	}
+	return len(removed), nil
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPortfoliosCSV = "id,name,base_ccy,created_at,updated_at,tags\np1,Main,USD,2025-01-01T00:00:00Z,2025-01-01T00:00:00Z,\n"
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSVKeepsSkippedRows(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "portfolios.csv", testPortfoliosCSV)
+	bad := "b,p1,AAPL,buy,USD,x,100,0,2025-01-02,-1000,2025-01-02T00:00:00Z,2025-01-02T00:00:00Z"
+	writeTestFile(t, dir, "transactions.csv", strings.Join(txHeader, ",")+"\n"+bad+"\n")
+
+	st, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewCSVTransactionRepo(st)
+	if n := len(repo.LoadErrors()); n != 1 {
+		t.Fatalf("load errors = %d, want 1", n)
+	}
+	now := time.Now()
+	if _, err := repo.Create("p1", Transaction{ID: "a", PortfolioID: "p1", Symbol: "MSFT", TradeType: TradeTypeBuy,
+		Currency: "USD", Shares: 1, Price: 10, Total: -10, Date: now, CreatedAt: now, UpdatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "transactions.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), bad) {
+		t.Fatalf("skipped row lost on save:\n%s", b)
+	}
+}
+
+func TestCSVUnparseableRowBlocksSave(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "portfolios.csv", testPortfoliosCSV)
+	writeTestFile(t, dir, "transactions.csv", strings.Join(txHeader, ",")+"\n"+`b,p1,"AAPL"x,buy`+"\n")
+
+	st, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	_, err = NewCSVTransactionRepo(st).Create("p1", Transaction{ID: "a", PortfolioID: "p1", Symbol: "MSFT", TradeType: TradeTypeBuy,
+		Currency: "USD", Shares: 1, Price: 10, Total: -10, Date: now, CreatedAt: now, UpdatedAt: now})
+	if err == nil {
+		t.Fatal("save over an unparseable row succeeded")
+	}
+}
+
+func TestCSVYearMoveWritesNewShardFirst(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "portfolios.csv", testPortfoliosCSV)
+	row := "a,p1,AAPL,buy,USD,1,100,0,2023-12-29,-100,2023-12-29T00:00:00Z,2023-12-29T00:00:00Z"
+	writeTestFile(t, dir, "transactions-2023.csv", strings.Join(txHeader, ",")+"\n"+row+"\n")
+	// The 2024 shard can't be replaced, so the move fails halfway
+	if err := os.Mkdir(filepath.Join(dir, "transactions-2024.csv"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewCSVTransactionRepo(st)
+	tx, err := repo.GetByID("p1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Date = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if _, err := repo.Update("p1", tx, nil); err == nil {
+		t.Fatal("update succeeded despite the unwritable shard")
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "transactions-2023.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "a,p1,AAPL") {
+		t.Fatalf("row removed from its old shard before the new one was written:\n%s", b)
+	}
+}
+
+func TestCSVExternalSaveInvalidatesSummaries(t *testing.T) {
+	defer func(d time.Duration) { csvReadRecheck = d }(csvReadRecheck)
+	csvReadRecheck = 0
+	dir := t.TempDir()
+	writeTestFile(t, dir, "portfolios.csv", testPortfoliosCSV)
+	here, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewTransactionService(NewCSVTransactionRepo(here), NewCSVPortfolioRepo(here), fixedPrices{"AAPL": 10}, nil, "USD")
+	buy := func(id string) Transaction {
+		now := time.Now()
+		return Transaction{ID: id, PortfolioID: "p1", Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD",
+			Shares: 1, Price: 10, Total: -10, Date: now, CreatedAt: now, UpdatedAt: now}
+	}
+	if _, err := NewCSVTransactionRepo(here).Create("p1", buy("a")); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := svc.ComputeSummary("p1"); err != nil || !approx(sum.TotalMarketValue, 10) {
+		t.Fatalf("summary = %v, %v; want market value 10", sum.TotalMarketValue, err)
+	}
+	if _, err := NewCSVTransactionRepo(other).Create("p1", buy("b")); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := svc.ComputeSummary("p1"); err != nil || !approx(sum.TotalMarketValue, 20) {
+		t.Errorf("summary after another process's save = %v, %v; want market value 20", sum.TotalMarketValue, err)
+	}
+}
+
+func TestCSVResyncTimePersists(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "portfolios.csv", testPortfoliosCSV)
+	st, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2025, 5, 6, 7, 8, 9, 0, time.UTC)
+	repo := NewCSVTransactionRepo(st)
+	repo.SetClock(FixedClock(at))
+	if _, err := repo.Create("p1", Transaction{ID: "a", PortfolioID: "p1", TradeType: TradeTypeCash, Currency: "USD",
+		Total: 1, Date: at, CreatedAt: at, UpdatedAt: at}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := repo.DeleteAll("p1"); err != nil || len(removed) != 1 {
+		t.Fatalf("delete all = %v, %v", removed, err)
+	}
+
+	reopened, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewCSVTransactionRepo(reopened).ResyncBefore()
+	if err != nil || !got.Equal(at) {
+		t.Errorf("resync_before after reopen = %v, %v; want %v", got, err, at)
+	}
+}
+
+func TestCSVFailedSaveLeavesMemoryUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "portfolios.csv", testPortfoliosCSV)
+	rows := []string{
+		"live,p1,AAPL,buy,USD,1,100,0,2025-01-02,-100,2025-01-02T00:00:00Z,2025-01-02T00:00:00Z,,false,,,,",
+		"gone,p1,MSFT,buy,USD,1,100,0,2025-01-02,-100,2025-01-02T00:00:00Z,2025-01-03T00:00:00Z,2025-01-03T00:00:00Z,false,,,,",
+	}
+	writeTestFile(t, dir, "transactions.csv", strings.Join(txHeader, ",")+"\n"+strings.Join(rows, "\n")+"\n")
+	st, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, pfs := NewCSVTransactionRepo(st), NewCSVPortfolioRepo(st)
+	snapshot := func() (map[string]Transaction, map[string]Portfolio) {
+		tm := map[string]Transaction{}
+		for id, tx := range st.transactions {
+			tm[id] = tx
+		}
+		pm := map[string]Portfolio{}
+		for id, p := range st.portfolios {
+			pm[id] = p
+		}
+		return tm, pm
+	}
+	wantTx, wantPf := snapshot()
+	live := st.transactions["live"]
+	live.Shares = 2
+	now := time.Now()
+
+	st.blocked[st.txPath] = "test"
+	failed := map[string]error{}
+	_, failed["create"] = txs.Create("p1", Transaction{ID: "new", PortfolioID: "p1", Symbol: "TSLA", TradeType: TradeTypeBuy, Currency: "USD", Shares: 1, Date: now})
+	_, failed["update"] = txs.Update("p1", live, nil)
+	failed["delete"] = txs.Delete("p1", "live", nil)
+	_, failed["restore"] = txs.Restore("p1", "gone")
+	_, failed["purge"] = txs.PurgeDeleted(now)
+	failed["delete portfolio"] = pfs.Delete("p1")
+	delete(st.blocked, st.txPath)
+
+	st.blocked[st.pfPath] = "test"
+	_, failed["create portfolio"] = pfs.Create(Portfolio{ID: "p2", Name: "Other", BaseCCY: "USD", CreatedAt: now, UpdatedAt: now})
+	_, failed["update portfolio"] = pfs.Update(Portfolio{ID: "p1", Name: "Renamed", BaseCCY: "USD"})
+	delete(st.blocked, st.pfPath)
+
+	for op, err := range failed {
+		if err == nil {
+			t.Errorf("%s succeeded with the file blocked", op)
+		}
+	}
+	gotTx, gotPf := snapshot()
+	if len(gotTx) != len(wantTx) || len(gotPf) != len(wantPf) {
+		t.Fatalf("after failed saves: %d transactions, %d portfolios; want %d, %d", len(gotTx), len(gotPf), len(wantTx), len(wantPf))
+	}
+	for id, tx := range wantTx {
+		g := gotTx[id]
+		if g.Shares != tx.Shares || (g.DeletedAt == nil) != (tx.DeletedAt == nil) || !g.UpdatedAt.Equal(tx.UpdatedAt) {
+			t.Errorf("transaction %s changed by a failed save: %+v, want %+v", id, g, tx)
+		}
+	}
+	if gotPf["p1"].Name != wantPf["p1"].Name {
+		t.Errorf("portfolio renamed by a failed save: %q", gotPf["p1"].Name)
+	}
+
+	// The files still agree with memory
+	st2, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st2.transactions) != len(wantTx) || len(st2.portfolios) != len(wantPf) {
+		t.Errorf("on disk: %d transactions, %d portfolios; want %d, %d", len(st2.transactions), len(st2.portfolios), len(wantTx), len(wantPf))
+	}
+}
+
+func TestCSVLoadUpperCasesCurrency(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, dir, "portfolios.csv", testPortfoliosCSV)
+	rows := []string{
+		"a,p1,AAPL,buy,usd,1,100,0,2025-01-02,-100,2025-01-02T00:00:00Z,2025-01-02T00:00:00Z",
+		"b,p1,AAPL,buy, Usd ,1,100,0,2025-01-03,-100,2025-01-03T00:00:00Z,2025-01-03T00:00:00Z",
+		"c,p1,AAPL,buy,USD,1,100,0,2025-01-04,-100,2025-01-04T00:00:00Z,2025-01-04T00:00:00Z",
+	}
+	writeTestFile(t, dir, "transactions.csv", strings.Join(txHeader, ",")+"\n"+strings.Join(rows, "\n")+"\n")
+
+	st, err := NewCSVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewCSVTransactionRepo(st).List("p1", ListFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(rows) {
+		t.Fatalf("loaded %d rows, want %d", len(got), len(rows))
+	}
+	for _, tx := range got {
+		if tx.Currency != "USD" {
+			t.Errorf("%s: currency %q, want USD", tx.ID, tx.Currency)
+		}
+	}
+}
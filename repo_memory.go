@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"sync"
 	"time"
 )
@@ -11,12 +12,15 @@ type memoryStore struct {
 	mu           sync.RWMutex
 	portfolios   map[string]Portfolio
 	transactions map[string]map[string]Transaction // portfolioID -> txID -> tx
+	clock        Clock                             // stamps updated_at/deleted_at
+	resyncBefore time.Time                         // see resyncTracker
 }
 
 func newMemoryStore() *memoryStore {
 	return &memoryStore{
 		portfolios:   make(map[string]Portfolio),
 		transactions: make(map[string]map[string]Transaction),
+		clock:        systemClock{},
 	}
 }
 
@@ -26,6 +30,8 @@ type memoryPortfolioRepo struct{ s *memoryStore }
 
 func NewMemoryPortfolioRepo(s *memoryStore) *memoryPortfolioRepo { return &memoryPortfolioRepo{s: s} }
 
+func (r *memoryPortfolioRepo) SetClock(c Clock) { r.s.clock = c }
+
 func (r *memoryPortfolioRepo) Create(p Portfolio) (Portfolio, error) {
 	r.s.mu.Lock()
 	defer r.s.mu.Unlock()
@@ -62,7 +68,7 @@ func (r *memoryPortfolioRepo) Update(p Portfolio) (Portfolio, error) {
 	if _, ok := r.s.portfolios[p.ID]; !ok {
 		return Portfolio{}, ErrNotFound
 	}
-	p.UpdatedAt = time.Now()
+	p.UpdatedAt = r.s.clock.Now()
 	r.s.portfolios[p.ID] = p
 	return p, nil
 }
@@ -75,15 +81,52 @@ func (r *memoryPortfolioRepo) Delete(id string) error {
 	}
 	delete(r.s.portfolios, id)
 	delete(r.s.transactions, id)
+	r.s.removedForGood(r.s.clock.Now())
 	return nil
 }
 
+// removedForGood moves the resync time up to at. The caller holds mu.
+func (s *memoryStore) removedForGood(at time.Time) {
+	if at.After(s.resyncBefore) {
+		s.resyncBefore = at
+	}
+}
+
+func (r *memoryPortfolioRepo) ResyncBefore() (time.Time, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return r.s.resyncBefore, nil
+}
+
 /* ---- Transaction repo ---- */
 
+// Reset drops every portfolio and transaction, returning how many portfolios
+// and transactions (soft-deleted ones included) were removed.
+func (r *memoryTransactionRepo) Reset() (int, int) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	pfs, txs := len(r.s.portfolios), 0
+	for _, pool := range r.s.transactions {
+		txs += len(pool)
+	}
+	r.s.portfolios = make(map[string]Portfolio)
+	r.s.transactions = make(map[string]map[string]Transaction)
+	r.s.removedForGood(r.s.clock.Now())
+	return pfs, txs
+}
+
+func (r *memoryTransactionRepo) ResyncBefore() (time.Time, error) {
+	r.s.mu.RLock()
+	defer r.s.mu.RUnlock()
+	return r.s.resyncBefore, nil
+}
+
 type memoryTransactionRepo struct{ s *memoryStore }
 
 func NewMemoryTransactionRepo(s *memoryStore) *memoryTransactionRepo { return &memoryTransactionRepo{s: s} }
 
+func (r *memoryTransactionRepo) SetClock(c Clock) { r.s.clock = c }
+
 func (r *memoryTransactionRepo) ensurePortfolio(portfolioID string) error {
 	if _, ok := r.s.portfolios[portfolioID]; !ok {
 		return ErrPortfolioNotFound
@@ -94,12 +137,26 @@ func (r *memoryTransactionRepo) ensurePortfolio(portfolioID string) error {
 	return nil
 }
 
+// idTaken reports whether id is stored under any portfolio. Ids are unique
+// across portfolios, as in the CSV store, where rows are keyed by id alone.
+func (r *memoryTransactionRepo) idTaken(id string) bool {
+	for _, pool := range r.s.transactions {
+		if _, ok := pool[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *memoryTransactionRepo) Create(portfolioID string, tx Transaction) (Transaction, error) {
 	r.s.mu.Lock()
 	defer r.s.mu.Unlock()
 	if err := r.ensurePortfolio(portfolioID); err != nil {
 		return Transaction{}, err
 	}
+	if r.idTaken(tx.ID) {
+		return Transaction{}, fmt.Errorf("%w %q", ErrDuplicateID, tx.ID)
+	}
 	r.s.transactions[portfolioID][tx.ID] = tx
 	return tx, nil
 }
@@ -110,10 +167,49 @@ func (r *memoryTransactionRepo) CreateBatch(portfolioID string, txs []Transactio
 	if err := r.ensurePortfolio(portfolioID); err != nil {
 		return nil, err
 	}
-	for _, tx := range txs {
-		r.s.transactions[portfolioID][tx.ID] = tx
+	pool := r.s.transactions[portfolioID]
+	batch, err := prepareBatch(portfolioID, txs, r.idTaken)
+	if err != nil {
+		return nil, err
+	}
+	// Validated as a whole under the write lock; inserting can't fail part-way.
+	for _, tx := range batch {
+		pool[tx.ID] = tx
+	}
+	return batch, nil
+}
+
+func (r *memoryTransactionRepo) Upsert(portfolioID string, txs []Transaction) ([]Transaction, []bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	if err := r.ensurePortfolio(portfolioID); err != nil {
+		return nil, nil, err
+	}
+	batch, err := prepareBatch(portfolioID, txs, func(id string) bool {
+		for pfID, pool := range r.s.transactions {
+			if _, ok := pool[id]; ok && pfID != portfolioID {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	pool := r.s.transactions[portfolioID]
+	created := make([]bool, len(batch))
+	for i, tx := range batch {
+		if old, ok := pool[tx.ID]; ok {
+			tx.CreatedAt = old.CreatedAt
+			tx.UpdatedAt = r.s.clock.Now()
+			tx.DeletedAt = nil
+			batch[i] = tx
+		} else {
+			created[i] = true
+		}
+		pool[tx.ID] = batch[i]
 	}
-	return txs, nil
+	return batch, created, nil
 }
 
 func (r *memoryTransactionRepo) GetByID(portfolioID, txID string) (Transaction, error) {
@@ -124,7 +220,7 @@ func (r *memoryTransactionRepo) GetByID(portfolioID, txID string) (Transaction,
 		return Transaction{}, ErrPortfolioNotFound
 	}
 	tx, ok := pool[txID]
-	if !ok {
+	if !ok || tx.DeletedAt != nil {
 		return Transaction{}, ErrNotFound
 	}
 	return tx, nil
@@ -139,54 +235,149 @@ func (r *memoryTransactionRepo) List(portfolioID string, filter ListFilter) ([]T
 	}
 	out := make([]Transaction, 0, len(pool))
 	for _, tx := range pool {
-		if filter.Symbol != "" && !equalFold(filter.Symbol, tx.Symbol) {
-			continue
+		if filter.matches(tx) {
+			out = append(out, tx)
 		}
-		out = append(out, tx)
-	}
-	switch filter.Sort {
-	case "date_asc":
-		insertionSort(out, func(a, b Transaction) bool { return a.Date.Before(b.Date) })
-	case "date_desc":
-		insertionSort(out, func(a, b Transaction) bool { return a.Date.After(b.Date) })
-	}
-	start := filter.Offset
-	if start > len(out) {
-		return []Transaction{}, nil
-	}
-	end := len(out)
-	if filter.Limit > 0 && start+filter.Limit < end {
-		end = start + filter.Limit
 	}
-	return out[start:end], nil
+	return pageTransactions(out, filter), nil
 }
 
-func (r *memoryTransactionRepo) Update(portfolioID string, tx Transaction) (Transaction, error) {
+func (r *memoryTransactionRepo) Update(portfolioID string, tx Transaction, check precondition) (Transaction, error) {
 	r.s.mu.Lock()
 	defer r.s.mu.Unlock()
 	pool, ok := r.s.transactions[portfolioID]
 	if !ok {
 		return Transaction{}, ErrPortfolioNotFound
 	}
-	if _, ok := pool[tx.ID]; !ok {
+	old, ok := pool[tx.ID]
+	if !ok || old.DeletedAt != nil {
 		return Transaction{}, ErrNotFound
 	}
-	tx.UpdatedAt = time.Now()
+	if check != nil {
+		if err := check(old); err != nil {
+			return Transaction{}, err
+		}
+	}
+	tx.UpdatedAt = r.s.clock.Now()
 	pool[tx.ID] = tx
 	return tx, nil
 }
 
-func (r *memoryTransactionRepo) Delete(portfolioID, txID string) error {
+func (r *memoryTransactionRepo) StampFX(tx Transaction, rate float64, ref string) (Transaction, bool, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	cur, ok := r.s.transactions[tx.PortfolioID][tx.ID]
+	if !ok || !fxStampable(cur, tx) {
+		return Transaction{}, false, nil
+	}
+	cur.FXRate, cur.FXRef = rate, ref
+	cur.UpdatedAt = r.s.clock.Now()
+	r.s.transactions[tx.PortfolioID][tx.ID] = cur
+	return cur, true, nil
+}
+
+func (r *memoryTransactionRepo) Delete(portfolioID, txID string, check precondition) error {
 	r.s.mu.Lock()
 	defer r.s.mu.Unlock()
 	pool, ok := r.s.transactions[portfolioID]
 	if !ok {
 		return ErrPortfolioNotFound
 	}
-	if _, ok := pool[txID]; !ok {
+	tx, ok := pool[txID]
+	if !ok || tx.DeletedAt != nil {
 		return ErrNotFound
 	}
-	delete(pool, txID)
+	if check != nil {
+		if err := check(tx); err != nil {
+			return err
+		}
+	}
+	now := r.s.clock.Now()
+	tx.DeletedAt = &now
+	tx.UpdatedAt = now
+	pool[txID] = tx
 	return nil
 }
 
+func (r *memoryTransactionRepo) Restore(portfolioID, txID string) (Transaction, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	pool, ok := r.s.transactions[portfolioID]
+	if !ok {
+		return Transaction{}, ErrPortfolioNotFound
+	}
+	tx, ok := pool[txID]
+	if !ok || tx.DeletedAt == nil {
+		return Transaction{}, ErrNotFound
+	}
+	tx.DeletedAt = nil
+	tx.UpdatedAt = r.s.clock.Now()
+	pool[txID] = tx
+	return tx, nil
+}
+
+func (r *memoryTransactionRepo) CreateTransfer(out, in Transaction, prepare transferPrep) (Transaction, Transaction, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	outPool, ok1 := r.s.transactions[out.PortfolioID]
+	inPool, ok2 := r.s.transactions[in.PortfolioID]
+	if !ok1 || !ok2 {
+		return Transaction{}, Transaction{}, ErrPortfolioNotFound
+	}
+	if prepare != nil {
+		var source []Transaction
+		for _, tx := range outPool {
+			if (ListFilter{}).matches(tx) {
+				source = append(source, tx)
+			}
+		}
+		var err error
+		if out, in, err = prepare(source, out, in); err != nil {
+			return Transaction{}, Transaction{}, err
+		}
+	}
+	if r.idTaken(out.ID) {
+		return Transaction{}, Transaction{}, fmt.Errorf("%w %q", ErrDuplicateID, out.ID)
+	}
+	if r.idTaken(in.ID) || in.ID == out.ID {
+		return Transaction{}, Transaction{}, fmt.Errorf("%w %q", ErrDuplicateID, in.ID)
+	}
+	outPool[out.ID] = out
+	inPool[in.ID] = in
+	return out, in, nil
+}
+
+func (r *memoryTransactionRepo) DeleteAll(portfolioID string) ([]Transaction, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	pool, ok := r.s.transactions[portfolioID]
+	if !ok {
+		return nil, ErrPortfolioNotFound
+	}
+	removed := make([]Transaction, 0, len(pool))
+	for _, tx := range pool {
+		removed = append(removed, tx)
+	}
+	r.s.transactions[portfolioID] = map[string]Transaction{}
+	if len(removed) > 0 {
+		r.s.removedForGood(r.s.clock.Now())
+	}
+	return removed, nil
+}
+
+func (r *memoryTransactionRepo) PurgeDeleted(cutoff time.Time) (int, error) {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+	n := 0
+	for _, pool := range r.s.transactions {
+		for id, tx := range pool {
+			if tx.DeletedAt != nil && tx.DeletedAt.Before(cutoff) {
+				delete(pool, id)
+				r.s.removedForGood(tx.UpdatedAt)
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
@@ -1,6 +1,11 @@
 package main
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
 
 // ===== Ports (interfaces) =====
 
@@ -13,26 +18,212 @@ type PortfolioRepository interface {
 }
 
 type ListFilter struct {
-	Symbol string
-	Limit  int
-	Offset int
-	Sort   string // "date_asc" | "date_desc" | ""
+	Symbol         string
+	Limit          int
+	Offset         int
+	Sort           string    // "date_asc" | "date_desc" | "updated_asc" | ""
+	IncludeDeleted bool      // include soft-deleted transactions
+	IncludePending bool      // include pending (not yet executed) transactions
+	TradeType      TradeType // only this trade type ("" = all)
+	From, To       time.Time // inclusive date range (zero = open-ended)
+	UpdatedSince   time.Time // only rows updated at or after this (zero = any)
+}
+
+// matches reports whether tx passes f's row conditions (everything but
+// sorting and paging).
+func (f ListFilter) matches(tx Transaction) bool {
+	if tx.DeletedAt != nil && !f.IncludeDeleted {
+		return false
+	}
+	if tx.Pending && !f.IncludePending {
+		return false
+	}
+	if f.Symbol != "" && !equalFold(f.Symbol, tx.Symbol) {
+		return false
+	}
+	if f.TradeType != "" && !equalFold(string(f.TradeType), string(tx.TradeType)) {
+		return false
+	}
+	if !f.From.IsZero() && tx.Date.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && tx.Date.After(f.To) {
+		return false
+	}
+	if !f.UpdatedSince.IsZero() && tx.UpdatedAt.Before(f.UpdatedSince) {
+		return false
+	}
+	return true
+}
+
+// clockSetter is implemented by repositories that stamp rows themselves
+// (updated_at on changes, deleted_at on soft deletes). The services pass
+// their clock on, so a FixedClock governs those stamps too.
+type clockSetter interface {
+	SetClock(Clock)
+}
+
+// resyncTracker is implemented by repositories that record when rows last
+// left for good: a purge, a delete-all, a portfolio delete or a reset. A
+// delta sync (UpdatedSince) can't report such rows, so a client whose cursor
+// is at or before ResyncBefore has to list everything again (see
+// ErrResyncRequired). For a purge that is the purged rows' last update; the
+// others take effect at the time of the delete.
+type resyncTracker interface {
+	ResyncBefore() (time.Time, error)
+}
+
+// reloader is implemented by repositories another process can change (the
+// CSV store). Reloads picks up such changes and returns how many it has
+// loaded so far, so caches of derived data know when to drop their entries.
+type reloader interface {
+	Reloads() (uint64, error)
+}
+
+// fxStampable reports whether a looked-up trade-date rate for tx may still
+// be recorded on cur, the stored row: it has no rate yet and its currency
+// and date are those the rate was looked up for (see fxStamper).
+func fxStampable(cur, tx Transaction) bool {
+	return cur.DeletedAt == nil && cur.FXRate <= 0 && cur.PortfolioID == tx.PortfolioID &&
+		ccyKey(cur.Currency) == ccyKey(tx.Currency) && cur.Date.Equal(tx.Date)
+}
+
+// pageTransactions sorts the filtered rows by f.Sort and applies Offset/Limit.
+func pageTransactions(out []Transaction, f ListFilter) []Transaction {
+	switch f.Sort {
+	case "date_asc":
+		stableSort(out, func(a, b Transaction) bool { return a.Date.Before(b.Date) })
+	case "date_desc":
+		stableSort(out, func(a, b Transaction) bool { return a.Date.After(b.Date) })
+	case "updated_asc":
+		// ties by ID, so a sync cursor pages through them in a fixed order
+		stableSort(out, func(a, b Transaction) bool {
+			if !a.UpdatedAt.Equal(b.UpdatedAt) {
+				return a.UpdatedAt.Before(b.UpdatedAt)
+			}
+			return a.ID < b.ID
+		})
+	}
+	start := f.Offset
+	if start > len(out) {
+		return []Transaction{}
+	}
+	end := len(out)
+	if f.Limit > 0 && start+f.Limit < end {
+		end = start + f.Limit
+	}
+	return out[start:end]
 }
 
 type TransactionRepository interface {
 	Create(portfolioID string, tx Transaction) (Transaction, error)
+	// CreateBatch is all-or-nothing: either every transaction is stored and
+	// returned in input order, or none is and the store is left unchanged.
+	// Implementations validate the whole set (portfolio exists, portfolio_id
+	// matches, IDs are unique and unused) before committing; a failing row is
+	// reported as a *BatchError.
 	CreateBatch(portfolioID string, txs []Transaction) ([]Transaction, error)
+	// Upsert stores txs by ID in one all-or-nothing write: a new ID is
+	// inserted, an existing one is replaced in place (keeping its CreatedAt
+	// and clearing any soft delete). created[i] reports which happened to
+	// txs[i]. IDs must be unique within txs, and an ID held by another
+	// portfolio is rejected with ErrDuplicateID.
+	Upsert(portfolioID string, txs []Transaction) (out []Transaction, created []bool, err error)
+	// CreateTransfer stores a transfer's out and in legs (in different
+	// portfolios) atomically: both are written or neither is. A non-nil
+	// prepare runs under the write lock with the source portfolio's live
+	// transactions and returns the legs to store, so checks against the
+	// source's holdings can't race another write.
+	CreateTransfer(out, in Transaction, prepare transferPrep) (Transaction, Transaction, error)
+	// GetByID, Update and Delete treat soft-deleted transactions as not found.
 	GetByID(portfolioID, txID string) (Transaction, error)
 	List(portfolioID string, filter ListFilter) ([]Transaction, error)
-	Update(portfolioID string, tx Transaction) (Transaction, error)
-	Delete(portfolioID, txID string) error
+	// Update and Delete run a non-nil check on the stored row under the
+	// write lock, and write nothing when it fails (see precondition).
+	Update(portfolioID string, tx Transaction, check precondition) (Transaction, error)
+	// Delete soft-deletes: the row is kept with DeletedAt set until purged.
+	// UpdatedAt moves too, so delta syncs (UpdatedSince) pick the delete up.
+	Delete(portfolioID, txID string, check precondition) error
+	// Restore clears DeletedAt on a soft-deleted transaction.
+	Restore(portfolioID, txID string) (Transaction, error)
+	// DeleteAll permanently removes every transaction of the portfolio
+	// (pending and soft-deleted ones included) in one write and returns the
+	// removed rows.
+	DeleteAll(portfolioID string) ([]Transaction, error)
+	// PurgeDeleted permanently removes rows soft-deleted before cutoff.
+	PurgeDeleted(cutoff time.Time) (int, error)
 }
 
+// precondition vets the stored row a write is about to replace, e.g. an
+// If-Match ETag; its error is returned as is.
+type precondition func(cur Transaction) error
+
+// transferPrep completes or rejects a transfer's legs given the source
+// portfolio's live transactions (see CreateTransfer).
+type transferPrep func(source []Transaction, out, in Transaction) (Transaction, Transaction, error)
+
 // Common errors
 var ErrNotFound = errors.New("not found")
 var ErrPortfolioNotFound = errors.New("portfolio not found")
+var ErrDuplicateID = errors.New("duplicate transaction id")
+
+// ErrResyncRequired is returned for an updated_since cursor that rows
+// removed for good would have been reported after (see resyncTracker).
+var ErrResyncRequired = errors.New("full resync required")
+
+// checkCursor returns ErrResyncRequired when since is at or before repo's
+// resync time. A zero since (a full listing) always passes.
+func checkCursor(repo any, since time.Time) error {
+	rt, ok := repo.(resyncTracker)
+	if since.IsZero() || !ok {
+		return nil
+	}
+	before, err := rt.ResyncBefore()
+	if err != nil {
+		return err
+	}
+	if !before.IsZero() && !since.After(before) {
+		return fmt.Errorf("%w: rows were removed for good up to %s, after updated_since; list again without updated_since",
+			ErrResyncRequired, before.UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// BatchError reports which input row (0-based) of a batch failed.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string { return fmt.Sprintf("row %d: %v", e.Index, e.Err) }
+func (e *BatchError) Unwrap() error { return e.Err }
 
 /* ======================== small helpers ======================== */
+
+// prepareBatch validates a batch against the portfolio it is being added to
+// and returns a copy with PortfolioID filled in. exists reports whether an ID
+// is already stored.
+func prepareBatch(portfolioID string, txs []Transaction, exists func(id string) bool) ([]Transaction, error) {
+	out := make([]Transaction, len(txs))
+	seen := make(map[string]struct{}, len(txs))
+	for i, tx := range txs {
+		if tx.ID == "" {
+			return nil, &BatchError{Index: i, Err: errors.New("transaction id is required")}
+		}
+		if tx.PortfolioID == "" {
+			tx.PortfolioID = portfolioID
+		}
+		if tx.PortfolioID != portfolioID {
+			return nil, &BatchError{Index: i, Err: fmt.Errorf("portfolio_id %q does not match %q", tx.PortfolioID, portfolioID)}
+		}
+		if _, dup := seen[tx.ID]; dup || exists(tx.ID) {
+			return nil, &BatchError{Index: i, Err: fmt.Errorf("%w %q", ErrDuplicateID, tx.ID)}
+		}
+		seen[tx.ID] = struct{}{}
+		out[i] = tx
+	}
+	return out, nil
+}
 func equalFold(a, b string) bool {
 	if len(a) != len(b) {
 		return false
@@ -52,12 +243,8 @@ func equalFold(a, b string) bool {
 	return true
 }
 
-func insertionSort(xs []Transaction, less func(a, b Transaction) bool) {
-	for i := 1; i < len(xs); i++ {
-		j := i
-		for j > 0 && less(xs[j], xs[j-1]) {
-			xs[j], xs[j-1] = xs[j-1], xs[j]
-			j--
-		}
-	}
+// stableSort sorts xs by less in O(n log n), keeping the input order of
+// equal elements.
+func stableSort[T any](xs []T, less func(a, b T) bool) {
+	sort.SliceStable(xs, func(i, j int) bool { return less(xs[i], xs[j]) })
 }
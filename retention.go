@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Soft-deleted transactions are kept for DELETE_RETENTION (Go duration,
+// default 30 days) so they can be restored, then purged by a background sweep.
+const (
+	defaultDeleteRetention = 30 * 24 * time.Hour
+	deleteSweepInterval    = time.Hour
+)
+
+func deleteRetentionFromEnv() time.Duration {
+	return envDuration("DELETE_RETENTION", defaultDeleteRetention)
+}
+
+// startDeleteSweep purges expired soft-deleted rows once immediately and then
+// every deleteSweepInterval for the life of the process.
+func startDeleteSweep(svc *TransactionService, retention time.Duration) {
+	sweep := func() {
+		n, err := svc.PurgeDeleted(retention)
+		if err != nil {
+			log.Printf("purge deleted transactions: %v", err)
+			return
+		}
+		if n > 0 {
+			log.Printf("purged %d deleted transaction(s) older than %s", n, retention)
+		}
+	}
+	sweep()
+	go func() {
+		t := time.NewTicker(deleteSweepInterval)
+		defer t.Stop()
+		for range t.C {
+			sweep()
+		}
+	}()
+}
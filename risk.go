@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+/* ===================== Beta-adjusted exposure ===================== */
+
+// minBetaReturns is how many paired daily returns a position needs before
+// its beta is reported.
+const minBetaReturns = 20
+
+// RiskItem is one open position's sensitivity to the benchmark. Beta and
+// BetaExposure are null when the symbol lacks enough daily history.
+type RiskItem struct {
+	Symbol        string  `json:"symbol"`
+	MarketValue   float64 `json:"market_value"`
+	WeightPercent float64 `json:"weight_percent"`
+	// Returns is the number of daily returns paired with the benchmark's
+	Returns      int      `json:"returns"`
+	Beta         *float64 `json:"beta"`
+	BetaExposure *float64 `json:"beta_exposure"` // market_value × beta
+	Reason       string   `json:"reason,omitempty"`
+
+	refCCY string // for rounding only; set by the enclosing response
+}
+
+type RiskResponse struct {
+	Benchmark        string    `json:"benchmark"`
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	RefCurrency      string    `json:"ref_currency"`
+	TotalMarketValue float64   `json:"total_market_value"`
+	// PortfolioBeta is the market-value-weighted beta of the positions that
+	// have one; the others are left out of it and of BetaExposure.
+	PortfolioBeta *float64   `json:"portfolio_beta"`
+	BetaExposure  float64    `json:"beta_exposure"`
+	Items         []RiskItem `json:"items"`
+	// Skipped lists symbols left out because no current price was available
+	Skipped []SkippedSymbol `json:"skipped,omitempty"`
+}
+
+// ComputeRisk estimates each open position's beta to benchmark from daily
+// closes over the last days calendar days, and the beta-adjusted exposure
+// (market value × beta) in the reference currency. Returns are close to
+// close over the days both the symbol and the benchmark have a bar.
+func (s *TransactionService) ComputeRisk(portfolioID, benchmark string, days int) (RiskResponse, error) {
+	hp, ok := s.prices.(HistoryProvider)
+	if !ok {
+		return RiskResponse{}, errors.New("risk needs a price provider with daily history")
+	}
+	benchmark = strings.ToUpper(strings.TrimSpace(benchmark))
+	if benchmark == "" {
+		return RiskResponse{}, errors.New("benchmark is required")
+	}
+	if _, err := s.repoPf.GetByID(portfolioID); err != nil {
+		return RiskResponse{}, ErrPortfolioNotFound
+	}
+	txs, err := s.repoTx.List(portfolioID, ListFilter{Limit: 0})
+	if err != nil {
+		return RiskResponse{}, err
+	}
+
+	to := s.today()
+	from := to.AddDate(0, 0, -days)
+	out := RiskResponse{Benchmark: benchmark, From: from, To: to, RefCurrency: s.refCCY}
+
+	// closes returns sym's close on each day in [from, to] that has a bar
+	// of its own; days carried over from an earlier bar are left out.
+	closes := func(sym string) map[time.Time]float64 {
+		m := map[time.Time]float64{}
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			if wd := d.Weekday(); wd == time.Saturday || wd == time.Sunday {
+				continue
+			}
+			p, bar, err := hp.GetPriceOn(sym, d)
+			if err != nil || p <= 0 || !sameYMD(bar, d) {
+				continue
+			}
+			m[d] = p
+		}
+		return m
+	}
+	bench := closes(benchmark)
+	if len(bench) < minBetaReturns+1 {
+		return RiskResponse{}, errors.New("not enough benchmark history for " + benchmark)
+	}
+	var benchDays []time.Time
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if _, ok := bench[d]; ok {
+			benchDays = append(benchDays, d)
+		}
+	}
+
+	var covered, exposure float64
+	items := []RiskItem{}
+	for sym, a := range s.aggregatePositions(txs) {
+		if a.shares <= 0 {
+			continue
+		}
+		price, _, err := s.priceFor(sym)
+		if err != nil {
+			out.Skipped = append(out.Skipped, SkippedSymbol{Symbol: sym, Reason: err.Error()})
+			continue
+		}
+		fx, _ := s.valuationRate(sym, a)
+		it := RiskItem{Symbol: sym, MarketValue: a.shares * price * multiplierForSymbol(sym) * fx}
+		out.TotalMarketValue += it.MarketValue
+
+		own := closes(sym)
+		var xs, ys []float64
+		var prev time.Time
+		for _, d := range benchDays {
+			if _, ok := own[d]; !ok {
+				continue
+			}
+			if !prev.IsZero() {
+				xs = append(xs, bench[d]/bench[prev]-1)
+				ys = append(ys, own[d]/own[prev]-1)
+			}
+			prev = d
+		}
+		it.Returns = len(xs)
+		if b, ok := beta(xs, ys); ok && it.Returns >= minBetaReturns {
+			e := it.MarketValue * b
+			it.Beta, it.BetaExposure = &b, &e
+			covered += it.MarketValue
+			exposure += e
+		} else {
+			it.Reason = "not enough daily history"
+		}
+		items = append(items, it)
+	}
+
+	for i := range items {
+		if out.TotalMarketValue > 0 {
+			items[i].WeightPercent = (items[i].MarketValue / out.TotalMarketValue) * 100.0
+		}
+	}
+	if covered > 0 {
+		pb := exposure / covered
+		out.PortfolioBeta = &pb
+	}
+	out.BetaExposure = exposure
+	stableSort(items, func(a, b RiskItem) bool { return a.Symbol < b.Symbol })
+	stableSort(items, func(a, b RiskItem) bool { return a.MarketValue > b.MarketValue })
+	out.Items = items
+	sortSkipped(out.Skipped)
+	return out, nil
+}
+
+// beta is cov(x, y) / var(x); false when x doesn't vary.
+func beta(xs, ys []float64) (float64, bool) {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0, false
+	}
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= n
+	my /= n
+	var cov, vx float64
+	for i := range xs {
+		cov += (xs[i] - mx) * (ys[i] - my)
+		vx += (xs[i] - mx) * (xs[i] - mx)
+	}
+	if vx == 0 || math.IsNaN(cov) {
+		return 0, false
+	}
+	return cov / vx, true
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Output rounding for response payloads. Computation stays in full precision;
+// only the JSON encoding of the response structs is rounded.
+//
+// Amounts are rounded to their currency's ISO 4217 minor unit (JPY 0, USD 2,
+// BHD 3); response structs pass the currency in with in().
+//
+// MONEY_DECIMALS   decimals for every amount, whatever its currency (unset: per currency)
+// PERCENT_DECIMALS decimals for percentages (default 4)
+
+type roundingConfig struct {
+	money   int
+	perCCY  bool // money follows the currency given to in()
+	percent int
+}
+
+var respRounding = roundingConfig{
+	money:   envDecimals("MONEY_DECIMALS", 2),
+	perCCY:  strings.TrimSpace(os.Getenv("MONEY_DECIMALS")) == "",
+	percent: envDecimals("PERCENT_DECIMALS", 4),
+}
+
+// in returns c with amounts rounded for ccy. An unknown or empty ccy keeps
+// 2 decimals.
+func (c roundingConfig) in(ccy string) roundingConfig {
+	if c.perCCY {
+		c.money = minorUnits(ccy)
+	}
+	return c
+}
+
+// refRow is a response row whose amounts are in the reference currency named
+// by the enclosing response, which hands it down with withRefCCY.
+type refRow[T any] interface {
+	withRefCCY(ccy string) T
+}
+
+// inRef returns a copy of rows that round their amounts for ccy.
+func inRef[T refRow[T]](rows []T, ccy string) []T {
+	if rows == nil {
+		return nil
+	}
+	out := make([]T, len(rows))
+	for i, r := range rows {
+		out[i] = r.withRefCCY(ccy)
+	}
+	return out
+}
+
+func (it AllocationItem) withRefCCY(ccy string) AllocationItem        { it.refCCY = ccy; return it }
+func (p PositionSummary) withRefCCY(ccy string) PositionSummary       { p.refCCY = ccy; return p }
+func (it AttributionItem) withRefCCY(ccy string) AttributionItem      { it.refCCY = ccy; return it }
+func (it RiskItem) withRefCCY(ccy string) RiskItem                    { it.refCCY = ccy; return it }
+func (it IncomeItem) withRefCCY(ccy string) IncomeItem                { it.refCCY = ccy; return it }
+func (p DashboardPortfolio) withRefCCY(ccy string) DashboardPortfolio { p.refCCY = ccy; return p }
+func (b ContributionBucket) withRefCCY(ccy string) ContributionBucket { b.refCCY = ccy; return b }
+func (l RealizedLot) withRefCCY(ccy string) RealizedLot               { l.refCCY = ccy; return l }
+
+func envDecimals(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 10 {
+		return def
+	}
+	return n
+}
+
+func roundTo(v float64, decimals int) float64 {
+	p := math.Pow(10, float64(decimals))
+	r := math.Round(v*p) / p
+	if r == 0 {
+		return 0 // avoid "-0"
+	}
+	return r
+}
+
+func (c roundingConfig) m(v float64) float64 { return roundTo(v, c.money) }
+func (c roundingConfig) p(v float64) float64 { return roundTo(v, c.percent) }
+
+func (it AllocationItem) MarshalJSON() ([]byte, error) {
+	type plain AllocationItem
+	c := respRounding.in(it.refCCY)
+	it.Invested = c.m(it.Invested)
+	it.MarketValue = c.m(it.MarketValue)
+	it.WeightPercent = c.p(it.WeightPercent)
+	it.UnrealizedPL = c.m(it.UnrealizedPL)
+	it.UnrealizedPLPercent = c.p(it.UnrealizedPLPercent)
+	it.DailyPL = c.m(it.DailyPL)
+	it.DailyPLPercent = c.p(it.DailyPLPercent)
+	it.DailyPrevMarketValue = c.m(it.DailyPrevMarketValue)
+	return json.Marshal(plain(it))
+}
+
+func (r AllocationResponse) MarshalJSON() ([]byte, error) {
+	type plain AllocationResponse
+	c := respRounding.in(r.RefCurrency)
+	r.Items = inRef(r.Items, r.RefCurrency)
+	r.TotalInvested = c.m(r.TotalInvested)
+	r.TotalMarketValue = c.m(r.TotalMarketValue)
+	return json.Marshal(plain(r))
+}
+
+func (p PositionSummary) MarshalJSON() ([]byte, error) {
+	type plain PositionSummary
+	c := respRounding.in(p.refCCY)
+	p.Invested = c.m(p.Invested)
+	p.MarketValue = c.m(p.MarketValue)
+	p.UnrealizedPL = c.m(p.UnrealizedPL)
+	p.UnrealizedPLPercent = c.p(p.UnrealizedPLPercent)
+	p.WeightPercentByMV = c.p(p.WeightPercentByMV)
+	p.DailyPL = c.m(p.DailyPL)
+	p.DailyPLPercent = c.p(p.DailyPLPercent)
+	if p.PricePL != nil {
+		v := c.m(*p.PricePL)
+		p.PricePL = &v
+	}
+	if p.FXPL != nil {
+		v := c.m(*p.FXPL)
+		p.FXPL = &v
+	}
+	p.TotalDiscrepancy = c.m(p.TotalDiscrepancy)
+	return json.Marshal(plain(p))
+}
+
+func (r SummaryResponse) MarshalJSON() ([]byte, error) {
+	type plain SummaryResponse
+	c := respRounding.in(r.RefCurrency)
+	r.Positions = inRef(r.Positions, r.RefCurrency)
+	if r.Reconciliation != nil {
+		rec := *r.Reconciliation
+		rec.refCCY = r.RefCurrency
+		r.Reconciliation = &rec
+	}
+	r.TotalInvested = c.m(r.TotalInvested)
+	r.TotalMarketValue = c.m(r.TotalMarketValue)
+	r.TotalUnrealizedPL = c.m(r.TotalUnrealizedPL)
+	r.TotalUnrealizedPLPerc = c.p(r.TotalUnrealizedPLPerc)
+	r.TotalUnrealizedPLPercCurrent = c.p(r.TotalUnrealizedPLPercCurrent)
+	r.DailyPL = c.m(r.DailyPL)
+	r.DailyPLPercent = c.p(r.DailyPLPercent)
+	r.Balance = c.m(r.Balance)
+	r.CashDeposits = c.m(r.CashDeposits)
+	r.CashWithdrawals = c.m(r.CashWithdrawals)
+	r.InferredDeposits = c.m(r.InferredDeposits)
+	r.EffectiveCashIn = c.m(r.EffectiveCashIn)
+	r.EffectiveCashInPeak = c.m(r.EffectiveCashInPeak)
+	r.InterestIncome = c.m(r.InterestIncome)
+	r.CashFees = c.m(r.CashFees)
+	if r.MinBalance != nil {
+		v := c.m(*r.MinBalance)
+		r.MinBalance = &v
+	}
+	return json.Marshal(plain(r))
+}
+
+func (r SummaryReconciliation) MarshalJSON() ([]byte, error) {
+	type plain SummaryReconciliation
+	c := respRounding.in(r.refCCY)
+	r.NetCashFlow = c.m(r.NetCashFlow)
+	r.Equity = c.m(r.Equity)
+	r.OpenCost = c.m(r.OpenCost)
+	r.UnrealizedPL = c.m(r.UnrealizedPL)
+	r.RealizedGains = c.m(r.RealizedGains)
+	r.DividendsReceived = c.m(r.DividendsReceived)
+	r.InterestReceived = c.m(r.InterestReceived)
+	r.CashFees = c.m(r.CashFees)
+	r.TransfersNetCost = c.m(r.TransfersNetCost)
+	r.UnbookedTradeCash = c.m(r.UnbookedTradeCash)
+	r.CashFXTranslation = c.m(r.CashFXTranslation)
+	r.FeesPaid = c.m(r.FeesPaid)
+	r.TotalPL = c.m(r.TotalPL)
+	return json.Marshal(plain(r))
+}
+
+func (v TransactionView) MarshalJSON() ([]byte, error) {
+	type plain TransactionView
+	v.TotalRef = respRounding.in(v.RefCurrency).m(v.TotalRef)
+	return json.Marshal(plain(v))
+}
+
+func (r BacktestResponse) MarshalJSON() ([]byte, error) {
+	type plain BacktestResponse
+	c := respRounding.in(r.RefCurrency)
+	r.AltPL = c.m(r.AltPL)
+	r.AltPLPercent = c.p(r.AltPLPercent)
+	r.AltMaxDropPercent = c.p(r.AltMaxDropPercent)
+	r.CurrentPL = c.m(r.CurrentPL)
+	r.CurrentPLPercent = c.p(r.CurrentPLPercent)
+	r.CurrentMaxDropPercent = c.p(r.CurrentMaxDropPercent)
+	return json.Marshal(plain(r))
+}
+
+func (it AttributionItem) MarshalJSON() ([]byte, error) {
+	type plain AttributionItem
+	c := respRounding.in(it.refCCY)
+	it.StartValue = c.m(it.StartValue)
+	it.EndValue = c.m(it.EndValue)
+	it.NetFlows = c.m(it.NetFlows)
+	it.Income = c.m(it.Income)
+	it.PL = c.m(it.PL)
+	it.WeightPercent = c.p(it.WeightPercent)
+	it.ReturnPercent = c.p(it.ReturnPercent)
+	it.ContributionPP = c.p(it.ContributionPP)
+	it.ExcessReturnPercent = c.p(it.ExcessReturnPercent)
+	it.ExcessContributionPP = c.p(it.ExcessContributionPP)
+	return json.Marshal(plain(it))
+}
+
+func (r AttributionResponse) MarshalJSON() ([]byte, error) {
+	type plain AttributionResponse
+	c := respRounding.in(r.RefCurrency)
+	r.Items = inRef(r.Items, r.RefCurrency)
+	r.TotalReturnPercent = c.p(r.TotalReturnPercent)
+	r.BenchmarkReturnPercent = c.p(r.BenchmarkReturnPercent)
+	r.ExcessReturnPercent = c.p(r.ExcessReturnPercent)
+	return json.Marshal(plain(r))
+}
+
+// AvgCost is a per-share price in the trade currency, not a ref-currency
+// amount, so it keeps more decimals than the currency's minor unit.
+func (it HoldingItem) MarshalJSON() ([]byte, error) {
+	type plain HoldingItem
+	it.AvgCost = roundTo(it.AvgCost, 6)
+	return json.Marshal(plain(it))
+}
+
+func (d HoldingDelta) MarshalJSON() ([]byte, error) {
+	type plain HoldingDelta
+	d.AvgCostBefore = roundTo(d.AvgCostBefore, 6)
+	d.AvgCostAfter = roundTo(d.AvgCostAfter, 6)
+	return json.Marshal(plain(d))
+}
+
+// Betas are ratios, not percentages, and keep 4 decimals regardless of
+// PERCENT_DECIMALS.
+func (it RiskItem) MarshalJSON() ([]byte, error) {
+	type plain RiskItem
+	c := respRounding.in(it.refCCY)
+	it.MarketValue = c.m(it.MarketValue)
+	it.WeightPercent = c.p(it.WeightPercent)
+	if it.Beta != nil {
+		b, e := roundTo(*it.Beta, 4), c.m(*it.BetaExposure)
+		it.Beta, it.BetaExposure = &b, &e
+	}
+	return json.Marshal(plain(it))
+}
+
+func (r RiskResponse) MarshalJSON() ([]byte, error) {
+	type plain RiskResponse
+	c := respRounding.in(r.RefCurrency)
+	r.Items = inRef(r.Items, r.RefCurrency)
+	r.TotalMarketValue = c.m(r.TotalMarketValue)
+	r.BetaExposure = c.m(r.BetaExposure)
+	if r.PortfolioBeta != nil {
+		b := roundTo(*r.PortfolioBeta, 4)
+		r.PortfolioBeta = &b
+	}
+	return json.Marshal(plain(r))
+}
+
+func (it IncomeItem) MarshalJSON() ([]byte, error) {
+	type plain IncomeItem
+	c := respRounding.in(it.refCCY)
+	it.AnnualDividendPerShare = roundTo(it.AnnualDividendPerShare, 6) // per share, as HoldingItem.AvgCost
+	it.TrailingIncome = c.m(it.TrailingIncome)
+	it.ProjectedIncome = c.m(it.ProjectedIncome)
+	it.MarketValue = c.m(it.MarketValue)
+	it.Invested = c.m(it.Invested)
+	it.YieldPercent = c.p(it.YieldPercent)
+	it.YieldOnCostPercent = c.p(it.YieldOnCostPercent)
+	return json.Marshal(plain(it))
+}
+
+func (r IncomeProjection) MarshalJSON() ([]byte, error) {
+	type plain IncomeProjection
+	c := respRounding.in(r.RefCurrency)
+	r.Items = inRef(r.Items, r.RefCurrency)
+	r.TrailingIncome = c.m(r.TrailingIncome)
+	r.ProjectedIncome = c.m(r.ProjectedIncome)
+	r.TotalMarketValue = c.m(r.TotalMarketValue)
+	r.TotalInvested = c.m(r.TotalInvested)
+	r.YieldPercent = c.p(r.YieldPercent)
+	r.YieldOnCostPercent = c.p(r.YieldOnCostPercent)
+	return json.Marshal(plain(r))
+}
+
+func (p DashboardPortfolio) MarshalJSON() ([]byte, error) {
+	type plain DashboardPortfolio
+	c := respRounding.in(p.refCCY)
+	p.TotalInvested = c.m(p.TotalInvested)
+	p.TotalMarketValue = c.m(p.TotalMarketValue)
+	p.TotalUnrealizedPL = c.m(p.TotalUnrealizedPL)
+	p.Balance = c.m(p.Balance)
+	return json.Marshal(plain(p))
+}
+
+func (b ContributionBucket) MarshalJSON() ([]byte, error) {
+	type plain ContributionBucket
+	c := respRounding.in(b.refCCY)
+	b.Deposits = c.m(b.Deposits)
+	b.InferredDeposits = c.m(b.InferredDeposits)
+	b.Withdrawals = c.m(b.Withdrawals)
+	b.Net = c.m(b.Net)
+	b.CumulativeNet = c.m(b.CumulativeNet)
+	return json.Marshal(plain(b))
+}
+
+func (r ContributionsResponse) MarshalJSON() ([]byte, error) {
+	type plain ContributionsResponse
+	r.TotalNet = respRounding.in(r.RefCurrency).m(r.TotalNet)
+	r.Buckets = inRef(r.Buckets, r.RefCurrency)
+	return json.Marshal(plain(r))
+}
+
+func (l RealizedLot) MarshalJSON() ([]byte, error) {
+	type plain RealizedLot
+	l.Cost = respRounding.in(l.Currency).m(l.Cost)
+	l.CostRef = respRounding.in(l.refCCY).m(l.CostRef)
+	return json.Marshal(plain(l))
+}
+
+func (r RealizedResponse) MarshalJSON() ([]byte, error) {
+	type plain RealizedResponse
+	c, ref := respRounding.in(r.Currency), respRounding.in(r.RefCurrency)
+	r.Lots = inRef(r.Lots, r.RefCurrency)
+	r.Proceeds = c.m(r.Proceeds)
+	r.ProceedsRef = ref.m(r.ProceedsRef)
+	r.CostRef = ref.m(r.CostRef)
+	r.RealizedPLRef = ref.m(r.RealizedPLRef)
+	r.DisallowedLossRef = ref.m(r.DisallowedLossRef)
+	if r.DisallowedLoss != nil {
+		v := c.m(*r.DisallowedLoss)
+		r.DisallowedLoss = &v
+	}
+	if r.Cost != nil {
+		v := c.m(*r.Cost)
+		r.Cost = &v
+	}
+	if r.RealizedPL != nil {
+		v := c.m(*r.RealizedPL)
+		r.RealizedPL = &v
+	}
+	return json.Marshal(plain(r))
+}
+
+func (r DashboardResponse) MarshalJSON() ([]byte, error) {
+	type plain DashboardResponse
+	r.Portfolios = inRef(r.Portfolios, r.Summary.RefCurrency)
+	return json.Marshal(plain(r))
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+)
+
+// sampleData is the demo data SEED=1 loads into an empty store: portfolios
+// with their transactions, in the same shape as the create endpoints take.
+//
+//go:embed sample_data.json
+var sampleData []byte
+
+type seedPortfolio struct {
+	portfolioDTO
+	Transactions []transactionDTO `json:"transactions"`
+}
+
+// SeedSampleData creates the bundled sample portfolios when there are no
+// portfolios yet, and reports whether it did. Existing data is never touched.
+func SeedSampleData(pf *PortfolioService, tx *TransactionService) (bool, error) {
+	existing, err := pf.List()
+	if err != nil {
+		return false, err
+	}
+	if len(existing) > 0 {
+		return false, nil
+	}
+	var seeds []seedPortfolio
+	if err := json.Unmarshal(sampleData, &seeds); err != nil {
+		return false, fmt.Errorf("sample data: %w", err)
+	}
+	for _, sp := range seeds {
+		p, err := pf.Create(sp.portfolioDTO)
+		if err != nil {
+			return false, fmt.Errorf("sample portfolio %q: %w", sp.Name, err)
+		}
+		if _, err := tx.CreateBatch(p.ID, sp.Transactions); err != nil {
+			return false, fmt.Errorf("sample portfolio %q: %w", sp.Name, err)
+		}
+	}
+	return true, nil
+}
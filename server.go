@@ -1,11 +1,16 @@
 package main
 
 import (
+    "crypto/subtle"
     "encoding/json"
+    "errors"
     "io"
     "net/http"
+    "net/url"
+    "os"
     "strconv"
     "strings"
+    "time"
     "embed"
     fs "io/fs"
 )
@@ -20,27 +25,62 @@ type Server struct {
 	pf  *PortfolioService
 	tx  *TransactionService
 	mux *http.ServeMux
+
+	changes     *changeBroadcaster
+	streamEvery time.Duration
+	timeout     time.Duration // per-request bound for API handlers (SUMMARY_TIMEOUT)
+	symbols     SymbolSearcher
+	cors        corsConfig
+	listLimits  listLimits
+	limiter     *rateLimiter // nil when RATE_LIMIT=0
+	adminToken  string       // ADMIN_TOKEN; empty disables POST /admin/reset
 }
 
 func NewServer(pf *PortfolioService, tx *TransactionService) *Server {
-    s := &Server{pf: pf, tx: tx, mux: http.NewServeMux()}
+    s := &Server{
+        pf:          pf,
+        tx:          tx,
+        mux:         http.NewServeMux(),
+        changes:     newChangeBroadcaster(),
+        streamEvery: streamIntervalFromEnv(),
+        timeout:     envDuration("SUMMARY_TIMEOUT", 30*time.Second),
+        cors:        corsFromEnv(),
+        listLimits:  listLimitsFromEnv(),
+        limiter:     rateLimiterFromEnv(),
+        adminToken:  strings.TrimSpace(os.Getenv("ADMIN_TOKEN")),
+    }
+    tx.Subscribe(s.changes)
     s.routes()
     return s
 }
 
 func (s *Server) routes() {
     // Global endpoints (all portfolios)
-    s.mux.HandleFunc("/allocations", s.handleAllocationsAll) // GET
-    s.mux.HandleFunc("/summary", s.handleSummaryAll)         // GET
-    s.mux.HandleFunc("/backtest", s.handleBacktestAll)       // GET
+    s.mux.Handle("/allocations", s.timed(s.handleAllocationsAll)) // GET
+    s.mux.Handle("/summary", s.timed(s.handleSummaryAll))         // GET
+    s.mux.HandleFunc("/summary/stream", s.handleSummaryStream)   // GET (SSE; long-lived, not timed)
+    s.mux.Handle("/backtest", s.timed(s.handleBacktestAll))       // GET
+    s.mux.Handle("/holdings", s.timed(s.handleHoldingsAll))       // GET (no pricing)
+    s.mux.Handle("/transactions", s.timed(s.handleTransactionsAll)) // GET (merged list)
+    s.mux.Handle("/dashboard", s.timed(s.handleDashboard))       // GET (summary + allocations + recent + portfolios)
+    s.mux.Handle("/contributions", s.timed(s.handleContributions)) // GET (net deposits by month/year)
+
+    // Admin
+    s.mux.HandleFunc("/admin/provider", s.handleAdminProvider) // GET
+    s.mux.HandleFunc("/admin/load-errors", s.handleLoadErrors) // GET
+    s.mux.Handle("/admin/fx", s.timed(s.handleAdminFX))        // GET
+    s.mux.HandleFunc("/admin/reset", s.handleAdminReset)       // POST (memory repo, ADMIN_TOKEN)
+    s.mux.HandleFunc("/version", s.handleVersion)              // GET
+    s.mux.HandleFunc("/openapi.json", s.handleOpenAPI)         // GET
+    s.mux.Handle("/symbols/search", s.timed(s.handleSymbolSearch)) // GET ?q=
 
 	// Root collection for portfolios (exact path)
-	s.mux.HandleFunc("/portfolios", s.handlePortfolios)
+	s.mux.Handle("/portfolios", s.timed(s.handlePortfolios))
 
     // Single subtree handler for everything under /portfolios/
-    s.mux.HandleFunc("/portfolios/", s.handlePortfoliosSub)
+    s.mux.Handle("/portfolios/", s.timed(s.handlePortfoliosSub))
 
-    // Static frontend: served at /app/ and /mobile/
+    // Static frontend: served at /app/ and /mobile/ (not timed)
     sub, err := fs.Sub(static, "frontend")
     if err == nil {
         // /app/ serves the root of frontend (desktop UI)
@@ -65,21 +105,50 @@ func (s *Server) routes() {
     })
 }
 
+// timed bounds an API handler's reads by s.timeout so a slow price provider
+// yields a 503 instead of a hung request. The request context is cancelled on
+// timeout. Writes aren't bounded: the providers don't watch the context, so a
+// write past the deadline would still commit while the client saw a 503 and
+// retried it.
+func (s *Server) timed(h http.HandlerFunc) http.Handler {
+    msg, _ := json.Marshal(map[string]string{
+        "error":  http.StatusText(http.StatusServiceUnavailable),
+        "detail": "request timed out after " + s.timeout.String() + " (price provider may be slow; try again)",
+    })
+    th := http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // The timeout writer starts with empty headers; copy the id back in
+        // so error bodies can include it
+        w.Header().Set(requestIDHeader, requestID(r.Context()))
+        h(w, r)
+    }), s.timeout, string(msg))
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            h(w, r)
+            return
+        }
+        // The timeout body is JSON; handlers' own headers replace this on success
+        w.Header().Set("Content-Type", "application/json")
+        th.ServeHTTP(w, r)
+    })
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-    // Permissive CORS for frontend dev
-    w.Header().Set("Access-Control-Allow-Origin", "*")
-    w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,DELETE,OPTIONS")
-    w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Accept")
+    r = withRequestID(w, r)
+    // CORS: "*" for frontend dev unless CORS_ORIGINS sets an allowlist
+    s.cors.apply(w, r)
     if r.Method == http.MethodOptions {
         w.WriteHeader(http.StatusNoContent)
         return
     }
+    if !s.limiter.limit(w, r) {
+        return
+    }
     s.mux.ServeHTTP(w, r)
 }
 
 /* ======= Global endpoints ======= */
 
-// GET /allocations?basis=invested|market_value  (across ALL portfolios)
+// GET /allocations?basis=invested|market_value&tag={tag}&as_of=YYYY/MM/DD  (across ALL portfolios)
 func (s *Server) handleAllocationsAll(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -89,8 +158,71 @@ func (s *Server) handleAllocationsAll(w http.ResponseWriter, r *http.Request) {
 	if basis == "" {
 		basis = "invested"
 	}
-	ref := pickRef(r.URL.Query().Get("ref_ccy"))
-	out, err := s.tx.WithRef(ref).ComputeAllocationsAll(basis)
+	ref, ok := refParam(w, r)
+	if !ok {
+		return
+	}
+	tag := r.URL.Query().Get("tag")
+	lookThrough := truthy(r.URL.Query().Get("look_through"))
+	asOf, err := parseAsOf(r.URL.Query().Get("as_of"), s.tx.today())
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	out, err := s.tx.WithRef(ref).WithTag(tag).WithLookThrough(lookThrough).WithAsOf(asOf).ComputeAllocationsAll(basis)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeRows(w, r, out, inRef(out.Items, out.RefCurrency))
+}
+
+// GET /transactions: transactions of every portfolio as one list, with the
+// per-portfolio list's filters plus tag; sorted date_desc unless sort is given
+func (s *Server) handleTransactionsAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	filter, ok := s.listFilter(w, r)
+	if !ok {
+		return
+	}
+	items, err := s.tx.WithTag(q.Get("tag")).ListAll(filter)
+	if err != nil {
+		httpError(w, listStatus(err), err.Error())
+		return
+	}
+	if truthy(q.Get("ref")) {
+		ref, ok := refParam(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.tx.WithRef(ref).RefViews(items))
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// GET /dashboard?recent=10&tag={tag}&ref_ccy=: the frontend's start-up data in
+// one response, priced once
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	recent, err := queryInt(q, "recent", 10)
+	if err != nil || recent < 1 || recent > s.listLimits.max {
+		httpError(w, http.StatusBadRequest, "invalid recent (use 1 to "+strconv.Itoa(s.listLimits.max)+")")
+		return
+	}
+	ref, ok := refParam(w, r)
+	if !ok {
+		return
+	}
+	out, err := s.tx.WithRef(ref).WithTag(q.Get("tag")).ComputeDashboard(recent)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, err.Error())
 		return
@@ -98,14 +230,23 @@ func (s *Server) handleAllocationsAll(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, out)
 }
 
-// GET /summary  (across ALL portfolios)
-func (s *Server) handleSummaryAll(w http.ResponseWriter, r *http.Request) {
+// GET /contributions?interval=month|year&tag={tag}&ref_ccy=: net contributions
+// per period across portfolios
+func (s *Server) handleContributions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
-	ref := pickRef(r.URL.Query().Get("ref_ccy"))
-	out, err := s.tx.WithRef(ref).ComputeSummaryAll()
+	q := r.URL.Query()
+	interval := strings.ToLower(strings.TrimSpace(q.Get("interval")))
+	if interval == "" {
+		interval = "month"
+	}
+	ref, ok := refParam(w, r)
+	if !ok {
+		return
+	}
+	out, err := s.tx.WithRef(ref).WithTag(q.Get("tag")).ComputeContributions(interval)
 	if err != nil {
 		httpError(w, http.StatusBadRequest, err.Error())
 		return
@@ -113,7 +254,106 @@ func (s *Server) handleSummaryAll(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, out)
 }
 
-// GET /backtest?symbol={symbol}  (across ALL portfolios)
+// parseAsOf reads the allocations as_of date (YYYY/MM/DD or YYYY-MM-DD).
+// Empty means now; dates after today (the service clock's) are rejected.
+func parseAsOf(v string, today time.Time) (time.Time, error) {
+	t, err := parseQueryDate(v)
+	if err != nil {
+		return time.Time{}, errors.New("invalid as_of (use YYYY/MM/DD)")
+	}
+	if t.After(today) {
+		return time.Time{}, errors.New("as_of must not be in the future")
+	}
+	return t, nil
+}
+
+// GET /holdings: share counts and average cost across portfolios, without
+// pricing or FX
+func (s *Server) handleHoldingsAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	out, err := s.tx.WithTag(q.Get("tag")).ComputeHoldingsAll(truthy(q.Get("include_closed")))
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// SetSymbolSearcher enables GET /symbols/search.
+func (s *Server) SetSymbolSearcher(ss SymbolSearcher) { s.symbols = ss }
+
+// GET /symbols/search?q=apple
+// Upstream failures return 503 with an empty result list so the UI can keep
+// its form usable.
+func (s *Server) handleSymbolSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		httpError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	if s.symbols == nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"query": q, "results": []SymbolMatch{}, "error": "symbol search not configured"})
+		return
+	}
+	res, err := s.symbols.SearchSymbols(q)
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"query": q, "results": []SymbolMatch{}, "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"query": q, "results": res})
+}
+
+// GET /summary?tag={tag}&fresh=1  (across ALL portfolios)
+func (s *Server) handleSummaryAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ref, ok := refParam(w, r)
+	if !ok {
+		return
+	}
+	tag := r.URL.Query().Get("tag")
+	svc, err := s.tx.WithRef(ref).WithTag(tag).WithDailyBasis(r.URL.Query().Get("daily_basis"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if truthy(r.URL.Query().Get("fresh")) {
+		svc = svc.Fresh()
+	}
+	// ?refs=USD,TWD: one summary per currency, keyed by code (JSON only)
+	if v := r.URL.Query().Get("refs"); v != "" {
+		refs, err := parseRefs(v)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		out, err := svc.ComputeSummaryAllRefs(refs)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	out, err := svc.ComputeSummaryAll()
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeRows(w, r, out, inRef(out.Positions, out.RefCurrency))
+}
+
+// GET /backtest?symbol={symbol}&tag={tag}  (across ALL portfolios)
 func (s *Server) handleBacktestAll(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodGet {
         httpError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -133,8 +373,12 @@ func (s *Server) handleBacktestAll(w http.ResponseWriter, r *http.Request) {
         priceBasis = "close"
     }
     debug := strings.TrimSpace(r.URL.Query().Get("debug")) == "1"
-    ref := pickRef(r.URL.Query().Get("ref_ccy"))
-    out, err := s.tx.WithRef(ref).ComputeBacktestAll(symbol, symbolCCY, priceBasis, debug)
+    ref, ok := refParam(w, r)
+    if !ok {
+        return
+    }
+    tag := r.URL.Query().Get("tag")
+    out, err := s.tx.WithRef(ref).WithTag(tag).ComputeBacktestAll(symbol, symbolCCY, priceBasis, debug)
     if err != nil {
         httpError(w, http.StatusBadRequest, err.Error())
         return
@@ -142,6 +386,67 @@ func (s *Server) handleBacktestAll(w http.ResponseWriter, r *http.Request) {
     writeJSON(w, http.StatusOK, out)
 }
 
+/* ======= Admin ======= */
+
+// GET /admin/provider  (price provider circuit breaker state)
+func (s *Server) handleAdminProvider(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	out := map[string]any{"circuit_breaker": nil}
+	if st, ok := s.tx.ProviderStatus(); ok {
+		out["circuit_breaker"] = st
+	}
+	if routes, ok := s.tx.RouteStatuses(); ok {
+		out["routes"] = routes
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// GET /admin/load-errors lists CSV rows skipped or repaired at startup
+func (s *Server) handleLoadErrors(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	errs := s.tx.LoadErrors()
+	if errs == nil {
+		errs = []LoadError{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"count": len(errs), "errors": errs})
+}
+
+// POST /admin/reset wipes every portfolio and transaction of the memory
+// repository. It needs "Authorization: Bearer $ADMIN_TOKEN" and is disabled
+// when ADMIN_TOKEN is unset; other repositories answer 405.
+func (s *Server) handleAdminReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.adminToken == "" {
+		httpError(w, http.StatusForbidden, "admin reset is disabled (set ADMIN_TOKEN)")
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(s.adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, http.StatusUnauthorized, "missing or invalid admin token")
+		return
+	}
+	pfs, txs, err := s.txFor(r).Reset()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrResetUnsupported) {
+			status = http.StatusMethodNotAllowed
+		}
+		httpError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"portfolios": pfs, "transactions": txs})
+}
+
 /* ======= Portfolios root ======= */
 
 func (s *Server) handlePortfolios(w http.ResponseWriter, r *http.Request) {
@@ -158,11 +463,34 @@ func (s *Server) handlePortfolios(w http.ResponseWriter, r *http.Request) {
 			httpError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		w.Header().Set("Location", "/portfolios/"+out.ID)
 		writeJSON(w, http.StatusCreated, out)
 	case http.MethodGet:
-		out, err := s.pf.List()
+		// ?sort=created_asc|created_desc|updated_asc|updated_desc|name_asc|name_desc&limit=&offset=&updated_since=
+		// limit defaults to 0 (all) so existing clients keep getting every portfolio.
+		q := r.URL.Query()
+		limit, err := queryInt(q, "limit", 0)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		offset, err := queryInt(q, "offset", 0)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		since, err := parseUpdatedSince(q.Get("updated_since"))
 		if err != nil {
-			httpError(w, http.StatusInternalServerError, err.Error())
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		out, err := s.pf.ListPage(q.Get("sort"), limit, offset, since)
+		if err != nil {
+			status := listStatus(err)
+			if errors.Is(err, ErrInvalidSort) {
+				status = http.StatusBadRequest
+			}
+			httpError(w, status, err.Error())
 			return
 		}
 		writeJSON(w, http.StatusOK, out)
@@ -192,12 +520,15 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 			p, err := s.pf.Get(id)
 			if err != nil {
 				status := http.StatusInternalServerError
-				if err == ErrNotFound {
+				if isNotFound(err) {
 					status = http.StatusNotFound
 				}
 				httpError(w, status, err.Error())
 				return
 			}
+			if notModified(w, r, portfolioETag(p), p.UpdatedAt) {
+				return
+			}
 			writeJSON(w, http.StatusOK, p)
 		case http.MethodPut:
 			defer r.Body.Close()
@@ -209,17 +540,18 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 			p, err := s.pf.Update(id, dto)
 			if err != nil {
 				status := http.StatusBadRequest
-				if err == ErrNotFound {
+				if isNotFound(err) {
 					status = http.StatusNotFound
 				}
 				httpError(w, status, err.Error())
 				return
 			}
+			w.Header().Set("ETag", portfolioETag(p))
 			writeJSON(w, http.StatusOK, p)
 		case http.MethodDelete:
 			if err := s.pf.Delete(id); err != nil {
 				status := http.StatusInternalServerError
-				if err == ErrNotFound {
+				if isNotFound(err) {
 					status = http.StatusNotFound
 				}
 				httpError(w, status, err.Error())
@@ -243,12 +575,61 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 				s.createTx(pfID, w, r)
 			case http.MethodGet:
 				s.listTx(pfID, w, r)
+			case http.MethodDelete:
+				// Reset: remove every transaction but keep the portfolio
+				n, err := s.txFor(r).DeleteAll(pfID)
+				if err != nil {
+					status := http.StatusInternalServerError
+					if isNotFound(err) {
+						status = http.StatusNotFound
+					}
+					httpError(w, status, err.Error())
+					return
+				}
+				writeJSON(w, http.StatusOK, map[string]int{"deleted": n})
 			default:
 				httpError(w, http.StatusMethodNotAllowed, "method not allowed")
 			}
 			return
 		}
 
+		// Dry run: /portfolios/{id}/transactions/validate
+		if len(parts) == 3 && parts[2] == "validate" {
+			if r.Method != http.MethodPost {
+				httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			s.validateTx(pfID, w, r)
+			return
+		}
+
+		// Transfer: /portfolios/{id}/transactions/transfer
+		if len(parts) == 3 && parts[2] == "transfer" {
+			if r.Method != http.MethodPost {
+				httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			defer r.Body.Close()
+			var dto transferDTO
+			if err := json.NewDecoder(r.Body).Decode(&dto); err != nil {
+				httpError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
+				return
+			}
+			out, in, err := s.txFor(r).Transfer(pfID, dto)
+			if err != nil {
+				status := http.StatusBadRequest
+				if isNotFound(err) {
+					status = http.StatusNotFound
+				} else if errors.Is(err, ErrDuplicateID) {
+					status = http.StatusConflict
+				}
+				httpError(w, status, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, map[string]Transaction{"out": out, "in": in})
+			return
+		}
+
 		// Item: /portfolios/{id}/transactions/{txID}
 		if len(parts) == 3 {
 			txID := parts[2]
@@ -257,12 +638,26 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 				tx, err := s.tx.Get(pfID, txID)
 				if err != nil {
 					status := http.StatusInternalServerError
-					if err == ErrNotFound || err == ErrPortfolioNotFound {
+					if isNotFound(err) {
 						status = http.StatusNotFound
 					}
 					httpError(w, status, err.Error())
 					return
 				}
+				if truthy(r.URL.Query().Get("ref")) {
+					// The converted total moves with FX, so no 304; the ETag
+					// still identifies the record for If-Match
+					w.Header().Set("ETag", transactionETag(tx))
+					ref, ok := refParam(w, r)
+					if !ok {
+						return
+					}
+					writeJSON(w, http.StatusOK, s.tx.WithRef(ref).RefView(tx))
+					return
+				}
+				if notModified(w, r, transactionETag(tx), tx.UpdatedAt) {
+					return
+				}
 				writeJSON(w, http.StatusOK, tx)
 			case http.MethodPut:
 				defer r.Body.Close()
@@ -271,21 +666,26 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 					httpError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
 					return
 				}
-				tx, err := s.tx.Update(pfID, txID, dto)
+				tx, err := s.txFor(r).Update(pfID, txID, dto, r.Header.Get("If-Match"))
 				if err != nil {
 					status := http.StatusBadRequest
-					if err == ErrNotFound || err == ErrPortfolioNotFound {
+					if isNotFound(err) {
 						status = http.StatusNotFound
+					} else if err == ErrPreconditionFailed {
+						status = http.StatusPreconditionFailed
 					}
 					httpError(w, status, err.Error())
 					return
 				}
+				w.Header().Set("ETag", transactionETag(tx))
 				writeJSON(w, http.StatusOK, tx)
 			case http.MethodDelete:
-				if err := s.tx.Delete(pfID, txID); err != nil {
+				if err := s.txFor(r).Delete(pfID, txID, r.Header.Get("If-Match")); err != nil {
 					status := http.StatusInternalServerError
-					if err == ErrNotFound || err == ErrPortfolioNotFound {
+					if isNotFound(err) {
 						status = http.StatusNotFound
+					} else if err == ErrPreconditionFailed {
+						status = http.StatusPreconditionFailed
 					}
 					httpError(w, status, err.Error())
 					return
@@ -296,6 +696,69 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
+
+		// Execute: /portfolios/{id}/transactions/{txID}/execute
+		if len(parts) == 4 && parts[3] == "execute" {
+			if r.Method != http.MethodPost {
+				httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			tx, err := s.txFor(r).Execute(pfID, parts[2])
+			if err != nil {
+				status := http.StatusInternalServerError
+				if isNotFound(err) {
+					status = http.StatusNotFound
+				} else if err == ErrNotPending {
+					status = http.StatusConflict
+				}
+				httpError(w, status, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, tx)
+			return
+		}
+
+		// Realized P/L of a sell: /portfolios/{id}/transactions/{txID}/realized
+		if len(parts) == 4 && parts[3] == "realized" {
+			if r.Method != http.MethodGet {
+				httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			ref, ok := refParam(w, r)
+			if !ok {
+				return
+			}
+			out, err := s.tx.WithRef(ref).ComputeRealized(pfID, parts[2])
+			if err != nil {
+				status := http.StatusBadRequest
+				if isNotFound(err) {
+					status = http.StatusNotFound
+				}
+				httpError(w, status, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, out)
+			return
+		}
+
+		// Restore: /portfolios/{id}/transactions/{txID}/restore
+		if len(parts) == 4 && parts[3] == "restore" {
+			if r.Method != http.MethodPost {
+				httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			tx, err := s.txFor(r).Restore(pfID, parts[2])
+			if err != nil {
+				status := http.StatusInternalServerError
+				if isNotFound(err) {
+					status = http.StatusNotFound
+				}
+				httpError(w, status, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, tx)
+			return
+		}
 	}
 
 	// Case C: /portfolios/{id}/allocations
@@ -309,17 +772,26 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 		if basis == "" {
 			basis = "invested" // default
 		}
-		ref := pickRef(r.URL.Query().Get("ref_ccy"))
-		out, err := s.tx.WithRef(ref).ComputeAllocations(pfID, basis)
+		ref, ok := refParam(w, r)
+		if !ok {
+			return
+		}
+		lookThrough := truthy(r.URL.Query().Get("look_through"))
+		asOf, err := parseAsOf(r.URL.Query().Get("as_of"), s.tx.today())
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		out, err := s.tx.WithRef(ref).WithLookThrough(lookThrough).WithAsOf(asOf).ComputeAllocations(pfID, basis)
 		if err != nil {
 			status := http.StatusBadRequest
-			if err == ErrPortfolioNotFound {
+			if isNotFound(err) {
 				status = http.StatusNotFound
 			}
 			httpError(w, status, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, out)
+		writeRows(w, r, out, inRef(out.Items, out.RefCurrency))
 		return
 	}
 
@@ -330,17 +802,28 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		pfID := parts[0]
-		ref := pickRef(r.URL.Query().Get("ref_ccy"))
-		out, err := s.tx.WithRef(ref).ComputeSummary(pfID)
+		ref, ok := refParam(w, r)
+		if !ok {
+			return
+		}
+		svc, err := s.tx.WithRef(ref).WithDailyBasis(r.URL.Query().Get("daily_basis"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if truthy(r.URL.Query().Get("fresh")) {
+			svc = svc.Fresh()
+		}
+		out, err := svc.ComputeSummary(pfID)
 		if err != nil {
 			status := http.StatusBadRequest
-			if err == ErrPortfolioNotFound {
+			if isNotFound(err) {
 				status = http.StatusNotFound
 			}
 			httpError(w, status, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, out)
+		writeRows(w, r, out, inRef(out.Positions, out.RefCurrency))
 		return
 	}
 
@@ -365,11 +848,14 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
             priceBasis = "close"
         }
         debug := strings.TrimSpace(r.URL.Query().Get("debug")) == "1"
-        ref := pickRef(r.URL.Query().Get("ref_ccy"))
+        ref, ok := refParam(w, r)
+        if !ok {
+            return
+        }
         out, err := s.tx.WithRef(ref).ComputeBacktest(pfID, symbol, symbolCCY, priceBasis, debug)
         if err != nil {
             status := http.StatusBadRequest
-            if err == ErrPortfolioNotFound {
+            if isNotFound(err) {
                 status = http.StatusNotFound
             }
 			httpError(w, status, err.Error())
@@ -379,6 +865,134 @@ func (s *Server) handlePortfoliosSub(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Case F: /portfolios/{id}/attribution?benchmark=SPY&from=&to=
+	if len(parts) == 2 && parts[1] == "attribution" {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		q := r.URL.Query()
+		from, err := parseQueryDate(q.Get("from"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "invalid from (use YYYY-MM-DD)")
+			return
+		}
+		to, err := parseQueryDate(q.Get("to"))
+		if err != nil {
+			httpError(w, http.StatusBadRequest, "invalid to (use YYYY-MM-DD)")
+			return
+		}
+		ref, ok := refParam(w, r)
+		if !ok {
+			return
+		}
+		out, err := s.tx.WithRef(ref).ComputeAttribution(parts[0], q.Get("benchmark"), from, to)
+		if err != nil {
+			status := http.StatusBadRequest
+			if isNotFound(err) {
+				status = http.StatusNotFound
+			}
+			httpError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	// Case G: /portfolios/{id}/holdings
+	if len(parts) == 2 && parts[1] == "holdings" {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		out, err := s.tx.ComputeHoldings(parts[0], truthy(r.URL.Query().Get("include_closed")))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isNotFound(err) {
+				status = http.StatusNotFound
+			}
+			httpError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	// Case H: /portfolios/{id}/risk?benchmark=SPY&days=365
+	if len(parts) == 2 && parts[1] == "risk" {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		q := r.URL.Query()
+		days := 365
+		if v := strings.TrimSpace(q.Get("days")); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 30 || n > 3650 {
+				httpError(w, http.StatusBadRequest, "invalid days (use 30 to 3650)")
+				return
+			}
+			days = n
+		}
+		ref, ok := refParam(w, r)
+		if !ok {
+			return
+		}
+		out, err := s.tx.WithRef(ref).ComputeRisk(parts[0], q.Get("benchmark"), days)
+		if err != nil {
+			status := http.StatusBadRequest
+			if isNotFound(err) {
+				status = http.StatusNotFound
+			}
+			httpError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	// Case I: /portfolios/{id}/income/projection
+	if len(parts) == 3 && parts[1] == "income" && parts[2] == "projection" {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		ref, ok := refParam(w, r)
+		if !ok {
+			return
+		}
+		out, err := s.tx.WithRef(ref).ComputeIncomeProjection(parts[0])
+		if err != nil {
+			status := http.StatusBadRequest
+			if isNotFound(err) {
+				status = http.StatusNotFound
+			}
+			httpError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	// Case J: /portfolios/{id}/stats
+	if len(parts) == 2 && parts[1] == "stats" {
+		if r.Method != http.MethodGet {
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		out, err := s.tx.ComputeStats(parts[0])
+		if err != nil {
+			status := http.StatusInternalServerError
+			if isNotFound(err) {
+				status = http.StatusNotFound
+			}
+			httpError(w, status, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
 	http.NotFound(w, r)
 }
 
@@ -393,6 +1007,11 @@ func (s *Server) createTx(pfID string, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?upsert=1 replaces rows whose id already exists instead of failing
+	upsert := truthy(r.URL.Query().Get("upsert"))
+	// ?aggregate_fills=1 merges a batch's partial fills (see aggregateFills)
+	aggregate := truthy(r.URL.Query().Get("aggregate_fills"))
+
 	switch firstNonWS(body) {
 	case '[':
 		var payload []transactionDTO
@@ -400,66 +1019,316 @@ func (s *Server) createTx(pfID string, w http.ResponseWriter, r *http.Request) {
 			httpError(w, http.StatusBadRequest, "invalid batch payload: "+err.Error())
 			return
 		}
-		out, err := s.tx.CreateBatch(pfID, payload)
+		rows := payload
+		var groups [][]int
+		if aggregate {
+			payload, groups = aggregateFills(rows)
+		}
+		var out []Transaction
+		if upsert {
+			out, _, err = s.txFor(r).Upsert(pfID, payload)
+		} else {
+			out, err = s.txFor(r).CreateBatch(pfID, payload)
+		}
 		if err != nil {
 			status := http.StatusBadRequest
-			if err == ErrPortfolioNotFound {
+			if isNotFound(err) {
 				status = http.StatusNotFound
+			} else if errors.Is(err, ErrDuplicateID) {
+				status = http.StatusConflict
+			}
+			var be *BatchError
+			if errors.As(err, &be) {
+				index := be.Index
+				if aggregate && index < len(groups) {
+					index = groups[index][0] // first input row of the merged fill
+				}
+				httpErrorFields(w, status, be.Err.Error(), map[string]any{"index": index})
+				return
 			}
 			httpError(w, status, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusCreated, out)
+		status := http.StatusCreated
+		if upsert {
+			status = http.StatusOK // may mix inserts and replacements
+		}
+		if aggregate {
+			writeJSON(w, status, withFills(out, rows, groups))
+			return
+		}
+		writeJSON(w, status, out)
 	case '{':
 		var payload transactionDTO
 		if err := json.Unmarshal(body, &payload); err != nil {
 			httpError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
 			return
 		}
-		out, err := s.tx.CreateOne(pfID, payload)
+		var out Transaction
+		created := true
+		if upsert {
+			var outs []Transaction
+			var flags []bool
+			outs, flags, err = s.txFor(r).Upsert(pfID, []transactionDTO{payload})
+			var be *BatchError
+			if errors.As(err, &be) {
+				err = be.Err
+			}
+			if err == nil {
+				out, created = outs[0], flags[0]
+			}
+		} else {
+			out, err = s.txFor(r).CreateOne(pfID, payload)
+		}
 		if err != nil {
 			status := http.StatusBadRequest
-			if err == ErrPortfolioNotFound {
+			if isNotFound(err) {
 				status = http.StatusNotFound
+			} else if errors.Is(err, ErrDuplicateID) {
+				status = http.StatusConflict
 			}
 			httpError(w, status, err.Error())
 			return
 		}
+		if !created {
+			writeJSON(w, http.StatusOK, out)
+			return
+		}
+		// Batch and transfer creates return several resources, so only the
+		// single create sets Location
+		w.Header().Set("Location", "/portfolios/"+pfID+"/transactions/"+out.ID)
 		writeJSON(w, http.StatusCreated, out)
 	default:
 		httpError(w, http.StatusBadRequest, "payload must be object or array")
 	}
 }
 
-func (s *Server) listTx(pfID string, w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	limit := atoiDefault(q.Get("limit"), 50)
-	offset := atoiDefault(q.Get("offset"), 0)
-	sort := q.Get("sort")
-	if sort != "" && sort != "date_asc" && sort != "date_desc" {
-		httpError(w, http.StatusBadRequest, "invalid sort (use date_asc|date_desc)")
+// validateTx takes the same body (and ?upsert=1) as createTx and reports per
+// row what the create would do, without storing anything. Row errors are
+// part of the 200 response; only a bad body or unknown portfolio fails it.
+func (s *Server) validateTx(pfID string, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	r.Body = http.MaxBytesReader(w, r.Body, 5<<20) // same limit as createTx
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	upsert := truthy(r.URL.Query().Get("upsert"))
+	aggregate := truthy(r.URL.Query().Get("aggregate_fills"))
+
+	var payload []transactionDTO
+	switch firstNonWS(body) {
+	case '[':
+		if err := json.Unmarshal(body, &payload); err != nil {
+			httpError(w, http.StatusBadRequest, "invalid batch payload: "+err.Error())
+			return
+		}
+	case '{':
+		var one transactionDTO
+		if err := json.Unmarshal(body, &one); err != nil {
+			httpError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
+			return
+		}
+		payload = []transactionDTO{one}
+	default:
+		httpError(w, http.StatusBadRequest, "payload must be object or array")
 		return
 	}
-	filter := ListFilter{
-		Symbol: q.Get("symbol"), // symbol-only filtering
-		Limit:  limit,
-		Offset: offset,
-		Sort:   sort,
+
+	var groups [][]int
+	if aggregate {
+		payload, groups = aggregateFills(payload)
 	}
-	items, err := s.tx.List(pfID, filter)
+	out, err := s.tx.Validate(pfID, payload, upsert)
 	if err != nil {
 		status := http.StatusInternalServerError
-		if err == ErrPortfolioNotFound {
+		if isNotFound(err) {
 			status = http.StatusNotFound
 		}
 		httpError(w, status, err.Error())
 		return
 	}
+	if aggregate {
+		out = perInputRow(out, groups)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// listLimits bounds page sizes on GET .../transactions. Limit 0 ("no
+// limit") is for internal callers only; over HTTP every list is paged.
+type listLimits struct {
+	def int // LIST_DEFAULT_LIMIT, used when the request has no limit
+	max int // LIST_MAX_LIMIT; larger requests are clamped to it
+}
+
+func listLimitsFromEnv() listLimits {
+	l := listLimits{
+		def: envPositiveInt("LIST_DEFAULT_LIMIT", 50),
+		max: envPositiveInt("LIST_MAX_LIMIT", 1000),
+	}
+	if l.def > l.max {
+		l.def = l.max
+	}
+	return l
+}
+
+func envPositiveInt(key string, def int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil || n <= 0 {
+		return def
+	}
+	return n
+}
+
+// listFilter parses the transaction list query shared by
+// /portfolios/{id}/transactions and /transactions. On a bad parameter it
+// writes the 400 and returns false.
+func (s *Server) listFilter(w http.ResponseWriter, r *http.Request) (ListFilter, bool) {
+	q := r.URL.Query()
+	limit, err := queryInt(q, "limit", s.listLimits.def)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return ListFilter{}, false
+	}
+	offset, err := queryInt(q, "offset", 0)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return ListFilter{}, false
+	}
+	if limit <= 0 {
+		httpError(w, http.StatusBadRequest, "limit must be positive")
+		return ListFilter{}, false
+	}
+	if offset < 0 {
+		httpError(w, http.StatusBadRequest, "offset must not be negative")
+		return ListFilter{}, false
+	}
+	if limit > s.listLimits.max {
+		limit = s.listLimits.max
+		w.Header().Set("X-Limit-Clamped", strconv.Itoa(limit))
+	}
+	sort := q.Get("sort")
+	if sort != "" && sort != "date_asc" && sort != "date_desc" && sort != "updated_asc" {
+		httpError(w, http.StatusBadRequest, "invalid sort (use date_asc|date_desc|updated_asc)")
+		return ListFilter{}, false
+	}
+	var tt TradeType
+	if v := strings.TrimSpace(q.Get("trade_type")); v != "" {
+		var err error
+		if tt, err = normalizeTradeType(TradeType(v)); err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return ListFilter{}, false
+		}
+	}
+	from, err := parseQueryDate(q.Get("from"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "invalid from (use YYYY-MM-DD)")
+		return ListFilter{}, false
+	}
+	to, err := parseQueryDate(q.Get("to"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, "invalid to (use YYYY-MM-DD)")
+		return ListFilter{}, false
+	}
+	since, err := parseUpdatedSince(q.Get("updated_since"))
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return ListFilter{}, false
+	}
+	includeDeleted := truthy(q.Get("include_deleted"))
+	if !since.IsZero() {
+		// A delta sync needs the deletes too, in the order of its cursor
+		includeDeleted = true
+		if sort == "" {
+			sort = "updated_asc"
+		}
+	}
+	return ListFilter{
+		Symbol:         q.Get("symbol"), // symbol-only filtering
+		Limit:          limit,
+		Offset:         offset,
+		Sort:           sort,
+		IncludeDeleted: includeDeleted, // surfaces soft-deleted rows
+		IncludePending: truthy(q.Get("include_pending")), // surfaces planned trades
+		TradeType:      tt,
+		From:           from,
+		To:             to,
+		UpdatedSince:   since,
+	}, true
+}
+
+// parseUpdatedSince parses an updated_since cursor (RFC 3339); empty is zero.
+func parseUpdatedSince(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	// An unescaped "+" in the offset arrives as a space
+	t, err := time.Parse(time.RFC3339Nano, strings.Replace(v, " ", "+", 1))
+	if err != nil {
+		return time.Time{}, errors.New("invalid updated_since (use RFC 3339, e.g. 2025-01-02T15:04:05Z)")
+	}
+	return t, nil
+}
+
+func (s *Server) listTx(pfID string, w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, ok := s.listFilter(w, r)
+	if !ok {
+		return
+	}
+	items, err := s.tx.List(pfID, filter)
+	if err != nil {
+		httpError(w, listStatus(err), err.Error())
+		return
+	}
+	if truthy(q.Get("ref")) {
+		// ?ref=1 adds total_ref/fx_rate in ref_ccy to each row
+		ref, ok := refParam(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, s.tx.WithRef(ref).RefViews(items))
+		return
+	}
 	writeJSON(w, http.StatusOK, items)
 }
 
 /* ======= small helpers ======= */
 
+// isNotFound reports whether err is any of the repository not-found sentinels
+// (missing portfolio or missing transaction); handlers map both to 404.
+func isNotFound(err error) bool {
+    return errors.Is(err, ErrNotFound) || errors.Is(err, ErrPortfolioNotFound)
+}
+
+// listStatus is the status for a failed list: 410 when an updated_since
+// cursor needs a full resync, 404 for a missing portfolio, else 500.
+func listStatus(err error) int {
+    switch {
+    case errors.Is(err, ErrResyncRequired):
+        return http.StatusGone
+    case isNotFound(err):
+        return http.StatusNotFound
+    }
+    return http.StatusInternalServerError
+}
+
+func truthy(v string) bool {
+    switch strings.ToLower(strings.TrimSpace(v)) {
+    case "1", "true", "yes":
+        return true
+    }
+    return false
+}
+
+// txFor is the transaction service for a mutating request: its change events
+// carry the request's id.
+func (s *Server) txFor(r *http.Request) *TransactionService {
+    return s.tx.WithRequestID(requestID(r.Context()))
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
     w.Header().Set("Content-Type", "application/json")
     w.WriteHeader(status)
@@ -467,32 +1336,84 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 }
 
 func httpError(w http.ResponseWriter, status int, msg string) {
-    w.Header().Set("Content-Type", "application/json")
-    w.WriteHeader(status)
-    _ = json.NewEncoder(w).Encode(map[string]any{
+    httpErrorFields(w, status, msg, nil)
+}
+
+// httpErrorFields writes the standard error body plus extra fields (e.g. the
+// failing "index" of a batch).
+func httpErrorFields(w http.ResponseWriter, status int, msg string, extra map[string]any) {
+    body := map[string]any{
         "error":  http.StatusText(status),
         "detail": msg,
-    })
+    }
+    if id := w.Header().Get(requestIDHeader); id != "" {
+        body["request_id"] = id
+    }
+    for k, v := range extra {
+        body[k] = v
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    _ = json.NewEncoder(w).Encode(body)
 }
 
-// pickRef validates ref_ccy for now to TWD or USD; default TWD.
-func pickRef(v string) string {
+// pickRef validates a reference currency against ISO 4217. Empty means the
+// service default (REF_CCY) and is returned as "".
+func pickRef(v string) (string, error) {
     r := strings.ToUpper(strings.TrimSpace(v))
-    if r == "USD" || r == "TWD" {
-        return r
+    if r == "" || isISOCurrency(r) {
+        return r, nil
     }
-    return ""
+    return "", errors.New("unsupported currency " + strconv.Quote(strings.TrimSpace(v)) + " (use an ISO 4217 code, e.g. USD, TWD, JPY)")
 }
 
-func atoiDefault(s string, def int) int {
-	if s == "" {
-		return def
+// refParam reads ref_ccy (see pickRef), answering 400 when it isn't a known
+// code; ok is false once the error has been written.
+func refParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+    ref, err := pickRef(r.URL.Query().Get("ref_ccy"))
+    if err != nil {
+        httpError(w, http.StatusBadRequest, "ref_ccy: "+err.Error())
+        return "", false
+    }
+    return ref, true
+}
+
+// parseRefs splits a comma-separated refs list, validating each code like
+// pickRef and dropping repeats.
+func parseRefs(v string) ([]string, error) {
+	var refs []string
+	seen := map[string]bool{}
+	for _, part := range strings.Split(v, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		ref, err := pickRef(part)
+		if err != nil {
+			return nil, errors.New("refs: " + err.Error())
+		}
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil, errors.New("refs lists no currency (e.g. refs=USD,TWD)")
 	}
-	n, err := strconv.Atoi(s)
+	return refs, nil
+}
+
+// queryInt parses the integer query parameter key. Absent or empty means
+// def; anything else that isn't an integer is an error naming key.
+func queryInt(q url.Values, key string, def int) (int, error) {
+	v := strings.TrimSpace(q.Get(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		return def
+		return 0, errors.New("invalid " + key + " (use an integer)")
 	}
-	return n
+	return n, nil
 }
 
 func firstNonWS(b []byte) byte {
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// brokenPortfolios fails every List, as a repository with an unreadable file
+// would.
+type brokenPortfolios struct{ PortfolioRepository }
+
+func (brokenPortfolios) List() ([]Portfolio, error) {
+	return nil, errors.New("read portfolios.csv: i/o error")
+}
+
+func TestListPortfoliosStatus(t *testing.T) {
+	_, tx := newTestServices(t, fixedPrices{}, nil, "USD")
+	st := newMemoryStore()
+	good := NewPortfolioService(NewMemoryPortfolioRepo(st))
+	broken := NewPortfolioService(brokenPortfolios{NewMemoryPortfolioRepo(st)})
+	cases := []struct {
+		name  string
+		pf    *PortfolioService
+		query string
+		want  int
+	}{
+		{"ok", good, "", http.StatusOK},
+		{"bad sort", good, "?sort=size", http.StatusBadRequest},
+		{"bad limit", good, "?limit=x", http.StatusBadRequest},
+		{"repository error", broken, "", http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		NewServer(c.pf, tx).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/portfolios"+c.query, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: status %d, want %d (%s)", c.name, rec.Code, c.want, rec.Body)
+		}
+	}
+}
+
+func TestTransactionNotFoundIs404(t *testing.T) {
+	pf, tx := newTestServices(t, fixedPrices{}, nil, "USD")
+	id := mustPortfolio(t, pf, tx, "USD",
+		transactionDTO{TradeType: TradeTypeCash, Currency: "USD", Date: "2025/01/02", Total: 100})
+	rows, err := tx.List(id, ListFilter{})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("list = %v, %v", rows, err)
+	}
+	txID := rows[0].ID
+	const missing = "00000000-0000-4000-8000-000000000000"
+	body := `{"trade_type":"cash","currency":"USD","date":"2025/01/02","total":100}`
+	cases := []struct {
+		name       string
+		pfID, txID string
+	}{
+		{"missing portfolio, existing tx", missing, txID},
+		{"existing portfolio, missing tx", id, missing},
+		{"missing both", missing, missing},
+	}
+	srv := NewServer(pf, tx)
+	for _, c := range cases {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			var req *http.Request
+			path := "/portfolios/" + c.pfID + "/transactions/" + c.txID
+			if method == http.MethodPut {
+				req = httptest.NewRequest(method, path, strings.NewReader(body))
+			} else {
+				req = httptest.NewRequest(method, path, nil)
+			}
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s %s: status %d, want 404 (%s)", method, c.name, rec.Code, rec.Body)
+			}
+		}
+	}
+}
+
+func TestTimedBoundsReadsOnly(t *testing.T) {
+	s := &Server{timeout: 10 * time.Millisecond}
+	h := s.timed(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})
+	cases := []struct {
+		method string
+		want   int
+	}{
+		{http.MethodGet, http.StatusServiceUnavailable},
+		{http.MethodPost, http.StatusCreated},
+		{http.MethodPut, http.StatusCreated},
+		{http.MethodPatch, http.StatusCreated},
+		{http.MethodDelete, http.StatusCreated},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(c.method, "/portfolios/p1/transactions", nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: status %d, want %d", c.method, rec.Code, c.want)
+		}
+	}
+}
+
+func TestRefCurrencyAcceptsISOCodes(t *testing.T) {
+	pf, tx := newTestServices(t, fixedPrices{"AAPL": 100.37}, fixedRates{"USDJPY": 150.33, "USDTWD": 30}, "TWD")
+	mustPortfolio(t, pf, tx, "USD", buyTx("AAPL", "USD", 3, 100))
+	srv := NewServer(pf, tx)
+	cases := []struct {
+		query string
+		want  int
+		refs  []string // currencies in the response
+	}{
+		{"", http.StatusOK, []string{"TWD"}},
+		{"?ref_ccy=jpy", http.StatusOK, []string{"JPY"}},
+		{"?ref_ccy=XYZ", http.StatusBadRequest, nil},
+		{"?refs=USD,JPY", http.StatusOK, []string{"USD", "JPY"}},
+		{"?refs=USD,XYZ", http.StatusBadRequest, nil},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/summary"+c.query, nil))
+		if rec.Code != c.want {
+			t.Errorf("%s: status %d, want %d (%s)", c.query, rec.Code, c.want, rec.Body)
+			continue
+		}
+		if c.want != http.StatusOK {
+			continue
+		}
+		sums := map[string]SummaryResponse{}
+		if strings.Contains(c.query, "refs=") {
+			if err := json.NewDecoder(rec.Body).Decode(&sums); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			var one SummaryResponse
+			if err := json.NewDecoder(rec.Body).Decode(&one); err != nil {
+				t.Fatal(err)
+			}
+			sums[one.RefCurrency] = one
+		}
+		for _, ref := range c.refs {
+			sum, ok := sums[ref]
+			if !ok {
+				t.Errorf("%s: no %s summary in %v", c.query, ref, sums)
+				continue
+			}
+			// 3 × 100.37 USD at 150.33 is 45,265.87 JPY, rounded to whole yen
+			if ref == "JPY" && sum.TotalMarketValue != 45266 {
+				t.Errorf("%s: JPY market value %v, want 45266", c.query, sum.TotalMarketValue)
+			}
+		}
+	}
+}
@@ -2,36 +2,132 @@ package main
 
 import (
     "errors"
+    "fmt"
+    "log"
+    "os"
     "regexp"
+    "strconv"
     "strings"
+    "sync"
     "time"
 )
 
 /* ===================== Portfolio service ===================== */
 
 type PortfolioService struct {
-	repo PortfolioRepository
+	repo     PortfolioRepository
+	onChange []func() // called after a successful create/update/delete
+	clock    Clock
 }
 
 func NewPortfolioService(r PortfolioRepository) *PortfolioService {
-	return &PortfolioService{repo: r}
+	return &PortfolioService{repo: r, clock: systemClock{}}
+}
+
+// SetClock replaces the system clock, e.g. with a FixedClock in tests, here
+// and in the repository. Call during wiring.
+func (s *PortfolioService) SetClock(c Clock) {
+	s.clock = c
+	if cs, ok := s.repo.(clockSetter); ok {
+		cs.SetClock(c)
+	}
 }
 
 func (s *PortfolioService) Create(dto portfolioDTO) (Portfolio, error) {
-	now := time.Now()
+	now := s.clock.Now()
 	p, err := dto.toDomain(now)
 	if err != nil {
 		return Portfolio{}, err
 	}
-	return s.repo.Create(p)
+	out, err := s.repo.Create(p)
+	if err == nil {
+		s.changed()
+	}
+	return out, err
+}
+
+// OnChange registers fn to run after every successful portfolio mutation.
+func (s *PortfolioService) OnChange(fn func()) { s.onChange = append(s.onChange, fn) }
+
+func (s *PortfolioService) changed() {
+	for _, fn := range s.onChange {
+		fn()
+	}
 }
 
 func (s *PortfolioService) List() ([]Portfolio, error)       { return s.repo.List() }
+
+// Portfolio list orderings accepted by ListPage.
+var portfolioSorts = map[string]func(a, b Portfolio) bool{
+	"created_asc":  func(a, b Portfolio) bool { return a.CreatedAt.Before(b.CreatedAt) },
+	"created_desc": func(a, b Portfolio) bool { return a.CreatedAt.After(b.CreatedAt) },
+	"updated_asc":  func(a, b Portfolio) bool { return a.UpdatedAt.Before(b.UpdatedAt) },
+	"updated_desc": func(a, b Portfolio) bool { return a.UpdatedAt.After(b.UpdatedAt) },
+	"name_asc":     func(a, b Portfolio) bool { return strings.ToLower(a.Name) < strings.ToLower(b.Name) },
+	"name_desc":    func(a, b Portfolio) bool { return strings.ToLower(a.Name) > strings.ToLower(b.Name) },
+}
+
+// ErrInvalidSort is returned by ListPage for an unknown sort order.
+var ErrInvalidSort = errors.New("invalid sort")
+
+// ListPage lists portfolios in a stable order (default created_asc, ties by
+// ID) and applies offset/limit; limit <= 0 returns everything after offset.
+// A non-zero since keeps only portfolios updated at or after it, and then
+// defaults the order to updated_asc.
+func (s *PortfolioService) ListPage(sortBy string, limit, offset int, since time.Time) ([]Portfolio, error) {
+	if sortBy == "" {
+		sortBy = "created_asc"
+		if !since.IsZero() {
+			sortBy = "updated_asc"
+		}
+	}
+	less, ok := portfolioSorts[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("%w %q (use created_asc|created_desc|updated_asc|updated_desc|name_asc|name_desc)", ErrInvalidSort, sortBy)
+	}
+	if err := checkCursor(s.repo, since); err != nil {
+		return nil, err
+	}
+	pfs, err := s.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	if !since.IsZero() {
+		kept := pfs[:0]
+		for _, p := range pfs {
+			if !p.UpdatedAt.Before(since) {
+				kept = append(kept, p)
+			}
+		}
+		pfs = kept
+	}
+	// The repos iterate a map; order by ID first so ties are deterministic.
+	stableSort(pfs, func(a, b Portfolio) bool { return a.ID < b.ID })
+	stableSort(pfs, less)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(pfs) {
+		return []Portfolio{}, nil
+	}
+	end := len(pfs)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return pfs[offset:end], nil
+}
 func (s *PortfolioService) Get(id string) (Portfolio, error) { return s.repo.GetByID(id) }
-func (s *PortfolioService) Delete(id string) error           { return s.repo.Delete(id) }
+
+func (s *PortfolioService) Delete(id string) error {
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+	s.changed()
+	return nil
+}
 
 func (s *PortfolioService) Update(id string, dto portfolioDTO) (Portfolio, error) {
-	now := time.Now()
+	now := s.clock.Now()
 	existing, err := s.repo.GetByID(id)
 	if err != nil {
 		return Portfolio{}, err
@@ -41,17 +137,58 @@ func (s *PortfolioService) Update(id string, dto portfolioDTO) (Portfolio, error
 		return Portfolio{}, err
 	}
 	p.CreatedAt = existing.CreatedAt
-	return s.repo.Update(p)
+	out, err := s.repo.Update(p)
+	if err == nil {
+		s.changed()
+	}
+	return out, err
 }
 
 /* ===================== Transaction service ===================== */
 
 type TransactionService struct {
-    repoTx    TransactionRepository
-    repoPf    PortfolioRepository
-    prices    PriceProvider
-    exchanger CurrencyExchanger
-    refCCY    string
+    repoTx       TransactionRepository
+    repoPf       PortfolioRepository
+    prices       PriceProvider
+    exchanger    CurrencyExchanger
+    refCCY       string
+    listeners    []TransactionListener
+    aliases      map[string]string // old symbol -> current symbol
+    cashOrdering string            // CashOrderingOptimistic | CashOrderingStrict
+    tag          string            // when set, global computations only include portfolios with this tag
+    dailyBasis   string            // DailyBasisPrevClose (default) | DailyBasisSessionOpen
+    summaries    *summaryCache     // shared by WithX copies; invalidated on mutation
+    fresh        bool              // bypass (but refill) the summary cache
+    holdings     map[string][]FundWeight // fund -> constituents, for look-through allocations
+    lookThrough  bool                    // split fund allocations into their constituents
+    tradeDateFX  bool                    // aggregation also books cost at trade-date FX (summaries)
+    fxRef        string                  // configured REF_CCY; target of rates stored on transactions
+    asOf         time.Time               // allocations valued at this past close (zero: now)
+    clock        Clock
+    requestID    string // stamped on the events this copy emits (WithRequestID)
+    fxMisses     *fxFallbacks // currencies counted at parity (WithFXWarnings)
+    fxStamps     *sync.WaitGroup // background trade-date rate lookups (stampFXLater)
+}
+
+// Transaction change events delivered to listeners after a successful mutation.
+const (
+    EventTransactionCreated  = "transaction.created"
+    EventTransactionUpdated  = "transaction.updated"
+    EventTransactionDeleted  = "transaction.deleted"
+    EventTransactionRestored = "transaction.restored"
+)
+
+type TransactionEvent struct {
+    Type        string      `json:"type"`
+    PortfolioID string      `json:"portfolio_id"`
+    Transaction Transaction `json:"transaction"`
+    RequestID   string      `json:"request_id,omitempty"` // the API request that made the change
+}
+
+// TransactionListener is notified synchronously from the mutating call;
+// implementations must not block.
+type TransactionListener interface {
+    TransactionChanged(ev TransactionEvent)
 }
 
 func NewTransactionService(txRepo TransactionRepository, pfRepo PortfolioRepository, priceProvider PriceProvider, exchanger CurrencyExchanger, refCCY string) *TransactionService {
@@ -59,26 +196,42 @@ func NewTransactionService(txRepo TransactionRepository, pfRepo PortfolioReposit
 		refCCY = "TWD"
 	}
     return &TransactionService{
-        repoTx:    txRepo,
-        repoPf:    pfRepo,
-        prices:    priceProvider,
-        exchanger: exchanger,
-        refCCY:    strings.ToUpper(refCCY),
+        repoTx:     txRepo,
+        repoPf:     pfRepo,
+        prices:     priceProvider,
+        exchanger:  exchanger,
+        refCCY:     strings.ToUpper(refCCY),
+        fxRef:      strings.ToUpper(refCCY),
+        dailyBasis: DailyBasisPrevClose,
+        summaries:  newSummaryCache(summaryCacheTTL),
+        clock:      systemClock{},
+        fxStamps:   &sync.WaitGroup{},
     }
 }
 
+// SetClock replaces the system clock, e.g. with a FixedClock in tests, so
+// "today" in daily P/L, backtests and date checks is deterministic, and the
+// repositories' updated_at/deleted_at stamps follow it. Call during wiring.
+func (s *TransactionService) SetClock(c Clock) {
+	s.clock = c
+	for _, r := range []any{s.repoTx, s.repoPf} {
+		if cs, ok := r.(clockSetter); ok {
+			cs.SetClock(c)
+		}
+	}
+}
+
+// today is the clock's calendar day (see calendarDay).
+func (s *TransactionService) today() time.Time { return calendarDay(s.clock.Now()) }
+
 // WithRef returns a shallow copy of the service using the provided
-// reference currency for calculations. Only TWD and USD are accepted
-// for now; anything else falls back to TWD.
+// reference currency for calculations. Anything but an ISO 4217 code (the
+// handlers reject those first) keeps the service's own, REF_CCY.
 func (s *TransactionService) WithRef(ref string) *TransactionService {
     r := strings.ToUpper(strings.TrimSpace(ref))
-    switch r {
-    case "USD", "TWD":
-        // ok
-    default:
-        if s != nil && s.refCCY != "" && (s.refCCY == "USD" || s.refCCY == "TWD") {
-            r = s.refCCY
-        } else {
+    if !isISOCurrency(r) {
+        r = s.refCCY
+        if r == "" {
             r = "TWD"
         }
     }
@@ -87,32 +240,251 @@ func (s *TransactionService) WithRef(ref string) *TransactionService {
     return &cp
 }
 
+// Subscribe registers l for transaction change events. Call during wiring,
+// before the service starts handling requests.
+func (s *TransactionService) Subscribe(l TransactionListener) {
+    s.listeners = append(s.listeners, l)
+}
+
+// SetSymbolAliases installs old->new symbol renames applied when pricing and
+// aggregating positions. Call during wiring.
+func (s *TransactionService) SetSymbolAliases(m map[string]string) {
+    s.aliases = m
+}
+
+// SetFundHoldings installs the fund constituent weights used by look-through
+// allocations. Call during wiring.
+func (s *TransactionService) SetFundHoldings(m map[string][]FundWeight) {
+    s.holdings = m
+}
+
+// WithLookThrough returns a shallow copy whose allocations decompose fund
+// positions into their constituents (see holdings.csv).
+func (s *TransactionService) WithLookThrough(on bool) *TransactionService {
+    cp := *s
+    cp.lookThrough = on
+    return &cp
+}
+
+// WithAsOf returns a shallow copy whose allocations only count transactions
+// dated on or before date and value them at that day's close. The zero time
+// means now.
+func (s *TransactionService) WithAsOf(date time.Time) *TransactionService {
+    cp := *s
+    cp.asOf = date
+    return &cp
+}
+
+// canonicalSymbol maps a recorded symbol to the one it now trades under.
+func (s *TransactionService) canonicalSymbol(sym string) string {
+    if to, ok := s.aliases[strings.ToUpper(sym)]; ok {
+        return to
+    }
+    return sym
+}
+
+func (s *TransactionService) notify(typ, portfolioID string, tx Transaction) {
+    s.InvalidateSummaries()
+    for _, l := range s.listeners {
+        l.TransactionChanged(TransactionEvent{Type: typ, PortfolioID: portfolioID, Transaction: tx, RequestID: s.requestID})
+    }
+}
+
+// WithTag returns a shallow copy of the service whose global computations
+// (summary, allocations, backtest across portfolios) only include portfolios
+// carrying tag. An empty tag includes every portfolio.
+func (s *TransactionService) WithTag(tag string) *TransactionService {
+    cp := *s
+    cp.tag = strings.TrimSpace(tag)
+    return &cp
+}
+
+// WithRequestID returns a shallow copy of the service whose change events
+// carry id, so webhooks can be matched to the request that caused them.
+func (s *TransactionService) WithRequestID(id string) *TransactionService {
+    cp := *s
+    cp.requestID = id
+    return &cp
+}
+
+// Reference point for daily P/L.
+const (
+    // DailyBasisPrevClose compares against the prior session's close (default).
+    DailyBasisPrevClose = "prev_close"
+    // DailyBasisSessionOpen compares against today's open ("since the open").
+    DailyBasisSessionOpen = "session_open"
+)
+
+// WithDailyBasis returns a shallow copy computing daily P/L against basis.
+// Empty means prev_close.
+func (s *TransactionService) WithDailyBasis(basis string) (*TransactionService, error) {
+    cp := *s
+    switch b := strings.ToLower(strings.TrimSpace(basis)); b {
+    case "", DailyBasisPrevClose:
+        cp.dailyBasis = DailyBasisPrevClose
+    case DailyBasisSessionOpen:
+        cp.dailyBasis = b
+    default:
+        return nil, fmt.Errorf("unsupported daily_basis %q (use prev_close|session_open)", basis)
+    }
+    return &cp, nil
+}
+
+// Fresh returns a shallow copy that recomputes summaries instead of serving
+// them from the cache. The recomputed result still refills the cache.
+func (s *TransactionService) Fresh() *TransactionService {
+    cp := *s
+    cp.fresh = true
+    return &cp
+}
+
+// InvalidateSummaries drops every memoized summary. Transaction mutations
+// call it through notify; portfolio mutations are wired to it in main.
+func (s *TransactionService) InvalidateSummaries() {
+    if s.summaries != nil {
+        s.summaries.invalidate()
+    }
+}
+
+// cachedSummary serves key from the summary cache, computing and storing it
+// on a miss (or always, for a Fresh copy).
+func (s *TransactionService) cachedSummary(key string, compute func() (SummaryResponse, error)) (SummaryResponse, error) {
+    if s.summaries == nil {
+        return compute()
+    }
+    if r, ok := s.repoTx.(reloader); ok {
+        n, err := r.Reloads()
+        if err != nil {
+            return SummaryResponse{}, err
+        }
+        s.summaries.sync(n)
+    }
+    key = key + "|" + s.refCCY + "|" + s.dailyBasis
+    resp, version, ok := s.summaries.get(key)
+    if ok && !s.fresh {
+        return resp, nil
+    }
+    resp, err := compute()
+    if err != nil {
+        return resp, err
+    }
+    s.summaries.put(key, version, resp)
+    return resp, nil
+}
+
+// listPortfolios lists the portfolios in scope for global computations.
+func (s *TransactionService) listPortfolios() ([]Portfolio, error) {
+    pfs, err := s.repoPf.List()
+    if err != nil || s.tag == "" {
+        return pfs, err
+    }
+    out := pfs[:0]
+    for _, pf := range pfs {
+        if hasTag(pf.Tags, s.tag) {
+            out = append(out, pf)
+        }
+    }
+    return out, nil
+}
+
+// errLoneTransfer rejects a transfer row written on its own, which would move
+// shares out of or into one portfolio only; Transfer writes both legs.
+var errLoneTransfer = errors.New("transfer rows are created in pairs with POST /portfolios/{id}/transactions/transfer")
+
 func (s *TransactionService) CreateOne(portfolioID string, dto transactionDTO) (Transaction, error) {
-	if _, err := s.repoPf.GetByID(portfolioID); err != nil {
+	pf, err := s.repoPf.GetByID(portfolioID)
+	if err != nil {
 		return Transaction{}, ErrPortfolioNotFound
 	}
-	now := time.Now()
-	tx, err := dto.toDomain(now, portfolioID)
+	now := s.clock.Now()
+	tx, err := dto.toDomain(now, portfolioID, pf.BaseCCY)
+	if err == nil && tx.TradeType == TradeTypeTransfer {
+		err = errLoneTransfer
+	}
+	if err != nil {
+		return Transaction{}, err
+	}
+	txs := []Transaction{tx}
+	s.stampFX(txs)
+	out, err := s.repoTx.Create(portfolioID, txs[0])
 	if err != nil {
 		return Transaction{}, err
 	}
-	return s.repoTx.Create(portfolioID, tx)
+	s.notify(EventTransactionCreated, portfolioID, out)
+	s.stampFXLater(out)
+	return out, nil
 }
 
+// CreateBatch creates all rows or none. The result is in the same order as
+// dtos; a row that fails validation is reported as a *BatchError.
 func (s *TransactionService) CreateBatch(portfolioID string, dtos []transactionDTO) ([]Transaction, error) {
-	if _, err := s.repoPf.GetByID(portfolioID); err != nil {
+	pf, err := s.repoPf.GetByID(portfolioID)
+	if err != nil {
 		return nil, ErrPortfolioNotFound
 	}
-	now := time.Now()
+	now := s.clock.Now()
 	txs := make([]Transaction, len(dtos))
+	ids := derivedIDs{}
 	for i, d := range dtos {
-		tx, err := d.toDomain(now, portfolioID)
+		tx, err := d.toDomain(now, portfolioID, pf.BaseCCY)
+		if err == nil && tx.TradeType == TradeTypeTransfer {
+			err = errLoneTransfer
+		}
 		if err != nil {
-			return nil, err
+			return nil, &BatchError{Index: i, Err: err}
 		}
+		tx.ID = ids.distinct(d, tx)
 		txs[i] = tx
 	}
-	return s.repoTx.CreateBatch(portfolioID, txs)
+	s.stampFX(txs)
+	out, err := s.repoTx.CreateBatch(portfolioID, txs)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range out {
+		s.notify(EventTransactionCreated, portfolioID, tx)
+	}
+	s.stampFXLater(out...)
+	return out, nil
+}
+
+// Upsert creates or replaces transactions by ID (client-supplied or derived
+// under DETERMINISTIC_IDS) in one write, for syncing from an external ledger.
+// created[i] reports whether dtos[i] was new; a row that fails validation is
+// reported as a *BatchError.
+func (s *TransactionService) Upsert(portfolioID string, dtos []transactionDTO) ([]Transaction, []bool, error) {
+	pf, err := s.repoPf.GetByID(portfolioID)
+	if err != nil {
+		return nil, nil, ErrPortfolioNotFound
+	}
+	now := s.clock.Now()
+	txs := make([]Transaction, len(dtos))
+	ids := derivedIDs{}
+	for i, d := range dtos {
+		tx, err := d.toDomain(now, portfolioID, pf.BaseCCY)
+		if err == nil && tx.TradeType == TradeTypeTransfer {
+			err = errLoneTransfer
+		}
+		if err != nil {
+			return nil, nil, &BatchError{Index: i, Err: err}
+		}
+		tx.ID = ids.distinct(d, tx)
+		txs[i] = tx
+	}
+	s.stampFX(txs)
+	out, created, err := s.repoTx.Upsert(portfolioID, txs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, tx := range out {
+		if created[i] {
+			s.notify(EventTransactionCreated, portfolioID, tx)
+		} else {
+			s.notify(EventTransactionUpdated, portfolioID, tx)
+		}
+	}
+	s.stampFXLater(out...)
+	return out, created, nil
 }
 
 func (s *TransactionService) Get(portfolioID, id string) (Transaction, error) {
@@ -120,45 +492,572 @@ func (s *TransactionService) Get(portfolioID, id string) (Transaction, error) {
 }
 
 func (s *TransactionService) List(portfolioID string, q ListFilter) ([]Transaction, error) {
+	if err := checkCursor(s.repoTx, q.UpdatedSince); err != nil {
+		return nil, err
+	}
 	return s.repoTx.List(portfolioID, q)
 }
 
-func (s *TransactionService) Update(portfolioID, id string, dto transactionDTO) (Transaction, error) {
+// ListAll lists transactions across every portfolio (honoring WithTag) as
+// one list: filtered, then sorted and paged over the merged rows. Sort
+// defaults to date_desc; ties are ordered by creation, so pages are stable.
+func (s *TransactionService) ListAll(filter ListFilter) ([]Transaction, error) {
+	if err := checkCursor(s.repoTx, filter.UpdatedSince); err != nil {
+		return nil, err
+	}
+	pfs, err := s.listPortfolios()
+	if err != nil {
+		return nil, err
+	}
+	rows := filter
+	rows.Limit, rows.Offset, rows.Sort = 0, 0, ""
+	var all []Transaction
+	for _, pf := range pfs {
+		txs, err := s.repoTx.List(pf.ID, rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, txs...)
+	}
+	stableSort(all, func(a, b Transaction) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	if filter.Sort == "" {
+		filter.Sort = "date_desc"
+	}
+	return pageTransactions(all, filter), nil
+}
+
+// TransactionView is a transaction with its total converted into the
+// reference currency. Stored data stays in the trade currency.
+type TransactionView struct {
+	Transaction
+	RefCurrency string  `json:"ref_currency"`
+	FXRate      float64 `json:"fx_rate"`   // trade currency -> RefCurrency
+	TotalRef    float64 `json:"total_ref"` // Total × FXRate
+}
+
+// RefView converts tx at the same rate summaries use.
+func (s *TransactionService) RefView(tx Transaction) TransactionView {
+	fx := s.txRate(tx)
+	return TransactionView{Transaction: tx, RefCurrency: s.refCCY, FXRate: fx, TotalRef: tx.Total * fx}
+}
+
+// RefViews is RefView over a list.
+func (s *TransactionService) RefViews(txs []Transaction) []TransactionView {
+	out := make([]TransactionView, len(txs))
+	for i, tx := range txs {
+		out[i] = s.RefView(tx)
+	}
+	return out
+}
+
+// Update replaces a transaction. A non-empty ifMatch must match the stored
+// transaction's ETag or ErrPreconditionFailed is returned without writing;
+// the repository compares it under its write lock (see ifMatchCheck).
+func (s *TransactionService) Update(portfolioID, id string, dto transactionDTO, ifMatch string) (Transaction, error) {
 	existing, err := s.repoTx.GetByID(portfolioID, id)
 	if err != nil {
 		return Transaction{}, err
 	}
-	now := time.Now()
-	tx, err := dto.toDomain(now, portfolioID, existing.ID)
+	if !etagMatches(ifMatch, existing) {
+		return Transaction{}, ErrPreconditionFailed // fail fast; rechecked at the write
+	}
+	pf, err := s.repoPf.GetByID(portfolioID)
+	if err != nil {
+		return Transaction{}, ErrPortfolioNotFound
+	}
+	now := s.clock.Now()
+	tx, err := dto.toDomain(now, portfolioID, pf.BaseCCY, existing.ID)
+	if err == nil && tx.TradeType == TradeTypeTransfer && existing.TradeType != TradeTypeTransfer {
+		err = errLoneTransfer
+	}
 	if err != nil {
 		return Transaction{}, err
 	}
 	tx.CreatedAt = existing.CreatedAt
-	return s.repoTx.Update(portfolioID, tx)
+	if tx.FXRate <= 0 && existing.FXRef == s.fxRef && ccyKey(tx.Currency) == ccyKey(existing.Currency) && tx.Date.Equal(existing.Date) {
+		tx.FXRate = existing.FXRate // same trade day: the looked-up rate still holds
+	}
+	txs := []Transaction{tx}
+	s.stampFX(txs)
+	out, err := s.repoTx.Update(portfolioID, txs[0], ifMatchCheck(ifMatch, nil))
+	if err != nil {
+		return Transaction{}, err
+	}
+	s.notify(EventTransactionUpdated, portfolioID, out)
+	s.stampFXLater(out)
+	return out, nil
 }
 
-func (s *TransactionService) Delete(portfolioID, id string) error {
-	return s.repoTx.Delete(portfolioID, id)
+// Delete soft-deletes a transaction, honoring ifMatch like Update.
+func (s *TransactionService) Delete(portfolioID, id string, ifMatch string) error {
+	var existing Transaction
+	if err := s.repoTx.Delete(portfolioID, id, ifMatchCheck(ifMatch, &existing)); err != nil {
+		return err
+	}
+	s.notify(EventTransactionDeleted, portfolioID, existing)
+	return nil
 }
 
-func (s *TransactionService) rate(from string) float64 {
+// ifMatchCheck is the precondition for an If-Match header: the stored row's
+// ETag must still match, compared under the repository's write lock so a
+// concurrent write can't slip in between. It also copies the stored row
+// into seen when non-nil.
+func ifMatchCheck(ifMatch string, seen *Transaction) precondition {
+	return func(cur Transaction) error {
+		if seen != nil {
+			*seen = cur
+		}
+		if !etagMatches(ifMatch, cur) {
+			return ErrPreconditionFailed
+		}
+		return nil
+	}
+}
+
+// Transfer moves shares from one portfolio to another without a sale. The
+// source must hold enough shares; the out and in legs are stored atomically.
+func (s *TransactionService) Transfer(fromPortfolioID string, dto transferDTO) (Transaction, Transaction, error) {
+	from, err := s.repoPf.GetByID(fromPortfolioID)
+	if err != nil {
+		return Transaction{}, Transaction{}, ErrPortfolioNotFound
+	}
+	if _, err := s.repoPf.GetByID(dto.ToPortfolioID); err != nil {
+		return Transaction{}, Transaction{}, fmt.Errorf("destination %w", ErrPortfolioNotFound)
+	}
+	out, in, err := dto.toDomain(s.clock.Now(), fromPortfolioID, from.BaseCCY)
+	if err != nil {
+		return Transaction{}, Transaction{}, err
+	}
+	legs := []Transaction{out, in}
+	s.stampFX(legs)
+	out, in, err = s.repoTx.CreateTransfer(legs[0], legs[1], s.transferCost)
+	if err != nil {
+		return Transaction{}, Transaction{}, err
+	}
+	s.notify(EventTransactionCreated, out.PortfolioID, out)
+	s.notify(EventTransactionCreated, in.PortfolioID, in)
+	s.stampFXLater(out, in)
+	return out, in, nil
+}
+
+// transferCost checks that the source holds the shares and carries their
+// average cost, in the trade currency, onto both legs: the out leg removes
+// that cost (aggregatePositions' reduce) and the in leg adds the same, so the
+// transfer preserves invested. It runs under the repository's write lock.
+func (s *TransactionService) transferCost(source []Transaction, out, in Transaction) (Transaction, Transaction, error) {
+	native := *s
+	native.exchanger = nil // amounts stay in their own currency
+	sym := s.canonicalSymbol(out.Symbol)
+	shares := -out.Shares
+	a := native.aggregatePositions(source)[sym]
+	if a == nil || a.shares <= 0 || shares > a.shares+cashEpsilon {
+		held := 0.0
+		if a != nil {
+			held = a.shares
+		}
+		return out, in, fmt.Errorf("source portfolio holds %g shares of %s; cannot transfer %g", held, sym, shares)
+	}
+	cost := a.invested / a.shares * shares
+	if a.currency != "" {
+		out.Currency, in.Currency = a.currency, a.currency
+	}
+	for _, leg := range []*Transaction{&out, &in} {
+		leg.Total, leg.Price = cost, cost/shares
+	}
+	return out, in, nil
+}
+
+// DeleteAll permanently removes every transaction of a portfolio, keeping the
+// portfolio itself. Listeners get one delete event per removed row that was
+// not already soft-deleted (those had theirs), pending ones included; the
+// count returned is of the same rows.
+func (s *TransactionService) DeleteAll(portfolioID string) (int, error) {
+	removed, err := s.repoTx.DeleteAll(portfolioID)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, tx := range removed {
+		if tx.DeletedAt != nil {
+			continue
+		}
+		s.notify(EventTransactionDeleted, portfolioID, tx)
+		n++
+	}
+	return n, nil
+}
+
+// ErrNotPending is returned when executing a transaction that already counts.
+var ErrNotPending = errors.New("transaction is not pending")
+
+// Execute turns a pending transaction into a real one, dated today (the
+// execution day) so it enters allocations, summaries and cash stats.
+func (s *TransactionService) Execute(portfolioID, id string) (Transaction, error) {
+	tx, err := s.repoTx.GetByID(portfolioID, id)
+	if err != nil {
+		return Transaction{}, err
+	}
+	if !tx.Pending {
+		return Transaction{}, ErrNotPending
+	}
+	tx.Pending = false
+	tx.Date = s.today()
+	txs := []Transaction{tx}
+	s.stampFX(txs) // rate on the execution day
+	stillPending := func(cur Transaction) error {
+		if !cur.Pending {
+			return ErrNotPending // executed meanwhile
+		}
+		return nil
+	}
+	out, err := s.repoTx.Update(portfolioID, txs[0], stillPending)
+	if err != nil {
+		return Transaction{}, err
+	}
+	s.notify(EventTransactionUpdated, portfolioID, out)
+	s.stampFXLater(out)
+	return out, nil
+}
+
+// Restore undoes a soft delete that has not been purged yet.
+func (s *TransactionService) Restore(portfolioID, id string) (Transaction, error) {
+	out, err := s.repoTx.Restore(portfolioID, id)
+	if err != nil {
+		return Transaction{}, err
+	}
+	s.notify(EventTransactionRestored, portfolioID, out)
+	return out, nil
+}
+
+// PurgeDeleted permanently removes transactions soft-deleted more than
+// retention ago.
+func (s *TransactionService) PurgeDeleted(retention time.Duration) (int, error) {
+	return s.repoTx.PurgeDeleted(s.clock.Now().Add(-retention))
+}
+
+// ErrResetUnsupported is returned by Reset for repositories that persist data.
+var ErrResetUnsupported = errors.New("reset is only available with REPO_KIND=memory")
+
+// Reset wipes every portfolio and transaction. Only the memory repository
+// supports it. Listeners get one delete event per live transaction.
+func (s *TransactionService) Reset() (portfolios, transactions int, err error) {
+	type resetter interface{ Reset() (int, int) }
+	r, ok := s.repoTx.(resetter)
+	if !ok {
+		return 0, 0, ErrResetUnsupported
+	}
+	pfs, err := s.repoPf.List()
+	if err != nil {
+		return 0, 0, err
+	}
+	var live []Transaction
+	for _, pf := range pfs {
+		txs, err := s.repoTx.List(pf.ID, ListFilter{Limit: 0})
+		if err != nil {
+			return 0, 0, err
+		}
+		live = append(live, txs...)
+	}
+	portfolios, transactions = r.Reset()
+	s.InvalidateSummaries()
+	for _, tx := range live {
+		s.notify(EventTransactionDeleted, tx.PortfolioID, tx)
+	}
+	return portfolios, transactions, nil
+}
+
+// unwrapProvider yields p and then each provider it wraps, following Unwrap
+// through decorators (the request memo, the overlay) that don't report a
+// status of their own.
+func unwrapProvider(p PriceProvider, visit func(PriceProvider) bool) {
+	type unwrapper interface{ Unwrap() PriceProvider }
+	for p != nil && !visit(p) {
+		u, ok := p.(unwrapper)
+		if !ok {
+			return
+		}
+		p = u.Unwrap()
+	}
+}
+
+// ProviderStatus reports the price provider's circuit breaker state, if the
+// provider is wrapped in one.
+func (s *TransactionService) ProviderStatus() (st BreakerStatus, found bool) {
+	type statuser interface{ Status() BreakerStatus }
+	unwrapProvider(s.prices, func(p PriceProvider) bool {
+		if b, ok := p.(statuser); ok {
+			st, found = b.Status(), true
+			return true
+		}
+		if rp, ok := p.(*RoutingProvider); ok {
+			st, found = rp.Breakers()[rp.fallback]
+			return true
+		}
+		return false
+	})
+	return st, found
+}
+
+// RouteStatuses reports per-provider breaker states when prices are routed
+// across several providers.
+func (s *TransactionService) RouteStatuses() (out map[string]BreakerStatus, found bool) {
+	unwrapProvider(s.prices, func(p PriceProvider) bool {
+		if rp, ok := p.(*RoutingProvider); ok {
+			out, found = rp.Breakers(), true
+			return true
+		}
+		return false
+	})
+	return out, found
+}
+
+// LoadErrors reports rows the repository could not load at startup. Only the
+// CSV repository tracks these; others report none.
+func (s *TransactionService) LoadErrors() []LoadError {
+	type reporter interface{ LoadErrors() []LoadError }
+	if r, ok := s.repoTx.(reporter); ok {
+		return r.LoadErrors()
+	}
+	return nil
+}
+
+// rate converts amounts in from into the reference currency. Without an
+// exchanger amounts stay in their own currency. A failed lookup (or a rate
+// that isn't positive) falls back to parity so valuations still go through,
+// but reports !ok and is noted for the response's warnings (WithFXWarnings).
+func (s *TransactionService) rate(from string) (float64, bool) {
 	if s.exchanger == nil || strings.EqualFold(from, s.refCCY) || strings.TrimSpace(from) == "" {
-		return 1.0
+		return 1.0, true
 	}
 	r, _, err := s.exchanger.Rate(from, s.refCCY)
-	if err != nil || r <= 0 {
-		return 1.0 // graceful fallback
+	if err == nil && r <= 0 {
+		err = fmt.Errorf("invalid rate %g", r)
 	}
+	if err != nil {
+		s.fxMisses.add(ccyKey(from), s.refCCY, err)
+		return 1.0, false
+	}
+	return r, true
+}
+
+// fxFallbacks collects the currencies one computation counted at parity
+// because no rate was available.
+type fxFallbacks struct {
+	mu     sync.Mutex
+	misses map[string]string // "USD→TWD" -> lookup error
+}
+
+func (f *fxFallbacks) add(from, to string, err error) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.misses == nil {
+		f.misses = map[string]string{}
+	}
+	f.misses[from+"→"+to] = err.Error()
+}
+
+// warnings describes each fallback, in pair order.
+func (f *fxFallbacks) warnings() []string {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]string, 0, len(f.misses))
+	for pair, reason := range f.misses {
+		from := pair[:strings.Index(pair, "→")]
+		out = append(out, fmt.Sprintf("no %s rate (%s); %s amounts counted at 1:1", pair, reason, from))
+	}
+	stableSort(out, func(a, b string) bool { return a < b })
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// WithFXWarnings returns a copy that notes the currencies it fails to
+// convert; read them with fxWarnings once the computation is done.
+func (s *TransactionService) WithFXWarnings() *TransactionService {
+	cp := *s
+	cp.fxMisses = &fxFallbacks{}
+	return &cp
+}
+
+func (s *TransactionService) fxWarnings() []string { return s.fxMisses.warnings() }
+
+// txRate converts tx's amounts into the reference currency: the rate stored
+// at trade time when it targets the current reference, else today's rate.
+// Without an exchanger amounts stay in the trade currency, as with rate.
+func (s *TransactionService) txRate(tx Transaction) float64 {
+	if r, ok := s.storedRate(tx); ok {
+		return r
+	}
+	r, _ := s.rate(tx.Currency)
 	return r
 }
 
+// cashRate converts tx's cash flow into the reference currency. Cash is
+// always at today's rate, so money in one currency nets to zero however the
+// rate moved between deposit and spend; stored trade-date rates are for cost
+// basis and FX P/L only.
+func (s *TransactionService) cashRate(tx Transaction) float64 {
+	r, _ := s.rate(tx.Currency)
+	return r
+}
+
+// storedRate is tx's recorded trade-date rate, if it targets the current reference.
+func (s *TransactionService) storedRate(tx Transaction) (float64, bool) {
+	if s.exchanger == nil || tx.FXRate <= 0 || !strings.EqualFold(tx.FXRef, s.refCCY) {
+		return 0, false
+	}
+	return tx.FXRate, true
+}
+
+// stampFX keeps a client-supplied trade-date rate, tagged with the
+// configured reference currency, and clears any other. Rates to look up are
+// filled in after the write by stampFXLater, so a slow or unreachable rate
+// source never holds up or fails a write. Pending transactions are stamped
+// when executed.
+func (s *TransactionService) stampFX(txs []Transaction) {
+	for i := range txs {
+		tx := &txs[i]
+		c := ccyKey(tx.Currency)
+		if tx.Pending || c == "" || c == s.fxRef || tx.FXRate <= 0 {
+			tx.FXRate, tx.FXRef = 0, ""
+			continue
+		}
+		tx.FXRef = s.fxRef
+	}
+}
+
+// fxStamper is implemented by repositories that can record a looked-up
+// trade-date rate on a stored transaction.
+type fxStamper interface {
+	// StampFX sets the rate on the stored copy of tx, bumping UpdatedAt, but
+	// only while it still has none and its currency and date are those of
+	// tx; ok is false when the row changed meanwhile (or is gone).
+	StampFX(tx Transaction, rate float64, ref string) (out Transaction, ok bool, err error)
+}
+
+// stampFXLater looks up, in the background, the trade-date rate of each
+// stored transaction that needs one and records it. A failed lookup leaves
+// the rate unset (today's rate is used) and isn't retried for the same
+// currency within the call.
+func (s *TransactionService) stampFXLater(txs ...Transaction) {
+	st, ok := s.repoTx.(fxStamper)
+	if !ok {
+		return
+	}
+	if _, ok := s.exchanger.(HistoryExchanger); !ok {
+		return
+	}
+	var todo []Transaction
+	for _, tx := range txs {
+		c := ccyKey(tx.Currency)
+		if tx.FXRate <= 0 && !tx.Pending && c != "" && c != s.fxRef {
+			todo = append(todo, tx)
+		}
+	}
+	if len(todo) == 0 {
+		return
+	}
+	s.fxStamps.Add(1)
+	go func() {
+		defer s.fxStamps.Done()
+		failed := map[string]bool{}
+		for _, tx := range todo {
+			c := ccyKey(tx.Currency)
+			if failed[c] {
+				continue
+			}
+			r, ok := s.historicalRate(c, s.fxRef, tx.Date)
+			if !ok {
+				failed[c] = true
+				continue
+			}
+			out, ok, err := st.StampFX(tx, r, s.fxRef)
+			if err != nil {
+				log.Printf("stamp trade-date rate on %s: %v", tx.ID, err)
+				continue
+			}
+			if ok {
+				s.notify(EventTransactionUpdated, out.PortfolioID, out)
+			}
+		}
+	}()
+}
+
+// historicalRate is the from→to rate at the daily close on or before date.
+// ok is false when the exchanger keeps no history or the lookup fails.
+func (s *TransactionService) historicalRate(from, to string, date time.Time) (float64, bool) {
+	he, ok := s.exchanger.(HistoryExchanger)
+	if !ok {
+		return 0, false
+	}
+	r, _, err := he.RateOn(from, to, date)
+	if err != nil || r <= 0 {
+		return 0, false
+	}
+	return r, true
+}
+
 // Detect option symbols and return contract multiplier.
 // For standard US equity options, Yahoo symbols look like: AAPL240118C00150000
 // Pattern: TICKER(1-6 letters) + YYMMDD + C|P + 8-digit strike.
-var reOptionSymbol = regexp.MustCompile(`^[A-Z]{1,6}\d{6}[CP]\d{8}$`)
+var reOptionSymbol = regexp.MustCompile(`^([A-Z]{1,6})(\d{6})([CP])(\d{8})$`)
+
+// OptionDetail is the parsed form of an OCC-style option symbol.
+type OptionDetail struct {
+    Underlying string  `json:"underlying"`
+    Expiry     string  `json:"expiry"` // YYYY-MM-DD
+    Right      string  `json:"right"`  // call | put
+    Strike     float64 `json:"strike"`
+    Expired    bool    `json:"expired,omitempty"`
+}
+
+// parseOptionSymbol splits e.g. AAPL240118C00150000 into AAPL, 2024-01-18,
+// call, 150.0. The strike field is the price × 1000. Expired is left unset:
+// it depends on the day, which parseOption takes from the service's clock.
+func parseOptionSymbol(sym string) (OptionDetail, bool) {
+    m := reOptionSymbol.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(sym)))
+    if m == nil {
+        return OptionDetail{}, false
+    }
+    exp, err := parseCalendarDate("060102", m[2])
+    if err != nil {
+        return OptionDetail{}, false
+    }
+    strike, err := strconv.ParseFloat(m[4], 64)
+    if err != nil {
+        return OptionDetail{}, false
+    }
+    right := "call"
+    if m[3] == "P" {
+        right = "put"
+    }
+    return OptionDetail{
+        Underlying: m[1],
+        Expiry:     exp.Format(txDateLayout),
+        Right:      right,
+        Strike:     strike / 1000.0,
+    }, true
+}
 
+// multiplierForSymbol returns the contract multiplier for sym: a
+// multipliers.csv override if one matches, else 100 for OCC-style options and 1.
 func multiplierForSymbol(sym string) float64 {
     s := strings.ToUpper(strings.TrimSpace(sym))
+    if m, ok := contractMultipliers.lookup(s); ok {
+        return m
+    }
     if reOptionSymbol.MatchString(s) {
         return 100.0
     }
@@ -172,6 +1071,67 @@ func sameYMD(a, b time.Time) bool {
     return a.Year() == b.Year() && a.Month() == b.Month() && a.Day() == b.Day()
 }
 
+// dailyCloses returns the current price and the daily reference price for
+// sym, preferring daily history and falling back to the live quote's
+// previous close when history is unavailable (prev_close basis only).
+func (s *TransactionService) dailyCloses(sym string, quoteAsOf time.Time) (cur, prev float64, stale, ok bool) {
+    if od, isOpt := s.parseOption(sym); isOpt && od.Expired {
+        return 0, 0, false, false // settled; no daily movement
+    }
+    if !s.asOf.IsZero() {
+        return 0, 0, false, false // past valuation (WithAsOf)
+    }
+    if cur, prev, stale, ok := s.historyCloses(sym, quoteAsOf); ok {
+        return cur, prev, stale, true
+    }
+    if s.dailyBasis == DailyBasisSessionOpen {
+        return 0, 0, false, false
+    }
+    qp, isQuote := s.prices.(QuoteProvider)
+    if !isQuote {
+        return 0, 0, false, false
+    }
+    q, err := qp.GetQuote(sym)
+    if err != nil || q.Price <= 0 || q.PreviousClose <= 0 {
+        return 0, 0, false, false
+    }
+    return q.Price, q.PreviousClose, false, true
+}
+
+// historyCloses returns the latest and prior daily closes for sym from a
+// history-capable provider. stale is true when the latest daily bar belongs to
+// a session that has not traded yet (e.g. Yahoo's pre-open placeholder for
+// today), judged against the live quote time quoteAsOf. With the
+// session_open daily basis, prev is the latest session's open instead.
+func (s *TransactionService) historyCloses(sym string, quoteAsOf time.Time) (cur, prev float64, stale, ok bool) {
+    hp, isHist := s.prices.(HistoryProvider)
+    if !isHist {
+        return 0, 0, false, false
+    }
+    cur, asOfDay, err := hp.GetPriceOn(sym, s.today())
+    if err != nil || cur <= 0 {
+        return 0, 0, false, false
+    }
+    if s.dailyBasis == DailyBasisSessionOpen {
+        bp, isBasis := s.prices.(BasisHistoryProvider)
+        if !isBasis {
+            return 0, 0, false, false
+        }
+        prev, _, err = bp.GetPriceOnBasis(sym, asOfDay, "open")
+    } else {
+        prev, _, err = hp.GetPriceOn(sym, asOfDay.AddDate(0, 0, -1))
+    }
+    if err != nil || prev <= 0 {
+        return 0, 0, false, false
+    }
+    if !quoteAsOf.IsZero() {
+        q := quoteAsOf.UTC()
+        qDay := time.Date(q.Year(), q.Month(), q.Day(), 0, 0, 0, 0, time.UTC)
+        stale = qDay.Before(asOfDay)
+    }
+    return cur, prev, stale, true
+}
+
 // lessForPositions orders transactions for position/invested calculations.
 // - Primary: by date (ascending)
 // - Same day: buys before dividends before sells; cash last
@@ -188,8 +1148,8 @@ func lessForPositions(a, b Transaction) bool {
         // but treat them as equal and keep current order.
         return false
     }
-    rank := func(t TradeType) int {
-        switch t {
+    rank := func(t Transaction) int {
+        switch t.TradeType {
         case TradeTypeBuy:
             return 0
         case TradeTypeDividend:
@@ -198,17 +1158,510 @@ func lessForPositions(a, b Transaction) bool {
             return 2
         case TradeTypeCash:
             return 3
+        case TradeTypeTransfer:
+            // in like a buy, out like a sell
+            if t.Shares >= 0 {
+                return 0
+            }
+            return 2
         default:
             return 9
         }
     }
-    ra, rb := rank(a.TradeType), rank(b.TradeType)
+    ra, rb := rank(a), rank(b)
     if ra != rb {
         return ra < rb
     }
     return a.ID < b.ID
 }
 
+// priceFor returns the latest per-unit price for sym in its quote currency.
+// Expired options are not quoted; they settle at intrinsic value.
+func (s *TransactionService) priceFor(sym string) (float64, time.Time, error) {
+    if od, ok := s.parseOption(sym); ok && od.Expired {
+        return s.expiredOptionValue(od)
+    }
+    return s.prices.GetPrice(sym)
+}
+
+// priceOn is priceFor at the close on date: an option that had expired by
+// then is valued at its intrinsic settlement.
+func (s *TransactionService) priceOn(hp HistoryProvider, sym string, date time.Time) (float64, time.Time, error) {
+    if od, ok := parseOptionSymbol(sym); ok && optionExpiredBy(od, date) {
+        return s.expiredOptionValue(od)
+    }
+    return hp.GetPriceOn(sym, date)
+}
+
+// parseOption is parseOptionSymbol with Expired judged on the service's clock.
+func (s *TransactionService) parseOption(sym string) (OptionDetail, bool) {
+    od, ok := parseOptionSymbol(sym)
+    if ok {
+        od.Expired = optionExpiredBy(od, s.today())
+    }
+    return od, ok
+}
+
+func optionExpiredBy(od OptionDetail, date time.Time) bool {
+    exp, err := parseCalendarDate(txDateLayout, od.Expiry)
+    return err == nil && exp.Before(date)
+}
+
+// expiredOptionValue returns the per-share intrinsic value of an expired
+// option from the underlying's close on expiry (latest price when no history
+// is available): max(0, S−K) for calls, max(0, K−S) for puts. The contract
+// multiplier is applied by the caller as for any other option.
+func (s *TransactionService) expiredOptionValue(od OptionDetail) (float64, time.Time, error) {
+    exp, err := parseCalendarDate(txDateLayout, od.Expiry)
+    if err != nil {
+        return 0, time.Time{}, err
+    }
+    var under float64
+    if hp, ok := s.prices.(HistoryProvider); ok {
+        under, _, err = hp.GetPriceOn(od.Underlying, exp)
+    }
+    if under <= 0 || err != nil {
+        under, _, err = s.prices.GetPrice(od.Underlying)
+        if err != nil {
+            return 0, time.Time{}, err
+        }
+    }
+    intrinsic := under - od.Strike
+    if od.Right == "put" {
+        intrinsic = od.Strike - under
+    }
+    if intrinsic < 0 {
+        intrinsic = 0
+    }
+    return intrinsic, exp, nil
+}
+
+// positionAgg is an open position in one symbol.
+type positionAgg struct {
+    shares   float64
+    invested float64 // cost of remaining shares in ref currency (after sells reduce by avg cost)
+    currency string  // last seen tx currency for the symbol
+    byCCY    map[string]float64 // open shares per trade currency, for FX conversion
+
+    // Closed-out flows in ref currency, for the summary reconciliation
+    realized    float64 // sell proceeds minus the average cost they removed
+    dividends   float64
+    fees        float64 // already included in trade totals; informational
+    transferNet float64 // cost basis transferred in minus cost basis transferred out
+
+    // investedSpot and investedAtTrade are invested with each lot converted
+    // at today's rate and at its trade date's rate (summaries only, see
+    // tradeDateFX); invested itself uses the stored rate where there is one.
+    // fxUntracked is set when a lot's rate was unavailable or the position
+    // went short; the price/FX split is then not reported.
+    investedSpot    float64
+    investedAtTrade float64
+    fxUntracked     bool
+
+    // unbooked is trade cash not booked as cost or proceeds (see
+    // INVESTED_INCLUDES_FEES), signed as a P/L effect, in ref currency
+    unbooked float64
+
+    // cashFX is what the trade cash is worth at today's rate (the cash
+    // ledger's rate, see cashRate) minus its value at the stored trade-date
+    // rate the position books, signed as a P/L effect
+    cashFX float64
+
+    // discrepancy sums the totals that miss shares × price ± fee by more
+    // than totalsTolerance, each by its gap, in ref currency
+    discrepancy float64
+}
+
+// allowShorts keeps net-short positions in summaries (ALLOW_SHORTS=1). A sell
+// beyond the shares held then opens a short at the sale proceeds, and later
+// buys cover it at average proceeds. Off by default: such positions are dropped.
+var allowShorts = truthy(os.Getenv("ALLOW_SHORTS"))
+
+// buy adds n shares costing cost. With shorts, shares bought against a short
+// first cover it at average proceeds; the gain on the cover is returned.
+func (a *positionAgg) buy(ccy string, n, cost float64) (realized float64) {
+    if allowShorts && a.shares < 0 && n > 0 {
+        cover := n
+        if cover > -a.shares {
+            cover = -a.shares
+        }
+        released := a.invested / a.shares * cover // proceeds of the covered shares
+        a.invested += released
+        a.scaleTranches((a.shares + cover) / a.shares)
+        a.shares += cover
+        realized = released - cost*cover/n
+        cost, n = cost*(n-cover)/n, n-cover
+    }
+    a.shares += n
+    a.invested += cost
+    a.addTranche(ccy, n)
+    return realized
+}
+
+// sell removes n shares for proceeds and returns the realized gain. With
+// shorts, shares sold beyond those held open a short carrying their proceeds
+// as negative invested.
+func (a *positionAgg) sell(ccy string, n, proceeds float64) (realized float64) {
+    if !allowShorts || n <= 0 {
+        return proceeds - a.reduce(n)
+    }
+    long := n
+    if long > a.shares {
+        long = a.shares
+    }
+    if long < 0 {
+        long = 0
+    }
+    realized = proceeds*long/n - a.reduce(long)
+    a.shares -= n - long
+    a.invested -= proceeds * (n - long) / n
+    a.addTranche(ccy, -(n - long))
+    return realized
+}
+
+// addTranche records n shares (negative for shorts) held in currency ccy.
+func (a *positionAgg) addTranche(ccy string, n float64) {
+    if a.byCCY == nil {
+        a.byCCY = map[string]float64{}
+    }
+    a.byCCY[ccy] += n
+}
+
+// scaleTranches shrinks every currency tranche by factor, so shares leave a
+// position in proportion to each currency, like cost at average cost.
+func (a *positionAgg) scaleTranches(factor float64) {
+    for c := range a.byCCY {
+        a.byCCY[c] *= factor
+    }
+}
+
+// reduce removes n shares at average cost (no realized P/L on the basis) and
+// returns the cost basis removed.
+func (a *positionAgg) reduce(n float64) float64 {
+    var removed float64
+    if a.shares > 0 {
+        avgCost := a.invested / a.shares
+        cut := n
+        if cut > a.shares {
+            cut = a.shares
+        }
+        removed = avgCost * cut
+        if removed > a.invested {
+            removed = a.invested
+        }
+        if a.invested > 0 {
+            a.investedSpot *= (a.invested - removed) / a.invested
+            a.investedAtTrade *= (a.invested - removed) / a.invested
+        }
+        a.invested -= removed
+        a.scaleTranches((a.shares - cut) / a.shares)
+    }
+    a.shares -= n
+    return removed
+}
+
+// aggregatePositions builds open positions by (canonical) symbol using average
+// cost. Sells only reduce the cost basis of their own portfolio: positions are
+// built per portfolio and then summed, so a sell in one portfolio never
+// consumes shares bought in another. txs is sorted in place.
+func (s *TransactionService) aggregatePositions(txs []Transaction) map[string]*positionAgg {
+    type key struct{ portfolioID, symbol string }
+    perPf := map[key]*positionAgg{}
+    lastCCY := map[string]string{}
+    fxMemo := map[string]float64{} // trade-date rates by currency+day (tradeDateFX)
+    fxFailed := map[string]bool{}
+
+    // Process in chronological order so average-cost reductions on sell are correct
+    stableSort(txs, lessForPositions)
+
+    for _, tx := range txs {
+        switch tx.TradeType {
+        case TradeTypeBuy, TradeTypeSell, TradeTypeDividend, TradeTypeTransfer:
+            sym := s.canonicalSymbol(tx.Symbol)
+            k := key{tx.PortfolioID, sym}
+            a := perPf[k]
+            if a == nil {
+                a = &positionAgg{}
+                perPf[k] = a
+            }
+            if tx.Currency != "" {
+                a.currency = strings.ToUpper(tx.Currency)
+                lastCCY[sym] = a.currency
+            }
+            amt := tx.Total
+            if amt < 0 {
+                amt = -amt
+            }
+            rate := s.txRate(tx)
+            if tx.TradeType != TradeTypeTransfer {
+                if spot := s.cashRate(tx); spot != rate {
+                    if tx.TradeType == TradeTypeBuy {
+                        a.cashFX -= amt * (spot - rate)
+                    } else {
+                        a.cashFX += amt * (spot - rate)
+                    }
+                }
+            }
+            if tx.TradeType == TradeTypeBuy || tx.TradeType == TradeTypeSell {
+                // Cash that moved but isn't booked as cost (buy) or proceeds (sell)
+                booked := bookedAmount(tx)
+                if tx.TradeType == TradeTypeBuy {
+                    a.unbooked -= (amt - booked) * rate
+                } else {
+                    a.unbooked += (amt - booked) * rate
+                }
+                amt = booked
+            }
+            amt *= rate
+            if gap := totalDiscrepancy(tx); gap > totalsTolerance {
+                a.discrepancy += gap * rate
+            }
+            if tx.TradeType != TradeTypeTransfer {
+                fee := tx.Fee
+                if fee < 0 {
+                    fee = -fee
+                }
+                a.fees += fee * rate
+            }
+            // Booked cost re-expressed at today's rate and at the trade
+            // date's rate, as multiples of the booked cost
+            spotFX, tradeFX := 1.0, 1.0
+            if s.tradeDateFX && (tx.TradeType == TradeTypeBuy || (tx.TradeType == TradeTypeTransfer && tx.Shares >= 0)) {
+                spot, _ := s.rate(tx.Currency)
+                spotFX = spot / rate
+                c, day := ccyKey(tx.Currency), tx.Date.Format("2006-01-02")
+                r, ok := s.storedRate(tx)
+                if !ok {
+                    r, ok = fxMemo[c+day]
+                }
+                if !ok && !fxFailed[c] {
+                    if r, ok = s.tradeDateRate(c, tx.Date); ok {
+                        fxMemo[c+day] = r
+                    } else {
+                        fxFailed[c] = true // don't retry the pair for every lot
+                    }
+                }
+                if ok {
+                    tradeFX = r / rate
+                } else {
+                    a.fxUntracked = true
+                }
+            }
+            switch tx.TradeType {
+            case TradeTypeBuy:
+                before := a.invested
+                a.realized += a.buy(ccyKey(tx.Currency), tx.Shares, amt)
+                if a.shares < 0 || before < 0 {
+                    a.fxUntracked = true // shorts aren't split
+                }
+                a.investedSpot += (a.invested - before) * spotFX
+                a.investedAtTrade += (a.invested - before) * tradeFX
+            case TradeTypeSell:
+                // Reduce invested by average cost per share for the shares sold
+                a.realized += a.sell(ccyKey(tx.Currency), tx.Shares, amt)
+                if a.shares < 0 {
+                    a.fxUntracked = true
+                }
+            case TradeTypeDividend:
+                // no change to invested/shares
+                a.dividends += amt
+            case TradeTypeTransfer:
+                if tx.Shares >= 0 {
+                    // Transfer in: like a buy, carrying the supplied cost basis
+                    a.shares += tx.Shares
+                    a.invested += amt
+                    a.investedSpot += amt * spotFX
+                    a.investedAtTrade += amt * tradeFX
+                    a.addTranche(ccyKey(tx.Currency), tx.Shares)
+                    a.transferNet += amt
+                } else {
+                    // Transfer out: like a sell at cost, nothing realized
+                    a.transferNet -= a.reduce(-tx.Shares)
+                }
+            }
+        }
+    }
+
+    bucket := map[string]*positionAgg{}
+    for k, a := range perPf {
+        b := bucket[k.symbol]
+        if b == nil {
+            b = &positionAgg{currency: lastCCY[k.symbol]}
+            bucket[k.symbol] = b
+        }
+        b.shares += a.shares
+        b.invested += a.invested
+        b.realized += a.realized
+        b.dividends += a.dividends
+        b.fees += a.fees
+        b.transferNet += a.transferNet
+        b.investedSpot += a.investedSpot
+        b.investedAtTrade += a.investedAtTrade
+        b.fxUntracked = b.fxUntracked || a.fxUntracked
+        b.discrepancy += a.discrepancy
+        b.unbooked += a.unbooked
+        b.cashFX += a.cashFX
+        for c, n := range a.byCCY {
+            b.addTranche(c, n)
+        }
+    }
+    return bucket
+}
+
+// tradeDateRate is the ccy→ref rate on date, for lots without a stored
+// rate. ok is false when the exchanger keeps no history or the lookup fails.
+func (s *TransactionService) tradeDateRate(ccy string, date time.Time) (float64, bool) {
+    c := ccyKey(ccy)
+    if s.exchanger == nil || c == "" || c == s.refCCY {
+        return 1.0, true
+    }
+    return s.historicalRate(c, s.refCCY, date)
+}
+
+// ccyKey normalizes a transaction currency for the per-currency tranches.
+func ccyKey(c string) string { return strings.ToUpper(strings.TrimSpace(c)) }
+
+// positionRate converts a position's price into the reference currency. Each
+// currency tranche is converted at its own rate, so a symbol recorded under
+// two currencies isn't valued entirely at the last one seen; the result is
+// the share-weighted blend (the plain rate when there is a single currency).
+func (s *TransactionService) positionRate(a *positionAgg) float64 {
+    var held, weighted float64
+    for c, n := range a.byCCY {
+        if c == "" {
+            c = a.currency // recorded without a currency
+        }
+        held += n
+        weighted += n * s.valueRate(c)
+    }
+    if held == 0 {
+        return s.valueRate(a.currency)
+    }
+    return weighted / held
+}
+
+// quoteUnits maps minor-unit quote currencies (as Yahoo reports them for
+// London, Johannesburg and Tel Aviv listings) to the ISO currency and scale.
+var quoteUnits = map[string]struct {
+    ccy   string
+    scale float64
+}{
+    "GBp": {"GBP", 0.01},
+    "GBX": {"GBP", 0.01},
+    "ZAc": {"ZAR", 0.01},
+    "ILA": {"ILS", 0.01},
+}
+
+// warnedQuoteCCY dedupes the currency mismatch warning per symbol and pair.
+var warnedQuoteCCY sync.Map
+
+// valuationRate converts a price of sym into the reference currency and
+// returns the currency the price is in. When the provider reports the quote's
+// currency, it wins over the currency recorded on the transactions, which is
+// often blank or wrong for foreign listings; a mismatch is logged once.
+// Without a reported currency this is positionRate.
+func (s *TransactionService) valuationRate(sym string, a *positionAgg) (float64, string) {
+    qp, ok := s.prices.(QuoteProvider)
+    if !ok {
+        return s.positionRate(a), a.currency
+    }
+    q, err := qp.GetQuote(sym)
+    qc := strings.TrimSpace(q.Currency)
+    if err != nil || qc == "" {
+        return s.positionRate(a), a.currency
+    }
+    iso, scale := strings.ToUpper(qc), 1.0
+    if u, ok := quoteUnits[qc]; ok {
+        iso, scale = u.ccy, u.scale
+    }
+    for c, n := range a.byCCY {
+        if n == 0 || c == iso {
+            continue
+        }
+        if c == "" {
+            c = "(blank)"
+        }
+        if _, seen := warnedQuoteCCY.LoadOrStore(sym+"|"+c+"|"+qc, true); !seen {
+            log.Printf("warning: %s is recorded in %s but quoted in %s; valuing it in %s", sym, c, qc, qc)
+        }
+    }
+    return s.valueRate(iso) * scale, qc
+}
+
+// valueRate converts a valuation in ccy into the reference currency: today's
+// rate, or the rate at the as-of close when one is set (WithAsOf).
+func (s *TransactionService) valueRate(ccy string) float64 {
+    if !s.asOf.IsZero() && s.exchanger != nil {
+        if r, ok := s.historicalRate(ccyKey(ccy), s.refCCY, s.asOf); ok {
+            return r
+        }
+    }
+    r, _ := s.rate(ccy)
+    return r
+}
+
+// SummaryReconciliation breaks total P/L into its parts so that
+//
+//	equity = effective_cash_in + total_pl
+//	total_pl = unrealized_pl + realized_gains + dividends_received
+//	           + interest_received − cash_fees + transfers_net_cost
+//
+// can be checked by hand. All amounts are in the reference currency.
+type SummaryReconciliation struct {
+    // NetCashFlow is deposits − withdrawals + inferred deposits (= effective_cash_in)
+    NetCashFlow float64 `json:"net_cash_flow"`
+    // Equity is total_market_value + balance
+    Equity float64 `json:"equity"`
+    // OpenCost is the cost basis of every open position, including unpriced ones
+    OpenCost float64 `json:"open_cost"`
+    // UnrealizedPL is total_market_value − open_cost
+    UnrealizedPL float64 `json:"unrealized_pl"`
+    // RealizedGains is sell proceeds minus the average cost of the shares sold
+    RealizedGains     float64 `json:"realized_gains"`
+    DividendsReceived float64 `json:"dividends_received"`
+    // InterestReceived and CashFees come from cash rows with cash_kind
+    // interest/fee; they are income and expense, not contributions
+    InterestReceived float64 `json:"interest_received"`
+    CashFees         float64 `json:"cash_fees"`
+    // TransfersNetCost is cost basis transferred in minus cost basis
+    // transferred out; transfers move no cash, so it is added back
+    TransfersNetCost float64 `json:"transfers_net_cost"`
+    // UnbookedTradeCash is buy/sell cash left out of cost and proceeds when
+    // INVESTED_INCLUDES_FEES books them from shares × price: fees under
+    // false, and totals that don't match their parts. Negative when it cost
+    // money; omitted when zero (the default booking)
+    UnbookedTradeCash float64 `json:"unbooked_trade_cash,omitempty"`
+    // CashFXTranslation is trade cash at today's rate (as the balance counts
+    // it) minus the same cash at the stored trade-date rates cost and
+    // proceeds are booked at; omitted when zero
+    CashFXTranslation float64 `json:"cash_fx_translation,omitempty"`
+    // FeesPaid is informational: fees are already inside trade totals
+    FeesPaid float64 `json:"fees_paid"`
+    // TotalPL is equity − net_cash_flow (= total_unrealized_pl)
+    TotalPL float64 `json:"total_pl"`
+
+    refCCY string // for rounding only; set by the enclosing response
+}
+
+// reconcile fills the reconciliation from the aggregated positions and the
+// summary's cash totals.
+func reconcile(bucket map[string]*positionAgg, marketValue, balance, effectiveCashIn, interest, cashFees float64) *SummaryReconciliation {
+    r := &SummaryReconciliation{NetCashFlow: effectiveCashIn, InterestReceived: interest, CashFees: cashFees}
+    for _, a := range bucket {
+        r.OpenCost += a.invested
+        r.RealizedGains += a.realized
+        r.DividendsReceived += a.dividends
+        r.FeesPaid += a.fees
+        r.TransfersNetCost += a.transferNet
+        r.UnbookedTradeCash += a.unbooked
+        r.CashFXTranslation += a.cashFX
+    }
+    r.Equity = marketValue + balance
+    r.UnrealizedPL = marketValue - r.OpenCost
+    r.TotalPL = r.Equity - effectiveCashIn
+    return r
+}
+
 /* ===================== Allocations ===================== */
 
 type AllocationItem struct {
@@ -217,11 +1670,28 @@ type AllocationItem struct {
     Invested      float64 `json:"invested"`
     MarketValue   float64 `json:"market_value"`
     WeightPercent float64 `json:"weight_percent"`
+    // Quote used for market_value basis, before FX and contract multiplier
+    Price         float64 `json:"price,omitempty"`
+    PriceCurrency string  `json:"price_currency,omitempty"`
+    // FXRate converts PriceCurrency into the reference currency
+    FXRate        float64 `json:"fx_rate,omitempty"`
+    // Unrealized gain/loss vs cost basis (market_value basis only)
+    UnrealizedPL        float64 `json:"unrealized_pl,omitempty"`
+    UnrealizedPLPercent float64 `json:"unrealized_pl_percent,omitempty"`
     // Optional daily P/L stats when a history-capable price provider is available
     DailyPL        float64 `json:"daily_pl,omitempty"`
     DailyPLPercent float64 `json:"daily_pl_percent,omitempty"`
     // Yesterday's market value used as the denominator for DailyPLPercent
     DailyPrevMarketValue float64 `json:"daily_prev_market_value,omitempty"`
+    // DailyPLStale is set when no new session has traded since the prior close;
+    // daily P/L is then reported as zero.
+    DailyPLStale bool `json:"daily_pl_stale,omitempty"`
+    // Expired marks an expired option valued at its intrinsic settlement
+    Expired bool `json:"expired,omitempty"`
+    // Via lists the funds whose look-through exposure is included in this item
+    Via []string `json:"via,omitempty"`
+
+    refCCY string // for rounding only; set by the enclosing response
 }
 
 type AllocationResponse struct {
@@ -231,6 +1701,22 @@ type AllocationResponse struct {
 	AsOf             time.Time        `json:"as_of,omitempty"`
 	RefCurrency      string           `json:"ref_currency"`
 	Items            []AllocationItem `json:"items"`
+	// Skipped lists held symbols left out because they couldn't be priced
+	Skipped []SkippedSymbol `json:"skipped,omitempty"`
+	// Warnings notes currencies counted at 1:1 because no FX rate was available
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// SkippedSymbol is a held symbol missing from market-value totals, with the
+// pricing error, so "not held" and "price fetch failed" can be told apart.
+type SkippedSymbol struct {
+	Symbol string `json:"symbol"`
+	Reason string `json:"reason"`
+}
+
+// sortSkipped orders skipped symbols by name (map iteration is random).
+func sortSkipped(xs []SkippedSymbol) {
+	stableSort(xs, func(a, b SkippedSymbol) bool { return a.Symbol < b.Symbol })
 }
 
 // Per-portfolio
@@ -247,7 +1733,7 @@ func (s *TransactionService) ComputeAllocations(portfolioID, basis string) (Allo
 
 // Global (all portfolios)
 func (s *TransactionService) ComputeAllocationsAll(basis string) (AllocationResponse, error) {
-	pfs, err := s.repoPf.List()
+	pfs, err := s.listPortfolios()
 	if err != nil {
 		return AllocationResponse{}, err
 	}
@@ -259,73 +1745,35 @@ func (s *TransactionService) ComputeAllocationsAll(basis string) (AllocationResp
 		}
 		all = append(all, txs...)
 	}
-	return s.computeAllocationsFromTxs(all, basis)
-}
-
-func (s *TransactionService) computeAllocationsFromTxs(all []Transaction, basis string) (AllocationResponse, error) {
-    type agg struct {
-        shares   float64
-        invested float64 // cost of remaining shares in ref currency (after sells reduce by avg cost)
-        currency string  // last seen tx currency for the symbol
-    }
-    bucket := map[string]*agg{}
-
-    // Process in chronological order so average-cost reductions on sell are correct
-    insertionSort(all, lessForPositions)
-
-    for _, tx := range all {
-        switch tx.TradeType {
-        case TradeTypeBuy, TradeTypeSell, TradeTypeDividend:
-            a := bucket[tx.Symbol]
-            if a == nil {
-                a = &agg{}
-                bucket[tx.Symbol] = a
-            }
-            if tx.Currency != "" {
-                a.currency = strings.ToUpper(tx.Currency)
-            }
-            switch tx.TradeType {
-            case TradeTypeBuy:
-                a.shares += tx.Shares
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                a.invested += amt * s.rate(tx.Currency)
-            case TradeTypeSell:
-                // Reduce invested by average cost per share for the shares sold
-                if a.shares > 0 {
-                    avgCost := 0.0
-                    if a.shares > 0 {
-                        avgCost = a.invested / a.shares
-                    }
-                    sellShares := tx.Shares
-                    if sellShares > a.shares {
-                        sellShares = a.shares
-                    }
-                    a.invested -= avgCost * sellShares
-                    if a.invested < 0 {
-                        a.invested = 0
-                    }
-                }
-                a.shares -= tx.Shares
-            case TradeTypeDividend:
-                // no change to invested/shares
+	return s.computeAllocationsFromTxs(all, basis)
+}
+
+func (s *TransactionService) computeAllocationsFromTxs(all []Transaction, basis string) (AllocationResponse, error) {
+    s = s.WithFXWarnings()
+    if !s.asOf.IsZero() {
+        kept := make([]Transaction, 0, len(all))
+        for _, tx := range all {
+            if !tx.Date.After(s.asOf) {
+                kept = append(kept, tx)
             }
         }
+        all = kept
     }
+    // Process in chronological order so average-cost reductions on sell are correct
+    bucket := s.aggregatePositions(all)
 
 	items := make([]AllocationItem, 0, len(bucket))
 	switch strings.ToLower(basis) {
 	case "", "invested":
 		var totalInv float64
 		for sym, a := range bucket {
-			if a.shares <= 0 && a.invested == 0 {
-				continue
+			if (a.shares <= 0 && a.invested == 0) || a.invested < 0 {
+				continue // closed, or a short (ALLOW_SHORTS) holding no capital
 			}
 			items = append(items, AllocationItem{Symbol: sym, Shares: a.shares, Invested: a.invested})
 			totalInv += a.invested
 		}
+		items = s.lookThroughItems(items)
 		for i := range items {
 			if totalInv > 0 {
 				items[i].WeightPercent = (items[i].Invested / totalInv) * 100.0
@@ -336,47 +1784,76 @@ func (s *TransactionService) computeAllocationsFromTxs(all []Transaction, basis
 			TotalInvested: totalInv,
 			RefCurrency:   s.refCCY,
 			Items:         items,
+			Warnings:      s.fxWarnings(),
 		}, nil
 
 	case "market_value":
 		if s.prices == nil {
 			return AllocationResponse{}, errors.New("no PriceProvider configured for market_value basis")
 		}
+		hp, hasHistory := s.prices.(HistoryProvider)
+		if !s.asOf.IsZero() && !hasHistory {
+			return AllocationResponse{}, errors.New("as_of needs a price provider with daily history")
+		}
 		var totalMV float64
 		var asOf time.Time
+		var skipped []SkippedSymbol
         for sym, a := range bucket {
             if a.shares <= 0 {
                 continue
             }
-            price, ts, err := s.prices.GetPrice(sym)
+            var price float64
+            var ts time.Time
+            var err error
+            if s.asOf.IsZero() {
+                price, ts, err = s.priceFor(sym)
+            } else {
+                price, ts, err = s.priceOn(hp, sym, s.asOf)
+            }
             if err != nil {
-                continue // skip symbols we can't price
+                // skip symbols we can't price, but say so
+                skipped = append(skipped, SkippedSymbol{Symbol: sym, Reason: err.Error()})
+                continue
             }
             mult := multiplierForSymbol(sym)
-            mv := a.shares * price * mult * s.rate(a.currency)
+            fx, priceCCY := s.valuationRate(sym, a)
+            mv := a.shares * price * mult * fx
 
             it := AllocationItem{
-                Symbol:      sym,
-                Shares:      a.shares,
-                Invested:    a.invested,
-                MarketValue: mv,
+                Symbol:        sym,
+                Shares:        a.shares,
+                Invested:      a.invested,
+                MarketValue:   mv,
+                Price:         price,
+                PriceCurrency: priceCCY,
+                FXRate:        fx,
+                UnrealizedPL:  mv - a.invested,
+            }
+            if a.invested > 0 {
+                it.UnrealizedPLPercent = (it.UnrealizedPL / a.invested) * 100.0
+            }
+            if od, ok := s.parseOption(sym); ok {
+                it.Expired = od.Expired
+                if !s.asOf.IsZero() {
+                    it.Expired = optionExpiredBy(od, s.asOf)
+                }
             }
 
             // Populate per-item daily P/L if historical prices are available
-            if hp, ok := s.prices.(HistoryProvider); ok {
-                today := time.Now().UTC()
-                if cur, asOfDay, err1 := hp.GetPriceOn(sym, today); err1 == nil && cur > 0 {
-                    if prev, _, err2 := hp.GetPriceOn(sym, asOfDay.AddDate(0, 0, -1)); err2 == nil && prev > 0 {
-                        rate := s.rate(a.currency)
-                        mult := multiplierForSymbol(sym)
-                        dailyPL := a.shares * (cur - prev) * mult * rate
-                        // Denominator is yesterday's MV for the symbol
-                        prevMV := a.shares * prev * mult * rate
-                        it.DailyPL = dailyPL
-                        it.DailyPrevMarketValue = prevMV
-                        if prevMV > 0 {
-                            it.DailyPLPercent = (dailyPL / prevMV) * 100.0
-                        }
+            if cur, prev, stale, ok := s.dailyCloses(sym, ts); ok {
+                if stale {
+                    // No new session yet: report zero rather than a spurious delta
+                    it.DailyPLStale = true
+                } else {
+                    rate := fx
+                    mult := multiplierForSymbol(sym)
+                    dailyPL := a.shares * (cur - prev) * mult * rate
+                    // Denominator is yesterday's MV for the symbol
+                    prevMV := a.shares * prev * mult * rate
+                    it.DailyPL = dailyPL
+                    it.DailyPrevMarketValue = prevMV
+                    if prevMV > 0 {
+                        it.DailyPLPercent = (dailyPL / prevMV) * 100.0
                     }
                 }
             }
@@ -387,17 +1864,24 @@ func (s *TransactionService) computeAllocationsFromTxs(all []Transaction, basis
                 asOf = ts
             }
         }
+		items = s.lookThroughItems(items)
 		for i := range items {
 			if totalMV > 0 {
 				items[i].WeightPercent = (items[i].MarketValue / totalMV) * 100.0
 			}
 		}
+		sortSkipped(skipped)
+		if !s.asOf.IsZero() {
+			asOf = s.asOf // the requested close, not the provider's bar dates
+		}
 		return AllocationResponse{
 			Basis:            "market_value",
 			TotalMarketValue: totalMV,
 			AsOf:             asOf,
 			RefCurrency:      s.refCCY,
 			Items:            items,
+			Skipped:          skipped,
+			Warnings:         s.fxWarnings(),
 		}, nil
 
 	default:
@@ -405,6 +1889,79 @@ func (s *TransactionService) computeAllocationsFromTxs(all []Transaction, basis
 	}
 }
 
+// lookThroughItems splits each fund item with known holdings into its
+// constituents, proportionally to the constituent weights, and merges them
+// into any direct holdings of the same symbol. The part of a fund the weights
+// don't cover stays under the fund. Totals are unchanged; weights are
+// computed by the caller afterwards.
+func (s *TransactionService) lookThroughItems(items []AllocationItem) []AllocationItem {
+    if !s.lookThrough || len(s.holdings) == 0 {
+        return items
+    }
+    out := make([]AllocationItem, 0, len(items))
+    index := map[string]int{}
+    merge := func(it AllocationItem) {
+        i, ok := index[it.Symbol]
+        if !ok {
+            index[it.Symbol] = len(out)
+            out = append(out, it)
+            return
+        }
+        m := &out[i]
+        m.Invested += it.Invested
+        m.MarketValue += it.MarketValue
+        m.UnrealizedPL += it.UnrealizedPL
+        m.DailyPL += it.DailyPL
+        m.DailyPrevMarketValue += it.DailyPrevMarketValue
+        m.Via = append(m.Via, it.Via...)
+        if it.Shares != 0 {
+            // Direct holding merged after look-through parts: keep its quote
+            m.Shares, m.Price, m.PriceCurrency, m.FXRate, m.Expired = it.Shares, it.Price, it.PriceCurrency, it.FXRate, it.Expired
+        }
+    }
+    part := func(it AllocationItem, sym string, frac float64) AllocationItem {
+        return AllocationItem{
+            Symbol:               sym,
+            Invested:             it.Invested * frac,
+            MarketValue:          it.MarketValue * frac,
+            UnrealizedPL:         it.UnrealizedPL * frac,
+            DailyPL:              it.DailyPL * frac,
+            DailyPrevMarketValue: it.DailyPrevMarketValue * frac,
+            DailyPLStale:         it.DailyPLStale,
+            Via:                  []string{it.Symbol},
+        }
+    }
+    for _, it := range items {
+        weights, ok := s.holdings[it.Symbol]
+        if !ok {
+            merge(it)
+            continue
+        }
+        rest := 1.0
+        for _, w := range weights {
+            merge(part(it, w.Symbol, w.WeightPercent/100.0))
+            rest -= w.WeightPercent / 100.0
+        }
+        if rest > 1e-9 {
+            // Uncovered remainder stays under the fund, with its own quote
+            r := part(it, it.Symbol, rest)
+            r.Via = nil
+            r.Shares, r.Price, r.PriceCurrency, r.FXRate = it.Shares, it.Price, it.PriceCurrency, it.FXRate
+            merge(r)
+        }
+    }
+    for i := range out {
+        it := &out[i]
+        if it.Invested > 0 && it.MarketValue > 0 {
+            it.UnrealizedPLPercent = (it.UnrealizedPL / it.Invested) * 100.0
+        }
+        if it.DailyPrevMarketValue > 0 {
+            it.DailyPLPercent = (it.DailyPL / it.DailyPrevMarketValue) * 100.0
+        }
+    }
+    return out
+}
+
 /* ===================== Global summary ===================== */
 
 type PositionSummary struct {
@@ -415,6 +1972,23 @@ type PositionSummary struct {
 	UnrealizedPL        float64 `json:"unrealized_pl"`
 	UnrealizedPLPercent float64 `json:"unrealized_pl_percent"`
 	WeightPercentByMV   float64 `json:"weight_percent_by_market_value"`
+	// Per-position daily P/L; these sum to the response's DailyPL
+	DailyPL        float64 `json:"daily_pl,omitempty"`
+	DailyPLPercent float64 `json:"daily_pl_percent,omitempty"`
+	DailyPLStale   bool    `json:"daily_pl_stale,omitempty"`
+	// Option is set for OCC-style option symbols
+	Option *OptionDetail `json:"option,omitempty"`
+	// PricePL + FXPL is the P/L against cost converted at each lot's trade
+	// date rate (UnrealizedPL uses the stored rate where a lot has one, and
+	// today's rate otherwise). Omitted for shorts and when a trade-date rate
+	// is unavailable.
+	PricePL *float64 `json:"price_pl,omitempty"` // price move, at the trade-date rate
+	FXPL    *float64 `json:"fx_pl,omitempty"`    // currency move since the trade dates
+	// TotalDiscrepancy adds up how far the symbol's buy/sell totals are from
+	// shares × price ± fee, counting only rows beyond TOTALS_TOLERANCE
+	TotalDiscrepancy float64 `json:"total_discrepancy,omitempty"`
+
+	refCCY string // for rounding only; set by the enclosing response
 }
 
 type SummaryResponse struct {
@@ -427,39 +2001,84 @@ type SummaryResponse struct {
     TotalUnrealizedPLPercCurrent float64    `json:"total_unrealized_pl_percent_current,omitempty"`
     DailyPL               float64           `json:"daily_pl,omitempty"`
     DailyPLPercent        float64           `json:"daily_pl_percent,omitempty"`
+    DailyPLStale          bool              `json:"daily_pl_stale,omitempty"`
+    DailyBasis            string            `json:"daily_basis,omitempty"` // prev_close | session_open
     Balance               float64           `json:"balance"`
     CashDeposits          float64           `json:"cash_deposits,omitempty"`
     CashWithdrawals       float64           `json:"cash_withdrawals,omitempty"`
     InferredDeposits      float64           `json:"inferred_deposits,omitempty"`
     EffectiveCashIn       float64           `json:"effective_cash_in,omitempty"`
     EffectiveCashInPeak   float64           `json:"effective_cash_in_peak,omitempty"`
+    InterestIncome        float64           `json:"interest_income,omitempty"`
+    CashFees              float64           `json:"cash_fees,omitempty"`
+    // MinBalance is the lowest running balance, reported with INFER_DEPOSITS=false
+    MinBalance            *float64          `json:"min_balance,omitempty"`
+    NegativeCashWarning   string            `json:"negative_cash_warning,omitempty"`
+    Reconciliation        *SummaryReconciliation `json:"reconciliation,omitempty"`
     Positions             []PositionSummary `json:"positions"`
+    // Skipped lists held symbols left out of the totals because they couldn't be priced
+    Skipped               []SkippedSymbol   `json:"skipped,omitempty"`
+    // Warnings notes currencies counted at 1:1 because no FX rate was available
+    Warnings              []string          `json:"warnings,omitempty"`
+}
+
+// splitFXPL splits p's UnrealizedPL into PricePL/FXPL using a's trade-date
+// cost: the price move converted at the average trade-date rate, and the
+// currency move as the rest, so the two always add up to UnrealizedPL.
+func splitFXPL(p *PositionSummary, a *positionAgg) {
+    if a.fxUntracked || a.investedSpot <= 0 || a.investedAtTrade <= 0 {
+        return
+    }
+    pricePL := (p.MarketValue - a.investedSpot) * a.investedAtTrade / a.investedSpot
+    fxPL := p.UnrealizedPL - pricePL
+    p.PricePL, p.FXPL = &pricePL, &fxPL
 }
 
+// errNoSummaryPrices is returned when a summary has open positions to value
+// but no PriceProvider. Summaries without open positions (a new portfolio,
+// cash only) don't need one.
+var errNoSummaryPrices = errors.New("no PriceProvider configured (required for summary)")
+
 // Overall (all portfolios). P/L here is UNREALIZED = MV − invested.
 // "Invested" = sum ABS(purchase totals) converted to refCCY; sells don't reduce invested.
 // Also: drop positions with zero shares (your request).
 func (s *TransactionService) ComputeSummaryAll() (SummaryResponse, error) {
-    if s.prices == nil {
-        return SummaryResponse{}, errors.New("no PriceProvider configured (required for summary)")
+    return s.cachedSummary("all:"+strings.ToLower(s.tag), s.computeSummaryAll)
+}
+
+// ComputeSummaryAllRefs computes the global summary in each of refs, keyed
+// by currency code. The summaries share one memoized price provider, so each
+// symbol is priced once and only the FX conversion differs between them.
+func (s *TransactionService) ComputeSummaryAllRefs(refs []string) (map[string]SummaryResponse, error) {
+    svc := s.WithPriceMemo()
+    out := make(map[string]SummaryResponse, len(refs))
+    for _, ref := range refs {
+        sum, err := svc.WithRef(ref).ComputeSummaryAll()
+        if err != nil {
+            return nil, err
+        }
+        out[sum.RefCurrency] = sum
     }
-    pfs, err := s.repoPf.List()
+    return out, nil
+}
+
+func (s *TransactionService) computeSummaryAll() (SummaryResponse, error) {
+    s = s.WithFXWarnings()
+    pfs, err := s.listPortfolios()
     if err != nil {
         return SummaryResponse{}, err
     }
-    // Build positions across all portfolios and compute per-portfolio balances (assuming no withdrawals)
-    type agg struct {
-        shares   float64
-        invested float64
-        currency string
-    }
-    bucket := map[string]*agg{}
+    // Compute per-portfolio cash stats, and collect every transaction so
+    // positions can be aggregated per portfolio and then summed by symbol.
+    var all []Transaction
     var sumBalance float64
     var sumDeposits float64
     var sumWithdrawals float64
     var sumInferred float64
     var sumEffectiveIn float64
     var sumPeakIn float64
+    var sumInterest float64
+    var sumCashFees float64
     for _, pf := range pfs {
         txs, err := s.repoTx.List(pf.ID, ListFilter{Limit: 0})
         if err != nil {
@@ -473,97 +2092,90 @@ func (s *TransactionService) ComputeSummaryAll() (SummaryResponse, error) {
         sumInferred += cs.inferred
         sumEffectiveIn += cs.effectiveIn
         sumPeakIn += cs.peakContrib
-        // accumulate positions using average cost
-        insertionSort(txs, lessForPositions)
-        for _, tx := range txs {
-            switch tx.TradeType {
-            case TradeTypeBuy, TradeTypeSell, TradeTypeDividend:
-                a := bucket[tx.Symbol]
-                if a == nil {
-                    a = &agg{}
-                    bucket[tx.Symbol] = a
-                }
-                if tx.Currency != "" {
-                    a.currency = strings.ToUpper(tx.Currency)
-                }
-                switch tx.TradeType {
-                case TradeTypeBuy:
-                    a.shares += tx.Shares
-                    amt := tx.Total
-                    if amt < 0 {
-                        amt = -amt
-                    }
-                    a.invested += amt * s.rate(tx.Currency)
-                case TradeTypeSell:
-                    if a.shares > 0 {
-                        avgCost := 0.0
-                        if a.shares > 0 {
-                            avgCost = a.invested / a.shares
-                        }
-                        sellShares := tx.Shares
-                        if sellShares > a.shares {
-                            sellShares = a.shares
-                        }
-                        a.invested -= avgCost * sellShares
-                        if a.invested < 0 {
-                            a.invested = 0
-                        }
-                    }
-                    a.shares -= tx.Shares
-                case TradeTypeDividend:
-                    // no effect on invested/shares
-                }
-            }
-        }
+        sumInterest += cs.interest
+        sumCashFees += cs.cashFees
+        all = append(all, txs...)
     }
+    withFX := *s
+    withFX.tradeDateFX = true // for the price/FX split, as in computeSummaryFromTxs
+    bucket := withFX.aggregatePositions(all)
 
     out := SummaryResponse{RefCurrency: s.refCCY}
     var totalMV, totalInv float64
     var asOf time.Time
     var dailyPL float64
     var prevMV float64
+    var dailyFresh, dailyStale int
+    var skipped []SkippedSymbol
     positions := make([]PositionSummary, 0, len(bucket))
     for sym, a := range bucket {
-        if a.shares <= 0 {
+        if a.shares == 0 || (a.shares < 0 && !allowShorts) {
             continue
         }
-        price, ts, err := s.prices.GetPrice(sym)
+        if s.prices == nil {
+            return SummaryResponse{}, errNoSummaryPrices
+        }
+        price, ts, err := s.priceFor(sym)
         if err != nil {
+            skipped = append(skipped, SkippedSymbol{Symbol: sym, Reason: err.Error()})
             continue
         }
         mult := multiplierForSymbol(sym)
-        mv := a.shares * price * mult * s.rate(a.currency)
+        // Shorts have negative shares, so negative MV, and negative invested
+        // (net proceeds): MV − invested is their P/L too
+        rate, _ := s.valuationRate(sym, a)
+        mv := a.shares * price * mult * rate
         pl := mv - a.invested
+        invested := a.invested
+        if invested < 0 {
+            invested = -invested // report a short's proceeds as positive
+        }
         plPct := 0.0
-        if a.invested > 0 {
-            plPct = (pl / a.invested) * 100.0
+        if invested > 0 {
+            plPct = (pl / invested) * 100.0
         }
-        positions = append(positions, PositionSummary{
+        ps := PositionSummary{
             Symbol:              sym,
             Shares:              a.shares,
-            Invested:            a.invested,
+            Invested:            invested,
             MarketValue:         mv,
             UnrealizedPL:        pl,
             UnrealizedPLPercent: plPct,
-        })
+            TotalDiscrepancy:    a.discrepancy,
+        }
+        if od, ok := s.parseOption(sym); ok {
+            ps.Option = &od
+        }
+        splitFXPL(&ps, a)
+        positions = append(positions, ps)
         totalMV += mv
-        totalInv += a.invested
+        totalInv += a.invested // a short's proceeds count as negative exposure
         if ts.After(asOf) {
             asOf = ts
         }
 
-        // Daily P/L = shares * (close_today - close_prev) converted to ref currency
-        if hp, ok := s.prices.(HistoryProvider); ok {
-            today := time.Now().UTC()
-            cur, asOfDay, err1 := hp.GetPriceOn(sym, today)
-            if err1 == nil && cur > 0 {
-                prev, _, err2 := hp.GetPriceOn(sym, asOfDay.AddDate(0, 0, -1))
-                if err2 == nil && prev > 0 {
-                    rate := s.rate(a.currency)
-                    mult := multiplierForSymbol(sym)
-                    dailyPL += a.shares * (cur - prev) * mult * rate
-                    prevMV += a.shares * prev * mult * rate
+        // Daily P/L = shares * (close_today - close_prev) converted to ref currency.
+        // Only positions that contribute to dailyPL add to the prevMV
+        // denominator, so the total percent reconciles with the per-position sums.
+        if cur, prev, stale, ok := s.dailyCloses(sym, ts); ok {
+            p := &positions[len(positions)-1]
+            if stale {
+                dailyStale++
+                p.DailyPLStale = true
+            } else {
+                dailyFresh++
+                mult := multiplierForSymbol(sym)
+                posPL := a.shares * (cur - prev) * mult * rate
+                posPrevMV := a.shares * prev * mult * rate
+                if posPrevMV < 0 {
+                    posPrevMV = -posPrevMV // shorts: percent of gross exposure
                 }
+                p.DailyPL = posPL
+                if posPrevMV > 0 {
+                    p.DailyPLPercent = (posPL / posPrevMV) * 100.0
+                }
+                dailyPL += posPL
+                prevMV += posPrevMV
             }
         }
     }
@@ -581,15 +2193,26 @@ func (s *TransactionService) ComputeSummaryAll() (SummaryResponse, error) {
     equity := totalMV + sumBalance
     out.TotalUnrealizedPL = equity - effectiveCashIn
     out.DailyPL = dailyPL
+    out.DailyBasis = s.dailyBasis
     if prevMV > 0 {
         out.DailyPLPercent = (dailyPL / prevMV) * 100.0
     }
+    // Every position is still waiting on its next session
+    out.DailyPLStale = dailyStale > 0 && dailyFresh == 0
     out.Balance = sumBalance
     out.CashDeposits = sumDeposits
     out.CashWithdrawals = sumWithdrawals
     out.InferredDeposits = sumInferred
     out.EffectiveCashIn = effectiveCashIn
     out.EffectiveCashInPeak = peakCashIn
+    out.InterestIncome = sumInterest
+    out.CashFees = sumCashFees
+    if !inferDeposits {
+        // Without inference the portfolios' ledgers simply add up, so the
+        // combined one shows the lowest total balance
+        s.setMinBalance(&out, s.computeCashStats(all))
+    }
+    out.Reconciliation = reconcile(bucket, totalMV, sumBalance, effectiveCashIn, sumInterest, sumCashFees)
     if peakCashIn > 0 {
         out.TotalUnrealizedPLPerc = (out.TotalUnrealizedPL / peakCashIn) * 100.0
     }
@@ -597,14 +2220,20 @@ func (s *TransactionService) ComputeSummaryAll() (SummaryResponse, error) {
         out.TotalUnrealizedPLPercCurrent = (out.TotalUnrealizedPL / effectiveCashIn) * 100.0
     }
     out.Positions = positions
+    sortSkipped(skipped)
+    out.Skipped = skipped
+    out.Warnings = s.fxWarnings()
     return out, nil
 }
 
 // Per-portfolio summary
 func (s *TransactionService) ComputeSummary(portfolioID string) (SummaryResponse, error) {
-    if s.prices == nil {
-        return SummaryResponse{}, errors.New("no PriceProvider configured (required for summary)")
-    }
+    return s.cachedSummary("pf:"+portfolioID, func() (SummaryResponse, error) {
+        return s.computeSummary(portfolioID)
+    })
+}
+
+func (s *TransactionService) computeSummary(portfolioID string) (SummaryResponse, error) {
     if _, err := s.repoPf.GetByID(portfolioID); err != nil {
         return SummaryResponse{}, ErrPortfolioNotFound
     }
@@ -617,106 +2246,89 @@ func (s *TransactionService) ComputeSummary(portfolioID string) (SummaryResponse
 
 // Shared summary computation from a list of transactions.
 func (s *TransactionService) computeSummaryFromTxs(allTx []Transaction) (SummaryResponse, error) {
-    type agg struct {
-        shares   float64
-        invested float64 // cost of remaining shares in ref currency (after sells reduce by avg cost)
-        currency string  // last seen tx currency for the symbol
-    }
-    bucket := map[string]*agg{}
-
-    // Sort by date for correct average cost handling on sells
-    insertionSort(allTx, lessForPositions)
-
-    for _, tx := range allTx {
-        // Position aggregation (ignore cash)
-        switch tx.TradeType {
-        case TradeTypeBuy, TradeTypeSell, TradeTypeDividend:
-            a := bucket[tx.Symbol]
-            if a == nil {
-                a = &agg{}
-                bucket[tx.Symbol] = a
-            }
-            if tx.Currency != "" {
-                a.currency = strings.ToUpper(tx.Currency)
-            }
-            switch tx.TradeType {
-            case TradeTypeBuy:
-                a.shares += tx.Shares
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                a.invested += amt * s.rate(tx.Currency)
-            case TradeTypeSell:
-                // Reduce invested by average cost per share for the shares sold
-                if a.shares > 0 {
-                    avgCost := 0.0
-                    if a.shares > 0 {
-                        avgCost = a.invested / a.shares
-                    }
-                    sellShares := tx.Shares
-                    if sellShares > a.shares {
-                        sellShares = a.shares
-                    }
-                    a.invested -= avgCost * sellShares
-                    if a.invested < 0 {
-                        a.invested = 0
-                    }
-                }
-                a.shares -= tx.Shares
-            case TradeTypeDividend:
-                // no change to invested/shares
-            }
-        }
-    }
+    s = s.WithFXWarnings()
+    // Sort by date for correct average cost handling on sells. Summaries also
+    // book cost at trade-date FX for the price/FX split of unrealized P/L.
+    withFX := *s
+    withFX.tradeDateFX = true
+    bucket := withFX.aggregatePositions(allTx)
 
     out := SummaryResponse{RefCurrency: s.refCCY}
     var totalMV, totalInv float64
     var asOf time.Time
     var dailyPL float64
     var prevMV float64
+    var dailyFresh, dailyStale int
+    var skipped []SkippedSymbol
     positions := make([]PositionSummary, 0, len(bucket))
     for sym, a := range bucket {
-        if a.shares <= 0 {
+        if a.shares == 0 || (a.shares < 0 && !allowShorts) {
             continue
         }
-        price, ts, err := s.prices.GetPrice(sym)
+        if s.prices == nil {
+            return SummaryResponse{}, errNoSummaryPrices
+        }
+        price, ts, err := s.priceFor(sym)
         if err != nil {
+            skipped = append(skipped, SkippedSymbol{Symbol: sym, Reason: err.Error()})
             continue
         }
         mult := multiplierForSymbol(sym)
-        mv := a.shares * price * mult * s.rate(a.currency)
+        // Shorts have negative shares, so negative MV, and negative invested
+        // (net proceeds): MV − invested is their P/L too
+        rate, _ := s.valuationRate(sym, a)
+        mv := a.shares * price * mult * rate
         pl := mv - a.invested
+        invested := a.invested
+        if invested < 0 {
+            invested = -invested // report a short's proceeds as positive
+        }
         plPct := 0.0
-        if a.invested > 0 {
-            plPct = (pl / a.invested) * 100.0
+        if invested > 0 {
+            plPct = (pl / invested) * 100.0
         }
-        positions = append(positions, PositionSummary{
+        ps := PositionSummary{
             Symbol:              sym,
             Shares:              a.shares,
-            Invested:            a.invested,
+            Invested:            invested,
             MarketValue:         mv,
             UnrealizedPL:        pl,
             UnrealizedPLPercent: plPct,
-        })
+            TotalDiscrepancy:    a.discrepancy,
+        }
+        if od, ok := s.parseOption(sym); ok {
+            ps.Option = &od
+        }
+        splitFXPL(&ps, a)
+        positions = append(positions, ps)
         totalMV += mv
-        totalInv += a.invested
+        totalInv += a.invested // a short's proceeds count as negative exposure
         if ts.After(asOf) {
             asOf = ts
         }
 
-        // Daily P/L = shares * (close_today - close_prev) converted to ref currency
-        if hp, ok := s.prices.(HistoryProvider); ok {
-            today := time.Now().UTC()
-            cur, asOfDay, err1 := hp.GetPriceOn(sym, today)
-            if err1 == nil && cur > 0 {
-                prev, _, err2 := hp.GetPriceOn(sym, asOfDay.AddDate(0, 0, -1))
-                if err2 == nil && prev > 0 {
-                    rate := s.rate(a.currency)
-                    mult := multiplierForSymbol(sym)
-                    dailyPL += a.shares * (cur - prev) * mult * rate
-                    prevMV += a.shares * prev * mult * rate
+        // Daily P/L = shares * (close_today - close_prev) converted to ref currency.
+        // Only positions that contribute to dailyPL add to the prevMV
+        // denominator, so the total percent reconciles with the per-position sums.
+        if cur, prev, stale, ok := s.dailyCloses(sym, ts); ok {
+            p := &positions[len(positions)-1]
+            if stale {
+                dailyStale++
+                p.DailyPLStale = true
+            } else {
+                dailyFresh++
+                mult := multiplierForSymbol(sym)
+                posPL := a.shares * (cur - prev) * mult * rate
+                posPrevMV := a.shares * prev * mult * rate
+                if posPrevMV < 0 {
+                    posPrevMV = -posPrevMV // shorts: percent of gross exposure
+                }
+                p.DailyPL = posPL
+                if posPrevMV > 0 {
+                    p.DailyPLPercent = (posPL / posPrevMV) * 100.0
                 }
+                dailyPL += posPL
+                prevMV += posPrevMV
             }
         }
     }
@@ -740,14 +2352,23 @@ func (s *TransactionService) computeSummaryFromTxs(allTx []Transaction) (Summary
     out.InferredDeposits = cs.inferred
     out.EffectiveCashIn = cs.effectiveIn
     out.EffectiveCashInPeak = cs.peakContrib
+    out.InterestIncome = cs.interest
+    out.CashFees = cs.cashFees
+    if !inferDeposits {
+        s.setMinBalance(&out, cs)
+    }
     // Cash-based P/L = Equity - EffectiveCashIn (current-basis).
     effectiveCashIn := cs.effectiveIn
     equity := out.TotalMarketValue + out.Balance
     out.TotalUnrealizedPL = equity - effectiveCashIn
+    out.Reconciliation = reconcile(bucket, out.TotalMarketValue, out.Balance, effectiveCashIn, cs.interest, cs.cashFees)
     out.DailyPL = dailyPL
+    out.DailyBasis = s.dailyBasis
     if prevMV > 0 {
         out.DailyPLPercent = (dailyPL / prevMV) * 100.0
     }
+    // Every position is still waiting on its next session
+    out.DailyPLStale = dailyStale > 0 && dailyFresh == 0
     if cs.peakContrib > 0 {
         out.TotalUnrealizedPLPerc = (out.TotalUnrealizedPL / cs.peakContrib) * 100.0
     }
@@ -755,92 +2376,108 @@ func (s *TransactionService) computeSummaryFromTxs(allTx []Transaction) (Summary
         out.TotalUnrealizedPLPercCurrent = (out.TotalUnrealizedPL / effectiveCashIn) * 100.0
     }
     out.Positions = positions
+    sortSkipped(skipped)
+    out.Skipped = skipped
+    out.Warnings = s.fxWarnings()
     return out, nil
 }
 
+// Same-date ordering for running-cash calculations (CASH_ORDERING).
+const (
+    // CashOrderingOptimistic applies inflows before outflows on the same
+    // date, minimizing inferred deposits (default).
+    CashOrderingOptimistic = "optimistic"
+    // CashOrderingStrict orders purely by timestamp then ID, matching
+    // actual settlement; inferred deposits may be larger.
+    CashOrderingStrict = "strict"
+)
+
+// SetCashOrdering selects how same-date transactions are ordered when
+// computing balances and inferred deposits. Empty means optimistic.
+func (s *TransactionService) SetCashOrdering(mode string) error {
+    switch m := strings.ToLower(strings.TrimSpace(mode)); m {
+    case "", CashOrderingOptimistic:
+        s.cashOrdering = CashOrderingOptimistic
+    case CashOrderingStrict:
+        s.cashOrdering = m
+    default:
+        return fmt.Errorf("unsupported cash ordering %q (use optimistic|strict)", mode)
+    }
+    return nil
+}
+
+// cashDelta is tx's signed effect on cash in the reference currency:
+// buys negative, sells/dividends positive, cash as recorded.
+func (s *TransactionService) cashDelta(tx Transaction) float64 {
+    amt := tx.Total
+    if amt < 0 {
+        amt = -amt
+    }
+    switch tx.TradeType {
+    case TradeTypeBuy:
+        return -amt * s.cashRate(tx)
+    case TradeTypeSell, TradeTypeDividend:
+        return +amt * s.cashRate(tx)
+    case TradeTypeCash:
+        return tx.Total * s.cashRate(tx)
+    default:
+        return 0
+    }
+}
+
+// sortForCash orders xs for running-cash calculations (see lessForCash),
+// computing each transaction's cash delta once rather than per comparison.
+func (s *TransactionService) sortForCash(xs []Transaction) {
+    type keyed struct {
+        tx    Transaction
+        delta float64
+    }
+    ks := make([]keyed, len(xs))
+    for i, tx := range xs {
+        ks[i] = keyed{tx: tx, delta: s.cashDelta(tx)}
+    }
+    stableSort(ks, func(a, b keyed) bool { return s.lessForCash(a.tx, b.tx, a.delta, b.delta) })
+    for i := range ks {
+        xs[i] = ks[i].tx
+    }
+}
+
+// lessForCash orders transactions for running-cash calculations: by date,
+// then (optimistic mode) inflows before outflows, then by ID. da and db are
+// the transactions' cashDelta values.
+func (s *TransactionService) lessForCash(a, b Transaction, da, db float64) bool {
+    if a.Date.Before(b.Date) {
+        return true
+    }
+    if a.Date.After(b.Date) {
+        return false
+    }
+    if s.cashOrdering != CashOrderingStrict {
+        if da != db {
+            // Want inflows (positive delta) before outflows (negative delta)
+            return da > db
+        }
+    }
+    // deterministic tie-breaker
+    return a.ID < b.ID
+}
+
 // inferBalance computes the ending balance assuming no withdrawals, and
 // injecting the minimal deposits needed so the running balance never goes below zero.
 func (s *TransactionService) inferBalance(txs []Transaction) float64 {
     if len(txs) == 0 {
         return 0
     }
-    // Copy and sort by date; for same date, place inflows before outflows
+    // Copy and sort by date (see lessForCash for same-date ordering)
     xs := make([]Transaction, len(txs))
     copy(xs, txs)
-    insertionSort(xs, func(a, b Transaction) bool {
-        if a.Date.Before(b.Date) {
-            return true
-        }
-        if a.Date.After(b.Date) {
-            return false
-        }
-        // Same timestamp: inflows before outflows to maximize non-negative balance
-        deltaA := func(tx Transaction) float64 {
-            switch tx.TradeType {
-            case TradeTypeBuy:
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                return -amt * s.rate(tx.Currency)
-            case TradeTypeSell:
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                return +amt * s.rate(tx.Currency)
-            case TradeTypeDividend:
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                return +amt * s.rate(tx.Currency)
-            case TradeTypeCash:
-                return tx.Total * s.rate(tx.Currency)
-            default:
-                return 0
-            }
-        }
-        da := deltaA(a)
-        db := deltaA(b)
-        if da == db {
-            // deterministic tie-breaker
-            return a.ID < b.ID
-        }
-        // Want inflows (positive delta) before outflows (negative delta)
-        return da > db
-    })
+    s.sortForCash(xs)
 
     var sum float64
     var prefix float64
     var minPrefix float64
     for _, tx := range xs {
-        var delta float64
-        switch tx.TradeType {
-        case TradeTypeBuy:
-            amt := tx.Total
-            if amt < 0 {
-                amt = -amt
-            }
-            delta = -amt * s.rate(tx.Currency)
-        case TradeTypeSell:
-            amt := tx.Total
-            if amt < 0 {
-                amt = -amt
-            }
-            delta = +amt * s.rate(tx.Currency)
-        case TradeTypeDividend:
-            amt := tx.Total
-            if amt < 0 {
-                amt = -amt
-            }
-            delta = +amt * s.rate(tx.Currency)
-        case TradeTypeCash:
-            // Deposits positive, withdrawals negative as provided
-            delta = tx.Total * s.rate(tx.Currency)
-        default:
-            delta = 0
-        }
+        delta := s.cashDelta(tx)
         sum += delta
         prefix += delta
         if prefix < minPrefix {
@@ -848,12 +2485,30 @@ func (s *TransactionService) inferBalance(txs []Transaction) float64 {
         }
     }
     inferredDeposit := 0.0
-    if minPrefix < 0 {
+    if minPrefix < -cashEpsilon {
         inferredDeposit = -minPrefix
     }
     return inferredDeposit + sum
 }
 
+// inferDeposits injects the minimal deposits that keep each portfolio's cash
+// from going negative (the default). INFER_DEPOSITS=false turns that off for
+// ledgers that record every deposit: cash may then go negative, and summaries
+// report the lowest balance with a negative_cash_warning instead.
+var inferDeposits = inferDepositsFromEnv(os.Getenv("INFER_DEPOSITS"))
+
+func inferDepositsFromEnv(v string) bool {
+    switch strings.ToLower(strings.TrimSpace(v)) {
+    case "0", "false", "no":
+        return false
+    }
+    return true
+}
+
+// cashEpsilon absorbs floating-point noise in running cash balances so that
+// e.g. -0.0000001 after an exact buy/sell round-trip doesn't infer a deposit.
+const cashEpsilon = 1e-6
+
 type cashStats struct {
     deposits    float64
     withdrawals float64
@@ -861,12 +2516,16 @@ type cashStats struct {
     balance     float64
     effectiveIn float64
     peakContrib float64
+    interest    float64 // cash_kind=interest income, not a contribution
+    cashFees    float64 // cash_kind=fee charges (positive magnitude), not a withdrawal
+    minBalance  float64   // lowest running balance; below zero only without inferDeposits
+    minDate     time.Time // when minBalance was reached
     inferredEvents   []cashEvent
     depositEvents    []cashEvent
     withdrawalEvents []cashEvent
 }
 
-// computeCashStats sorts the transactions by date (inflows before outflows within the same date),
+// computeCashStats sorts the transactions by date (see lessForCash for same-date order),
 // computes deposits, withdrawals, minimal inferred deposits to avoid negative balance, and ending balance.
 func (s *TransactionService) computeCashStats(txs []Transaction) cashStats {
     if len(txs) == 0 {
@@ -874,89 +2533,36 @@ func (s *TransactionService) computeCashStats(txs []Transaction) cashStats {
     }
     xs := make([]Transaction, len(txs))
     copy(xs, txs)
-    // Sort with inflows before outflows at equal timestamps
-    insertionSort(xs, func(a, b Transaction) bool {
-        if a.Date.Before(b.Date) {
-            return true
-        }
-        if a.Date.After(b.Date) {
-            return false
-        }
-        deltaA := func(tx Transaction) float64 {
-            switch tx.TradeType {
-            case TradeTypeBuy:
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                return -amt * s.rate(tx.Currency)
-            case TradeTypeSell:
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                return +amt * s.rate(tx.Currency)
-            case TradeTypeDividend:
-                amt := tx.Total
-                if amt < 0 {
-                    amt = -amt
-                }
-                return +amt * s.rate(tx.Currency)
-            case TradeTypeCash:
-                return tx.Total * s.rate(tx.Currency)
-            default:
-                return 0
-            }
-        }
-        da := deltaA(a)
-        db := deltaA(b)
-        if da == db {
-            return a.ID < b.ID
-        }
-        return da > db
-    })
+    // Sort by date (see lessForCash for same-date ordering)
+    s.sortForCash(xs)
 
     var sum float64            // running cash balance
     var prefix float64         // same as sum, kept for clarity
     var minPrefix float64
+    var minDate time.Time
     var deposits float64
     var withdrawals float64
     var contribPrefix float64  // running net contributions (deposits - withdrawals + inferred)
     var peakContrib float64
     var inferredTotal float64
+    var interest float64
+    var cashFees float64
     var inferredEvents []cashEvent
     var depositEvents []cashEvent
     var withdrawalEvents []cashEvent
     for _, tx := range xs {
-        var delta float64
-        switch tx.TradeType {
-        case TradeTypeBuy:
-            amt := tx.Total
-            if amt < 0 {
-                amt = -amt
-            }
-            delta = -amt * s.rate(tx.Currency)
-        case TradeTypeSell:
-            amt := tx.Total
-            if amt < 0 {
-                amt = -amt
-            }
-            delta = +amt * s.rate(tx.Currency)
-        case TradeTypeDividend:
-            amt := tx.Total
-            if amt < 0 {
-                amt = -amt
-            }
-            delta = +amt * s.rate(tx.Currency)
-        case TradeTypeCash:
-            v := tx.Total * s.rate(tx.Currency)
-            delta = v
-            if v >= 0 {
-                deposits += v
-                contribPrefix += v
-                depositEvents = append(depositEvents, cashEvent{when: tx.Date, amount: v})
+        delta := s.cashDelta(tx)
+        if tx.TradeType == TradeTypeCash {
+            if tx.CashKind == CashKindInterest {
+                interest += delta
+            } else if tx.CashKind == CashKindFee {
+                cashFees -= delta
+            } else if delta >= 0 {
+                deposits += delta
+                contribPrefix += delta
+                depositEvents = append(depositEvents, cashEvent{when: tx.Date, amount: delta})
             } else {
-                w := -v
+                w := -delta
                 withdrawals += w
                 contribPrefix -= w
                 if contribPrefix < 0 {
@@ -966,7 +2572,7 @@ func (s *TransactionService) computeCashStats(txs []Transaction) cashStats {
             }
         }
         // Before applying delta, if it would take balance negative, inject minimal inferred deposit
-        if prefix+delta < 0 {
+        if inferDeposits && prefix+delta < -cashEpsilon {
             need := -(prefix + delta)
             inferredTotal += need
             contribPrefix += need
@@ -977,7 +2583,7 @@ func (s *TransactionService) computeCashStats(txs []Transaction) cashStats {
         sum += delta
         prefix += delta
         if prefix < minPrefix {
-            minPrefix = prefix
+            minPrefix, minDate = prefix, tx.Date
         }
         if contribPrefix > peakContrib {
             peakContrib = contribPrefix
@@ -991,12 +2597,27 @@ func (s *TransactionService) computeCashStats(txs []Transaction) cashStats {
         balance:     sum, // inferred was already injected during the run
         effectiveIn: deposits - withdrawals + inferred,
         peakContrib: peakContrib,
+        interest:    interest,
+        cashFees:    cashFees,
+        minBalance:  minPrefix,
+        minDate:     minDate,
         inferredEvents:   inferredEvents,
         depositEvents:    depositEvents,
         withdrawalEvents: withdrawalEvents,
     }
 }
 
+// setMinBalance reports cs's lowest balance on out, with a warning when it
+// went below zero (only possible without inferDeposits).
+func (s *TransactionService) setMinBalance(out *SummaryResponse, cs cashStats) {
+    lowest := cs.minBalance
+    out.MinBalance = &lowest
+    if lowest < -cashEpsilon {
+        out.NegativeCashWarning = fmt.Sprintf("cash went negative, lowest %.2f %s on %s; a deposit may be missing",
+            lowest, s.refCCY, cs.minDate.Format(txDateLayout))
+    }
+}
+
 type cashEvent struct {
     when   time.Time
     amount float64 // always positive magnitude in ref currency
@@ -1051,7 +2672,7 @@ func (s *TransactionService) ComputeBacktest(portfolioID, symbol, symbolCCY, pri
 
 // Global backtest
 func (s *TransactionService) ComputeBacktestAll(symbol, symbolCCY, priceBasis string, debug bool) (BacktestResponse, error) {
-    pfs, err := s.repoPf.List()
+    pfs, err := s.listPortfolios()
     if err != nil {
         return BacktestResponse{}, err
     }
@@ -1085,13 +2706,13 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
     for _, e := range cs.withdrawalEvents {
         evs = append(evs, backtestEvent{when: e.when, kind: "withdrawal", amount: e.amount})
     }
-    insertionSortEvents(evs)
+    sortEvents(evs)
 
     // helpers for pricing on date
     getOn := func(d time.Time) (float64, time.Time, error) {
         if hp, ok := s.prices.(HistoryProvider); ok {
             // Toggle basis when supported by provider
-            if yp, ok2 := s.prices.(*YahooProvider); ok2 && (priceBasis == "open" || priceBasis == "close") {
+            if yp, ok2 := s.prices.(BasisHistoryProvider); ok2 && (priceBasis == "open" || priceBasis == "close") {
                 p, asOf, err := yp.GetPriceOnBasis(symbol, d, priceBasis)
                 if err == nil && p > 0 {
                     return p, asOf, nil
@@ -1109,10 +2730,7 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
 
     var shares float64
     mult := multiplierForSymbol(symbol)
-    rateSymToRef := s.rate(symbolCCY)
-    if rateSymToRef <= 0 {
-        rateSymToRef = 1.0
-    }
+    rateSymToRef, _ := s.rate(symbolCCY)
     var dbg BacktestDebug
     // Track alternate equity (ref ccy) over daily history to compute max drop
     altPeak := 0.0
@@ -1126,11 +2744,17 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
             evByDay[d] = append(evByDay[d], e)
             if d.Before(start) { start = d }
         }
-        today := time.Now().UTC()
+        today := s.today()
+        ix := s.dailyIndex(symbol, priceBasis)
         for d := start; !d.After(today); d = d.AddDate(0, 0, 1) {
             // Daily price on chosen basis
             price, asOf, err := func() (float64, time.Time, error) {
-                if yp, ok2 := s.prices.(*YahooProvider); ok2 && (priceBasis == "open" || priceBasis == "close") {
+                if ix != nil {
+                    if p, as, ok, err := ix.at(d); ok {
+                        return p, as, err
+                    }
+                }
+                if yp, ok2 := s.prices.(BasisHistoryProvider); ok2 && (priceBasis == "open" || priceBasis == "close") {
                     return yp.GetPriceOnBasis(symbol, d, priceBasis)
                 }
                 return hp.GetPriceOn(symbol, d)
@@ -1252,31 +2876,10 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
     // Compute current portfolio max drop (drawdown) over time sampled by dates of transactions
     currentMaxDrop := 0.0 // negative percentage
     if s.prices != nil {
-        // Sort transactions chronologically with inflows before outflows on same date
+        // Sort transactions chronologically, same-date order per lessForCash
         xs := make([]Transaction, len(allTx))
         copy(xs, allTx)
-        insertionSort(xs, func(a, b Transaction) bool {
-            if a.Date.Before(b.Date) { return true }
-            if a.Date.After(b.Date) { return false }
-            // inflows before outflows at equal timestamps (reuse logic)
-            deltaA := func(tx Transaction) float64 {
-                switch tx.TradeType {
-                case TradeTypeBuy:
-                    amt := tx.Total; if amt < 0 { amt = -amt }
-                    return -amt * s.rate(tx.Currency)
-                case TradeTypeSell, TradeTypeDividend:
-                    amt := tx.Total; if amt < 0 { amt = -amt }
-                    return +amt * s.rate(tx.Currency)
-                case TradeTypeCash:
-                    return tx.Total * s.rate(tx.Currency)
-                default:
-                    return 0
-                }
-            }
-            da := deltaA(a); db := deltaA(b)
-            if da == db { return a.ID < b.ID }
-            return da > db
-        })
+        s.sortForCash(xs)
 
         type agg struct{
             shares float64
@@ -1289,7 +2892,19 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
         type key struct{ sym string; y int; m int; d int; basis string }
         priceCache := map[key]float64{}
         asOfCache := map[key]time.Time{}
+        // whole series per symbol, loaded on first use (nil: not available)
+        indexes := map[string]*dayIndex{}
         getOn2 := func(sym string, d time.Time) (float64, time.Time, error) {
+            ix, seen := indexes[sym]
+            if !seen {
+                ix = s.dailyIndex(sym, priceBasis)
+                indexes[sym] = ix
+            }
+            if ix != nil {
+                if p, as, ok, err := ix.at(d); ok {
+                    return p, as, err
+                }
+            }
             k := key{sym: sym, y: d.Year(), m: int(d.Month()), d: d.Day(), basis: priceBasis}
             if p, ok := priceCache[k]; ok {
                 return p, asOfCache[k], nil
@@ -1298,7 +2913,7 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
             var as time.Time
             var err error
             if hp, ok := s.prices.(HistoryProvider); ok {
-                if yp, ok2 := s.prices.(*YahooProvider); ok2 && (priceBasis == "open" || priceBasis == "close") {
+                if yp, ok2 := s.prices.(BasisHistoryProvider); ok2 && (priceBasis == "open" || priceBasis == "close") {
                     p, as, err = yp.GetPriceOnBasis(sym, d, priceBasis)
                 } else {
                     p, as, err = hp.GetPriceOn(sym, d)
@@ -1321,7 +2936,8 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
                 p, _, err := getOn2(sym, day)
                 if err != nil || p <= 0 { continue }
                 mult := multiplierForSymbol(sym)
-                total += a.shares * p * mult * s.rate(a.ccy)
+                r, _ := s.rate(a.ccy)
+                total += a.shares * p * mult * r
             }
             return total
         }
@@ -1347,39 +2963,35 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
                 curDay = tx.Date
                 haveDay = true
             }
-            // Compute cash delta for this tx
-            delta := 0.0
-            switch tx.TradeType {
-            case TradeTypeBuy:
-                amt := tx.Total; if amt < 0 { amt = -amt }
-                delta = -amt * s.rate(tx.Currency)
-            case TradeTypeSell:
-                amt := tx.Total; if amt < 0 { amt = -amt }
-                delta = +amt * s.rate(tx.Currency)
-            case TradeTypeDividend:
-                amt := tx.Total; if amt < 0 { amt = -amt }
-                delta = +amt * s.rate(tx.Currency)
-            case TradeTypeCash:
-                delta = tx.Total * s.rate(tx.Currency)
-            }
+            delta := s.cashDelta(tx)
             // Inject inferred cash if needed before applying delta
-            if cash+delta < 0 {
+            if inferDeposits && cash+delta < -cashEpsilon {
                 need := -(cash + delta)
                 cash += need
             }
             // Apply holdings change
             switch tx.TradeType {
             case TradeTypeBuy:
-                a := holdings[tx.Symbol]
-                if a == nil { a = &agg{}; holdings[tx.Symbol] = a }
+                sym := s.canonicalSymbol(tx.Symbol)
+                a := holdings[sym]
+                if a == nil { a = &agg{}; holdings[sym] = a }
                 if tx.Currency != "" { a.ccy = strings.ToUpper(tx.Currency) }
                 a.shares += tx.Shares
             case TradeTypeSell:
-                a := holdings[tx.Symbol]
-                if a == nil { a = &agg{}; holdings[tx.Symbol] = a }
+                sym := s.canonicalSymbol(tx.Symbol)
+                a := holdings[sym]
+                if a == nil { a = &agg{}; holdings[sym] = a }
                 if tx.Currency != "" { a.ccy = strings.ToUpper(tx.Currency) }
                 a.shares -= tx.Shares
                 if a.shares < 0 { a.shares = 0 }
+            case TradeTypeTransfer:
+                // no cash effect; signed shares move between portfolios
+                sym := s.canonicalSymbol(tx.Symbol)
+                a := holdings[sym]
+                if a == nil { a = &agg{}; holdings[sym] = a }
+                if tx.Currency != "" { a.ccy = strings.ToUpper(tx.Currency) }
+                a.shares += tx.Shares
+                if a.shares < 0 { a.shares = 0 }
             case TradeTypeDividend:
                 // no change to shares
             case TradeTypeCash:
@@ -1396,8 +3008,7 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
 
         // Also include an as-of evaluation (today) if we have any holdings
         if haveDay {
-            today := time.Now().UTC()
-            updateDraw(today)
+            updateDraw(s.today())
         }
     }
 
@@ -1418,7 +3029,61 @@ func (s *TransactionService) computeBacktestFromTxs(allTx []Transaction, symbol,
     return resp, nil
 }
 
-func insertionSortEvents(xs []backtestEvent) {
+// dayIndex answers "last price at or before day" in O(1) from a daily
+// series filled forward over every calendar day from its first bar up to
+// (not including) until. Later days are left to the provider, which may
+// splice in a live quote for today.
+type dayIndex struct {
+    first  time.Time
+    prices []float64   // by days since first
+    bars   []time.Time // the bar each day resolves to
+}
+
+func newDayIndex(days []time.Time, prices []float64, until time.Time) *dayIndex {
+    ix := &dayIndex{first: days[0]}
+    j := 0
+    for d := days[0]; d.Before(until); d = d.AddDate(0, 0, 1) {
+        for j+1 < len(days) && !days[j+1].After(d) {
+            j++
+        }
+        ix.prices = append(ix.prices, prices[j])
+        ix.bars = append(ix.bars, days[j])
+    }
+    return ix
+}
+
+// at looks day up; ok is false when the index can't answer (day is until or
+// later) and the caller should ask the provider.
+func (ix *dayIndex) at(day time.Time) (price float64, bar time.Time, ok bool, err error) {
+    day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+    if day.Before(ix.first) {
+        return 0, time.Time{}, true, ErrPriceNotFound
+    }
+    i := int(day.Sub(ix.first).Hours() / 24)
+    if i >= len(ix.prices) {
+        return 0, time.Time{}, false, nil
+    }
+    return ix.prices[i], ix.bars[i], true, nil
+}
+
+// dailyIndex loads sym's whole series once when the provider offers it
+// (DailySeriesProvider). nil means look days up one at a time.
+func (s *TransactionService) dailyIndex(sym, basis string) *dayIndex {
+    sp, ok := s.prices.(DailySeriesProvider)
+    if !ok {
+        return nil
+    }
+    if _, ok := s.prices.(BasisHistoryProvider); !ok {
+        basis = "close" // what GetPriceOn would give
+    }
+    days, prices, err := sp.DailySeries(sym, basis)
+    if err != nil || len(days) == 0 || len(prices) != len(days) {
+        return nil
+    }
+    return newDayIndex(days, prices, s.today())
+}
+
+func sortEvents(xs []backtestEvent) {
     less := func(a, b backtestEvent) bool {
         if a.when.Before(b.when) {
             return true
@@ -1435,13 +3100,7 @@ func insertionSortEvents(xs []backtestEvent) {
         }
         return false
     }
-    for i := 1; i < len(xs); i++ {
-        j := i
-        for j > 0 && less(xs[j], xs[j-1]) {
-            xs[j], xs[j-1] = xs[j-1], xs[j]
-            j--
-        }
-    }
+    stableSort(xs, less)
 }
 
 type backtestEvent struct {
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// fixedRates is a CurrencyExchanger with constant rates, keyed by pair (USDTWD).
+type fixedRates map[string]float64
+
+func (f fixedRates) Rate(from, to string) (float64, time.Time, error) {
+	if from == to {
+		return 1, time.Now(), nil
+	}
+	r, ok := f[from+to]
+	if !ok {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	return r, time.Now(), nil
+}
+
+// fixedPrices is a PriceProvider with constant prices by symbol.
+type fixedPrices map[string]float64
+
+func (f fixedPrices) GetPrice(symbol string) (float64, time.Time, error) {
+	p, ok := f[symbol]
+	if !ok {
+		return 0, time.Time{}, ErrPriceNotFound
+	}
+	return p, time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC), nil
+}
+
+// newTestServices wires in-memory repos into both services.
+func newTestServices(t testing.TB, prices PriceProvider, ex CurrencyExchanger, ref string) (*PortfolioService, *TransactionService) {
+	t.Helper()
+	st := newMemoryStore()
+	pfRepo, txRepo := NewMemoryPortfolioRepo(st), NewMemoryTransactionRepo(st)
+	return NewPortfolioService(pfRepo), NewTransactionService(txRepo, pfRepo, prices, ex, ref)
+}
+
+// mustPortfolio creates a portfolio with txs, failing the test on any error.
+func mustPortfolio(t testing.TB, pf *PortfolioService, tx *TransactionService, baseCCY string, txs ...transactionDTO) string {
+	t.Helper()
+	p, err := pf.Create(portfolioDTO{Name: "test", BaseCCY: baseCCY})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range txs {
+		if _, err := tx.CreateOne(p.ID, d); err != nil {
+			t.Fatalf("create %+v: %v", d, err)
+		}
+	}
+	return p.ID
+}
+
+func approx(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+
+// testDate is the trade date of the fixtures below; same-day rows apply in
+// the order given.
+const testDate = "2025/01/02"
+
+// buyTx and sellTx trade shares of sym at price in ccy, with the matching
+// total and no fee.
+func buyTx(sym, ccy string, shares, price float64) transactionDTO {
+	return transactionDTO{Symbol: sym, TradeType: TradeTypeBuy, Currency: ccy, Shares: shares, Price: price, Total: -shares * price, Date: testDate}
+}
+
+func sellTx(sym, ccy string, shares, price float64) transactionDTO {
+	return transactionDTO{Symbol: sym, TradeType: TradeTypeSell, Currency: ccy, Shares: shares, Price: price, Total: shares * price, Date: testDate}
+}
+
+// cashTx deposits total in ccy, or withdraws it when negative.
+func cashTx(ccy string, total float64) transactionDTO {
+	return transactionDTO{TradeType: TradeTypeCash, Currency: ccy, Total: total, Date: testDate}
+}
+
+// summaries creates one portfolio with txs and returns its summary and the
+// global one.
+func summaries(t testing.TB, prices PriceProvider, ex CurrencyExchanger, ref, baseCCY string, txs ...transactionDTO) (one, all SummaryResponse) {
+	t.Helper()
+	pf, tx := newTestServices(t, prices, ex, ref)
+	id := mustPortfolio(t, pf, tx, baseCCY, txs...)
+	one, err := tx.ComputeSummary(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err = tx.ComputeSummaryAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return one, all
+}
+
+func TestForeignCashNetsAtSpot(t *testing.T) {
+	pf, svc := newTestServices(t, fixedPrices{"AAPL": 110}, fixedRates{"USDTWD": 31}, "TWD")
+	id := mustPortfolio(t, pf, svc, "USD",
+		transactionDTO{TradeType: TradeTypeCash, Currency: "USD", Date: "2025/01/02", Total: 1000, FXRate: 30},
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Shares: 10, Price: 100, Date: "2025/02/03", Total: -1000, FXRate: 32},
+	)
+	sum, err := svc.ComputeSummary(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(sum.Balance, 0) || sum.InferredDeposits != 0 {
+		t.Fatalf("balance %v, inferred %v; want 0, 0", sum.Balance, sum.InferredDeposits)
+	}
+	if !approx(sum.TotalInvested, 32000) {
+		t.Errorf("invested %v, want 32000 (cost at the stored rate)", sum.TotalInvested)
+	}
+	r := sum.Reconciliation
+	parts := r.UnrealizedPL + r.RealizedGains + r.DividendsReceived + r.InterestReceived - r.CashFees +
+		r.TransfersNetCost + r.UnbookedTradeCash + r.CashFXTranslation
+	if !approx(parts, r.TotalPL) {
+		t.Errorf("reconciliation parts %v != total_pl %v", parts, r.TotalPL)
+	}
+}
+
+// slowHistory is a HistoryExchanger whose RateOn waits for release.
+type slowHistory struct {
+	fixedRates
+	release chan struct{}
+}
+
+func (s slowHistory) RateOn(from, to string, date time.Time) (float64, time.Time, error) {
+	<-s.release
+	return 30, date, nil
+}
+
+func TestStampFXDoesNotBlockWrites(t *testing.T) {
+	ex := slowHistory{fixedRates{"USDTWD": 31}, make(chan struct{})}
+	pf, svc := newTestServices(t, nil, ex, "TWD")
+	id := mustPortfolio(t, pf, svc, "USD")
+
+	done := make(chan Transaction)
+	go func() {
+		tx, err := svc.CreateOne(id, transactionDTO{TradeType: TradeTypeCash, Currency: "USD", Date: "2025/01/02", Total: 100})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- tx
+	}()
+	var created Transaction
+	select {
+	case created = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("create waited on the rate lookup")
+	}
+	if created.FXRate != 0 {
+		t.Fatalf("rate %v stamped synchronously", created.FXRate)
+	}
+
+	close(ex.release)
+	svc.fxStamps.Wait()
+	got, err := svc.Get(id, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.FXRate != 30 || got.FXRef != "TWD" {
+		t.Fatalf("stamped rate %v %q, want 30 TWD", got.FXRate, got.FXRef)
+	}
+}
+
+func TestHardDeletesRequireResync(t *testing.T) {
+	t0 := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	pf, svc := newTestServices(t, nil, nil, "USD")
+	pf.SetClock(FixedClock(t0))
+	svc.SetClock(FixedClock(t0))
+	id := mustPortfolio(t, pf, svc, "USD",
+		transactionDTO{TradeType: TradeTypeCash, Currency: "USD", Date: "2025/01/02", Total: 100})
+	rows, err := svc.List(id, ListFilter{})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("list = %v, %v", rows, err)
+	}
+	svc.SetClock(FixedClock(t0.Add(time.Hour)))
+	if err := svc.Delete(id, rows[0].ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	svc.SetClock(FixedClock(t0.Add(48 * time.Hour)))
+	if n, err := svc.PurgeDeleted(24 * time.Hour); err != nil || n != 1 {
+		t.Fatalf("purge = %d, %v", n, err)
+	}
+
+	for _, tc := range []struct {
+		since time.Time
+		want  error
+	}{
+		{t0, ErrResyncRequired},                // would have seen the soft delete
+		{t0.Add(time.Hour), ErrResyncRequired}, // the cursor is inclusive
+		{t0.Add(time.Hour + time.Second), nil}, // already synced past it
+		{time.Time{}, nil},                     // full listing
+	} {
+		_, err := svc.List(id, ListFilter{UpdatedSince: tc.since})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("List since %v: err %v, want %v", tc.since, err, tc.want)
+		}
+		_, err = svc.ListAll(ListFilter{UpdatedSince: tc.since})
+		if !errors.Is(err, tc.want) {
+			t.Errorf("ListAll since %v: err %v, want %v", tc.since, err, tc.want)
+		}
+	}
+
+	// Deleting a portfolio takes effect now, for every older cursor.
+	pf.SetClock(FixedClock(t0.Add(72 * time.Hour)))
+	if err := pf.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pf.ListPage("", 0, 0, t0.Add(71*time.Hour)); !errors.Is(err, ErrResyncRequired) {
+		t.Errorf("portfolios since before the delete: err %v, want ErrResyncRequired", err)
+	}
+}
+
+func TestSplitFXPLAddsUpToUnrealizedPL(t *testing.T) {
+	cases := []struct {
+		name          string
+		mv, invested  float64
+		spot, atTrade float64
+		pricePL       float64
+	}{
+		// cost booked at the stored trade-date rate: invested == atTrade
+		{"stored rate", 3300, 2500, 3000, 2500, 250},
+		// a lot without a stored rate is booked at today's rate
+		{"spot booked", 3300, 3000, 3000, 2500, 250},
+		{"loss", 2700, 2800, 3000, 2500, -250},
+	}
+	for _, c := range cases {
+		p := PositionSummary{MarketValue: c.mv, UnrealizedPL: c.mv - c.invested}
+		splitFXPL(&p, &positionAgg{investedSpot: c.spot, investedAtTrade: c.atTrade})
+		if p.PricePL == nil || p.FXPL == nil {
+			t.Fatalf("%s: split not reported", c.name)
+		}
+		if !approx(*p.PricePL, c.pricePL) {
+			t.Errorf("%s: price P/L %v, want %v", c.name, *p.PricePL, c.pricePL)
+		}
+		if !approx(*p.PricePL+*p.FXPL, p.UnrealizedPL) {
+			t.Errorf("%s: price %v + FX %v != unrealized %v", c.name, *p.PricePL, *p.FXPL, p.UnrealizedPL)
+		}
+	}
+}
+
+func TestTransactionIDsAreUniqueAcrossPortfolios(t *testing.T) {
+	pf, tx := newTestServices(t, fixedPrices{}, nil, "USD")
+	a := mustPortfolio(t, pf, tx, "USD")
+	b := mustPortfolio(t, pf, tx, "USD")
+	const id = "6f1c2a5e-9a4b-4c2d-8e57-3b0f7a9d1c11"
+	row := transactionDTO{ID: id, Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Shares: 1, Price: 100, Total: -100, Date: "2025/01/02"}
+	if _, err := tx.CreateOne(a, row); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.CreateOne(b, row); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("create in another portfolio: %v, want ErrDuplicateID", err)
+	}
+	if _, err := tx.CreateBatch(b, []transactionDTO{row}); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("batch in another portfolio: %v, want ErrDuplicateID", err)
+	}
+}
+
+func TestDeterministicIDsNumberIdenticalRows(t *testing.T) {
+	old := deterministicIDs
+	deterministicIDs = true
+	t.Cleanup(func() { deterministicIDs = old })
+
+	pf, tx := newTestServices(t, fixedPrices{}, nil, "USD")
+	id := mustPortfolio(t, pf, tx, "USD")
+	fill := transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Shares: 5, Price: 100, Total: -500, Date: "2025/01/02"}
+	other := fill
+	other.Shares, other.Total = 3, -300
+	batch := []transactionDTO{fill, other, fill, fill}
+
+	v, err := tx.Validate(id, batch, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Invalid != 0 {
+		t.Fatalf("validate: %+v, want every row valid", v.Rows)
+	}
+	out, err := tx.CreateBatch(id, batch)
+	if err != nil {
+		t.Fatalf("identical fills in one batch: %v", err)
+	}
+	seen := map[string]bool{}
+	for i, r := range out {
+		if seen[r.ID] {
+			t.Errorf("row %d reuses id %s", i, r.ID)
+		}
+		seen[r.ID] = true
+		if r.ID != v.Rows[i].ID {
+			t.Errorf("row %d stored as %s, validate said %s", i, r.ID, v.Rows[i].ID)
+		}
+	}
+	// Re-sending the import maps every row onto the id it got before
+	again, _, err := tx.Upsert(id, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range out {
+		if again[i].ID != out[i].ID {
+			t.Errorf("re-import row %d got id %s, first import %s", i, again[i].ID, out[i].ID)
+		}
+	}
+	if _, err := tx.CreateBatch(id, batch); !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("re-create: %v, want ErrDuplicateID", err)
+	}
+}
+
+func TestShortProceedsReduceTotalInvested(t *testing.T) {
+	old := allowShorts
+	allowShorts = true
+	t.Cleanup(func() { allowShorts = old })
+
+	pf, tx := newTestServices(t, fixedPrices{"AAPL": 110, "MSFT": 180}, nil, "USD")
+	id := mustPortfolio(t, pf, tx, "USD",
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Shares: 10, Price: 100, Total: -1000, Date: "2025/01/02"},
+		transactionDTO{Symbol: "MSFT", TradeType: TradeTypeSell, Currency: "USD", Shares: 5, Price: 200, Total: 1000, Date: "2025/01/02"},
+	)
+	sums := map[string]func() (SummaryResponse, error){
+		"portfolio": func() (SummaryResponse, error) { return tx.ComputeSummary(id) },
+		"all":       tx.ComputeSummaryAll,
+	}
+	for name, sum := range sums {
+		s, err := sum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !approx(s.TotalInvested, 0) {
+			t.Errorf("%s: total invested %v, want 0 (1000 long − 1000 short proceeds)", name, s.TotalInvested)
+		}
+		if !approx(s.TotalMarketValue, 200) {
+			t.Errorf("%s: total market value %v, want 200 (1100 − 900)", name, s.TotalMarketValue)
+		}
+		for _, p := range s.Positions {
+			if p.Symbol == "MSFT" && (!approx(p.Invested, 1000) || !approx(p.UnrealizedPL, 100)) {
+				t.Errorf("%s: short position %+v, want invested 1000 and P/L 100", name, p)
+			}
+		}
+	}
+}
+
+func TestBlankCurrencyDefaultsToBaseCCY(t *testing.T) {
+	pf, tx := newTestServices(t, fixedPrices{"AAPL": 110}, fixedRates{"USDTWD": 30}, "TWD")
+	id := mustPortfolio(t, pf, tx, "USD")
+	blank := func(shares float64) transactionDTO {
+		return transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Shares: shares, Price: 100, Total: -100 * shares, Date: "2025/01/02"}
+	}
+	check := func(path string, got Transaction, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if got.Currency != "USD" {
+			t.Errorf("%s: currency %q, want the portfolio's USD", path, got.Currency)
+		}
+	}
+
+	one, err := tx.CreateOne(id, blank(1))
+	check("create", one, err)
+	batch, err := tx.CreateBatch(id, []transactionDTO{blank(2), blank(3)})
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	for _, b := range batch {
+		check("batch", b, nil)
+	}
+	up, _, err := tx.Upsert(id, []transactionDTO{blank(4)})
+	if err != nil {
+		t.Fatalf("upsert: %v", err)
+	}
+	check("upsert", up[0], nil)
+	upd, err := tx.Update(id, one.ID, blank(5), "")
+	check("update", upd, err)
+
+	// Valued as USD: 14 shares × 110 at 30 TWD per USD
+	sum, err := tx.ComputeSummary(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(sum.TotalMarketValue, 14*110*30) {
+		t.Errorf("market value %v, want %v", sum.TotalMarketValue, 14*110*30)
+	}
+}
+
+func TestSummaryAllKeepsCostBasisPerPortfolio(t *testing.T) {
+	cases := []struct {
+		name     string
+		a, b     []transactionDTO
+		shares   float64
+		invested float64
+	}{
+		// pooled, selling 10 at an average of 150 would leave 1500
+		{"a sells out", []transactionDTO{buyTx("AAPL", "USD", 10, 100), sellTx("AAPL", "USD", 10, 150)}, []transactionDTO{buyTx("AAPL", "USD", 10, 200)}, 10, 2000},
+		{"a sells half", []transactionDTO{buyTx("AAPL", "USD", 10, 100), sellTx("AAPL", "USD", 5, 150)}, []transactionDTO{buyTx("AAPL", "USD", 10, 200)}, 15, 2500},
+		{"both sell", []transactionDTO{buyTx("AAPL", "USD", 10, 100), sellTx("AAPL", "USD", 5, 150)}, []transactionDTO{buyTx("AAPL", "USD", 10, 200), sellTx("AAPL", "USD", 5, 150)}, 10, 1500},
+		{"no sells", []transactionDTO{buyTx("AAPL", "USD", 10, 100)}, []transactionDTO{buyTx("AAPL", "USD", 10, 200)}, 20, 3000},
+	}
+	for _, c := range cases {
+		pf, tx := newTestServices(t, fixedPrices{"AAPL": 150}, nil, "USD")
+		mustPortfolio(t, pf, tx, "USD", c.a...)
+		mustPortfolio(t, pf, tx, "USD", c.b...)
+		sum, err := tx.ComputeSummaryAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sum.Positions) != 1 {
+			t.Fatalf("%s: positions %+v, want one AAPL", c.name, sum.Positions)
+		}
+		p := sum.Positions[0]
+		if !approx(p.Shares, c.shares) || !approx(p.Invested, c.invested) {
+			t.Errorf("%s: %v shares invested %v, want %v shares invested %v", c.name, p.Shares, p.Invested, c.shares, c.invested)
+		}
+	}
+}
+
+func TestExactCashRoundTripsInferNoDeposit(t *testing.T) {
+	cases := []struct {
+		name string
+		txs  []transactionDTO
+	}{
+		// 0.3 − 0.1 − 0.2 is −2.8e-17 in floating point
+		{"deposit spent in parts", []transactionDTO{cashTx("USD", 0.3), buyTx("AAPL", "USD", 1, 0.1), buyTx("MSFT", "USD", 1, 0.2)}},
+		{"sale proceeds spent in parts", []transactionDTO{cashTx("USD", 0.1), buyTx("AAPL", "USD", 1, 0.1), sellTx("AAPL", "USD", 1, 0.3), buyTx("MSFT", "USD", 1, 0.1), buyTx("TSLA", "USD", 1, 0.2)}},
+		{"withdrawal of the whole balance", []transactionDTO{cashTx("USD", 0.1), cashTx("USD", 0.2), cashTx("USD", -0.3)}},
+	}
+	for _, c := range cases {
+		one, all := summaries(t, fixedPrices{"AAPL": 1, "MSFT": 1, "TSLA": 1}, nil, "USD", "USD", c.txs...)
+		if one.InferredDeposits != 0 || all.InferredDeposits != 0 {
+			t.Errorf("%s: inferred deposits %v (all %v), want 0", c.name, one.InferredDeposits, all.InferredDeposits)
+		}
+	}
+}
+
+// recordedEvents is a TransactionListener that keeps every event.
+type recordedEvents struct{ events []TransactionEvent }
+
+func (r *recordedEvents) TransactionChanged(ev TransactionEvent) { r.events = append(r.events, ev) }
+
+func TestDeleteAllNotifiesRemovedRows(t *testing.T) {
+	pf, tx := newTestServices(t, fixedPrices{}, nil, "USD")
+	pending := buyTx("AAPL", "USD", 1, 100)
+	pending.Pending = true
+	id := mustPortfolio(t, pf, tx, "USD", cashTx("USD", 100), buyTx("AAPL", "USD", 1, 50), pending)
+	rows, err := tx.List(id, ListFilter{IncludePending: true})
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("list = %v, %v", rows, err)
+	}
+	if err := tx.Delete(id, rows[0].ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	rec := &recordedEvents{}
+	tx.Subscribe(rec)
+
+	n, err := tx.DeleteAll(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the soft-deleted row had its event when it was deleted
+	if n != 2 || len(rec.events) != n {
+		t.Fatalf("deleted %d with %d events, want 2 and 2", n, len(rec.events))
+	}
+	for _, ev := range rec.events {
+		if ev.Type != EventTransactionDeleted || ev.Transaction.ID == rows[0].ID {
+			t.Errorf("event %s for %s", ev.Type, ev.Transaction.ID)
+		}
+	}
+}
+
+func TestReconciliationIdentity(t *testing.T) {
+	feeBuy := buyTx("AAPL", "USD", 10, 100)
+	feeBuy.Fee, feeBuy.Total = 1, -1001
+	feeSell := sellTx("AAPL", "USD", 4, 120)
+	feeSell.Fee, feeSell.Total = 2, 478
+	dividend := transactionDTO{Symbol: "AAPL", TradeType: TradeTypeDividend, Currency: "USD", Total: 12, Date: testDate}
+	cases := []struct {
+		name string
+		txs  []transactionDTO
+	}{
+		{"funded buy", []transactionDTO{cashTx("USD", 2000), feeBuy}},
+		{"inferred deposit", []transactionDTO{buyTx("AAPL", "USD", 10, 100)}},
+		{"sale and dividend", []transactionDTO{cashTx("USD", 1000), buyTx("AAPL", "USD", 10, 100), feeSell, dividend, cashTx("USD", -100)}},
+		{"foreign holding", []transactionDTO{buyTx("2330.TW", "TWD", 10, 600), buyTx("AAPL", "USD", 1, 100)}},
+	}
+	for _, c := range cases {
+		sum, _ := summaries(t, fixedPrices{"AAPL": 110, "2330.TW": 650}, fixedRates{"TWDUSD": 1.0 / 30}, "USD", "USD", c.txs...)
+		r := sum.Reconciliation
+		if r == nil {
+			t.Fatalf("%s: no reconciliation", c.name)
+		}
+		if !approx(r.Equity, sum.TotalMarketValue+sum.Balance) {
+			t.Errorf("%s: equity %v, want market value + balance %v", c.name, r.Equity, sum.TotalMarketValue+sum.Balance)
+		}
+		if !approx(r.NetCashFlow, sum.EffectiveCashIn) {
+			t.Errorf("%s: net cash flow %v, want effective cash in %v", c.name, r.NetCashFlow, sum.EffectiveCashIn)
+		}
+		if !approx(r.Equity, r.NetCashFlow+r.TotalPL) {
+			t.Errorf("%s: equity %v != net cash flow %v + total P/L %v", c.name, r.Equity, r.NetCashFlow, r.TotalPL)
+		}
+	}
+}
+
+func TestMixedCurrencyHoldingConvertsPerTranche(t *testing.T) {
+	usd, twd := buyTx("AAPL", "USD", 10, 100), buyTx("AAPL", "TWD", 10, 3000)
+	cases := []struct {
+		name     string
+		txs      []transactionDTO
+		invested float64
+	}{
+		{"USD only", []transactionDTO{usd}, 30000},
+		{"TWD only", []transactionDTO{twd}, 30000},
+		// each tranche at its own rate; 1000 + 30000 at the last-seen
+		// currency's rate would give 31000 (TWD) or 930000 (USD)
+		{"USD then TWD", []transactionDTO{usd, twd}, 60000},
+		{"TWD then USD", []transactionDTO{twd, usd}, 60000},
+	}
+	for _, c := range cases {
+		sum, _ := summaries(t, fixedPrices{"AAPL": 110}, fixedRates{"USDTWD": 30}, "TWD", "USD", c.txs...)
+		if !approx(sum.TotalInvested, c.invested) {
+			t.Errorf("%s: invested %v, want %v", c.name, sum.TotalInvested, c.invested)
+		}
+	}
+}
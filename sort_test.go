@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// syntheticLedger returns n buys, sells and dividends spread over ten years
+// and 50 symbols in portfolio pfID, in random order and with many same-day
+// rows, so the tie-breaks matter.
+func syntheticLedger(pfID string, n int) []Transaction {
+	rng := rand.New(rand.NewSource(1))
+	start := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	types := []TradeType{TradeTypeBuy, TradeTypeBuy, TradeTypeBuy, TradeTypeSell, TradeTypeDividend}
+	txs := make([]Transaction, n)
+	for i := range txs {
+		tt := types[rng.Intn(len(types))]
+		shares, total := float64(1+rng.Intn(20)), -100.0
+		if tt != TradeTypeBuy {
+			shares, total = 1, 10
+		}
+		txs[i] = Transaction{
+			ID:          fmt.Sprintf("tx-%05d", i),
+			PortfolioID: pfID,
+			Symbol:      fmt.Sprintf("S%02d", rng.Intn(50)),
+			TradeType:   tt,
+			Currency:    "USD",
+			Shares:      shares,
+			Price:       100,
+			Total:       total * shares,
+			Date:        start.AddDate(0, 0, rng.Intn(3650)),
+		}
+	}
+	return txs
+}
+
+// insertionSortTx is the quadratic sort stableSort replaced, kept as the
+// benchmark baseline and the reference for the tie-break order.
+func insertionSortTx(xs []Transaction, less func(a, b Transaction) bool) {
+	for i := 1; i < len(xs); i++ {
+		for j := i; j > 0 && less(xs[j], xs[j-1]); j-- {
+			xs[j], xs[j-1] = xs[j-1], xs[j]
+		}
+	}
+}
+
+func TestStableSortKeepsPositionOrder(t *testing.T) {
+	a := syntheticLedger("p1", 2000)
+	b := append([]Transaction(nil), a...)
+	stableSort(a, lessForPositions)
+	insertionSortTx(b, lessForPositions)
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			t.Fatalf("row %d: %s, insertion sort has %s", i, a[i].ID, b[i].ID)
+		}
+	}
+}
+
+// BenchmarkSortTransactions20k sorts a 20k-row ledger into position order.
+func BenchmarkSortTransactions20k(b *testing.B) {
+	ledger := syntheticLedger("p1", 20000)
+	for _, bm := range []struct {
+		name string
+		sort func([]Transaction, func(a, b Transaction) bool)
+	}{
+		{"stable", stableSort[Transaction]},
+		{"insertion", insertionSortTx},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			xs := make([]Transaction, len(ledger))
+			for b.Loop() {
+				copy(xs, ledger)
+				bm.sort(xs, lessForPositions)
+			}
+		})
+	}
+}
+
+// BenchmarkSummaryAll20k computes the global summary over 20k transactions,
+// bypassing the summary cache.
+func BenchmarkSummaryAll20k(b *testing.B) {
+	prices := fixedPrices{}
+	for i := range 50 {
+		prices[fmt.Sprintf("S%02d", i)] = 120
+	}
+	pf, tx := newTestServices(b, prices, nil, "USD")
+	p, err := pf.Create(portfolioDTO{Name: "bench", BaseCCY: "USD"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := tx.repoTx.CreateBatch(p.ID, syntheticLedger(p.ID, 20000)); err != nil {
+		b.Fatal(err)
+	}
+	for b.Loop() {
+		if _, err := tx.computeSummaryAll(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+/* ===================== Portfolio stats ===================== */
+
+// PortfolioStats is a pricing-free overview of a portfolio's transactions,
+// for sanity-checking data after an import. Pending rows are counted apart
+// and left out of every other figure.
+type PortfolioStats struct {
+	PortfolioID    string             `json:"portfolio_id"`
+	Transactions   int                `json:"transactions"`
+	ByTradeType    map[TradeType]int  `json:"by_trade_type"`
+	Pending        int                `json:"pending"`
+	FirstDate      *time.Time         `json:"first_date,omitempty"`
+	LastDate       *time.Time         `json:"last_date,omitempty"`
+	Symbols        int                `json:"symbols"` // distinct, cash rows excluded
+	FeesByCurrency map[string]float64 `json:"fees_by_currency"`
+}
+
+// ComputeStats scans the portfolio's transactions once. Fees are summed in
+// their trade currency, so no exchange rate is needed.
+func (s *TransactionService) ComputeStats(portfolioID string) (PortfolioStats, error) {
+	if _, err := s.repoPf.GetByID(portfolioID); err != nil {
+		return PortfolioStats{}, ErrPortfolioNotFound
+	}
+	txs, err := s.repoTx.List(portfolioID, ListFilter{Limit: 0, IncludePending: true})
+	if err != nil {
+		return PortfolioStats{}, err
+	}
+	out := PortfolioStats{
+		PortfolioID:    portfolioID,
+		ByTradeType:    map[TradeType]int{},
+		FeesByCurrency: map[string]float64{},
+	}
+	symbols := map[string]bool{}
+	for _, tx := range txs {
+		if tx.Pending {
+			out.Pending++
+			continue
+		}
+		out.Transactions++
+		out.ByTradeType[tx.TradeType]++
+		if out.FirstDate == nil || tx.Date.Before(*out.FirstDate) {
+			d := tx.Date
+			out.FirstDate = &d
+		}
+		if out.LastDate == nil || tx.Date.After(*out.LastDate) {
+			d := tx.Date
+			out.LastDate = &d
+		}
+		if tx.TradeType != TradeTypeCash {
+			if sym := strings.ToUpper(strings.TrimSpace(tx.Symbol)); sym != "" {
+				symbols[sym] = true
+			}
+		}
+		if tx.Fee != 0 {
+			out.FeesByCurrency[ccyKey(tx.Currency)] += tx.Fee
+		}
+	}
+	out.Symbols = len(symbols)
+	return out, nil
+}
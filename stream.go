@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-sent events for the live global summary
+
+const (
+	defaultStreamInterval = 60 * time.Second // matches the quote cache TTL
+	minStreamInterval     = 5 * time.Second
+)
+
+// streamIntervalFromEnv reads SUMMARY_STREAM_INTERVAL (seconds or a Go
+// duration such as "30s"); invalid or missing values use the default.
+func streamIntervalFromEnv() time.Duration {
+	return parseStreamInterval(os.Getenv("SUMMARY_STREAM_INTERVAL"), defaultStreamInterval)
+}
+
+func parseStreamInterval(v string, def time.Duration) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		n, err2 := strconv.Atoi(v)
+		if err2 != nil {
+			return def
+		}
+		d = time.Duration(n) * time.Second
+	}
+	if d < minStreamInterval {
+		d = minStreamInterval
+	}
+	return d
+}
+
+// changeBroadcaster fans transaction events out to stream subscribers. Each
+// subscriber has a one-slot channel, so bursts (e.g. a batch import) coalesce
+// into a single recompute.
+type changeBroadcaster struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func newChangeBroadcaster() *changeBroadcaster {
+	return &changeBroadcaster{subs: make(map[chan struct{}]struct{})}
+}
+
+func (b *changeBroadcaster) TransactionChanged(TransactionEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *changeBroadcaster) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// GET /summary/stream?ref_ccy=TWD|USD&interval=30&tag={tag}  (across ALL portfolios)
+func (s *Server) handleSummaryStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	ref, ok := refParam(w, r)
+	if !ok {
+		return
+	}
+	tag := r.URL.Query().Get("tag")
+	every := parseStreamInterval(r.URL.Query().Get("interval"), s.streamEvery)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	changes, unsubscribe := s.changes.subscribe()
+	defer unsubscribe()
+
+	push := func() {
+		out, err := s.tx.WithRef(ref).WithTag(tag).ComputeSummaryAll()
+		if err != nil {
+			writeSSE(w, "error", map[string]any{"error": "summary failed", "detail": err.Error()})
+		} else {
+			writeSSE(w, "summary", out)
+		}
+		flusher.Flush()
+	}
+
+	push()
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			push()
+		case <-changes:
+			push()
+			ticker.Reset(every)
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// summaryCacheTTL bounds how long a memoized summary is served; it matches
+// the providers' default quote TTL. A summary holding a symbol with a shorter
+// TTL in price_ttl.csv expires with that symbol's quote (see ttlFor).
+const summaryCacheTTL = 60 * time.Second
+
+// summaryCache memoizes computed summaries keyed by scope, reference currency
+// and daily basis. Any transaction or portfolio mutation bumps version, which
+// drops every entry; a result computed across an invalidation is not stored.
+type summaryCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	version uint64
+	reloads uint64 // the repository's reload count the entries were computed at
+	entries map[string]summaryEntry
+}
+
+type summaryEntry struct {
+	resp SummaryResponse
+	at   time.Time
+	ttl  time.Duration
+}
+
+func newSummaryCache(ttl time.Duration) *summaryCache {
+	return &summaryCache{ttl: ttl, entries: map[string]summaryEntry{}}
+}
+
+// get returns a fresh cached summary for key along with the current version,
+// which must be passed back to put.
+func (c *summaryCache) get(key string) (SummaryResponse, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if ok && time.Since(e.at) < e.ttl {
+		return e.resp.clone(), c.version, true
+	}
+	return SummaryResponse{}, c.version, false
+}
+
+func (c *summaryCache) put(key string, version uint64, resp SummaryResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != c.version {
+		return
+	}
+	c.entries[key] = summaryEntry{resp: resp.clone(), at: time.Now(), ttl: c.ttlFor(resp)}
+}
+
+// ttlFor is how long resp may be served: the shortest quote TTL among its
+// positions (see priceTTLFor), at most the cache's own.
+func (c *summaryCache) ttlFor(resp SummaryResponse) time.Duration {
+	ttl := c.ttl
+	for _, p := range resp.Positions {
+		if d := priceTTLFor(p.Symbol, c.ttl); d < ttl {
+			ttl = d
+		}
+	}
+	return ttl
+}
+
+// clone deep-copies r, so callers that round, filter or re-sort a summary
+// (the handlers and the combined refs= response do) never touch a cached one.
+func (r SummaryResponse) clone() SummaryResponse {
+	r.Positions = cloneSlice(r.Positions)
+	for i := range r.Positions {
+		p := &r.Positions[i]
+		p.Option = clonePtr(p.Option)
+		p.PricePL = clonePtr(p.PricePL)
+		p.FXPL = clonePtr(p.FXPL)
+	}
+	r.Skipped = cloneSlice(r.Skipped)
+	r.Warnings = cloneSlice(r.Warnings)
+	r.MinBalance = clonePtr(r.MinBalance)
+	r.Reconciliation = clonePtr(r.Reconciliation)
+	return r
+}
+
+// cloneSlice copies xs, keeping nil and empty apart ("null" vs "[]" in JSON).
+func cloneSlice[T any](xs []T) []T {
+	if xs == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(xs)), xs...)
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// sync drops every entry once the repository has reloaded data another
+// process changed (see reloader).
+func (c *summaryCache) sync(reloads uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if reloads != c.reloads {
+		c.reloads = reloads
+		c.version++
+		c.entries = map[string]summaryEntry{}
+	}
+}
+
+func (c *summaryCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version++
+	c.entries = map[string]summaryEntry{}
+}
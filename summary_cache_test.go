@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummaryCacheReturnsCopies(t *testing.T) {
+	pf, svc := newTestServices(t, fixedPrices{"AAPL": 110}, nil, "USD")
+	id := mustPortfolio(t, pf, svc, "USD",
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Currency: "USD", Shares: 10, Price: 100, Date: "2025/02/03", Total: -1000},
+	)
+	first, err := svc.ComputeSummary(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Positions) != 1 || first.Positions[0].PricePL == nil {
+		t.Fatalf("positions = %+v, want one with a price/FX split", first.Positions)
+	}
+	first.Positions[0].Symbol = "MUTATED"
+	*first.Positions[0].PricePL = -1
+	first.Warnings = append(first.Warnings, "mutated")
+
+	again, err := svc.ComputeSummary(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := again.Positions[0]; p.Symbol != "AAPL" || *p.PricePL == -1 || len(again.Warnings) != 0 {
+		t.Errorf("cached summary changed through a caller's copy: %+v, warnings %v", p, again.Warnings)
+	}
+}
+
+func TestSummaryCacheExpiresWithShortestQuoteTTL(t *testing.T) {
+	old := priceTTLs
+	priceTTLs = symbolTable[time.Duration]{}
+	t.Cleanup(func() { priceTTLs = old })
+	for pattern, d := range map[string]time.Duration{"TSLA": 5 * time.Second, "0050.TW": time.Hour, "*.TWO": 30 * time.Second} {
+		if err := priceTTLs.set(pattern, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summary := func(syms ...string) SummaryResponse {
+		var r SummaryResponse
+		for _, s := range syms {
+			r.Positions = append(r.Positions, PositionSummary{Symbol: s})
+		}
+		return r
+	}
+	cases := []struct {
+		name string
+		resp SummaryResponse
+		want time.Duration
+	}{
+		{"no positions", summary(), summaryCacheTTL},
+		{"default TTLs", summary("AAPL", "MSFT"), summaryCacheTTL},
+		{"longer TTL doesn't extend", summary("0050.TW"), summaryCacheTTL},
+		{"pattern", summary("AAPL", "6488.TWO"), 30 * time.Second},
+		{"shortest wins", summary("6488.TWO", "TSLA", "0050.TW"), 5 * time.Second},
+	}
+	c := newSummaryCache(summaryCacheTTL)
+	for _, tc := range cases {
+		if got := c.ttlFor(tc.resp); got != tc.want {
+			t.Errorf("%s: ttl %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// An entry holding TSLA is gone after its 5s, one without it is not
+	_, v, _ := c.get("tsla")
+	c.put("tsla", v, summary("AAPL", "TSLA"))
+	c.put("aapl", v, summary("AAPL"))
+	for key, e := range c.entries {
+		e.at = e.at.Add(-10 * time.Second)
+		c.entries[key] = e
+	}
+	if _, _, ok := c.get("tsla"); ok {
+		t.Error("summary with TSLA served past TSLA's 5s quote TTL")
+	}
+	if _, _, ok := c.get("aapl"); !ok {
+		t.Error("summary without short-TTL symbols expired before 60s")
+	}
+}
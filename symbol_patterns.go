@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+/*
+Per-symbol CSV tables (multipliers.csv, shares_precision.csv, price_ttl.csv)
+share one layout: a header row starting with "symbol", then one row per
+symbol or pattern with the value in the following columns. A row ending in
+'*' matches every symbol with that prefix and one starting with '*' every
+symbol with that suffix; an exact symbol wins over a pattern, and a longer
+pattern over a shorter one (a prefix over a suffix of the same length).
+*/
+
+// symbolTable maps symbols and symbol patterns to a V. The zero value is an
+// empty table.
+type symbolTable[V any] struct {
+	exact    map[string]V
+	prefixes map[string]V
+	suffixes map[string]V
+}
+
+// set stores v under pattern (already upper-cased): an exact symbol, a
+// prefix ending in '*' or a suffix starting with '*'.
+func (t *symbolTable[V]) set(pattern string, v V) error {
+	if t.exact == nil {
+		t.exact, t.prefixes, t.suffixes = map[string]V{}, map[string]V{}, map[string]V{}
+	}
+	if p, ok := strings.CutSuffix(pattern, "*"); ok {
+		if p == "" {
+			return errors.New("empty prefix")
+		}
+		t.prefixes[p] = v
+	} else if s, ok := strings.CutPrefix(pattern, "*"); ok {
+		if s == "" {
+			return errors.New("empty suffix")
+		}
+		t.suffixes[s] = v
+	} else if pattern != "" {
+		t.exact[pattern] = v
+	}
+	return nil
+}
+
+// lookup returns the value for sym, if a row matches it.
+func (t symbolTable[V]) lookup(sym string) (V, bool) {
+	return t.lookupAny(sym)
+}
+
+// lookupAny returns the best match over several forms of one symbol (say,
+// as recorded and as the provider spells it): an exact row for any form
+// first, then the longest pattern; earlier forms win ties.
+func (t symbolTable[V]) lookupAny(forms ...string) (V, bool) {
+	var v V
+	best, found := 0, false
+	for _, sym := range forms {
+		if mv, n, ok := t.match(sym); ok && (!found || n > best) {
+			v, best, found = mv, n, true
+		}
+	}
+	return v, found
+}
+
+// match returns sym's row and how specific the match is: the pattern length,
+// or math.MaxInt for an exact row.
+func (t symbolTable[V]) match(sym string) (V, int, bool) {
+	sym = strings.ToUpper(strings.TrimSpace(sym))
+	if v, ok := t.exact[sym]; ok {
+		return v, math.MaxInt, true
+	}
+	best, found := 0, false
+	var v V
+	for p, pv := range t.prefixes {
+		if strings.HasPrefix(sym, p) && (!found || len(p) > best) {
+			best, v, found = len(p), pv, true
+		}
+	}
+	for s, sv := range t.suffixes {
+		if strings.HasSuffix(sym, s) && (!found || len(s) > best) {
+			best, v, found = len(s), sv, true
+		}
+	}
+	return v, best, found
+}
+
+// loadSymbolTable reads a per-symbol CSV from path. parse turns the columns
+// after the symbol (at least one) into a value; its error is reported with
+// the file and line. A missing file yields an empty table.
+func loadSymbolTable[V any](path string, parse func(cols []string) (V, error)) (symbolTable[V], error) {
+	var t symbolTable[V]
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return t, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return t, err
+	}
+	for i, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		sym := strings.ToUpper(strings.TrimSpace(row[0]))
+		if i == 0 && sym == "SYMBOL" {
+			continue // header
+		}
+		v, err := parse(row[1:])
+		if err == nil {
+			err = t.set(sym, v)
+		}
+		if err != nil {
+			return t, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSymbolTableLookup(t *testing.T) {
+	var tbl symbolTable[int]
+	for pattern, v := range map[string]int{
+		"2330.TW": 1,
+		"*.TW":    2,
+		"23*":     3,
+		"*0.TW":   4,
+		"NIY*":    5,
+		"NIYM*":   6,
+	} {
+		if err := tbl.set(pattern, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cases := []struct {
+		sym  string
+		want int
+		ok   bool
+	}{
+		{"2330.TW", 1, true},  // exact beats any pattern
+		{"2330.tw", 1, true},  // case-insensitive
+		{"2317.TW", 2, true},  // 3-char suffix beats 2-char prefix
+		{"0050.TW", 4, true},  // longer suffix
+		{"2317.TWO", 3, true}, // only the prefix matches
+		{"NIYM5", 6, true},    // longer prefix
+		{"NIYZ5", 5, true},
+		{"AAPL", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := tbl.lookup(c.sym)
+		if got != c.want || ok != c.ok {
+			t.Errorf("lookup(%q) = %d, %v; want %d, %v", c.sym, got, ok, c.want, c.ok)
+		}
+	}
+	if err := tbl.set("*", 0); err == nil {
+		t.Error("set accepted an empty pattern")
+	}
+}
+
+func TestLoadSymbolTables(t *testing.T) {
+	dir := t.TempDir()
+	mult := filepath.Join(dir, multiplierFile)
+	writeTestFile(t, dir, multiplierFile, "symbol,multiplier\nES=F,50\nNIY*,500\n*.OPT,10\n")
+	m, err := LoadMultipliers(mult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for sym, want := range map[string]float64{"ES=F": 50, "NIYM5": 500, "X.OPT": 10} {
+		if got, ok := m.lookup(sym); !ok || got != want {
+			t.Errorf("multiplier %s = %v, %v; want %v", sym, got, ok, want)
+		}
+	}
+
+	prec := filepath.Join(dir, sharesPrecisionFile)
+	writeTestFile(t, dir, sharesPrecisionFile, "symbol,decimals,lot\n*.TW,0\n2330.TW,0,1000\n")
+	p, err := LoadSharesPrecision(prec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := p.lookup("2330.TW"); !ok || r.lot != 1000 {
+		t.Errorf("2330.TW rule = %+v, %v; want lot 1000", r, ok)
+	}
+
+	for name, body := range map[string]string{
+		"bad value":    "symbol,multiplier\nES=F,abc\n",
+		"empty prefix": "symbol,multiplier\n*,5\n",
+	} {
+		writeTestFile(t, dir, multiplierFile, body)
+		if _, err := LoadMultipliers(mult); err == nil {
+			t.Errorf("%s: loaded without an error", name)
+		}
+	}
+	if m, err := LoadMultipliers(filepath.Join(dir, "missing.csv")); err != nil || len(m.exact) != 0 {
+		t.Errorf("missing file = %+v, %v; want an empty table", m, err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// YahooSymbolSearch proxies Yahoo's v1 search endpoint (cached briefly).
+type YahooSymbolSearch struct {
+	cli   *http.Client
+	ttl   time.Duration
+	mu    sync.RWMutex
+	cache map[string]cachedSearch // by lower-cased query
+}
+
+type cachedSearch struct {
+	matches []SymbolMatch
+	fetched time.Time
+}
+
+func NewYahooSymbolSearch() *YahooSymbolSearch {
+	return &YahooSymbolSearch{
+		cli:   newYahooClient(5 * time.Second),
+		ttl:   5 * time.Minute,
+		cache: make(map[string]cachedSearch),
+	}
+}
+
+func (y *YahooSymbolSearch) SearchSymbols(query string) ([]SymbolMatch, error) {
+	query = strings.TrimSpace(query)
+	key := strings.ToLower(query)
+
+	y.mu.RLock()
+	if c, ok := y.cache[key]; ok && time.Since(c.fetched) < y.ttl {
+		y.mu.RUnlock()
+		return c.matches, nil
+	}
+	y.mu.RUnlock()
+
+	u := yahooBaseURL() + "/v1/finance/search?quotesCount=10&newsCount=0&q=" + url.QueryEscape(query)
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.Header.Set("User-Agent", "stock-portfolios/1.0")
+	resp, err := y.cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("yahoo search http %d", resp.StatusCode)
+	}
+
+	var raw struct {
+		Quotes []struct {
+			Symbol    string `json:"symbol"`
+			ShortName string `json:"shortname"`
+			LongName  string `json:"longname"`
+			Exchange  string `json:"exchDisp"`
+			QuoteType string `json:"quoteType"`
+		} `json:"quotes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	out := make([]SymbolMatch, 0, len(raw.Quotes))
+	for _, q := range raw.Quotes {
+		if q.Symbol == "" {
+			continue
+		}
+		name := q.LongName
+		if name == "" {
+			name = q.ShortName
+		}
+		out = append(out, SymbolMatch{Symbol: q.Symbol, Name: name, Exchange: q.Exchange, Type: q.QuoteType})
+	}
+
+	y.mu.Lock()
+	y.cache[key] = cachedSearch{matches: out, fetched: time.Now()}
+	y.mu.Unlock()
+	return out, nil
+}
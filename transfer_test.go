@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestTransferCarriesAverageCost(t *testing.T) {
+	pf, svc := newTestServices(t, nil, nil, "USD")
+	src := mustPortfolio(t, pf, svc, "USD",
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Shares: 10, Price: 100, Date: "2025/01/02", Total: -1000},
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Shares: 10, Price: 200, Date: "2025/01/03", Total: -2000},
+	)
+	dst := mustPortfolio(t, pf, svc, "USD")
+
+	out, in, err := svc.Transfer(src, transferDTO{ToPortfolioID: dst, Symbol: "AAPL", Shares: 5, Date: "2025/02/01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approx(out.Total, 750) || !approx(in.Total, 750) || !approx(in.Price, 150) {
+		t.Fatalf("legs carry %v / %v at %v; want 750 at 150, the source's average cost", out.Total, in.Total, in.Price)
+	}
+	for id, want := range map[string]HoldingItem{src: {Shares: 15, AvgCost: 150}, dst: {Shares: 5, AvgCost: 150}} {
+		h, err := svc.ComputeHoldings(id, false)
+		if err != nil || len(h) != 1 || !approx(h[0].Shares, want.Shares) || !approx(h[0].AvgCost, want.AvgCost) {
+			t.Errorf("holdings of %s = %+v, %v; want %+v", id, h, err, want)
+		}
+	}
+}
+
+func TestTransferRejects(t *testing.T) {
+	pf, svc := newTestServices(t, nil, nil, "USD")
+	src := mustPortfolio(t, pf, svc, "USD",
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Shares: 10, Price: 100, Date: "2025/01/02", Total: -1000})
+	dst := mustPortfolio(t, pf, svc, "USD")
+
+	for _, tc := range []struct {
+		name string
+		dto  transferDTO
+	}{
+		{"client cost basis", transferDTO{ToPortfolioID: dst, Symbol: "AAPL", Shares: 1, CostBasis: 1, Date: "2025/02/01"}},
+		{"more than held", transferDTO{ToPortfolioID: dst, Symbol: "AAPL", Shares: 11, Date: "2025/02/01"}},
+		{"not held", transferDTO{ToPortfolioID: dst, Symbol: "MSFT", Shares: 1, Date: "2025/02/01"}},
+	} {
+		if _, _, err := svc.Transfer(src, tc.dto); err == nil {
+			t.Errorf("%s: transfer accepted", tc.name)
+		}
+	}
+
+	leg := transactionDTO{Symbol: "AAPL", TradeType: TradeTypeTransfer, Shares: 5, Date: "2025/02/01", Total: 500}
+	if _, err := svc.CreateOne(dst, leg); !errors.Is(err, errLoneTransfer) {
+		t.Errorf("lone leg via create: err %v, want errLoneTransfer", err)
+	}
+	if _, err := svc.CreateBatch(dst, []transactionDTO{leg}); !errors.Is(err, errLoneTransfer) {
+		t.Errorf("lone leg via batch: err %v, want errLoneTransfer", err)
+	}
+	if _, _, err := svc.Upsert(dst, []transactionDTO{leg}); !errors.Is(err, errLoneTransfer) {
+		t.Errorf("lone leg via upsert: err %v, want errLoneTransfer", err)
+	}
+}
+
+func TestConcurrentTransfersCannotOverdraw(t *testing.T) {
+	pf, svc := newTestServices(t, nil, nil, "USD")
+	src := mustPortfolio(t, pf, svc, "USD",
+		transactionDTO{Symbol: "AAPL", TradeType: TradeTypeBuy, Shares: 20, Price: 100, Date: "2025/01/02", Total: -2000})
+	dst := mustPortfolio(t, pf, svc, "USD")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, errs[i] = svc.Transfer(src, transferDTO{ToPortfolioID: dst, Symbol: "AAPL", Shares: 15, Date: "2025/02/01"})
+		}(i)
+	}
+	wg.Wait()
+	ok := 0
+	for _, err := range errs {
+		if err == nil {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Fatalf("%d of %d concurrent transfers of 15 out of 20 shares went through, want 1", ok, len(errs))
+	}
+}
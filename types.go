@@ -11,12 +11,25 @@ const (
     TradeTypeSell     TradeType = "sell"
     TradeTypeDividend TradeType = "dividend"
     TradeTypeCash     TradeType = "cash"
+    // TradeTypeTransfer moves shares between portfolios without a sale:
+    // positive Shares = transfer in (at the Total cost basis), negative = out.
+    TradeTypeTransfer TradeType = "transfer"
+)
+
+// Cash transaction kinds. Interest and fees are income and expense on the
+// account, not external contributions, so they stay out of effective cash in.
+const (
+    CashKindDeposit    = "deposit"
+    CashKindWithdrawal = "withdrawal"
+    CashKindInterest   = "interest"
+    CashKindFee        = "fee"
 )
 
 type Portfolio struct {
 	ID        string    `json:"id"`
 	Name      string    `json:"name"`
 	BaseCCY   string    `json:"base_ccy"`
+	Tags      []string  `json:"tags,omitempty"` // e.g. retirement, taxable
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -34,4 +47,18 @@ type Transaction struct {
 	Total       float64   `json:"total"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// DeletedAt is set on soft-deleted transactions (restorable until purged)
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	// Pending marks a planned trade (e.g. a limit order) that is excluded from
+	// every aggregation until executed
+	Pending bool `json:"pending,omitempty"`
+	// Note is a free-form memo ("tax-loss harvest"); not used in calculations
+	Note string `json:"note,omitempty"`
+	// CashKind classifies a cash transaction (CashKind* constants). Empty
+	// means deposit or withdrawal by the sign of Total.
+	CashKind string `json:"cash_kind,omitempty"`
+	// FXRate is the Currency→FXRef rate on the trade date, recorded at
+	// creation. Zero when unknown; conversions then fall back to spot.
+	FXRate float64 `json:"trade_fx_rate,omitempty"`
+	FXRef  string  `json:"trade_fx_ref,omitempty"`
 }
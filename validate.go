@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+)
+
+/* ===================== Dry-run import validation ===================== */
+
+// ValidateRow is the outcome for one input row (0-based, as in BatchError).
+type ValidateRow struct {
+	Index int    `json:"index"`
+	OK    bool   `json:"ok"`
+	ID    string `json:"id,omitempty"` // the id the row would be stored under
+	Error string `json:"error,omitempty"`
+}
+
+// HoldingDelta is how one holding would change if the valid rows were
+// imported. Shares and costs are in the trade currency, as in HoldingItem.
+type HoldingDelta struct {
+	Symbol        string  `json:"symbol"`
+	Currency      string  `json:"currency"`
+	SharesBefore  float64 `json:"shares_before"`
+	SharesAfter   float64 `json:"shares_after"`
+	SharesDelta   float64 `json:"shares_delta"`
+	AvgCostBefore float64 `json:"avg_cost_before"`
+	AvgCostAfter  float64 `json:"avg_cost_after"`
+}
+
+type ValidateResponse struct {
+	Valid   int           `json:"valid"`
+	Invalid int           `json:"invalid"`
+	Rows    []ValidateRow `json:"rows"`
+	// HoldingsDelta covers the valid rows only; the real import is
+	// all-or-nothing, so it goes through only when Invalid is 0.
+	HoldingsDelta []HoldingDelta `json:"holdings_delta"`
+}
+
+// Validate runs the checks CreateBatch (or Upsert, when upsert is set) would
+// run on dtos, row by row, and previews the holdings change. Nothing is
+// stored and no trade-date FX is looked up.
+func (s *TransactionService) Validate(portfolioID string, dtos []transactionDTO, upsert bool) (ValidateResponse, error) {
+	pf, err := s.repoPf.GetByID(portfolioID)
+	if err != nil {
+		return ValidateResponse{}, ErrPortfolioNotFound
+	}
+	existing, err := s.repoTx.List(portfolioID, ListFilter{Limit: 0})
+	if err != nil {
+		return ValidateResponse{}, err
+	}
+	pfs, err := s.repoPf.List()
+	if err != nil {
+		return ValidateResponse{}, err
+	}
+	// heldElsewhere reports an id stored under another portfolio, which
+	// neither create nor upsert may take over.
+	heldElsewhere := func(id string) bool {
+		for _, p := range pfs {
+			if p.ID == portfolioID {
+				continue
+			}
+			if _, err := s.repoTx.GetByID(p.ID, id); err == nil {
+				return true
+			}
+		}
+		return false
+	}
+	stored := make(map[string]int, len(existing))
+	for i, tx := range existing {
+		stored[tx.ID] = i
+	}
+
+	out := ValidateResponse{Rows: make([]ValidateRow, len(dtos)), HoldingsDelta: []HoldingDelta{}}
+	after := append([]Transaction(nil), existing...)
+	seen := make(map[string]struct{}, len(dtos))
+	ids := derivedIDs{}
+	now := s.clock.Now()
+	for i, d := range dtos {
+		row := ValidateRow{Index: i}
+		tx, err := d.toDomain(now, portfolioID, pf.BaseCCY)
+		if err == nil && tx.TradeType == TradeTypeTransfer {
+			err = errLoneTransfer
+		}
+		if err == nil {
+			tx.ID = ids.distinct(d, tx)
+			_, dup := seen[tx.ID]
+			_, here := stored[tx.ID]
+			if dup || (here && !upsert) || heldElsewhere(tx.ID) {
+				err = fmt.Errorf("%w %q", ErrDuplicateID, tx.ID)
+			}
+		}
+		if err != nil {
+			row.Error = err.Error()
+			out.Invalid++
+			out.Rows[i] = row
+			continue
+		}
+		seen[tx.ID] = struct{}{}
+		row.OK, row.ID = true, tx.ID
+		out.Valid++
+		out.Rows[i] = row
+		if j, here := stored[tx.ID]; here {
+			after[j] = tx // replaced in place by the upsert
+		} else {
+			after = append(after, tx)
+		}
+	}
+
+	base := map[string]string{pf.ID: pf.BaseCCY}
+	out.HoldingsDelta = diffHoldings(s.holdingsFromTxs(existing, base, true), s.holdingsFromTxs(after, base, true))
+	return out, nil
+}
+
+// diffHoldings pairs before and after rows by symbol and keeps the ones whose
+// shares or average cost moved. The currency is the one after the import.
+func diffHoldings(before, after []HoldingItem) []HoldingDelta {
+	byKey := map[string]*HoldingDelta{}
+	var order []string
+	get := func(it HoldingItem) *HoldingDelta {
+		d := byKey[it.Symbol]
+		if d == nil {
+			d = &HoldingDelta{Symbol: it.Symbol}
+			byKey[it.Symbol] = d
+			order = append(order, it.Symbol)
+		}
+		d.Currency = it.Currency
+		return d
+	}
+	for _, it := range before {
+		d := get(it)
+		d.SharesBefore, d.AvgCostBefore = it.Shares, it.AvgCost
+	}
+	for _, it := range after {
+		d := get(it)
+		d.SharesAfter, d.AvgCostAfter = it.Shares, it.AvgCost
+	}
+	out := []HoldingDelta{}
+	for _, k := range order {
+		d := byKey[k]
+		d.SharesDelta = d.SharesAfter - d.SharesBefore
+		if d.SharesDelta == 0 && d.AvgCostAfter == d.AvgCostBefore {
+			continue
+		}
+		out = append(out, *d)
+	}
+	stableSort(out, func(a, b HoldingDelta) bool {
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		return a.Currency < b.Currency
+	})
+	return out
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, injected at link time:
+//
+//	go build -ldflags "-X main.buildCommit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	buildCommit = ""
+	buildTime   = ""
+)
+
+type versionInfo struct {
+	Commit        string `json:"commit"`
+	BuildTime     string `json:"build_time"`
+	GoVersion     string `json:"go_version"`
+	RepoKind      string `json:"repo_kind"`
+	PriceProvider string `json:"price_provider"`
+	RefCurrency   string `json:"ref_currency"`
+}
+
+// activeConfig records the effective configuration chosen in main (after
+// defaults and fallbacks), reported by GET /version.
+var activeConfig struct {
+	repoKind      string
+	priceProvider string
+	refCCY        string
+}
+
+func currentVersion() versionInfo {
+	v := versionInfo{
+		Commit:        buildCommit,
+		BuildTime:     buildTime,
+		GoVersion:     runtime.Version(),
+		RepoKind:      activeConfig.repoKind,
+		PriceProvider: activeConfig.priceProvider,
+		RefCurrency:   activeConfig.refCCY,
+	}
+	// Fall back to the VCS stamp Go embeds when ldflags weren't set
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, st := range bi.Settings {
+			switch st.Key {
+			case "vcs.revision":
+				if v.Commit == "" {
+					v.Commit = st.Value
+				}
+			case "vcs.time":
+				if v.BuildTime == "" {
+					v.BuildTime = st.Value
+				}
+			}
+		}
+	}
+	if v.Commit == "" {
+		v.Commit = "unknown"
+	}
+	if v.BuildTime == "" {
+		v.BuildTime = "unknown"
+	}
+	return v
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, currentVersion())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Outbound webhook for transaction changes (optional; enabled via WEBHOOK_URL)
+
+var ErrWebhookURLMissing = errors.New("WEBHOOK_URL not set")
+
+const webhookQueueSize = 256
+
+type WebhookNotifier struct {
+	url    string
+	secret []byte
+	cli    *http.Client
+	queue  chan TransactionEvent
+}
+
+func NewWebhookNotifierFromEnv() (*WebhookNotifier, error) {
+	url := strings.TrimSpace(os.Getenv("WEBHOOK_URL"))
+	if url == "" {
+		return nil, ErrWebhookURLMissing
+	}
+	n := &WebhookNotifier{
+		url:    url,
+		secret: []byte(os.Getenv("WEBHOOK_SECRET")),
+		cli:    &http.Client{Timeout: 8 * time.Second},
+		queue:  make(chan TransactionEvent, webhookQueueSize),
+	}
+	go n.run()
+	return n, nil
+}
+
+// TransactionChanged enqueues the event without blocking; when the queue is
+// full the event is dropped with a warning.
+func (n *WebhookNotifier) TransactionChanged(ev TransactionEvent) {
+	select {
+	case n.queue <- ev:
+	default:
+		log.Printf("webhook: queue full, dropping %s for transaction %s (request %s)", ev.Type, ev.Transaction.ID, ev.RequestID)
+	}
+}
+
+func (n *WebhookNotifier) run() {
+	for ev := range n.queue {
+		if err := n.post(ev); err != nil {
+			log.Printf("webhook: %s for transaction %s (request %s): %v", ev.Type, ev.Transaction.ID, ev.RequestID, err)
+		}
+	}
+}
+
+func (n *WebhookNotifier) post(ev TransactionEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "stock-portfolios/1.0")
+	if ev.RequestID != "" {
+		req.Header.Set(requestIDHeader, ev.RequestID)
+	}
+	if len(n.secret) > 0 {
+		req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(n.secret, body))
+	}
+	resp, err := n.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook http %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// signWebhook returns the hex-encoded HMAC-SHA256 of body keyed by secret.
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
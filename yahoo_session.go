@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Yahoo answers some regions' chart requests with 401 "Invalid Crumb" unless
+// they carry the consent cookie set by fc.yahoo.com and the crumb issued for
+// it. The handshake endpoints are vars so they can be pointed elsewhere; with
+// YAHOO_BASE_URL set, the crumb comes from that host too.
+var (
+	yahooCookieURL = "https://fc.yahoo.com"
+	yahooCrumbURL  = strings.TrimRight(envString("YAHOO_BASE_URL", "https://query1.finance.yahoo.com"), "/") + "/v1/test/getcrumb"
+)
+
+// yahooBaseURL is the Yahoo Finance API host for chart and search requests.
+// YAHOO_BASE_URL replaces it, e.g. with a regional mirror or a caching proxy.
+func yahooBaseURL() string {
+	return strings.TrimRight(envString("YAHOO_BASE_URL", "https://query2.finance.yahoo.com"), "/")
+}
+
+// newYahooClient is the HTTP client shared by the Yahoo provider, exchanger
+// and symbol search. It goes through HTTP_PROXY/HTTPS_PROXY (minus NO_PROXY)
+// when those are set.
+func newYahooClient(timeout time.Duration) *http.Client {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.Proxy = http.ProxyFromEnvironment
+	return &http.Client{Timeout: timeout, Transport: tr}
+}
+
+// yahooHandshakeBackoff spaces out handshake attempts after one fails, so an
+// outage doesn't double every request.
+const yahooHandshakeBackoff = time.Minute
+
+// yahooSession attaches Yahoo's cookie and crumb to API requests. The
+// handshake runs on first use and again when a request comes back 401.
+// YAHOO_COOKIE and YAHOO_CRUMB set both by hand (e.g. copied from a browser)
+// and skip the handshake.
+type yahooSession struct {
+	cli    *http.Client
+	cookie string // YAHOO_COOKIE; empty: the jar's cookies
+	fixed  bool   // YAHOO_CRUMB given
+
+	mu       sync.Mutex
+	crumb    string
+	failedAt time.Time
+	pending  chan struct{} // closed when the handshake in flight ends; nil when none is
+}
+
+// sharedYahooSession is the one session behind the Yahoo provider and
+// exchanger (including the exchanger's history), so they share a cookie jar
+// and a crumb and a refused crumb is renewed once for all of them.
+var sharedYahooSession = sync.OnceValue(func() *yahooSession {
+	return newYahooSession(newYahooClient(8 * time.Second))
+})
+
+// newYahooSession sends through cli, giving it a cookie jar if it has none.
+func newYahooSession(cli *http.Client) *yahooSession {
+	if cli.Jar == nil {
+		cli.Jar, _ = cookiejar.New(nil)
+	}
+	s := &yahooSession{
+		cli:    cli,
+		cookie: strings.TrimSpace(os.Getenv("YAHOO_COOKIE")),
+		crumb:  strings.TrimSpace(os.Getenv("YAHOO_CRUMB")),
+	}
+	s.fixed = s.crumb != ""
+	return s
+}
+
+// get fetches u with the session's crumb. On a 401 it redoes the handshake
+// and retries once.
+func (s *yahooSession) get(u string) (*http.Response, error) {
+	crumb := s.crumbFor("")
+	resp, err := s.send(u, crumb)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || s.fixed {
+		return resp, err
+	}
+	resp.Body.Close()
+	return s.send(u, s.crumbFor(crumb))
+}
+
+// crumbFor returns the current crumb, handshaking first when there is none
+// or it is still stale (the one a request was just refused with). The
+// handshake runs outside the lock; callers that need it meanwhile wait for
+// its result instead of starting another.
+func (s *yahooSession) crumbFor(stale string) string {
+	s.mu.Lock()
+	for {
+		if s.fixed || (s.crumb != "" && s.crumb != stale) ||
+			(!s.failedAt.IsZero() && time.Since(s.failedAt) < yahooHandshakeBackoff) {
+			crumb := s.crumb
+			s.mu.Unlock()
+			return crumb
+		}
+		if s.pending == nil {
+			break
+		}
+		wait := s.pending
+		s.mu.Unlock()
+		<-wait
+		s.mu.Lock()
+	}
+	done := make(chan struct{})
+	s.pending = done
+	s.mu.Unlock()
+
+	crumb, err := s.handshake()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = nil
+	close(done)
+	if err != nil {
+		log.Printf("yahoo: crumb handshake: %v", err)
+		s.failedAt = time.Now()
+		return s.crumb
+	}
+	s.crumb, s.failedAt = crumb, time.Time{}
+	return crumb
+}
+
+// handshake collects the consent cookie into the jar and fetches its crumb.
+func (s *yahooSession) handshake() (string, error) {
+	if s.cookie == "" {
+		req, _ := http.NewRequest(http.MethodGet, yahooCookieURL, nil)
+		req.Header.Set("User-Agent", "stock-portfolios/1.0")
+		resp, err := s.cli.Do(req)
+		if err != nil {
+			return "", err
+		}
+		// The page itself is a 404; only its Set-Cookie matters
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, yahooCrumbURL, nil)
+	s.decorate(req)
+	resp, err := s.cli.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getcrumb http %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return "", err
+	}
+	crumb := strings.TrimSpace(string(b))
+	if crumb == "" || strings.ContainsAny(crumb, "<{ ") {
+		return "", errors.New("getcrumb returned no crumb")
+	}
+	return crumb, nil
+}
+
+func (s *yahooSession) send(u, crumb string) (*http.Response, error) {
+	if crumb != "" {
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + "crumb=" + url.QueryEscape(crumb)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.decorate(req)
+	return s.cli.Do(req)
+}
+
+func (s *yahooSession) decorate(req *http.Request) {
+	req.Header.Set("User-Agent", "stock-portfolios/1.0")
+	if s.cookie != "" {
+		req.Header.Set("Cookie", s.cookie)
+	}
+}
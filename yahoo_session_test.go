@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestYahooSessionIsShared(t *testing.T) {
+	p, ex := NewYahooProvider(), NewYahooExchanger()
+	if p.yahoo != ex.yahoo || ex.hist.yahoo != ex.yahoo {
+		t.Fatal("provider, exchanger and exchanger history use separate Yahoo sessions")
+	}
+}
+
+func TestYahooHandshakeRunsOnceOutsideTheLock(t *testing.T) {
+	var handshakes atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/getcrumb" {
+			return // consent cookie page
+		}
+		if handshakes.Add(1) == 1 {
+			close(entered)
+		}
+		<-release
+		w.Write([]byte("crumb1"))
+	}))
+	defer srv.Close()
+	oldCookie, oldCrumb := yahooCookieURL, yahooCrumbURL
+	yahooCookieURL, yahooCrumbURL = srv.URL+"/cookie", srv.URL+"/getcrumb"
+	t.Cleanup(func() { yahooCookieURL, yahooCrumbURL = oldCookie, oldCrumb })
+	t.Setenv("YAHOO_COOKIE", "")
+	t.Setenv("YAHOO_CRUMB", "")
+
+	s := newYahooSession(srv.Client())
+	var wg sync.WaitGroup
+	got := make([]string, 8)
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = s.crumbFor("")
+		}()
+	}
+	<-entered
+	locked := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		s.mu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Error("session lock held during the handshake")
+	}
+	close(release)
+	wg.Wait()
+
+	if n := handshakes.Load(); n != 1 {
+		t.Errorf("%d handshakes for concurrent callers, want 1", n)
+	}
+	for i, c := range got {
+		if c != "crumb1" {
+			t.Errorf("caller %d got crumb %q, want crumb1", i, c)
+		}
+	}
+}